@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/goslammu/yp_go_devops/internal/pkg/server"
@@ -17,6 +18,13 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == migrateCommand {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	go func() {
 		if err := http.ListenAndServe(":6060", nil); err != nil {
 			log.Println(err)
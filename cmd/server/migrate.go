@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/migrate"
+)
+
+// Name of subcommand migrating storage, e.g. "server migrate --from file:./tmp/metricStorage.json --to postgres://...".
+const migrateCommand = "migrate"
+
+var errMigrateLocations = errors.New("both --from and --to must be defined")
+
+// runMigrate copies metrics between storages defined by arguments and prints report of every tenant.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet(migrateCommand, flag.ContinueOnError)
+
+	from := fs.String("from", "", `source storage: "file:<path>" or Postgres URL`)
+	to := fs.String("to", "", `destination storage: "file:<path>" or Postgres URL`)
+	statePath := fs.String("state", "migrate.state.json", "file of migration progress, empty value turns resuming off")
+	batchSize := fs.Int("batch-size", migrate.DefaultBatchSize, "number of metrics copied at once")
+	dryRun := fs.Bool("dry-run", false, "only compare source with destination")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" {
+		return errMigrateLocations
+	}
+
+	src, err := migrate.Open(*from, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := src.Close(); errClose != nil {
+			log.Println(errClose)
+		}
+	}()
+
+	dst, err := migrate.Open(*to, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := dst.Close(); errClose != nil {
+			log.Println(errClose)
+		}
+	}()
+
+	report, err := migrate.Run(src, dst, migrate.Options{StatePath: *statePath, BatchSize: *batchSize, DryRun: *dryRun})
+	if report != nil {
+		for _, tr := range report.Tenants {
+			log.Printf("tenant %q: %d metrics, %d copied, %d in destination, checksum %s, verified: %t",
+				tr.Tenant, tr.Metrics, tr.Copied, tr.Existing, tr.Checksum, tr.Verified)
+		}
+
+		log.Printf("%d metrics, %d copied in %d batches, resumed: %t, dry run: %t",
+			report.Metrics, report.Copied, report.Batches, report.Resumed, report.DryRun)
+	}
+
+	return err
+}
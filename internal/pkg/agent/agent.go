@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/goslammu/yp_go_devops/internal/pkg/filestorage"
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
@@ -52,6 +53,12 @@ type agent struct {
 	initialized, turnedOn bool
 
 	client http.Client
+
+	// Version of hashes encoding agreed with server.
+	hashVersion int32
+
+	// Unix time in milliseconds agent started at. Is sent with counters to let server detect their resets.
+	epoch int64
 }
 
 // Agent constructor.
@@ -79,6 +86,16 @@ func (agn *agent) Init() error {
 	}
 
 	agn.storage = filestorage.New("")
+	agn.epoch = time.Now().UnixMilli()
+
+	agn.hashVersion = metric.HashV1
+	if agn.config.HashVersion != 0 {
+		if !metric.IsHashVersionSupported(agn.config.HashVersion) {
+			return metric.ErrUnsupportedHashVersion
+		}
+
+		agn.hashVersion = int32(agn.config.HashVersion)
+	}
 
 	agn.initialized = true
 
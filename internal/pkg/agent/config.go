@@ -44,6 +44,12 @@ type agentConfig struct {
 	// Destination of TLS certification data.
 	CertDestination string `env:"CRYPTO_KEY" json:"crypto_key"`
 
+	// Bearer token issued by server administrator. Nothing is sent if Token is empty.
+	Token string `env:"TOKEN" json:"token"`
+
+	// Tenant which metrics belong to. If is empty, default tenant is used.
+	Tenant string `env:"TENANT" json:"tenant"`
+
 	// Defines http content-type of report packet.
 	ContentType string
 
@@ -56,6 +62,9 @@ type agentConfig struct {
 	// Time interval between sendings metrics to the server.
 	ReportInterval time.Duration `env:"REPORT_INTERVAL" json:"report_interval"`
 
+	// Initial version of hashes encoding. Is switched to the latest one accepted by server after first response.
+	HashVersion int `env:"HASH_VERSION" json:"hash_version"`
+
 	// Defines if use HTTP or HTTPS. If CertDestination is not defined, turns to false.
 	EnableHTTPS bool
 }
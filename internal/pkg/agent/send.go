@@ -6,8 +6,11 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,6 +26,17 @@ const (
 	HTTPS                = "https://"
 )
 
+const (
+	// Request header with version of hashes encoding.
+	HashVersionHeader = "Hash-Version"
+
+	// Response header with list of hash versions accepted by server.
+	AcceptHashVersionsHeader = "Accept-Hash-Versions"
+
+	// Request header defining tenant of metrics.
+	TenantHeader = "X-Tenant"
+)
+
 // Sends individual metric to the server.
 func (agn *agent) sendMetric(name string) error {
 	m, err := agn.storage.GetMetric(name)
@@ -30,17 +44,19 @@ func (agn *agent) sendMetric(name string) error {
 		return err
 	}
 
-	if errUpdateHash := m.UpdateHash(agn.config.HashKey); errUpdateHash != nil {
+	hashVersion := agn.getHashVersion()
+
+	if errUpdateHash := m.UpdateHashVersion(agn.config.HashKey, hashVersion); errUpdateHash != nil {
 		return errUpdateHash
 	}
 
 	switch agn.config.ContentType {
 	case ContentTypeTextPlain:
-		if err := agn.sendMetricAsTextPlain(m); err != nil {
+		if err := agn.sendMetricAsTextPlain(m, hashVersion); err != nil {
 			return err
 		}
 	case ContentTypeJSON:
-		if err := agn.sendMetricAsJSON(m); err != nil {
+		if err := agn.sendMetricAsJSON(m, hashVersion); err != nil {
 			return err
 		}
 	default:
@@ -56,7 +72,7 @@ func (agn *agent) sendMetric(name string) error {
 	return nil
 }
 
-func (agn *agent) sendMetricAsTextPlain(m *metric.Metric) error {
+func (agn *agent) sendMetricAsTextPlain(m *metric.Metric, hashVersion int) error {
 	var val string
 
 	switch m.MType {
@@ -72,6 +88,7 @@ func (agn *agent) sendMetricAsTextPlain(m *metric.Metric) error {
 		agn.config.ServerAddress+"/update/"+m.MType+"/"+m.ID+"/"+val,
 		m.Hash,
 		ContentTypeTextPlain,
+		hashVersion,
 		nil); err != nil {
 
 		return err
@@ -80,7 +97,11 @@ func (agn *agent) sendMetricAsTextPlain(m *metric.Metric) error {
 	return nil
 }
 
-func (agn *agent) sendMetricAsJSON(m *metric.Metric) error {
+func (agn *agent) sendMetricAsJSON(m *metric.Metric, hashVersion int) error {
+	if m.MType == Counter {
+		m.Epoch = agn.epoch
+	}
+
 	body, err := json.Marshal(m)
 	if err != nil {
 		return err
@@ -90,6 +111,7 @@ func (agn *agent) sendMetricAsJSON(m *metric.Metric) error {
 		agn.config.ServerAddress+"/update/",
 		m.Hash,
 		ContentTypeJSON,
+		hashVersion,
 		body); err != nil {
 		return err
 	}
@@ -99,7 +121,9 @@ func (agn *agent) sendMetricAsJSON(m *metric.Metric) error {
 
 // Sends all storaged metrics collected in batch to the server.
 func (agn *agent) sendBatchAsJSON() error {
-	body, err := agn.getStorageBatch()
+	hashVersion := agn.getHashVersion()
+
+	body, err := agn.getStorageBatch(hashVersion)
 	if err != nil {
 		return err
 	}
@@ -107,6 +131,7 @@ func (agn *agent) sendBatchAsJSON() error {
 		agn.config.ServerAddress+"/updates/",
 		"",
 		ContentTypeJSON,
+		hashVersion,
 		body); err != nil {
 		return err
 	}
@@ -119,7 +144,7 @@ func (agn *agent) sendBatchAsJSON() error {
 }
 
 // Unified POST-request for all sending methods.
-func (agn *agent) postRequest(url, hash, contentType string, body []byte) error {
+func (agn *agent) postRequest(url, hash, contentType string, hashVersion int, body []byte) error {
 	modePrefix := ""
 
 	if agn.config.EnableHTTPS {
@@ -137,11 +162,26 @@ func (agn *agent) postRequest(url, hash, contentType string, body []byte) error
 	}
 
 	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(HashVersionHeader, strconv.Itoa(hashVersion))
 
 	if hash != "" {
 		req.Header.Set("Hash", hash)
 	}
 
+	if agn.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+agn.config.Token)
+	}
+
+	if agn.config.Tenant != "" {
+		req.Header.Set(TenantHeader, agn.config.Tenant)
+	}
+
+	if agn.config.HashKey != "" {
+		if err := signer.SignRequest(req, agn.config.HashKey, body); err != nil {
+			return err
+		}
+	}
+
 	res, err := agn.client.Do(req)
 	if err != nil {
 		return err
@@ -155,5 +195,37 @@ func (agn *agent) postRequest(url, hash, contentType string, body []byte) error
 
 	log.Println("REQ SENT:", res.Status, res.Request.URL)
 
+	agn.negotiateHashVersion(res.Header.Get(AcceptHashVersionsHeader))
+
 	return nil
 }
+
+// Gives version of hashes encoding currently agreed with server.
+func (agn *agent) getHashVersion() int {
+	return int(atomic.LoadInt32(&agn.hashVersion))
+}
+
+// Switches to the latest hash version supported both by agent and server.
+// Servers which don't advertise accepted versions are treated as legacy ones, so current version is kept.
+func (agn *agent) negotiateHashVersion(accepted string) {
+	if accepted == "" {
+		return
+	}
+
+	best := 0
+
+	for _, v := range strings.Split(accepted, ",") {
+		version, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+
+		if metric.IsHashVersionSupported(version) && version > best {
+			best = version
+		}
+	}
+
+	if best != 0 && atomic.SwapInt32(&agn.hashVersion, int32(best)) != int32(best) {
+		log.Println("HASH VERSION NEGOTIATED:", best)
+	}
+}
@@ -3,7 +3,7 @@ package agent
 import "encoding/json"
 
 // Gives a batch of all storaged metrics in json format.
-func (agn *agent) getStorageBatch() ([]byte, error) {
+func (agn *agent) getStorageBatch(hashVersion int) ([]byte, error) {
 	allMetrics, err := agn.storage.GetBatch()
 	if err != nil {
 		return nil, err
@@ -14,9 +14,13 @@ func (agn *agent) getStorageBatch() ([]byte, error) {
 	}
 
 	for i := range allMetrics {
-		if errUpdateHash := allMetrics[i].UpdateHash(agn.config.HashKey); errUpdateHash != nil {
+		if errUpdateHash := allMetrics[i].UpdateHashVersion(agn.config.HashKey, hashVersion); errUpdateHash != nil {
 			return nil, errUpdateHash
 		}
+
+		if allMetrics[i].MType == Counter {
+			allMetrics[i].Epoch = agn.epoch
+		}
 	}
 
 	mj, err := json.Marshal(allMetrics)
@@ -0,0 +1,231 @@
+// Package alerting evaluates alerting rules, e.g. "HeapAlloc > 1e9 for 2m", and tracks states of their alerts.
+//
+// Alert of rule becomes pending when condition of rule is true, and firing when condition stays true during
+// duration of rule. Firing alert becomes resolved when condition is false again. Missing data makes condition
+// false, except of absent().
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/expr"
+)
+
+var (
+	ErrInvalidRule   = errors.New("invalid alerting rule")
+	ErrDuplicateRule = errors.New("duplicate alerting rule")
+)
+
+// States of alerts.
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Period resolved alerts are listed for.
+const ResolvedRetention = 15 * time.Minute
+
+// Rule is alerting rule in format "<condition> [for <duration>]".
+type Rule struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	cond   *expr.Expr
+	Name   string        `json:"name"`
+	Expr   string        `json:"expr"`
+	Tenant string        `json:"tenant,omitempty"`
+	For    time.Duration `json:"-"`
+}
+
+// Compile parses rule expression and duration.
+func (r *Rule) Compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is not defined", ErrInvalidRule)
+	}
+
+	source := r.Expr
+	r.For = 0
+
+	if i := strings.LastIndex(source, " for "); i >= 0 {
+		d, err := time.ParseDuration(strings.TrimSpace(source[i+len(" for "):]))
+		if err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidRule, r.Name, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("%w %q: negative duration", ErrInvalidRule, r.Name)
+		}
+
+		source, r.For = source[:i], d
+	}
+
+	cond, err := expr.Parse(source)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidRule, r.Name, err)
+	}
+	r.cond = cond
+
+	return nil
+}
+
+// Alert is state of rule of tenant. Value is the latest value of left side of condition while it is true.
+// Zero times are not reached yet.
+type Alert struct {
+	ActiveAt   time.Time         `json:"active_at"`
+	FiredAt    time.Time         `json:"fired_at"`
+	ResolvedAt time.Time         `json:"resolved_at"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Rule       string            `json:"rule"`
+	Tenant     string            `json:"tenant,omitempty"`
+	State      string            `json:"state"`
+	Expr       string            `json:"expr"`
+	Value      float64           `json:"value"`
+}
+
+type key struct {
+	tenant string
+	rule   string
+}
+
+// Engine evaluates rules and keeps states of their alerts. Is concurrent-safe.
+type Engine struct {
+	alerts map[key]*Alert
+	rules  []Rule
+	mu     sync.RWMutex
+}
+
+// NewEngine compiles rules. Names of rules must be unique within tenant.
+func NewEngine(rules []Rule) (*Engine, error) {
+	e := &Engine{
+		alerts: map[key]*Alert{},
+		rules:  make([]Rule, len(rules)),
+	}
+
+	names := map[key]bool{}
+
+	for i := range rules {
+		e.rules[i] = rules[i]
+		if err := e.rules[i].Compile(); err != nil {
+			return nil, err
+		}
+
+		k := key{tenant: rules[i].Tenant, rule: rules[i].Name}
+		if names[k] {
+			return nil, fmt.Errorf("%w %q", ErrDuplicateRule, rules[i].Name)
+		}
+		names[k] = true
+	}
+
+	return e, nil
+}
+
+// Restore sets states of alerts, e.g. persisted before restart. Alerts of unknown rules are ignored.
+func (e *Engine) Restore(alerts []Alert) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.rules {
+		for j := range alerts {
+			if alerts[j].Tenant != e.rules[i].Tenant || alerts[j].Rule != e.rules[i].Name {
+				continue
+			}
+
+			a := alerts[j]
+			a.Labels = e.rules[i].Labels
+			a.Expr = e.rules[i].Expr
+			e.alerts[key{tenant: a.Tenant, rule: a.Rule}] = &a
+		}
+	}
+}
+
+// Eval evaluates every rule by data given by env of rule tenant. Gives alerts which changed their states
+// and errors of rules which couldn't be evaluated. States of failed rules are kept.
+func (e *Engine) Eval(now time.Time, env func(tenant string) expr.Env) ([]Alert, []error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changed := []Alert{}
+	errs := []error{}
+
+	for i := range e.rules {
+		r := &e.rules[i]
+		k := key{tenant: r.Tenant, rule: r.Name}
+
+		value, err := r.cond.Eval(env(r.Tenant))
+		if err != nil && !errors.Is(err, expr.ErrNoData) {
+			errs = append(errs, fmt.Errorf("rule %q: %w", r.Name, err))
+			continue
+		}
+		active := err == nil && value != 0
+
+		if active {
+			if subject, err := r.cond.Subject().Eval(env(r.Tenant)); err == nil {
+				value = subject
+			}
+		}
+
+		a, ok := e.alerts[k]
+		if ok && a.State == StateResolved {
+			if !active && now.Sub(a.ResolvedAt) > ResolvedRetention {
+				delete(e.alerts, k)
+			}
+			ok = !active
+		}
+
+		switch {
+		case active && !ok:
+			a = &Alert{
+				Labels:   r.Labels,
+				Rule:     r.Name,
+				Tenant:   r.Tenant,
+				Expr:     r.Expr,
+				State:    StatePending,
+				ActiveAt: now,
+				Value:    value,
+			}
+			e.alerts[k] = a
+
+			if r.For == 0 {
+				a.State, a.FiredAt = StateFiring, now
+			}
+			changed = append(changed, *a)
+		case active:
+			a.Value = value
+
+			if a.State == StatePending && now.Sub(a.ActiveAt) >= r.For {
+				a.State, a.FiredAt = StateFiring, now
+				changed = append(changed, *a)
+			}
+		case ok && a.State == StateFiring:
+			a.State, a.ResolvedAt = StateResolved, now
+			changed = append(changed, *a)
+		case ok && a.State == StatePending:
+			delete(e.alerts, k)
+		}
+	}
+
+	return changed, errs
+}
+
+// Alerts gives alerts of all tenants sorted by tenant and rule.
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		alerts = append(alerts, *a)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Tenant != alerts[j].Tenant {
+			return alerts[i].Tenant < alerts[j].Tenant
+		}
+		return alerts[i].Rule < alerts[j].Rule
+	})
+
+	return alerts
+}
@@ -0,0 +1,148 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/expr"
+	"github.com/stretchr/testify/assert"
+)
+
+// testEnv keeps values and rates of metrics in maps.
+type testEnv struct {
+	values map[string]float64
+	rates  map[string]float64
+}
+
+func (e testEnv) Value(id string) (float64, error) {
+	v, ok := e.values[id]
+	if !ok {
+		return 0, expr.ErrNoData
+	}
+
+	return v, nil
+}
+
+func (e testEnv) Rate(id string) (float64, error) {
+	v, ok := e.rates[id]
+	if !ok {
+		return 0, expr.ErrNoData
+	}
+
+	return v, nil
+}
+
+func states(alerts []Alert) map[string]string {
+	res := map[string]string{}
+	for _, a := range alerts {
+		res[a.Rule] = a.State
+	}
+
+	return res
+}
+
+func Test_Compile(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Rule          Rule
+		ExpectedFor   time.Duration
+		ExpectedError bool
+	}{
+		{Name: "with duration", Rule: Rule{Name: "heap", Expr: "HeapAlloc > 1e9 for 2m"}, ExpectedFor: 2 * time.Minute},
+		{Name: "without duration", Rule: Rule{Name: "absent", Expr: "absent(CPUutilization1)"}},
+		{Name: "missing name", Rule: Rule{Expr: "1"}, ExpectedError: true},
+		{Name: "invalid duration", Rule: Rule{Name: "heap", Expr: "HeapAlloc > 1 for ever"}, ExpectedError: true},
+		{Name: "invalid condition", Rule: Rule{Name: "heap", Expr: "HeapAlloc > for 1m"}, ExpectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := tt.Rule.Compile()
+			if tt.ExpectedError {
+				assert.ErrorIs(t, err, ErrInvalidRule)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ExpectedFor, tt.Rule.For)
+		})
+	}
+}
+
+func Test_EngineStates(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{Name: "heap", Expr: "HeapAlloc > 1e9 for 2m", Labels: map[string]string{"severity": "page"}},
+		{Name: "stuck", Expr: "rate(PollCount) == 0 for 1m"},
+		{Name: "absent", Expr: "absent(CPUutilization1)"},
+		{Name: "broken", Expr: "HeapAlloc / Zero"},
+	})
+	assert.NoError(t, err)
+
+	env := testEnv{
+		values: map[string]float64{"HeapAlloc": 2e9, "Zero": 0},
+		rates:  map[string]float64{"PollCount": 0},
+	}
+	envOf := func(string) expr.Env { return env }
+
+	start := time.Now()
+
+	changed, errs := e.Eval(start, envOf)
+	assert.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], expr.ErrDivisionByZero)
+	assert.Equal(t, map[string]string{"heap": StatePending, "stuck": StatePending, "absent": StateFiring}, states(changed))
+
+	changed, _ = e.Eval(start.Add(time.Minute), envOf)
+	assert.Equal(t, map[string]string{"stuck": StateFiring}, states(changed))
+
+	env.values["HeapAlloc"] = 1
+	env.values["CPUutilization1"] = 5
+	changed, _ = e.Eval(start.Add(2*time.Minute), envOf)
+	assert.Equal(t, map[string]string{"absent": StateResolved}, states(changed))
+	assert.Equal(t, map[string]string{"stuck": StateFiring, "absent": StateResolved}, states(e.Alerts()))
+
+	delete(env.rates, "PollCount")
+	changed, _ = e.Eval(start.Add(3*time.Minute), envOf)
+	assert.Equal(t, map[string]string{"stuck": StateResolved}, states(changed))
+
+	changed, _ = e.Eval(start.Add(3*time.Minute+ResolvedRetention), envOf)
+	assert.Empty(t, changed)
+	assert.Equal(t, map[string]string{"stuck": StateResolved}, states(e.Alerts()))
+
+	env.values["HeapAlloc"] = 2e9
+	changed, _ = e.Eval(start.Add(4*time.Minute+ResolvedRetention), envOf)
+	assert.Equal(t, map[string]string{"heap": StatePending}, states(changed))
+	assert.Equal(t, map[string]string{"severity": "page"}, changed[0].Labels)
+	assert.Equal(t, 2e9, changed[0].Value)
+}
+
+func Test_EngineRestore(t *testing.T) {
+	rules := []Rule{{Name: "heap", Expr: "HeapAlloc > 1e9 for 2m", Tenant: "team"}}
+
+	e, err := NewEngine(rules)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	env := testEnv{values: map[string]float64{"HeapAlloc": 2e9}}
+	envOf := func(string) expr.Env { return env }
+
+	e.Eval(start, envOf)
+	e.Eval(start.Add(2*time.Minute), envOf)
+	persisted := e.Alerts()
+	assert.Equal(t, StateFiring, persisted[0].State)
+
+	restarted, err := NewEngine(rules)
+	assert.NoError(t, err)
+	restarted.Restore(append(persisted, Alert{Rule: "removed", State: StateFiring}))
+
+	changed, _ := restarted.Eval(start.Add(3*time.Minute), envOf)
+	assert.Empty(t, changed)
+	assert.Equal(t, persisted, restarted.Alerts())
+}
+
+func Test_NewEngineDuplicates(t *testing.T) {
+	_, err := NewEngine([]Rule{{Name: "heap", Expr: "1"}, {Name: "heap", Expr: "2"}})
+	assert.ErrorIs(t, err, ErrDuplicateRule)
+
+	_, err = NewEngine([]Rule{{Name: "heap", Expr: "1"}, {Name: "heap", Expr: "2", Tenant: "team"}})
+	assert.NoError(t, err)
+}
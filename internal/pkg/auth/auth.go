@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrTokenMissing      = errors.New("bearer token is missing")
+	ErrTokenUnknown      = errors.New("bearer token is unknown")
+	ErrTokenInvalid      = errors.New("token definition is invalid")
+	ErrScopeUnknown      = errors.New("token scope is unknown")
+	ErrScopeDenied       = errors.New("token scope doesn't allow this action")
+	ErrPrefixDenied      = errors.New("token is not allowed to write metric with this name")
+	ErrRequestsExhausted = errors.New("token requests budget is exhausted")
+	ErrMetricsExhausted  = errors.New("token metrics budget is exhausted")
+)
+
+// Supported token scopes.
+const (
+	// Allows to read metrics.
+	ScopeRead = "read"
+
+	// Allows to read metrics and to update metrics with names starting with token WritePrefix.
+	ScopeWrite = "write"
+
+	// Allows everything including deletes.
+	ScopeAdmin = "admin"
+)
+
+const bearerPrefix = "Bearer "
+
+type contextKey struct{}
+
+// Token describes bearer token issued for a team.
+type Token struct {
+	// Human readable token owner, used in logs.
+	Name string `json:"name"`
+
+	// Secret sent by clients in "Authorization: Bearer <token>" header.
+	Token string `json:"token"`
+
+	// If is not empty, write scope allows to update only metrics which names start with it.
+	WritePrefix string `json:"write_prefix"`
+
+	// If is not empty, token works only with metrics of this tenant.
+	Tenant string `json:"tenant"`
+
+	// List of scopes: read, write or admin.
+	Scopes []string `json:"scopes"`
+
+	// Maximal amount of requests per second. Zero means unlimited.
+	RequestsPerSecond float64 `json:"requests_per_second"`
+
+	// Maximal amount of updated metrics per second. Zero means unlimited. Larger batch is allowed
+	// after a second without updates and delays the following ones.
+	MetricsPerSecond float64 `json:"metrics_per_second"`
+}
+
+// Checks if token definition is consistent.
+func (t *Token) Validate() error {
+	if t.Token == "" || t.RequestsPerSecond < 0 || t.MetricsPerSecond < 0 {
+		return ErrTokenInvalid
+	}
+
+	for _, s := range t.Scopes {
+		if s != ScopeRead && s != ScopeWrite && s != ScopeAdmin {
+			return ErrScopeUnknown
+		}
+	}
+
+	return nil
+}
+
+// Checks if token has given scope. Admin scope includes write, and write includes read.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		switch {
+		case s == scope,
+			s == ScopeAdmin,
+			s == ScopeWrite && scope == ScopeRead:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks if token allows to update metric with given name.
+func (t *Token) CanWrite(id string) bool {
+	if !t.HasScope(ScopeWrite) {
+		return false
+	}
+
+	if t.HasScope(ScopeAdmin) {
+		return true
+	}
+
+	return strings.HasPrefix(id, t.WritePrefix)
+}
+
+// Authenticator checks bearer tokens and enforces their scopes and budgets.
+// If no tokens are defined, authentication is turned off.
+type Authenticator struct {
+	tokens map[string]*issuedToken
+}
+
+type issuedToken struct {
+	requests, metrics *bucket
+	Token
+}
+
+// Constructor. Returns error if any token is invalid or duplicated.
+func NewAuthenticator(tokens []Token) (*Authenticator, error) {
+	a := &Authenticator{
+		tokens: map[string]*issuedToken{},
+	}
+
+	for i := range tokens {
+		if err := tokens[i].Validate(); err != nil {
+			return nil, err
+		}
+
+		if _, ok := a.tokens[tokens[i].Token]; ok {
+			return nil, ErrTokenInvalid
+		}
+
+		a.tokens[tokens[i].Token] = &issuedToken{
+			Token:    tokens[i],
+			requests: newBucket(tokens[i].RequestsPerSecond),
+			metrics:  newBucket(tokens[i].MetricsPerSecond),
+		}
+	}
+
+	return a, nil
+}
+
+// Checks if any token is defined.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && len(a.tokens) != 0
+}
+
+// Authorize gives middleware component which passes only requests with known token having given scope.
+// Token is put into request context for further checks of write prefix and metrics budget.
+func (a *Authenticator) Authorize(scope string) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.Enabled() {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, bearerPrefix) {
+				log.Println(ErrTokenMissing)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, ErrTokenMissing.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			token, ok := a.tokens[strings.TrimPrefix(header, bearerPrefix)]
+			if !ok {
+				log.Println(ErrTokenUnknown)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, ErrTokenUnknown.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if !token.HasScope(scope) {
+				log.Println(token.Name, ErrScopeDenied)
+				http.Error(w, ErrScopeDenied.Error(), http.StatusForbidden)
+				return
+			}
+
+			if !token.requests.take(1) {
+				log.Println(token.Name, ErrRequestsExhausted)
+				http.Error(w, ErrRequestsExhausted.Error(), http.StatusTooManyRequests)
+				return
+			}
+
+			handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, token)))
+		})
+	}
+}
+
+// Returns token which authorized the request. Returns false if authentication is turned off.
+func FromContext(ctx context.Context) (*Token, bool) {
+	token, ok := ctx.Value(contextKey{}).(*issuedToken)
+	if !ok {
+		return nil, false
+	}
+
+	return &token.Token, true
+}
+
+// Checks if request token allows to update metric with given name.
+func CheckWrite(ctx context.Context, id string) error {
+	token, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	if !token.CanWrite(id) {
+		return ErrPrefixDenied
+	}
+
+	return nil
+}
+
+// Takes given amount of metrics from request token budget.
+func AllowMetrics(ctx context.Context, n int) error {
+	token, ok := ctx.Value(contextKey{}).(*issuedToken)
+	if !ok {
+		return nil
+	}
+
+	if !token.metrics.take(float64(n)) {
+		return ErrMetricsExhausted
+	}
+
+	return nil
+}
+
+// bucket implements token bucket rate limiter. Capacity equals to one second of rate.
+// Full bucket may be overdrawn, so amount larger than capacity is allowed once per its refill time.
+type bucket struct {
+	last          time.Time
+	rate, current float64
+	sync.Mutex
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{
+		rate:    rate,
+		current: math.Max(rate, 1),
+		last:    time.Now(),
+	}
+}
+
+// take removes n items from bucket. Returns false if there is not enough items and bucket isn't full.
+// Zero rate means unlimited bucket.
+func (b *bucket) take(n float64) bool {
+	if b.rate == 0 {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	capacity := math.Max(b.rate, 1)
+
+	now := time.Now()
+	b.current = math.Min(capacity, b.current+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.current < n && b.current < capacity {
+		return false
+	}
+
+	b.current -= n
+
+	return true
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewAuthenticator(t *testing.T) {
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Tokens        []Token
+	}{
+		{
+			Name:          "no tokens",
+			Tokens:        nil,
+			ExpectedError: nil,
+		},
+		{
+			Name:          "valid tokens",
+			Tokens:        []Token{{Token: "a", Scopes: []string{ScopeRead}}, {Token: "b", Scopes: []string{ScopeAdmin}}},
+			ExpectedError: nil,
+		},
+		{
+			Name:          "empty token",
+			Tokens:        []Token{{Scopes: []string{ScopeRead}}},
+			ExpectedError: ErrTokenInvalid,
+		},
+		{
+			Name:          "duplicated token",
+			Tokens:        []Token{{Token: "a"}, {Token: "a"}},
+			ExpectedError: ErrTokenInvalid,
+		},
+		{
+			Name:          "unknown scope",
+			Tokens:        []Token{{Token: "a", Scopes: []string{"root"}}},
+			ExpectedError: ErrScopeUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := NewAuthenticator(tt.Tokens)
+			assert.ErrorIs(t, err, tt.ExpectedError)
+		})
+	}
+}
+
+func Test_Token(t *testing.T) {
+	reader := Token{Scopes: []string{ScopeRead}}
+	writer := Token{Scopes: []string{ScopeWrite}, WritePrefix: "team1."}
+	admin := Token{Scopes: []string{ScopeAdmin}, WritePrefix: "team1."}
+
+	assert.True(t, reader.HasScope(ScopeRead))
+	assert.False(t, reader.HasScope(ScopeWrite))
+	assert.False(t, reader.CanWrite("team1.Alloc"))
+
+	assert.True(t, writer.HasScope(ScopeRead))
+	assert.False(t, writer.HasScope(ScopeAdmin))
+	assert.True(t, writer.CanWrite("team1.Alloc"))
+	assert.False(t, writer.CanWrite("team2.Alloc"))
+
+	assert.True(t, admin.HasScope(ScopeWrite))
+	assert.True(t, admin.CanWrite("team2.Alloc"))
+}
+
+func Test_Authorize(t *testing.T) {
+	a, err := NewAuthenticator([]Token{
+		{Name: "dashboard", Token: "reader", Scopes: []string{ScopeRead}},
+		{Name: "team1", Token: "writer", Scopes: []string{ScopeWrite}, WritePrefix: "team1.", RequestsPerSecond: 1},
+	})
+	assert.NoError(t, err)
+
+	var authorized *Token
+	handler := a.Authorize(ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorized, _ = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		Name           string
+		Header         string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "missing token",
+			Header:         "",
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "unknown token",
+			Header:         "Bearer unknown",
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "insufficient scope",
+			Header:         "Bearer reader",
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Name:           "allowed",
+			Header:         "Bearer writer",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "requests budget exhausted",
+			Header:         "Bearer writer",
+			ExpectedStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/update/", nil)
+			if tt.Header != "" {
+				req.Header.Set("Authorization", tt.Header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+		})
+	}
+
+	assert.Equal(t, "team1", authorized.Name)
+}
+
+func Test_Authorize_disabled(t *testing.T) {
+	a, err := NewAuthenticator(nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	a.Authorize(ScopeAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rec, httptest.NewRequest("DELETE", "/value/gauge/Alloc", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, CheckWrite(context.Background(), "any"))
+	assert.NoError(t, AllowMetrics(context.Background(), 1000))
+}
+
+func Test_AllowMetrics(t *testing.T) {
+	token := &issuedToken{
+		Token:   Token{Token: "t", Scopes: []string{ScopeWrite}, MetricsPerSecond: 10},
+		metrics: newBucket(10),
+	}
+	ctx := context.WithValue(context.Background(), contextKey{}, token)
+
+	assert.NoError(t, AllowMetrics(ctx, 8))
+	assert.ErrorIs(t, AllowMetrics(ctx, 8), ErrMetricsExhausted)
+	assert.ErrorIs(t, AllowMetrics(ctx, 100), ErrMetricsExhausted)
+}
+
+func Test_AllowMetrics_largeBatch(t *testing.T) {
+	token := &issuedToken{
+		Token:   Token{Token: "t", Scopes: []string{ScopeWrite}, MetricsPerSecond: 1000},
+		metrics: newBucket(1000),
+	}
+	ctx := context.WithValue(context.Background(), contextKey{}, token)
+
+	// Batch larger than rate is allowed as soon as bucket is full and its excess is paid by the following requests.
+	assert.NoError(t, AllowMetrics(ctx, 500))
+	assert.ErrorIs(t, AllowMetrics(ctx, 1500), ErrMetricsExhausted)
+
+	assert.Eventually(t, func() bool {
+		return AllowMetrics(ctx, 1500) == nil
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.ErrorIs(t, AllowMetrics(ctx, 1), ErrMetricsExhausted)
+}
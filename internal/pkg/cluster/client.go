@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+)
+
+const (
+	// Header defining tenant of internal requests.
+	TenantHeader = "X-Tenant"
+
+	DefaultTimeout = 10 * time.Second
+)
+
+// ItemError is error of metric of batch rejected by owner.
+type ItemError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Index   int    `json:"index"`
+	Status  int    `json:"status"`
+}
+
+// Response is body of response to internal updates.
+type Response struct {
+	Errors   []ItemError `json:"errors,omitempty"`
+	Accepted int         `json:"accepted"`
+}
+
+// RemoteError is error given by node. Status and Code are status and error code of its response.
+type RemoteError struct {
+	Node    string
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("node %q: %s", e.Node, e.Message)
+}
+
+// Client makes internal requests to nodes. Requests are signed by key if it is not empty.
+type Client struct {
+	client  *http.Client
+	headers map[string]string
+	self    string
+	key     string
+}
+
+// Constructor. Self is name of requesting node, headers are added to requests, e.g. authorization.
+func NewClient(self, key string, headers map[string]string) *Client {
+	return &Client{
+		client:  &http.Client{Timeout: DefaultTimeout},
+		headers: headers,
+		self:    self,
+		key:     key,
+	}
+}
+
+// Update sends metrics of tenant to owner. Gives errors of metrics by their indexes in batch. If move is set,
+// owner keeps gauges it has already, e.g. when metrics are moved by rebalance, since they are newer.
+func (c *Client) Update(ctx context.Context, node Node, tenant string, batch []*metric.Metric, move bool) ([]error, error) {
+	endpoint := strings.TrimSuffix(node.URL, "/") + UpdatesPath
+	if move {
+		endpoint += "?move=1"
+	}
+
+	res := Response{}
+	if err := c.do(ctx, http.MethodPost, endpoint, node, tenant, batch, &res); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(batch))
+	for _, ie := range res.Errors {
+		if ie.Index < 0 || ie.Index >= len(batch) {
+			return nil, fmt.Errorf("%w: node %q gave error of metric %d of %d", ErrNodeUnavailable, node.Name, ie.Index, len(batch))
+		}
+
+		errs[ie.Index] = &RemoteError{Node: node.Name, Code: ie.Code, Message: ie.Message, Status: ie.Status}
+	}
+
+	return errs, nil
+}
+
+// Values gives stored metrics of tenant by their names. Missing names are skipped.
+func (c *Client) Values(ctx context.Context, node Node, tenant string, ids []string) ([]*metric.Metric, error) {
+	batch := []*metric.Metric{}
+	if err := c.do(ctx, http.MethodPost, strings.TrimSuffix(node.URL, "/")+ValuesPath, node, tenant, ids, &batch); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// SetNodes passes membership of cluster to node.
+func (c *Client) SetNodes(ctx context.Context, node Node, nodes []Node) error {
+	return c.do(ctx, http.MethodPut, strings.TrimSuffix(node.URL, "/")+NodesPath, node, "", nodes, nil)
+}
+
+// do makes request with json body and decodes json response into res.
+func (c *Client) do(ctx context.Context, method, endpoint string, node Node, tenant string, body, res interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(NodeHeader, c.self)
+	if tenant != "" {
+		req.Header.Set(TenantHeader, tenant)
+	}
+
+	if c.key != "" {
+		if err := signer.SignRequest(req, c.key, data); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrNodeUnavailable, node.Name, err)
+	}
+
+	defer func() {
+		if errBodyClose := resp.Body.Close(); errBodyClose != nil {
+			log.Println(errBodyClose)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrNodeUnavailable, node.Name, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(node, resp.StatusCode, respBody)
+	}
+
+	if res == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, res); err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrNodeUnavailable, node.Name, err)
+	}
+
+	return nil
+}
+
+// responseError gives error of unsuccessful response. Errors in json envelope of server are given as they are,
+// other server errors mean that node is unavailable.
+func responseError(node Node, status int, body []byte) error {
+	envelope := struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error.Code != "" {
+		return &RemoteError{Node: node.Name, Code: envelope.Error.Code, Message: envelope.Error.Message, Status: status}
+	}
+
+	if status >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: %q: status %d: %s", ErrNodeUnavailable, node.Name, status, strings.TrimSpace(string(body)))
+	}
+
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+
+	return &RemoteError{Node: node.Name, Code: code, Message: strings.TrimSpace(string(body)), Status: status}
+}
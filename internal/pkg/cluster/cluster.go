@@ -0,0 +1,152 @@
+// Package cluster shards metrics between servers of static membership by consistent hashing.
+//
+// Every metric of tenant is owned by one node of ring. Nodes pass metrics they don't own to owners
+// by internal requests, and changing membership moves only metrics whose owners changed.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrInvalidNode     = errors.New("invalid cluster node")
+	ErrNodeUnavailable = errors.New("cluster node is unavailable")
+)
+
+const (
+	// Header of internal requests naming node they came from. Requests with it are served by receiving node
+	// and never passed further.
+	NodeHeader = "X-Cluster-Node"
+
+	// Paths of internal endpoints.
+	UpdatesPath = "/cluster/updates"
+	ValuesPath  = "/cluster/values"
+	NodesPath   = "/cluster/nodes"
+
+	// Number of ring points of every node. More points spread metrics more evenly.
+	DefaultPoints = 128
+)
+
+// Node is server of cluster. URL is its base URL, e.g. "http://node1:8080".
+type Node struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Validate checks node.
+func (n *Node) Validate() error {
+	if n.Name == "" {
+		return fmt.Errorf("%w: name is not defined", ErrInvalidNode)
+	}
+
+	if u, err := url.Parse(n.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%w %q: invalid url %q", ErrInvalidNode, n.Name, n.URL)
+	}
+
+	return nil
+}
+
+// Ring maps metrics to nodes by consistent hashing. Is immutable, so it is safe for concurrent use.
+type Ring struct {
+	nodes  []Node
+	points []uint64
+	owners []int
+}
+
+// NewRing makes ring of nodes. Nodes must have unique names.
+func NewRing(nodes []Node) (*Ring, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%w: cluster has no nodes", ErrInvalidNode)
+	}
+
+	names := map[string]struct{}{}
+	for i := range nodes {
+		if err := nodes[i].Validate(); err != nil {
+			return nil, err
+		}
+
+		if _, ok := names[nodes[i].Name]; ok {
+			return nil, fmt.Errorf("%w: name %q is repeated", ErrInvalidNode, nodes[i].Name)
+		}
+		names[nodes[i].Name] = struct{}{}
+	}
+
+	r := &Ring{
+		nodes:  append([]Node{}, nodes...),
+		points: make([]uint64, 0, len(nodes)*DefaultPoints),
+		owners: make([]int, 0, len(nodes)*DefaultPoints),
+	}
+
+	type point struct {
+		hash  uint64
+		owner int
+	}
+
+	points := make([]point, 0, len(nodes)*DefaultPoints)
+	for i := range nodes {
+		for j := 0; j < DefaultPoints; j++ {
+			points = append(points, point{hash: hash(nodes[i].Name + "#" + strconv.Itoa(j)), owner: i})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+
+	return r, nil
+}
+
+// Nodes gives nodes of ring.
+func (r *Ring) Nodes() []Node {
+	return append([]Node{}, r.nodes...)
+}
+
+// Node gives node by name.
+func (r *Ring) Node(name string) (Node, bool) {
+	for _, n := range r.nodes {
+		if n.Name == name {
+			return n, true
+		}
+	}
+
+	return Node{}, false
+}
+
+// Owner gives node owning metric of tenant.
+func (r *Ring) Owner(tenant, id string) Node {
+	h := hash(tenant + "\x00" + id)
+
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.nodes[r.owners[i]]
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	// Finalizer of splitmix64 spreads close FNV values over the ring.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+func nodes(names ...string) []Node {
+	res := make([]Node, 0, len(names))
+	for _, name := range names {
+		res = append(res, Node{Name: name, URL: "http://" + name + ":8080"})
+	}
+
+	return res
+}
+
+func Test_NewRing(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Nodes []Node
+	}{
+		{Name: "without nodes"},
+		{Name: "repeated name", Nodes: append(nodes("a"), nodes("a")...)},
+		{Name: "invalid url", Nodes: []Node{{Name: "a", URL: "a:8080"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := NewRing(tt.Nodes)
+			assert.ErrorIs(t, err, ErrInvalidNode)
+		})
+	}
+}
+
+func Test_RingOwner(t *testing.T) {
+	ring, err := NewRing(nodes("a", "b", "c"))
+	assert.NoError(t, err)
+
+	grown, err := NewRing(nodes("a", "b", "c", "d"))
+	assert.NoError(t, err)
+
+	const keys = 10000
+
+	owned := map[string]int{}
+	moved := 0
+
+	for i := 0; i < keys; i++ {
+		id := "metric" + strconv.Itoa(i)
+
+		owner := ring.Owner("", id)
+		owned[owner.Name]++
+		assert.Equal(t, owner, ring.Owner("", id))
+
+		// Added node takes metrics only from others, metrics never move between old nodes.
+		if newOwner := grown.Owner("", id); newOwner != owner {
+			assert.Equal(t, "d", newOwner.Name)
+			moved++
+		}
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		assert.InDelta(t, keys/3, owned[name], keys/10, name)
+	}
+	assert.InDelta(t, keys/4, moved, keys/10)
+
+	node, ok := ring.Node("b")
+	assert.True(t, ok)
+	assert.Equal(t, "http://b:8080", node.URL)
+}
+
+func Test_ClientUpdate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "a", r.Header.Get(NodeHeader))
+		assert.Equal(t, "Bearer cluster", r.Header.Get("Authorization"))
+		assert.NotEmpty(t, r.Header.Get(signer.HeaderSignature))
+
+		switch r.URL.Path {
+		case UpdatesPath:
+			assert.Equal(t, "team", r.Header.Get(TenantHeader))
+			assert.Equal(t, "1", r.URL.Query().Get("move"))
+
+			batch := []*metric.Metric{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+
+			body, _ := json.Marshal(Response{
+				Accepted: len(batch) - 1,
+				Errors:   []ItemError{{Index: 1, Status: http.StatusTooManyRequests, Code: "quota_exceeded", Message: "quota"}},
+			})
+			w.Write(body)
+		case ValuesPath:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":"forbidden","message":"access denied"}}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewClient("a", "secret", map[string]string{"Authorization": "Bearer cluster"})
+	node := Node{Name: "b", URL: ts.URL}
+
+	delta := int64(1)
+	errs, err := c.Update(context.Background(), node, "team", []*metric.Metric{
+		{ID: "a", MType: "counter", Delta: &delta},
+		{ID: "b", MType: "counter", Delta: &delta},
+	}, true)
+	assert.NoError(t, err)
+	if assert.Len(t, errs, 2) {
+		assert.NoError(t, errs[0])
+
+		re := &RemoteError{}
+		if assert.True(t, errors.As(errs[1], &re)) {
+			assert.Equal(t, "quota_exceeded", re.Code)
+			assert.Equal(t, http.StatusTooManyRequests, re.Status)
+		}
+	}
+
+	_, err = c.Values(context.Background(), node, "team", []string{"a"})
+	re := &RemoteError{}
+	if assert.True(t, errors.As(err, &re)) {
+		assert.Equal(t, "forbidden", re.Code)
+	}
+
+	assert.ErrorIs(t, c.SetNodes(context.Background(), node, nil), ErrNodeUnavailable)
+}
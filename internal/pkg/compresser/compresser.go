@@ -18,6 +18,19 @@ func (w customWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends compressed data written so far to client, e.g. for streaming responses.
+func (w customWriter) Flush() {
+	if f, ok := w.Writer.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // Middleware component for handling gzip-encoded requests.
 func Compresser(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
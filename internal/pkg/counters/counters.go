@@ -0,0 +1,118 @@
+// Package counters tracks updates of counters to compute their rates and detect resets.
+//
+// Reporting process could define its epoch, which is Unix time in milliseconds it started at.
+// Change of epoch means the process was restarted, so its counter started from zero again.
+package counters
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the latest update of counter. Rate is per-second increase between the previous and the latest
+// updates, it is nil until counter was updated twice. If counter was reset, increase is counted from
+// the start of new process.
+type State struct {
+	At          time.Time `json:"at"`
+	PrevAt      time.Time `json:"prev_at,omitempty"`
+	LastResetAt time.Time `json:"last_reset_at,omitempty"`
+	Rate        *float64  `json:"rate,omitempty"`
+	Value       float64   `json:"value"`
+	Prev        float64   `json:"prev"`
+	Epoch       int64     `json:"epoch,omitempty"`
+	Resets      int64     `json:"resets"`
+}
+
+type key struct {
+	tenant string
+	id     string
+}
+
+// Tracker keeps the latest states of counters of every tenant. Is concurrent-safe.
+type Tracker struct {
+	states map[key]*State
+	mu     sync.RWMutex
+}
+
+// Constructor.
+func New() *Tracker {
+	return &Tracker{
+		states: map[key]*State{},
+	}
+}
+
+// Observe records accumulated value of counter updated at given time by process of given epoch.
+// Zero epoch is unknown one, it doesn't reset counter. Nil tracker does nothing.
+func (t *Tracker) Observe(tenant, id string, value float64, epoch int64, now time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k := key{tenant: tenant, id: id}
+
+	s, ok := t.states[k]
+	if !ok {
+		t.states[k] = &State{At: now, Value: value, Epoch: epoch}
+		return
+	}
+
+	since := s.At
+	if epoch != 0 && s.Epoch != 0 && epoch != s.Epoch {
+		s.Resets++
+		s.LastResetAt = now
+
+		if start := time.UnixMilli(epoch); start.After(since) && start.Before(now) {
+			since = start
+		}
+	}
+	if epoch != 0 {
+		s.Epoch = epoch
+	}
+
+	s.Prev, s.PrevAt = s.Value, s.At
+	s.Value, s.At = value, now
+
+	s.Rate = nil
+	if elapsed := now.Sub(since).Seconds(); elapsed > 0 {
+		rate := (s.Value - s.Prev) / elapsed
+		s.Rate = &rate
+	}
+}
+
+// Get gives copy of the latest state of counter. Nil tracker has no states.
+func (t *Tracker) Get(tenant, id string) (State, bool) {
+	if t == nil {
+		return State{}, false
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	s, ok := t.states[key{tenant: tenant, id: id}]
+	if !ok {
+		return State{}, false
+	}
+
+	res := *s
+	if s.Rate != nil {
+		rate := *s.Rate
+		res.Rate = &rate
+	}
+
+	return res, true
+}
+
+// Forget removes state of counter, e.g. deleted one. Nil tracker does nothing.
+func (t *Tracker) Forget(tenant, id string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.states, key{tenant: tenant, id: id})
+}
@@ -0,0 +1,116 @@
+package counters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// update is counter update at given second since start.
+type update struct {
+	Second int
+	Value  float64
+	Epoch  int64
+}
+
+func Test_Observe(t *testing.T) {
+	start := time.UnixMilli(1_700_000_000_000)
+	epoch := start.UnixMilli()
+
+	tests := []struct {
+		ExpectedRate   *float64
+		Name           string
+		Updates        []update
+		ExpectedPrev   float64
+		ExpectedResets int64
+	}{
+		{
+			Name:    "single update",
+			Updates: []update{{Second: 0, Value: 10, Epoch: epoch}},
+		},
+		{
+			Name:         "steady increase",
+			Updates:      []update{{Second: 0, Value: 10, Epoch: epoch}, {Second: 10, Value: 30, Epoch: epoch}},
+			ExpectedRate: rate(2),
+			ExpectedPrev: 10,
+		},
+		{
+			Name: "reset counted from start of new process",
+			Updates: []update{
+				{Second: 0, Value: 10, Epoch: epoch},
+				{Second: 20, Value: 20, Epoch: epoch + 15_000},
+			},
+			ExpectedRate:   rate(2),
+			ExpectedPrev:   10,
+			ExpectedResets: 1,
+		},
+		{
+			Name: "reset with unknown start",
+			Updates: []update{
+				{Second: 0, Value: 10, Epoch: 2},
+				{Second: 20, Value: 20, Epoch: 1},
+			},
+			ExpectedRate:   rate(0.5),
+			ExpectedPrev:   10,
+			ExpectedResets: 1,
+		},
+		{
+			Name: "unknown epoch doesn't reset",
+			Updates: []update{
+				{Second: 0, Value: 10, Epoch: epoch},
+				{Second: 10, Value: 20},
+				{Second: 20, Value: 40, Epoch: epoch},
+			},
+			ExpectedRate: rate(2),
+			ExpectedPrev: 20,
+		},
+		{
+			Name:         "simultaneous updates",
+			Updates:      []update{{Second: 0, Value: 10}, {Second: 0, Value: 20}},
+			ExpectedPrev: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			tr := New()
+			for _, u := range tt.Updates {
+				tr.Observe("team", "PollCount", u.Value, u.Epoch, start.Add(time.Duration(u.Second)*time.Second))
+			}
+
+			s, ok := tr.Get("team", "PollCount")
+			assert.True(t, ok)
+			assert.Equal(t, tt.ExpectedRate, s.Rate)
+			assert.Equal(t, tt.ExpectedPrev, s.Prev)
+			assert.Equal(t, tt.ExpectedResets, s.Resets)
+			assert.Equal(t, tt.Updates[len(tt.Updates)-1].Value, s.Value)
+		})
+	}
+}
+
+func Test_TrackerTenantsAndForget(t *testing.T) {
+	tr := New()
+	now := time.Now()
+
+	tr.Observe("", "PollCount", 1, 0, now)
+	tr.Observe("team", "PollCount", 2, 0, now)
+
+	s, ok := tr.Get("", "PollCount")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, s.Value)
+
+	tr.Forget("team", "PollCount")
+	_, ok = tr.Get("team", "PollCount")
+	assert.False(t, ok)
+
+	var disabled *Tracker
+	disabled.Observe("", "PollCount", 1, 0, now)
+	_, ok = disabled.Get("", "PollCount")
+	assert.False(t, ok)
+	disabled.Forget("", "PollCount")
+}
+
+func rate(v float64) *float64 {
+	return &v
+}
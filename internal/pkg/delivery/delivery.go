@@ -0,0 +1,90 @@
+// Package delivery posts requests to remote targets and retries failed attempts with exponential backoff.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+)
+
+// Backoff is doubled after every failed attempt, but isn't longer than this.
+const MaxBackoff = time.Minute
+
+// Retry makes attempts until one of them succeeds, fails without retry or retries are exhausted.
+// Negative retries turn retries off. Backoff between attempts is waited by wait, which gives false to stop retrying.
+// Error of the last attempt is given.
+func Retry(name string, retries int, backoff time.Duration, wait func(time.Duration) bool, attempt func() (bool, error)) error {
+	for n := 0; ; n++ {
+		retry, err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		if !retry || n >= retries || !wait(backoff) {
+			return err
+		}
+
+		log.Println(fmt.Errorf("target %q, attempt %d: %w", name, n+1, err))
+
+		if backoff *= 2; backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+}
+
+// Post makes one attempt of delivery, which is cancelled with ctx. Request is signed by key if it is not empty.
+// Gives if failed attempt could be retried. Client errors except of 429 aren't retried.
+func Post(ctx context.Context, client *http.Client, url, contentType string, headers map[string]string, body []byte, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	// Receivers verify signature by path of received request, which is never empty.
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if key != "" {
+		if err := signer.SignRequest(req, key, body); err != nil {
+			return false, err
+		}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	defer func() {
+		if errBodyClose := res.Body.Close(); errBodyClose != nil {
+			log.Println(errBodyClose)
+		}
+	}()
+
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		log.Println(err)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+
+	return retry, errors.New("unexpected status " + strconv.Itoa(res.StatusCode))
+}
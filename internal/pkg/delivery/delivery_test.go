@@ -0,0 +1,101 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Retry(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		ExpectedError    error
+		Name             string
+		ExpectedBackoffs []time.Duration
+		Failures         int
+		Retries          int
+		ExpectedAttempts int
+		Retry            bool
+		Stop             bool
+	}{
+		{Name: "recovered", Failures: 2, Retries: 3, Retry: true, ExpectedAttempts: 3, ExpectedBackoffs: []time.Duration{time.Second, 2 * time.Second}},
+		{Name: "retries exhausted", Failures: 5, Retries: 1, Retry: true, ExpectedAttempts: 2, ExpectedBackoffs: []time.Duration{time.Second}, ExpectedError: errFailed},
+		{Name: "not retried", Failures: 1, Retries: 3, ExpectedAttempts: 1, ExpectedError: errFailed},
+		{Name: "retries off", Failures: 1, Retries: -1, Retry: true, ExpectedAttempts: 1, ExpectedError: errFailed},
+		{Name: "stopped", Failures: 1, Retries: 3, Retry: true, Stop: true, ExpectedAttempts: 1, ExpectedBackoffs: []time.Duration{time.Second}, ExpectedError: errFailed},
+		{Name: "backoff is limited", Failures: 8, Retries: 8, Retry: true, ExpectedAttempts: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			attempts := 0
+			backoffs := []time.Duration{}
+
+			err := Retry("target", tt.Retries, time.Second, func(d time.Duration) bool {
+				backoffs = append(backoffs, d)
+				return !tt.Stop
+			}, func() (bool, error) {
+				if attempts++; attempts <= tt.Failures {
+					return tt.Retry, errFailed
+				}
+				return false, nil
+			})
+
+			assert.ErrorIs(t, err, tt.ExpectedError)
+			assert.Equal(t, tt.ExpectedAttempts, attempts)
+
+			if tt.ExpectedBackoffs != nil {
+				assert.Equal(t, tt.ExpectedBackoffs, backoffs)
+			}
+			for _, d := range backoffs {
+				assert.LessOrEqual(t, d, MaxBackoff)
+			}
+		})
+	}
+}
+
+func Test_Post(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Status        int
+		ExpectedRetry bool
+		ExpectedError bool
+	}{
+		{Name: "delivered", Status: http.StatusNoContent},
+		{Name: "server error", Status: http.StatusBadGateway, ExpectedRetry: true, ExpectedError: true},
+		{Name: "rate limited", Status: http.StatusTooManyRequests, ExpectedRetry: true, ExpectedError: true},
+		{Name: "client error", Status: http.StatusBadRequest, ExpectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+				assert.Equal(t, "value", r.Header.Get("X-Header"))
+				assert.NotEmpty(t, r.Header.Get(signer.HeaderSignature))
+				w.WriteHeader(tt.Status)
+			}))
+			defer ts.Close()
+
+			retry, err := Post(context.Background(), ts.Client(), ts.URL, "text/plain", map[string]string{"X-Header": "value"}, []byte("body"), "key")
+			assert.Equal(t, tt.ExpectedRetry, retry)
+			assert.Equal(t, tt.ExpectedError, err != nil)
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+
+		retry, err := Post(context.Background(), ts.Client(), ts.URL, "text/plain", nil, nil, "")
+		assert.True(t, retry)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,252 @@
+// Package dump defines backend-neutral format of storage backups.
+//
+// Dump is gzip-compressed json lines. The first line is header with format version, the following lines are
+// records of metrics grouped by tenant, with their history if it is kept, and the last line is trailer with
+// number of records and checksum of their lines. Dump without valid trailer is treated as truncated.
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/history"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var (
+	ErrInvalidDump        = errors.New("invalid dump")
+	ErrUnsupportedVersion = errors.New("unsupported dump version")
+)
+
+const (
+	// Name of format in header.
+	Format = "metrics-dump"
+
+	// Version of format written by Writer. Reader accepts versions up to it.
+	Version = 1
+
+	ContentType = "application/gzip"
+
+	// Maximal size of dump line, i.e. of one metric with its history.
+	maxLineSize = 16 << 20
+)
+
+// Header is the first line of dump.
+type Header struct {
+	Created time.Time `json:"created"`
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+}
+
+// Record is metric of tenant with its samples from the oldest to the latest. Counters keep accumulated values.
+type Record struct {
+	Metric  *metric.Metric   `json:"metric"`
+	Tenant  string           `json:"tenant"`
+	History []history.Sample `json:"history,omitempty"`
+}
+
+// Trailer is the last line of dump. Checksum is hex-encoded sha256 of record lines.
+type Trailer struct {
+	Checksum string `json:"checksum"`
+	Records  int    `json:"records"`
+}
+
+// line is any line following header.
+type line struct {
+	End *Trailer `json:"end,omitempty"`
+	Record
+}
+
+// Writer writes dump. Close must be called to finish it.
+type Writer struct {
+	zw      *gzip.Writer
+	sum     hash.Hash
+	records int
+}
+
+// NewWriter starts dump and writes its header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	dw := &Writer{
+		zw:  gzip.NewWriter(w),
+		sum: sha256.New(),
+	}
+
+	if err := dw.writeLine(Header{Format: Format, Version: Version, Created: time.Now().UTC()}, false); err != nil {
+		return nil, err
+	}
+
+	return dw, nil
+}
+
+// Write adds record to dump. Hash of metric isn't kept, since it depends on key of server.
+func (w *Writer) Write(rec Record) error {
+	if rec.Metric == nil {
+		return fmt.Errorf("%w: record has no metric", ErrInvalidDump)
+	}
+
+	m := *rec.Metric
+	m.Hash = ""
+	rec.Metric = &m
+
+	if err := w.writeLine(line{Record: rec}, true); err != nil {
+		return err
+	}
+
+	w.records++
+
+	return nil
+}
+
+// Close writes trailer and flushes dump. Doesn't close underlying writer.
+func (w *Writer) Close() error {
+	if err := w.writeLine(line{End: &Trailer{Records: w.records, Checksum: hex.EncodeToString(w.sum.Sum(nil))}}, false); err != nil {
+		return err
+	}
+
+	return w.zw.Close()
+}
+
+// Records gives number of records written.
+func (w *Writer) Records() int {
+	return w.records
+}
+
+func (w *Writer) writeLine(v interface{}, summed bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if summed {
+		w.sum.Write(data)
+	}
+
+	_, err = w.zw.Write(data)
+
+	return err
+}
+
+// Reader reads dump and checks its integrity.
+type Reader struct {
+	br      *bufio.Reader
+	sum     hash.Hash
+	header  Header
+	records int
+	done    bool
+}
+
+// NewReader starts reading dump and checks its header. Dump may be given already decompressed,
+// e.g. if it was sent with Content-Encoding gzip.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	// Gzip streams start with magic bytes 0x1f 0x8b.
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	dr := &Reader{
+		br:  br,
+		sum: sha256.New(),
+	}
+
+	data, err := dr.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &dr.header); err != nil || dr.header.Format != Format {
+		return nil, fmt.Errorf("%w: invalid header", ErrInvalidDump)
+	}
+
+	if dr.header.Version < 1 || dr.header.Version > Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, dr.header.Version)
+	}
+
+	return dr, nil
+}
+
+// Header gives header of dump.
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next gives the next record. Gives io.EOF after trailer if dump is complete and its checksum matches.
+func (r *Reader) Next() (Record, error) {
+	if r.done {
+		return Record{}, io.EOF
+	}
+
+	data, err := r.readLine()
+	if err != nil {
+		return Record{}, err
+	}
+
+	l := line{}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return Record{}, fmt.Errorf("%w: record %d: %v", ErrInvalidDump, r.records+1, err)
+	}
+
+	if l.End != nil {
+		if l.End.Records != r.records || l.End.Checksum != hex.EncodeToString(r.sum.Sum(nil)) {
+			return Record{}, fmt.Errorf("%w: checksum mismatch", ErrInvalidDump)
+		}
+
+		// Reading to the end checks that nothing follows trailer and that compressed stream is intact.
+		if _, err := r.br.ReadByte(); !errors.Is(err, io.EOF) {
+			return Record{}, fmt.Errorf("%w: unexpected data after trailer", ErrInvalidDump)
+		}
+
+		r.done = true
+
+		return Record{}, io.EOF
+	}
+
+	if l.Metric == nil || l.Metric.ID == "" || (l.Metric.Delta == nil && l.Metric.Value == nil) {
+		return Record{}, fmt.Errorf("%w: record %d has no metric", ErrInvalidDump, r.records+1)
+	}
+
+	r.sum.Write(data)
+	r.records++
+
+	return l.Record, nil
+}
+
+// readLine gives the next line with its line break. Missing line means truncated dump.
+func (r *Reader) readLine() ([]byte, error) {
+	var buf bytes.Buffer
+
+	for {
+		chunk, err := r.br.ReadSlice('\n')
+		buf.Write(chunk)
+
+		if buf.Len() > maxLineSize {
+			return nil, fmt.Errorf("%w: line is too long", ErrInvalidDump)
+		}
+
+		switch {
+		case err == nil:
+			return buf.Bytes(), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return nil, fmt.Errorf("%w: dump is truncated", ErrInvalidDump)
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package dump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/history"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleDump(t *testing.T) []byte {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf)
+	assert.NoError(t, err)
+
+	delta, value := int64(7), 0.5
+	assert.NoError(t, w.Write(Record{Tenant: "team", Metric: &metric.Metric{ID: "jobs", MType: "counter", Delta: &delta, Hash: "abc"}}))
+	assert.NoError(t, w.Write(Record{
+		Metric:  &metric.Metric{ID: "load", MType: "gauge", Value: &value},
+		History: []history.Sample{{Time: time.Unix(100, 0).UTC(), Value: 0.25}, {Time: time.Unix(110, 0).UTC(), Value: 0.5}},
+	}))
+	assert.Error(t, w.Write(Record{Tenant: "team"}))
+	assert.Equal(t, 2, w.Records())
+	assert.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func readAll(r *Reader) ([]Record, error) {
+	records := []Record{}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+
+		records = append(records, rec)
+	}
+}
+
+func Test_WriterReader(t *testing.T) {
+	data := sampleDump(t)
+
+	r, err := NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, Version, r.Header().Version)
+
+	records, err := readAll(r)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "team", records[0].Tenant)
+		assert.Equal(t, int64(7), *records[0].Metric.Delta)
+		assert.Empty(t, records[0].Metric.Hash)
+		assert.Equal(t, "", records[1].Tenant)
+		assert.Len(t, records[1].History, 2)
+	}
+
+	// Dump decompressed by transport is read too.
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+
+	r, err = NewReader(bytes.NewReader(plain))
+	assert.NoError(t, err)
+	records, err = readAll(r)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+}
+
+func Test_ReaderErrors(t *testing.T) {
+	zr, err := gzip.NewReader(bytes.NewReader(sampleDump(t)))
+	assert.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+
+	lines := strings.SplitAfter(string(plain), "\n")
+
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Dump          string
+	}{
+		{
+			Name:          "empty",
+			ExpectedError: ErrInvalidDump,
+		},
+		{
+			Name:          "unknown format",
+			Dump:          `{"format":"backup","version":1}` + "\n",
+			ExpectedError: ErrInvalidDump,
+		},
+		{
+			Name:          "newer version",
+			Dump:          `{"format":"metrics-dump","version":2}` + "\n",
+			ExpectedError: ErrUnsupportedVersion,
+		},
+		{
+			Name:          "without trailer",
+			Dump:          lines[0] + lines[1] + lines[2],
+			ExpectedError: ErrInvalidDump,
+		},
+		{
+			Name:          "changed record",
+			Dump:          lines[0] + strings.Replace(lines[1], `"delta":7`, `"delta":8`, 1) + lines[2] + lines[3],
+			ExpectedError: ErrInvalidDump,
+		},
+		{
+			Name:          "missing record",
+			Dump:          lines[0] + lines[2] + lines[3],
+			ExpectedError: ErrInvalidDump,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r, err := NewReader(strings.NewReader(tt.Dump))
+			if err == nil {
+				_, err = readAll(r)
+			}
+
+			assert.ErrorIs(t, err, tt.ExpectedError)
+		})
+	}
+}
@@ -0,0 +1,458 @@
+// Package expr parses and evaluates arithmetic expressions over metrics, e.g. "HeapInuse / HeapSys",
+// "HeapAlloc > 1e9", "rate(PollCount) == 0" or "absent(CPUutilization1)".
+//
+// Every value is a number. Comparisons and logical operators "and", "or" give 1 for true and 0 for false.
+// Operators by increasing precedence: "or"; "and"; "==", "!=", "<", "<=", ">", ">="; "+", "-"; "*", "/"; unary "-".
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrSyntax         = errors.New("syntax error")
+	ErrNoData         = errors.New("no data")
+	ErrDivisionByZero = errors.New("division by zero")
+)
+
+// Env gives data of metrics to expressions.
+type Env interface {
+	// Value gives current value of metric: value of gauge or accumulated value of counter.
+	// Missing metric gives ErrNoData.
+	Value(id string) (float64, error)
+
+	// Rate gives per-second increase of counter. Not enough data gives ErrNoData.
+	Rate(id string) (float64, error)
+}
+
+var comparisonOps = []string{"==", "!=", "<", "<=", ">", ">="}
+
+// Functions which could be called by expressions. Every function takes metric name.
+var functions = map[string]func(env Env, id string) (float64, error){
+	"rate": func(env Env, id string) (float64, error) {
+		return env.Rate(id)
+	},
+	"absent": func(env Env, id string) (float64, error) {
+		_, err := env.Value(id)
+		switch {
+		case errors.Is(err, ErrNoData):
+			return 1, nil
+		case err != nil:
+			return 0, err
+		default:
+			return 0, nil
+		}
+	},
+}
+
+// node is element of parsed expression tree.
+type node interface {
+	eval(env Env) (float64, error)
+}
+
+type number float64
+
+func (n number) eval(Env) (float64, error) {
+	return float64(n), nil
+}
+
+type ident string
+
+func (i ident) eval(env Env) (float64, error) {
+	return env.Value(string(i))
+}
+
+type call struct {
+	name string
+	arg  string
+}
+
+func (c *call) eval(env Env) (float64, error) {
+	return functions[c.name](env, c.arg)
+}
+
+type unary struct {
+	x node
+}
+
+func (u *unary) eval(env Env) (float64, error) {
+	x, err := u.x.eval(env)
+	return -x, err
+}
+
+type binary struct {
+	x, y node
+	op   string
+}
+
+func (b *binary) eval(env Env) (float64, error) {
+	x, err := b.x.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	// Logical operators skip the second operand if the first one defines result.
+	switch {
+	case b.op == "and" && x == 0:
+		return 0, nil
+	case b.op == "or" && x != 0:
+		return 1, nil
+	}
+
+	y, err := b.y.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return x / y, nil
+	case "==":
+		return truth(x == y), nil
+	case "!=":
+		return truth(x != y), nil
+	case "<":
+		return truth(x < y), nil
+	case "<=":
+		return truth(x <= y), nil
+	case ">":
+		return truth(x > y), nil
+	case ">=":
+		return truth(x >= y), nil
+	default: // "and", "or"
+		return truth(y != 0), nil
+	}
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Expr is parsed expression.
+type Expr struct {
+	root    node
+	source  string
+	metrics []string
+}
+
+// Parse parses expression. Errors are wrapped by ErrSyntax.
+func Parse(source string) (*Expr, error) {
+	p := &parser{
+		lexer:   lexer{input: source},
+		metrics: map[string]bool{},
+	}
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+
+	e := &Expr{
+		root:   root,
+		source: strings.TrimSpace(source),
+	}
+
+	for id := range p.metrics {
+		e.metrics = append(e.metrics, id)
+	}
+	sort.Strings(e.metrics)
+
+	return e, nil
+}
+
+// Eval evaluates expression. Missing data of any used metric gives ErrNoData, except of absent().
+func (e *Expr) Eval(env Env) (float64, error) {
+	return e.root.eval(env)
+}
+
+// Subject gives left operand of expression if it is comparison, e.g. "HeapAlloc" of "HeapAlloc > 1e9",
+// otherwise expression itself. It is the value reported by conditions.
+func (e *Expr) Subject() *Expr {
+	if b, ok := e.root.(*binary); ok && contains(comparisonOps, b.op) {
+		return &Expr{root: b.x, source: e.source, metrics: e.metrics}
+	}
+
+	return e
+}
+
+// Metrics gives sorted names of metrics used by expression.
+func (e *Expr) Metrics() []string {
+	return append([]string{}, e.metrics...)
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	text string
+	kind int
+	pos  int
+}
+
+// lexer splits expression to tokens.
+type lexer struct {
+	input string
+	pos   int
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.' || c == ':'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && strings.IndexByte(" \t\n\r", l.input[l.pos]) >= 0 {
+		l.pos++
+	}
+
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.input[l.pos]
+
+	switch {
+	case isDigit(c) || (c == '.' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1])):
+		for l.pos < len(l.input) && (isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+			l.pos++
+		}
+		if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+			l.pos++
+			if l.pos < len(l.input) && (l.input[l.pos] == '+' || l.input[l.pos] == '-') {
+				l.pos++
+			}
+			for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+				l.pos++
+			}
+		}
+		return token{kind: tokNumber, text: l.input[start:l.pos], pos: start}, nil
+	case isIdentStart(c):
+		for l.pos < len(l.input) && isIdentPart(l.input[l.pos]) {
+			l.pos++
+		}
+		text := l.input[start:l.pos]
+		if text == "and" || text == "or" {
+			return token{kind: tokOp, text: text, pos: start}, nil
+		}
+		return token{kind: tokIdent, text: text, pos: start}, nil
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/"} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+
+	return token{}, fmt.Errorf("%w: unexpected symbol %q at %d", ErrSyntax, c, start)
+}
+
+// parser builds expression tree by recursive descent.
+type parser struct {
+	metrics map[string]bool
+	lexer   lexer
+	tok     token
+}
+
+func (p *parser) next() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at %d", ErrSyntax, fmt.Sprintf(format, args...), p.tok.pos)
+}
+
+// parseBinary parses left-associative sequence of operands joined by given operators.
+func (p *parser) parseBinary(operand func() (node, error), ops ...string) (node, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokOp && contains(ops, p.tok.text) {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		x = &binary{op: op, x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "or")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseComparison, "and")
+}
+
+func (p *parser) parseComparison() (node, error) {
+	return p.parseBinary(p.parseSum, comparisonOps...)
+}
+
+func (p *parser) parseSum() (node, error) {
+	return p.parseBinary(p.parseProduct, "+", "-")
+}
+
+func (p *parser) parseProduct() (node, error) {
+	return p.parseBinary(p.parseUnary, "*", "/")
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOp && p.tok.text == "-" {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unary{x: x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return number(v), p.next()
+	case tokLParen:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected \")\"")
+		}
+		return x, p.next()
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind != tokLParen {
+			p.metrics[tok.text] = true
+			return ident(tok.text), nil
+		}
+
+		return p.parseCall(tok)
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	default:
+		return nil, p.errorf("unexpected %q", tok.text)
+	}
+}
+
+// parseCall parses function call after its name. The only argument of function is metric name.
+func (p *parser) parseCall(name token) (node, error) {
+	if _, ok := functions[name.text]; !ok {
+		return nil, fmt.Errorf("%w: unknown function %q at %d", ErrSyntax, name.text, name.pos)
+	}
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokIdent {
+		return nil, p.errorf("function %q expects metric name", name.text)
+	}
+	arg := p.tok.text
+	p.metrics[arg] = true
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokRParen {
+		return nil, p.errorf("expected \")\"")
+	}
+
+	return &call{name: name.text, arg: arg}, p.next()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
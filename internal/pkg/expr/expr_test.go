@@ -0,0 +1,144 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEnv keeps values and rates of metrics in maps.
+type testEnv struct {
+	values map[string]float64
+	rates  map[string]float64
+}
+
+func (e testEnv) Value(id string) (float64, error) {
+	v, ok := e.values[id]
+	if !ok {
+		return 0, ErrNoData
+	}
+
+	return v, nil
+}
+
+func (e testEnv) Rate(id string) (float64, error) {
+	v, ok := e.rates[id]
+	if !ok {
+		return 0, ErrNoData
+	}
+
+	return v, nil
+}
+
+func Test_Eval(t *testing.T) {
+	env := testEnv{
+		values: map[string]float64{
+			"HeapInuse":   50,
+			"HeapSys":     200,
+			"HeapAlloc":   2e9,
+			"FreeMemory":  1,
+			"TotalMemory": 4,
+			"Zero":        0,
+			"db.queries":  3,
+		},
+		rates: map[string]float64{
+			"PollCount": 0,
+		},
+	}
+
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Source        string
+		Expected      float64
+	}{
+		{Name: "division", Source: "HeapInuse / HeapSys", Expected: 0.25},
+		{Name: "precedence", Source: "1 - FreeMemory/TotalMemory", Expected: 0.75},
+		{Name: "parentheses", Source: "(1 - FreeMemory) * 2", Expected: 0},
+		{Name: "unary minus", Source: "-HeapInuse + 60", Expected: 10},
+		{Name: "left associativity", Source: "8 - 4 - 2", Expected: 2},
+		{Name: "exponent", Source: "HeapAlloc > 1e9", Expected: 1},
+		{Name: "fraction", Source: ".5 + 0.25", Expected: 0.75},
+		{Name: "comparison is false", Source: "HeapAlloc <= 1e9", Expected: 0},
+		{Name: "rate", Source: "rate(PollCount) == 0", Expected: 1},
+		{Name: "absent", Source: "absent(CPUutilization1)", Expected: 1},
+		{Name: "present", Source: "absent(HeapSys)", Expected: 0},
+		{Name: "and", Source: "HeapSys > 100 and HeapInuse < 100", Expected: 1},
+		{Name: "or", Source: "HeapSys > 1000 or absent(Missing)", Expected: 1},
+		{Name: "and skips missing data", Source: "HeapSys > 1000 and Missing > 0", Expected: 0},
+		{Name: "dotted name", Source: "db.queries != 2", Expected: 1},
+		{Name: "missing metric", Source: "Missing + 1", ExpectedError: ErrNoData},
+		{Name: "missing rate", Source: "rate(HeapSys)", ExpectedError: ErrNoData},
+		{Name: "division by zero", Source: "HeapSys / Zero", ExpectedError: ErrDivisionByZero},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			e, err := Parse(tt.Source)
+			assert.NoError(t, err)
+
+			v, err := e.Eval(env)
+			assert.ErrorIs(t, err, tt.ExpectedError)
+			assert.Equal(t, tt.Expected, v)
+		})
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	tests := []struct {
+		Name            string
+		Source          string
+		ExpectedMetrics []string
+		ExpectedError   bool
+	}{
+		{Name: "metrics are listed once", Source: "HeapInuse / HeapSys + HeapSys", ExpectedMetrics: []string{"HeapInuse", "HeapSys"}},
+		{Name: "function argument", Source: "rate(PollCount) > 1", ExpectedMetrics: []string{"PollCount"}},
+		{Name: "constant", Source: "1 + 2", ExpectedMetrics: []string{}},
+		{Name: "empty", Source: " ", ExpectedError: true},
+		{Name: "unclosed parenthesis", Source: "(1 + 2", ExpectedError: true},
+		{Name: "trailing operator", Source: "HeapSys /", ExpectedError: true},
+		{Name: "unknown function", Source: "sqrt(HeapSys)", ExpectedError: true},
+		{Name: "function of expression", Source: "rate(1 + 2)", ExpectedError: true},
+		{Name: "unknown symbol", Source: "HeapSys % 2", ExpectedError: true},
+		{Name: "extra tokens", Source: "HeapSys HeapInuse", ExpectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			e, err := Parse(tt.Source)
+			if tt.ExpectedError {
+				assert.ErrorIs(t, err, ErrSyntax)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ExpectedMetrics, e.Metrics())
+			assert.Equal(t, tt.Source, e.String())
+		})
+	}
+}
+
+func Test_Subject(t *testing.T) {
+	env := testEnv{values: map[string]float64{"HeapAlloc": 2e9}}
+
+	tests := []struct {
+		Source   string
+		Expected float64
+	}{
+		{Source: "HeapAlloc > 1e9", Expected: 2e9},
+		{Source: "HeapAlloc / 2 <= 1", Expected: 1e9},
+		{Source: "HeapAlloc + 1", Expected: 2e9 + 1},
+		{Source: "HeapAlloc > 1 and HeapAlloc > 2", Expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Source, func(t *testing.T) {
+			e, err := Parse(tt.Source)
+			assert.NoError(t, err)
+
+			v, err := e.Subject().Eval(env)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Expected, v)
+		})
+	}
+}
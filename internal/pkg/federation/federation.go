@@ -0,0 +1,186 @@
+// Package federation defines data exchanged by hierarchical servers: parent pulls metrics from /federate
+// of its children, or children push the same payload to /federate of parent.
+//
+// Counters are given by accumulated values, so parent applies only their increase since the previous payload
+// of the same source, and repeated or overlapping payloads never apply the same increment twice.
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+)
+
+var (
+	ErrInvalidChild   = errors.New("invalid federation child")
+	ErrInvalidPayload = errors.New("invalid federation payload")
+	ErrRequest        = errors.New("federation request failed")
+)
+
+const (
+	// Label of merged metrics naming the server they came from.
+	SourceLabel = "source"
+
+	// Path of federation endpoint.
+	Path = "/federate"
+
+	DefaultTimeout = 10 * time.Second
+)
+
+// Sample is metric of payload. Counters keep accumulated values. Name is series name, i.e. ID without suffix
+// of labels hash. Timestamp is Unix time in milliseconds of the latest update known to source, zero means unknown.
+type Sample struct {
+	Labels    map[string]string `json:"labels,omitempty"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Name      string            `json:"name"`
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+}
+
+// Payload is response of /federate and body of pushes. Source names pushing server, Time is Unix time
+// in milliseconds payload was made at.
+type Payload struct {
+	Source  string   `json:"source,omitempty"`
+	Metrics []Sample `json:"metrics"`
+	Time    int64    `json:"time"`
+}
+
+// Child is server whose metrics are pulled. URL is base URL of child, e.g. "http://dc1:8080" or
+// "http://dc1:8080/t/team" for metrics of its tenant. Its metrics are stored to Tenant of parent.
+type Child struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Tenant  string            `json:"tenant,omitempty"`
+}
+
+// Validate checks child.
+func (c *Child) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is not defined", ErrInvalidChild)
+	}
+
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%w %q: invalid url %q", ErrInvalidChild, c.Name, c.URL)
+	}
+
+	return nil
+}
+
+// Labels gives labels of metric merged from source. Label "source" is set to source name. Metrics merged
+// by source already keep their origin after it, e.g. "eu/dc1", so hierarchy of any depth keeps series apart.
+func Labels(source string, labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	if origin, ok := labels[SourceLabel]; ok && origin != "" {
+		source += "/" + origin
+	}
+	merged[SourceLabel] = source
+
+	return merged
+}
+
+// Client pulls payloads from children and pushes them to parent. Requests are signed by key if it is not empty.
+type Client struct {
+	client *http.Client
+	key    string
+}
+
+// Constructor.
+func NewClient(key string) *Client {
+	return &Client{
+		client: &http.Client{Timeout: DefaultTimeout},
+		key:    key,
+	}
+}
+
+// Pull gets payload of child.
+func (c *Client) Pull(ctx context.Context, child Child) (*Payload, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(child.URL, "/")+Path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.do(req, child.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("%w: child %q: %v", ErrRequest, child.Name, err)
+	}
+
+	p := &Payload{}
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, fmt.Errorf("%w: child %q: %v", ErrInvalidPayload, child.Name, err)
+	}
+
+	return p, nil
+}
+
+// Push posts payload to federation endpoint of parent, e.g. "http://global:8080/federate".
+func (c *Client) Push(ctx context.Context, endpoint string, headers map[string]string, p *Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if c.key != "" {
+		if err := signer.SignRequest(req, c.key, body); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.do(req, headers); err != nil {
+		return fmt.Errorf("%w: parent %q: %v", ErrRequest, endpoint, err)
+	}
+
+	return nil
+}
+
+// do makes request and gives body of successful response.
+func (c *Client) do(req *http.Request, headers map[string]string) ([]byte, error) {
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if errBodyClose := res.Body.Close(); errBodyClose != nil {
+			log.Println(errBodyClose)
+		}
+	}()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
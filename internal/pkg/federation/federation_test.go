@@ -0,0 +1,97 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Labels(t *testing.T) {
+	tests := []struct {
+		Labels   map[string]string
+		Expected map[string]string
+		Name     string
+	}{
+		{Name: "without labels", Expected: map[string]string{SourceLabel: "dc1"}},
+		{
+			Name:     "labels are kept",
+			Labels:   map[string]string{"host": "web-1"},
+			Expected: map[string]string{"host": "web-1", SourceLabel: "dc1"},
+		},
+		{
+			Name:     "origin of lower level is kept",
+			Labels:   map[string]string{SourceLabel: "rack2"},
+			Expected: map[string]string{SourceLabel: "dc1/rack2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			original := map[string]string{}
+			for k, v := range tt.Labels {
+				original[k] = v
+			}
+
+			assert.Equal(t, tt.Expected, Labels("dc1", tt.Labels))
+
+			if tt.Labels != nil {
+				assert.Equal(t, original, tt.Labels)
+			}
+		})
+	}
+}
+
+func Test_ChildValidate(t *testing.T) {
+	assert.NoError(t, (&Child{Name: "dc1", URL: "http://dc1:8080"}).Validate())
+	assert.ErrorIs(t, (&Child{URL: "http://dc1:8080"}).Validate(), ErrInvalidChild)
+	assert.ErrorIs(t, (&Child{Name: "dc1", URL: "dc1:8080"}).Validate(), ErrInvalidChild)
+}
+
+func Test_ClientPullPush(t *testing.T) {
+	value := 0.5
+	payload := Payload{Time: 1700000000000, Metrics: []Sample{{ID: "load", Name: "load", MType: "gauge", Value: &value}}}
+
+	var pushed Payload
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/t/team"+Path {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		switch r.Method {
+		case http.MethodGet:
+			body, err := json.Marshal(payload)
+			assert.NoError(t, err)
+			w.Write(body)
+		case http.MethodPost:
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, r.Header.Get(signer.HeaderSignature))
+			assert.NoError(t, json.Unmarshal(body, &pushed))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewClient("secret")
+	headers := map[string]string{"Authorization": "Bearer token"}
+
+	p, err := c.Pull(context.Background(), Child{Name: "dc1", URL: ts.URL + "/t/team/", Headers: headers})
+	assert.NoError(t, err)
+	assert.Equal(t, &payload, p)
+
+	payload.Source = "dc1"
+	assert.NoError(t, c.Push(context.Background(), ts.URL+"/t/team"+Path, headers, &payload))
+	assert.Equal(t, payload, pushed)
+
+	_, err = c.Pull(context.Background(), Child{Name: "dc1", URL: ts.URL + "/missing"})
+	assert.ErrorIs(t, err, ErrRequest)
+}
@@ -0,0 +1,69 @@
+package filestorage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+)
+
+// Suffix of file keeping alert states next to storage file.
+const alertsFileSuffix = ".alerts"
+
+// Returns alert states of all tenants saved by SaveAlerts. Storage without file keeps them in memory.
+func (st *fileStorage) GetAlerts() ([]alerting.Alert, error) {
+	if st.root != nil {
+		return st.root.GetAlerts()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	if st.FilePath == "" {
+		return append([]alerting.Alert{}, st.alerts...), nil
+	}
+
+	aj, err := os.ReadFile(st.FilePath + alertsFileSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return []alerting.Alert{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := []alerting.Alert{}
+	if err := json.Unmarshal(aj, &alerts); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// Replaces saved alert states of all tenants. File is replaced atomically, so it is never partially written.
+func (st *fileStorage) SaveAlerts(alerts []alerting.Alert) error {
+	if st.root != nil {
+		return st.root.SaveAlerts(alerts)
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	st.alerts = append([]alerting.Alert{}, alerts...)
+
+	if st.FilePath == "" {
+		return nil
+	}
+
+	aj, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := st.FilePath + alertsFileSuffix + ".tmp"
+	if err := os.WriteFile(tmpPath, aj, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, st.FilePath+alertsFileSuffix)
+}
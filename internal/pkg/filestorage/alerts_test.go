@@ -0,0 +1,37 @@
+package filestorage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SaveAlerts(t *testing.T) {
+	now := time.Now().UTC()
+	alerts := []alerting.Alert{
+		{Tenant: "team", Rule: "heap", State: alerting.StateFiring, Value: 2e9, ActiveAt: now, FiredAt: now},
+	}
+
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "storage.json")} {
+		ms := New(path)
+
+		saved, err := ms.GetAlerts()
+		assert.NoError(t, err)
+		assert.Empty(t, saved)
+
+		assert.NoError(t, ms.ForTenant("team").(*fileStorage).SaveAlerts(alerts))
+
+		if path != "" {
+			ms = New(path)
+		}
+
+		saved, err = ms.GetAlerts()
+		assert.NoError(t, err)
+		assert.Len(t, saved, 1)
+		assert.Equal(t, alerts[0].Rule, saved[0].Rule)
+		assert.True(t, alerts[0].FiredAt.Equal(saved[0].FiredAt))
+	}
+}
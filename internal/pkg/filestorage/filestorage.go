@@ -8,30 +8,128 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
 )
 
 // Realization of metrics storage based on map. Is concurrent-safe due to Mutex.
+// Root storage keeps metrics of default tenant and storages of other tenants.
 type fileStorage struct {
 	metrics  map[string]*metric.Metric
+	tenants  map[string]*fileStorage
+	root     *fileStorage
+	rollups  *rollup.Memory
 	FilePath string
+	// Tenant of storage which is created on the first write, is empty for created storages.
+	pending string
+	alerts  []alerting.Alert
 	sync.RWMutex
 }
 
+// storedMetric is a line of storage file. Tenant is omitted for metrics of default tenant.
+type storedMetric struct {
+	*metric.Metric
+	Tenant string `json:"tenant,omitempty"`
+}
+
 // Constructor.
 func New(filePath string) *fileStorage {
 	return &fileStorage{
 		metrics:  map[string]*metric.Metric{},
+		tenants:  map[string]*fileStorage{},
 		FilePath: filePath,
 	}
 }
 
+// Returns storage of given tenant sharing file with the root storage. Empty tenant is the default one.
+// Storage of unknown tenant is created by the first write to it, reads give nothing until then.
+func (st *fileStorage) ForTenant(tenant string) metric.MetricStorage {
+	if ts := st.tenant(tenant, false); ts != nil {
+		return ts
+	}
+
+	return &fileStorage{root: st.rootStorage(), pending: tenant}
+}
+
+func (st *fileStorage) rootStorage() *fileStorage {
+	if st.root != nil {
+		return st.root
+	}
+
+	return st
+}
+
+// tenant gives storage of tenant. Missing storage is created if create is set, otherwise nil is given.
+func (st *fileStorage) tenant(tenant string, create bool) *fileStorage {
+	if st.root != nil {
+		return st.root.tenant(tenant, create)
+	}
+
+	if tenant == metric.DefaultTenant {
+		return st
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	if st.tenants == nil {
+		st.tenants = map[string]*fileStorage{}
+	}
+
+	ts, ok := st.tenants[tenant]
+	if !ok && create {
+		ts = &fileStorage{
+			metrics: map[string]*metric.Metric{},
+			root:    st,
+		}
+		st.tenants[tenant] = ts
+	}
+
+	return ts
+}
+
+// resolve gives storage keeping metrics of st. Storage of pending tenant is looked up on every call,
+// so it sees writes made through other handles, and is nil if it isn't created and create isn't set.
+func (st *fileStorage) resolve(create bool) *fileStorage {
+	if st.pending == "" {
+		return st
+	}
+
+	return st.root.tenant(st.pending, create)
+}
+
+// Returns names of tenants having metrics, including default one.
+func (st *fileStorage) Tenants() ([]string, error) {
+	if st.root != nil {
+		return st.root.Tenants()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	tenants := []string{metric.DefaultTenant}
+	for name, ts := range st.tenants {
+		ts.Lock()
+		if len(ts.metrics) != 0 {
+			tenants = append(tenants, name)
+		}
+		ts.Unlock()
+	}
+
+	return tenants, nil
+}
+
 func (st *fileStorage) Close() error {
 	return nil
 }
 
 // Returns existing metric by it's name.
 func (st *fileStorage) GetMetric(name string) (*metric.Metric, error) {
+	if st = st.resolve(false); st == nil {
+		return nil, metric.ErrMetricDoesntExist
+	}
+
 	st.Lock()
 	defer st.Unlock()
 
@@ -43,6 +141,10 @@ func (st *fileStorage) GetMetric(name string) (*metric.Metric, error) {
 
 // Returns all storaged metrics in slice.
 func (st *fileStorage) GetBatch() ([]*metric.Metric, error) {
+	if st = st.resolve(false); st == nil {
+		return []*metric.Metric{}, nil
+	}
+
 	st.Lock()
 	defer st.Unlock()
 
@@ -55,8 +157,28 @@ func (st *fileStorage) GetBatch() ([]*metric.Metric, error) {
 	return allMetrics, nil
 }
 
+// Returns existing metrics by their names. Missing names are skipped.
+func (st *fileStorage) GetMetrics(ids []string) ([]*metric.Metric, error) {
+	if st = st.resolve(false); st == nil {
+		return []*metric.Metric{}, nil
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	found := []*metric.Metric{}
+	for _, id := range ids {
+		if m, ok := st.metrics[id]; ok {
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
 // Updates metric valuable fields: overrides Value and increments Delta.
 func (st *fileStorage) UpdateMetric(m *metric.Metric) error {
+	st = st.resolve(true)
+
 	st.Lock()
 	defer st.Unlock()
 
@@ -90,8 +212,30 @@ func (st *fileStorage) UpdateBatch(batch []*metric.Metric) error {
 	return nil
 }
 
+// Removes existing metric by it's name.
+func (st *fileStorage) DeleteMetric(name string) error {
+	if st = st.resolve(false); st == nil {
+		return metric.ErrMetricDoesntExist
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	if _, ok := st.metrics[name]; !ok {
+		return metric.ErrMetricDoesntExist
+	}
+
+	delete(st.metrics, name)
+
+	return nil
+}
+
 // Checks if storage is initialized.
 func (st *fileStorage) AccessCheck() error {
+	if st.pending != "" {
+		return st.root.AccessCheck()
+	}
+
 	st.Lock()
 	defer st.Unlock()
 
@@ -101,8 +245,12 @@ func (st *fileStorage) AccessCheck() error {
 	return nil
 }
 
-// Uploads storage to json-file on path defined in constructor.
+// Uploads storage of all tenants to json-file on path defined in constructor.
 func (st *fileStorage) UploadStorage() error {
+	if st.root != nil {
+		return st.root.UploadStorage()
+	}
+
 	st.Lock()
 	defer st.Unlock()
 
@@ -116,13 +264,15 @@ func (st *fileStorage) UploadStorage() error {
 		}
 	}()
 
-	for name := range st.metrics {
-		mj, err := json.Marshal(st.metrics[name])
-		if err != nil {
-			return err
-		}
-		mj = append(mj, '\n')
-		_, err = file.Write(mj)
+	if err := writeMetrics(file, metric.DefaultTenant, st.metrics); err != nil {
+		return err
+	}
+
+	for tenant, ts := range st.tenants {
+		ts.Lock()
+		err := writeMetrics(file, tenant, ts.metrics)
+		ts.Unlock()
+
 		if err != nil {
 			return err
 		}
@@ -148,13 +298,13 @@ func (st *fileStorage) DownloadStorage() error {
 	b := bufio.NewScanner(file)
 
 	for b.Scan() {
-		m := metric.Metric{}
+		m := storedMetric{}
 
 		if err := json.Unmarshal(b.Bytes(), &m); err != nil {
 			return err
 		}
 
-		if err := st.UpdateMetric(&m); err != nil {
+		if err := st.tenant(m.Tenant, true).UpdateMetric(m.Metric); err != nil {
 			return err
 		}
 	}
@@ -163,3 +313,22 @@ func (st *fileStorage) DownloadStorage() error {
 
 	return nil
 }
+
+func writeMetrics(file *os.File, tenant string, metrics map[string]*metric.Metric) error {
+	for name := range metrics {
+		mj, err := json.Marshal(storedMetric{
+			Metric: metrics[name],
+			Tenant: tenant,
+		})
+		if err != nil {
+			return err
+		}
+		mj = append(mj, '\n')
+		_, err = file.Write(mj)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -92,6 +92,23 @@ func Test_GetBatch(t *testing.T) {
 	assert.Equal(t, expectedMetrics, actualMetrics)
 }
 
+func Test_GetMetrics(t *testing.T) {
+	ms := New("")
+
+	var delta int64 = 3
+	var value float64 = 4
+	ms.metrics["PollCount"] = &metric.Metric{ID: "PollCount", MType: "counter", Delta: &delta}
+	ms.metrics["Alloc"] = &metric.Metric{ID: "Alloc", MType: "gauge", Value: &value}
+
+	found, err := ms.GetMetrics([]string{"Alloc", "Missing", "PollCount"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*metric.Metric{ms.metrics["Alloc"], ms.metrics["PollCount"]}, found)
+
+	found, err = ms.GetMetrics(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
 func Test_UpdateMetric(t *testing.T) {
 	var delta1 int64 = 100
 	var delta2 int64 = 200
@@ -228,3 +245,84 @@ func Test_LoadStorage(t *testing.T) {
 		return
 	}
 }
+
+func Test_DeleteMetric(t *testing.T) {
+	var value float64 = 200
+
+	ms := New("")
+	ms.metrics["name"] = &metric.Metric{
+		ID:    "name",
+		MType: "gauge",
+		Value: &value,
+	}
+
+	assert.NoError(t, ms.DeleteMetric("name"))
+	assert.NotContains(t, ms.metrics, "name")
+	assert.ErrorIs(t, ms.DeleteMetric("name"), metric.ErrMetricDoesntExist)
+}
+
+func Test_ForTenant(t *testing.T) {
+	path := "./tenants.json"
+	var value float64 = 1
+	var delta int64 = 2
+
+	msUp := New(path)
+	assert.Same(t, msUp, msUp.ForTenant(metric.DefaultTenant))
+
+	assert.NoError(t, msUp.UpdateMetric(&metric.Metric{ID: "Alloc", MType: "gauge", Value: &value}))
+	assert.NoError(t, msUp.ForTenant("team").UpdateMetric(&metric.Metric{ID: "PollCount", MType: "counter", Delta: &delta}))
+
+	_, err := msUp.GetMetric("PollCount")
+	assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+
+	_, err = msUp.ForTenant("team").GetMetric("Alloc")
+	assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+
+	tenants, err := msUp.Tenants()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{metric.DefaultTenant, "team"}, tenants)
+
+	t.Run("reads don't create tenants", func(t *testing.T) {
+		ts := msUp.ForTenant("unknown")
+
+		_, err := ts.GetMetric("Alloc")
+		assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+		assert.ErrorIs(t, ts.DeleteMetric("Alloc"), metric.ErrMetricDoesntExist)
+
+		batch, err := ts.GetBatch()
+		assert.NoError(t, err)
+		assert.Empty(t, batch)
+
+		batch, err = ts.GetMetrics([]string{"Alloc"})
+		assert.NoError(t, err)
+		assert.Empty(t, batch)
+
+		assert.NotContains(t, msUp.tenants, "unknown")
+
+		// Handle sees metrics written through another one.
+		assert.NoError(t, msUp.ForTenant("unknown").UpdateMetric(&metric.Metric{ID: "Alloc", MType: "gauge", Value: &value}))
+		assert.Contains(t, msUp.tenants, "unknown")
+
+		_, err = ts.GetMetric("Alloc")
+		assert.NoError(t, err)
+		assert.NoError(t, ts.DeleteMetric("Alloc"))
+	})
+
+	t.Run("tenants are uploaded", func(t *testing.T) {
+		assert.NoError(t, msUp.ForTenant("team").(*fileStorage).UploadStorage())
+
+		msDown := New(path)
+		assert.NoError(t, msDown.DownloadStorage())
+
+		m, err := msDown.ForTenant("team").GetMetric("PollCount")
+		assert.NoError(t, err)
+		assert.Equal(t, delta, *m.Delta)
+
+		_, err = msDown.GetMetric("Alloc")
+		assert.NoError(t, err)
+	})
+
+	if err := os.Remove(path); err != nil {
+		return
+	}
+}
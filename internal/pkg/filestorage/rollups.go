@@ -0,0 +1,197 @@
+package filestorage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
+)
+
+// Suffix of file keeping rollup points next to storage file.
+const rollupsFileSuffix = ".rollups"
+
+// storedPoint is a line of rollups file. Later lines replace earlier ones with the same key.
+type storedPoint struct {
+	rollup.Point
+	Resolution time.Duration `json:"resolution"`
+}
+
+// Saves points of tier, existing ones are replaced. Points are appended to file, so saving is cheap.
+func (st *fileStorage) SavePoints(resolution time.Duration, points []rollup.Point) error {
+	if st.root != nil {
+		return st.root.SavePoints(resolution, points)
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	mem, err := st.loadRollups()
+	if err != nil {
+		return err
+	}
+
+	if err := mem.SavePoints(resolution, points); err != nil {
+		return err
+	}
+
+	if st.FilePath == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(st.FilePath+rollupsFileSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for i := range points {
+		if err := encoder.Encode(storedPoint{Point: points[i], Resolution: resolution}); err != nil {
+			if errClose := file.Close(); errClose != nil {
+				log.Println(errClose)
+			}
+			return err
+		}
+	}
+
+	return file.Close()
+}
+
+// Returns points of metric of tenant in range [from, to) sorted by time.
+func (st *fileStorage) GetPoints(resolution time.Duration, tenant, id string, from, to time.Time) ([]rollup.Point, error) {
+	mem, err := st.rollupsMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	return mem.GetPoints(resolution, tenant, id, from, to)
+}
+
+// Returns points of all metrics in range [from, to) sorted by tenant, name and time.
+func (st *fileStorage) RangePoints(resolution time.Duration, from, to time.Time) ([]rollup.Point, error) {
+	mem, err := st.rollupsMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	return mem.RangePoints(resolution, from, to)
+}
+
+// Returns time of the latest point of tier. Zero time means there are no points.
+func (st *fileStorage) LatestPoint(resolution time.Duration) (time.Time, error) {
+	mem, err := st.rollupsMemory()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return mem.LatestPoint(resolution)
+}
+
+// Removes points of tier older than given time. If any points are removed, file is rewritten atomically.
+func (st *fileStorage) DeletePoints(resolution time.Duration, before time.Time) error {
+	if st.root != nil {
+		return st.root.DeletePoints(resolution, before)
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	mem, err := st.loadRollups()
+	if err != nil {
+		return err
+	}
+
+	kept := mem.Len()
+
+	if err := mem.DeletePoints(resolution, before); err != nil {
+		return err
+	}
+
+	if st.FilePath == "" || mem.Len() == kept {
+		return nil
+	}
+
+	tmpPath := st.FilePath + rollupsFileSuffix + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+
+	mem.Each(func(resolution time.Duration, p rollup.Point) {
+		if err == nil {
+			err = encoder.Encode(storedPoint{Point: p, Resolution: resolution})
+		}
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, st.FilePath+rollupsFileSuffix)
+}
+
+// rollupsMemory gives points of root storage loaded from file.
+func (st *fileStorage) rollupsMemory() (*rollup.Memory, error) {
+	if st.root != nil {
+		return st.root.rollupsMemory()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	return st.loadRollups()
+}
+
+// loadRollups reads points from file on the first call. Must be called under lock of root storage.
+func (st *fileStorage) loadRollups() (*rollup.Memory, error) {
+	if st.rollups != nil {
+		return st.rollups, nil
+	}
+
+	mem := rollup.NewMemory()
+
+	if st.FilePath != "" {
+		file, err := os.Open(st.FilePath + rollupsFileSuffix)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			defer func() {
+				if errClose := file.Close(); errClose != nil {
+					log.Println(errClose)
+				}
+			}()
+
+			decoder := json.NewDecoder(bufio.NewReader(file))
+			for decoder.More() {
+				sp := storedPoint{}
+				if err := decoder.Decode(&sp); err != nil {
+					return nil, err
+				}
+
+				if err := mem.SavePoints(sp.Resolution, []rollup.Point{sp.Point}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	st.rollups = mem
+
+	return mem, nil
+}
@@ -0,0 +1,46 @@
+package filestorage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Rollups(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := rollup.NewSampler()
+
+	points := []rollup.Point{}
+	for i := 0; i < 6; i++ {
+		points = append(points, s.Gauge("team", "Alloc", start.Add(time.Duration(i)*10*time.Second), float64(i)))
+	}
+
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "storage.json")} {
+		ms := New(path)
+
+		assert.NoError(t, ms.ForTenant("team").(*fileStorage).SavePoints(10*time.Second, points[:4]))
+		assert.NoError(t, ms.SavePoints(10*time.Second, points[3:]))
+		assert.NoError(t, ms.SavePoints(time.Minute, rollup.Aggregate(points, time.Minute)))
+		assert.NoError(t, ms.DeletePoints(10*time.Second, start.Add(20*time.Second)))
+
+		if path != "" {
+			ms = New(path)
+		}
+
+		raw, err := ms.GetPoints(10*time.Second, "team", "Alloc", start, start.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, points[2:], raw)
+
+		all, err := ms.RangePoints(time.Minute, start, start.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Len(t, all, 1)
+		assert.Equal(t, 15.0, all[0].Sum)
+
+		latest, err := ms.ForTenant("team").(*fileStorage).LatestPoint(10 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, start.Add(50*time.Second), latest)
+	}
+}
@@ -0,0 +1,430 @@
+// Package forward sends accepted metric updates to upstream systems: to Graphite plaintext over TCP,
+// to InfluxDB line protocol over HTTP and to /updates/ of another server.
+//
+// Every target has its own bounded queue, so slow targets delay neither storing nor other targets.
+// Updates not fitting into queue are dropped and counted. Queued updates are sent in batches, one request
+// per tenant. Failed requests are retried with exponential backoff and dropped after the last attempt.
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/delivery"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var (
+	ErrInvalidTarget = errors.New("invalid forwarding target")
+	ErrDelivery      = errors.New("forwarding failed")
+)
+
+// Kinds of targets.
+const (
+	KindGraphite = "graphite"
+	KindInflux   = "influx"
+	KindUpdates  = "updates"
+)
+
+const (
+	DefaultQueueSize     = 10000
+	DefaultBatchSize     = 500
+	DefaultFlushInterval = time.Second
+	DefaultRetries       = 3
+	DefaultBackoff       = time.Second
+	DefaultTimeout       = 10 * time.Second
+)
+
+// Target is upstream system receiving updates. Address is "host:port" for Graphite targets and URL of write
+// endpoint for others, e.g. "http://influx:8086/write?db=metrics" or "http://global:8080/updates/".
+// Headers are added to HTTP requests. Zero sizes, intervals and Retries mean defaults, negative Retries turn retries off.
+type Target struct {
+	Headers       map[string]string `json:"headers,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind"`
+	Address       string            `json:"address"`
+	Tenants       []string          `json:"tenants,omitempty"`
+	QueueSize     int               `json:"queue_size,omitempty"`
+	BatchSize     int               `json:"batch_size,omitempty"`
+	FlushInterval time.Duration     `json:"flush_interval,omitempty"`
+	Backoff       time.Duration     `json:"backoff,omitempty"`
+	Retries       int               `json:"retries,omitempty"`
+}
+
+// Validate checks target and sets defaults of undefined settings.
+func (t *Target) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is not defined", ErrInvalidTarget)
+	}
+
+	if t.Address == "" {
+		return fmt.Errorf("%w %q: address is not defined", ErrInvalidTarget, t.Name)
+	}
+
+	switch t.Kind {
+	case KindGraphite:
+	case KindInflux, KindUpdates:
+		if u, err := url.Parse(t.Address); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w %q: invalid url %q", ErrInvalidTarget, t.Name, t.Address)
+		}
+	default:
+		return fmt.Errorf("%w %q: unknown kind %q", ErrInvalidTarget, t.Name, t.Kind)
+	}
+
+	if t.QueueSize <= 0 {
+		t.QueueSize = DefaultQueueSize
+	}
+	if t.BatchSize <= 0 {
+		t.BatchSize = DefaultBatchSize
+	}
+	if t.FlushInterval <= 0 {
+		t.FlushInterval = DefaultFlushInterval
+	}
+	if t.Backoff <= 0 {
+		t.Backoff = DefaultBackoff
+	}
+	if t.Retries == 0 {
+		t.Retries = DefaultRetries
+	}
+
+	return nil
+}
+
+// accepts defines if target receives updates of tenant.
+func (t *Target) accepts(tenant string) bool {
+	if len(t.Tenants) == 0 {
+		return true
+	}
+
+	for _, tn := range t.Tenants {
+		if tn == tenant {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Item is accepted update of metric. Counters keep delta of update. Name is series name of metric,
+// i.e. name Graphite and Influx targets get.
+type Item struct {
+	Time   time.Time
+	Metric *metric.Metric
+	Tenant string
+	Name   string
+}
+
+// Stats is state of target queue. Dropped counts updates which didn't fit into queue,
+// Failed counts updates dropped after the last delivery attempt.
+type Stats struct {
+	Target   string `json:"target"`
+	Kind     string `json:"kind"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Sent     int64  `json:"sent"`
+	Dropped  int64  `json:"dropped"`
+	Failed   int64  `json:"failed"`
+	Retries  int64  `json:"retries"`
+}
+
+// sender delivers updates of tenant to target. Gives if failed delivery could be retried.
+type sender interface {
+	send(tenant string, items []Item) (bool, error)
+}
+
+// queue keeps updates of target and sends them in batches.
+type queue struct {
+	sender  sender
+	items   chan Item
+	stop    chan struct{}
+	done    chan struct{}
+	target  Target
+	sent    int64
+	dropped int64
+	failed  int64
+	retries int64
+}
+
+// Forwarder puts updates into queues of targets. Is concurrent-safe.
+type Forwarder struct {
+	name    func(m *metric.Metric) string
+	queues  []*queue
+	once    sync.Once
+	started bool
+}
+
+// Constructor. Updates sent to other servers are hashed and signed by key if it is not empty.
+// Function name gives series name of metric, nil means metric ID.
+func New(targets []Target, key string, name func(m *metric.Metric) string) (*Forwarder, error) {
+	f := &Forwarder{name: name}
+
+	for i := range targets {
+		t := targets[i]
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+
+		var s sender
+		switch t.Kind {
+		case KindGraphite:
+			s = &graphiteSender{address: t.Address, timeout: DefaultTimeout}
+		case KindInflux:
+			s = newInfluxSender(t)
+		case KindUpdates:
+			s = newUpdatesSender(t, key)
+		}
+
+		f.queues = append(f.queues, &queue{
+			sender: s,
+			items:  make(chan Item, t.QueueSize),
+			stop:   make(chan struct{}),
+			done:   make(chan struct{}),
+			target: t,
+		})
+	}
+
+	return f, nil
+}
+
+// Snapshot makes items of metrics of tenant. Must be called before storing metrics, since storages could change them.
+// Gives nothing if no target accepts tenant.
+func (f *Forwarder) Snapshot(tenant string, batch ...*metric.Metric) []Item {
+	if f == nil || !f.accepts(tenant) {
+		return nil
+	}
+
+	now := time.Now()
+	items := make([]Item, 0, len(batch))
+
+	for _, m := range batch {
+		name := m.ID
+		if f.name != nil {
+			name = f.name(m)
+		}
+
+		items = append(items, Item{
+			Time:   now,
+			Metric: copyMetric(m),
+			Tenant: tenant,
+			Name:   name,
+		})
+	}
+
+	return items
+}
+
+// accepts defines if any target receives updates of tenant.
+func (f *Forwarder) accepts(tenant string) bool {
+	for _, q := range f.queues {
+		if q.target.accepts(tenant) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Enqueue puts items into queues of targets accepting them without blocking. Items not fitting into queue are dropped.
+func (f *Forwarder) Enqueue(items ...Item) {
+	if f == nil {
+		return
+	}
+
+	for _, q := range f.queues {
+		for i := range items {
+			if !q.target.accepts(items[i].Tenant) {
+				continue
+			}
+
+			select {
+			case q.items <- items[i]:
+			default:
+				atomic.AddInt64(&q.dropped, 1)
+			}
+		}
+	}
+}
+
+// Start starts sending queued updates.
+func (f *Forwarder) Start() {
+	if f == nil || f.started {
+		return
+	}
+
+	f.started = true
+
+	for _, q := range f.queues {
+		go q.run()
+	}
+}
+
+// Close stops sending. Queued updates are sent by one attempt.
+func (f *Forwarder) Close() {
+	if f == nil {
+		return
+	}
+
+	f.once.Do(func() {
+		for _, q := range f.queues {
+			close(q.stop)
+		}
+
+		if !f.started {
+			return
+		}
+
+		for _, q := range f.queues {
+			<-q.done
+		}
+	})
+}
+
+// Stats gives states of target queues in order of targets.
+func (f *Forwarder) Stats() []Stats {
+	if f == nil {
+		return []Stats{}
+	}
+
+	stats := make([]Stats, 0, len(f.queues))
+	for _, q := range f.queues {
+		stats = append(stats, Stats{
+			Target:   q.target.Name,
+			Kind:     q.target.Kind,
+			Queued:   len(q.items),
+			Capacity: cap(q.items),
+			Sent:     atomic.LoadInt64(&q.sent),
+			Dropped:  atomic.LoadInt64(&q.dropped),
+			Failed:   atomic.LoadInt64(&q.failed),
+			Retries:  atomic.LoadInt64(&q.retries),
+		})
+	}
+
+	return stats
+}
+
+// run collects queued items into batches and sends them until queue is stopped.
+func (q *queue) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.target.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Item, 0, q.target.BatchSize)
+
+	for {
+		select {
+		case item := <-q.items:
+			batch = append(batch, item)
+			if len(batch) >= q.target.BatchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			q.flush(batch)
+			batch = batch[:0]
+		case <-q.stop:
+			for {
+				select {
+				case item := <-q.items:
+					batch = append(batch, item)
+					if len(batch) >= q.target.BatchSize {
+						q.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					q.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush sends batch as one request per tenant.
+func (q *queue) flush(batch []Item) {
+	for len(batch) > 0 {
+		tenant := batch[0].Tenant
+
+		group := []Item{}
+		rest := batch[:0:0]
+		for i := range batch {
+			if batch[i].Tenant == tenant {
+				group = append(group, batch[i])
+			} else {
+				rest = append(rest, batch[i])
+			}
+		}
+
+		if err := q.send(tenant, group); err != nil {
+			log.Println(err)
+		}
+
+		batch = rest
+	}
+}
+
+// send delivers items of tenant, failed attempts are retried with exponential backoff unless queue is stopped.
+func (q *queue) send(tenant string, items []Item) error {
+	attempts := 0
+
+	err := delivery.Retry(q.target.Name, q.target.Retries, q.target.Backoff, q.wait, func() (bool, error) {
+		if attempts++; attempts > 1 {
+			atomic.AddInt64(&q.retries, 1)
+		}
+		return q.sender.send(tenant, items)
+	})
+	if err != nil {
+		atomic.AddInt64(&q.failed, int64(len(items)))
+		return fmt.Errorf("%w: target %q: %d updates dropped: %v", ErrDelivery, q.target.Name, len(items), err)
+	}
+
+	atomic.AddInt64(&q.sent, int64(len(items)))
+
+	return nil
+}
+
+// wait waits for duration. Gives false if queue is stopped.
+func (q *queue) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-q.stop:
+		return false
+	}
+}
+
+// copyMetric gives deep copy of metric without hash.
+func copyMetric(m *metric.Metric) *metric.Metric {
+	c := &metric.Metric{
+		ID:    m.ID,
+		MType: m.MType,
+	}
+
+	if m.Delta != nil {
+		delta := *m.Delta
+		c.Delta = &delta
+	}
+
+	if m.Value != nil {
+		value := *m.Value
+		c.Value = &value
+	}
+
+	if len(m.Labels) != 0 {
+		c.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			c.Labels[k] = v
+		}
+	}
+
+	return c
+}
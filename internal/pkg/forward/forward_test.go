@@ -0,0 +1,244 @@
+package forward
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "secret"
+
+// receiver is HTTP stand-in of upstream recording delivered requests. First failures requests are answered by status.
+type receiver struct {
+	*httptest.Server
+	requests []*http.Request
+	bodies   []string
+	status   int
+	failures int
+	attempts int
+	mu       sync.Mutex
+}
+
+func newReceiver(t *testing.T, status, failures int) *receiver {
+	rc := &receiver{status: status, failures: failures}
+
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		rc.attempts++
+		if rc.attempts <= rc.failures {
+			w.WriteHeader(rc.status)
+			return
+		}
+
+		rc.requests = append(rc.requests, r)
+		rc.bodies = append(rc.bodies, string(body))
+	}))
+	t.Cleanup(rc.Close)
+
+	return rc
+}
+
+func (rc *receiver) received() []string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return append([]string{}, rc.bodies...)
+}
+
+func gauge(id string, value float64, labels map[string]string) *metric.Metric {
+	return &metric.Metric{ID: id, MType: "gauge", Value: &value, Labels: labels}
+}
+
+func counter(id string, delta int64) *metric.Metric {
+	return &metric.Metric{ID: id, MType: "counter", Delta: &delta}
+}
+
+func newTestForwarder(t *testing.T, targets []Target, key string) *Forwarder {
+	f, err := New(targets, key, func(m *metric.Metric) string {
+		return strings.TrimSuffix(m.ID, ":hash")
+	})
+	assert.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func Test_forwardGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			conn.Close()
+		}
+	}()
+
+	f := newTestForwarder(t, []Target{{Name: "graphite", Kind: KindGraphite, Address: ln.Addr().String(), FlushInterval: 10 * time.Millisecond}}, "")
+	f.Start()
+
+	f.Enqueue(f.Snapshot("", gauge("cpu:hash", 0.5, map[string]string{"host": "web 1", "dc": "eu"}), counter("requests", 3))...)
+
+	received := []string{}
+	for len(received) < 2 {
+		select {
+		case line := <-lines:
+			received = append(received, line[:strings.LastIndexByte(line, ' ')])
+		case <-time.After(time.Second):
+			t.Fatal("lines are not received")
+		}
+	}
+
+	assert.Equal(t, []string{"cpu;dc=eu;host=web_1 0.5", "requests 3"}, received)
+	assert.Equal(t, int64(2), f.Stats()[0].Sent)
+}
+
+func Test_forwardInflux(t *testing.T) {
+	rc := newReceiver(t, http.StatusServiceUnavailable, 1)
+
+	f := newTestForwarder(t, []Target{{
+		Name:          "influx",
+		Kind:          KindInflux,
+		Address:       rc.URL + "/write?db=metrics",
+		Headers:       map[string]string{"Authorization": "Token abc"},
+		FlushInterval: 10 * time.Millisecond,
+		Backoff:       time.Millisecond,
+	}}, "")
+	f.Start()
+
+	f.Enqueue(f.Snapshot("", gauge("disk usage:hash", 0.75, map[string]string{"path": "/var,lib"}), counter("requests", 3))...)
+
+	assert.Eventually(t, func() bool { return len(rc.received()) == 1 }, time.Second, 5*time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(rc.received()[0]), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.True(t, strings.HasPrefix(lines[0], `disk\ usage,path=/var\,lib value=0.75 `), lines[0])
+		assert.True(t, strings.HasPrefix(lines[1], "requests delta=3i "), lines[1])
+	}
+
+	rc.mu.Lock()
+	assert.Equal(t, "metrics", rc.requests[0].URL.Query().Get("db"))
+	assert.Equal(t, "Token abc", rc.requests[0].Header.Get("Authorization"))
+	rc.mu.Unlock()
+
+	stats := f.Stats()[0]
+	assert.Equal(t, int64(2), stats.Sent)
+	assert.Equal(t, int64(1), stats.Retries)
+}
+
+func Test_forwardUpdates(t *testing.T) {
+	rc := newReceiver(t, 0, 0)
+
+	f := newTestForwarder(t, []Target{{Name: "global", Kind: KindUpdates, Address: rc.URL + "/updates/", BatchSize: 2, FlushInterval: time.Hour}}, testKey)
+	f.Start()
+
+	// Full batch is sent at once, items of different tenants are sent by separate requests.
+	f.Enqueue(f.Snapshot("", counter("requests", 3))...)
+	f.Enqueue(f.Snapshot("team", gauge("cpu", 1, nil))...)
+
+	assert.Eventually(t, func() bool { return len(rc.received()) == 2 }, time.Second, 5*time.Millisecond)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	tenants := map[string][]*metric.Metric{}
+	for i, r := range rc.requests {
+		batch := []*metric.Metric{}
+		assert.NoError(t, json.Unmarshal([]byte(rc.bodies[i]), &batch))
+		tenants[r.Header.Get(TenantHeader)] = batch
+
+		assert.Equal(t, "2", r.Header.Get(HashVersionHeader))
+		assert.NotEmpty(t, r.Header.Get(signer.HeaderSignature))
+	}
+
+	if assert.Len(t, tenants[""], 1) {
+		assert.Equal(t, int64(3), *tenants[""][0].Delta)
+		assert.NotEmpty(t, tenants[""][0].Hash)
+	}
+	if assert.Len(t, tenants["team"], 1) {
+		assert.Equal(t, 1.0, *tenants["team"][0].Value)
+	}
+}
+
+func Test_forwardQueue(t *testing.T) {
+	rc := newReceiver(t, http.StatusInternalServerError, 100)
+
+	f := newTestForwarder(t, []Target{
+		{Name: "failing", Kind: KindUpdates, Address: rc.URL, QueueSize: 2, Retries: 1, Backoff: time.Millisecond, FlushInterval: time.Hour},
+		{Name: "team only", Kind: KindUpdates, Address: rc.URL, Tenants: []string{"team"}},
+	}, "")
+
+	assert.Nil(t, (&Forwarder{}).Snapshot("team", counter("requests", 1)))
+
+	// Queue isn't sent before start, so the third item doesn't fit into it.
+	f.Enqueue(f.Snapshot("", counter("a", 1), counter("b", 1), counter("c", 1))...)
+
+	stats := f.Stats()
+	assert.Equal(t, Stats{Target: "failing", Kind: KindUpdates, Queued: 2, Capacity: 2, Dropped: 1}, stats[0])
+	assert.Equal(t, 0, stats[1].Queued)
+
+	// Queued items are sent on closing by one attempt, failed delivery drops them.
+	f.Start()
+	f.Close()
+
+	stats = f.Stats()
+	assert.Equal(t, 0, stats[0].Queued)
+	assert.Equal(t, int64(2), stats[0].Failed)
+	assert.Equal(t, int64(0), stats[0].Sent)
+	assert.Equal(t, int64(0), stats[0].Retries)
+}
+
+func Test_TargetValidate(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Target Target
+		Valid  bool
+	}{
+		{Name: "graphite", Target: Target{Name: "g", Kind: KindGraphite, Address: "localhost:2003"}, Valid: true},
+		{Name: "updates", Target: Target{Name: "u", Kind: KindUpdates, Address: "http://localhost:8080/updates/"}, Valid: true},
+		{Name: "without name", Target: Target{Kind: KindGraphite, Address: "localhost:2003"}},
+		{Name: "without address", Target: Target{Name: "g", Kind: KindGraphite}},
+		{Name: "unknown kind", Target: Target{Name: "g", Kind: "statsd", Address: "localhost:8125"}},
+		{Name: "invalid url", Target: Target{Name: "i", Kind: KindInflux, Address: "localhost:8086"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := tt.Target.Validate()
+			if !tt.Valid {
+				assert.ErrorIs(t, err, ErrInvalidTarget)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, DefaultQueueSize, tt.Target.QueueSize)
+			assert.Equal(t, DefaultRetries, tt.Target.Retries)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/delivery"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+// Headers of requests to other servers.
+const (
+	TenantHeader      = "X-Tenant"
+	HashVersionHeader = "Hash-Version"
+)
+
+// graphiteSender writes lines "<name>[;tag=value...] <value> <timestamp>" to Graphite TCP listener.
+// Counters are sent as deltas of updates.
+type graphiteSender struct {
+	address string
+	timeout time.Duration
+}
+
+var graphiteReplacer = strings.NewReplacer(" ", "_", ";", "_", "=", "_", "\n", "_")
+
+func (s *graphiteSender) send(_ string, items []Item) (bool, error) {
+	buf := bytes.Buffer{}
+
+	for i := range items {
+		value, ok := itemValue(items[i].Metric)
+		if !ok {
+			continue
+		}
+
+		buf.WriteString(graphiteReplacer.Replace(items[i].Name))
+		for _, k := range sortedKeys(items[i].Metric.Labels) {
+			buf.WriteString(";" + graphiteReplacer.Replace(k) + "=" + graphiteReplacer.Replace(items[i].Metric.Labels[k]))
+		}
+		buf.WriteString(" " + value + " " + strconv.FormatInt(items[i].Time.Unix(), 10) + "\n")
+	}
+
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return true, err
+	}
+
+	defer func() {
+		if errClose := conn.Close(); errClose != nil {
+			log.Println(errClose)
+		}
+	}()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(s.timeout)); err != nil {
+		return true, err
+	}
+
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return true, err
+	}
+
+	return false, nil
+}
+
+// influxSender posts InfluxDB lines "<name>[,tag=value...] value=<value> <timestamp>" with nanosecond timestamps.
+// Counters are sent as integer fields "delta" keeping deltas of updates.
+type influxSender struct {
+	client  *http.Client
+	headers map[string]string
+	url     string
+}
+
+func newInfluxSender(t Target) *influxSender {
+	return &influxSender{
+		client:  &http.Client{Timeout: DefaultTimeout},
+		headers: t.Headers,
+		url:     t.Address,
+	}
+}
+
+var (
+	influxNameReplacer = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagReplacer  = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func (s *influxSender) send(_ string, items []Item) (bool, error) {
+	buf := bytes.Buffer{}
+
+	for i := range items {
+		m := items[i].Metric
+
+		var field string
+		switch {
+		case m.Value != nil:
+			field = "value=" + strconv.FormatFloat(*m.Value, 'g', -1, 64)
+		case m.Delta != nil:
+			field = "delta=" + strconv.FormatInt(*m.Delta, 10) + "i"
+		default:
+			continue
+		}
+
+		buf.WriteString(influxNameReplacer.Replace(items[i].Name))
+		for _, k := range sortedKeys(m.Labels) {
+			buf.WriteString("," + influxTagReplacer.Replace(k) + "=" + influxTagReplacer.Replace(m.Labels[k]))
+		}
+		buf.WriteString(" " + field + " " + strconv.FormatInt(items[i].Time.UnixNano(), 10) + "\n")
+	}
+
+	return delivery.Post(context.Background(), s.client, s.url, "text/plain; charset=utf-8", s.headers, buf.Bytes(), "")
+}
+
+// updatesSender posts JSON batches to /updates/ of another server. Metrics are hashed and requests are signed by key
+// if it is not empty.
+type updatesSender struct {
+	client  *http.Client
+	headers map[string]string
+	url     string
+	key     string
+}
+
+func newUpdatesSender(t Target, key string) *updatesSender {
+	return &updatesSender{
+		client:  &http.Client{Timeout: DefaultTimeout},
+		headers: t.Headers,
+		url:     t.Address,
+		key:     key,
+	}
+}
+
+func (s *updatesSender) send(tenant string, items []Item) (bool, error) {
+	batch := make([]*metric.Metric, 0, len(items))
+
+	for i := range items {
+		m := copyMetric(items[i].Metric)
+
+		if s.key != "" {
+			if err := m.UpdateHashVersion(s.key, metric.LatestHashVersion); err != nil {
+				return false, err
+			}
+		}
+
+		batch = append(batch, m)
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return false, err
+	}
+
+	headers := map[string]string{}
+	for k, v := range s.headers {
+		headers[k] = v
+	}
+	if tenant != metric.DefaultTenant {
+		headers[TenantHeader] = tenant
+	}
+	if s.key != "" {
+		headers[HashVersionHeader] = strconv.Itoa(metric.LatestHashVersion)
+	}
+
+	return delivery.Post(context.Background(), s.client, s.url, "application/json", headers, body, s.key)
+}
+
+// itemValue formats value of gauge or delta of counter.
+func itemValue(m *metric.Metric) (string, bool) {
+	switch {
+	case m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'g', -1, 64), true
+	case m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10), true
+	default:
+		return "", false
+	}
+}
+
+// sortedKeys gives keys of labels in order.
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
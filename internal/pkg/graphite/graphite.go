@@ -0,0 +1,71 @@
+// Package graphite parses Graphite plaintext protocol:
+//
+//	path[;tag=value...] value [timestamp]
+//
+// Timestamp is in Unix seconds, missing timestamp or -1 means now.
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidLine = errors.New("invalid line")
+
+// Point is a parsed line.
+type Point struct {
+	Time  time.Time
+	Tags  map[string]string
+	Path  string
+	Value float64
+}
+
+// ParseLine parses single line without line break.
+func ParseLine(line string, now time.Time) (Point, error) {
+	p := Point{Time: now}
+
+	parts := strings.Fields(line)
+	if len(parts) < 2 || len(parts) > 3 {
+		return p, fmt.Errorf("%w: expected path, value and optional timestamp", ErrInvalidLine)
+	}
+
+	tags := strings.Split(parts[0], ";")
+	p.Path = tags[0]
+	if p.Path == "" {
+		return p, fmt.Errorf("%w: missing path", ErrInvalidLine)
+	}
+
+	for _, tag := range tags[1:] {
+		i := strings.IndexByte(tag, '=')
+		if i <= 0 || i == len(tag)-1 {
+			return p, fmt.Errorf("%w: invalid tag %q", ErrInvalidLine, tag)
+		}
+
+		if p.Tags == nil {
+			p.Tags = map[string]string{}
+		}
+		p.Tags[tag[:i]] = tag[i+1:]
+	}
+
+	value, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return p, fmt.Errorf("%w: invalid value %q", ErrInvalidLine, parts[1])
+	}
+	p.Value = value
+
+	if len(parts) == 3 && parts[2] != "-1" {
+		ts, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) || ts < 0 {
+			return p, fmt.Errorf("%w: invalid timestamp %q", ErrInvalidLine, parts[2])
+		}
+
+		sec, frac := math.Modf(ts)
+		p.Time = time.Unix(int64(sec), int64(frac*float64(time.Second)))
+	}
+
+	return p, nil
+}
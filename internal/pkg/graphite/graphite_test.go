@@ -0,0 +1,68 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseLine(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Line          string
+		Expected      Point
+	}{
+		{
+			Name:     "path, value and timestamp",
+			Line:     "servers.web-1.cpu 0.75 1700000001",
+			Expected: Point{Path: "servers.web-1.cpu", Value: 0.75, Time: time.Unix(1700000001, 0)},
+		},
+		{
+			Name:     "without timestamp",
+			Line:     "servers.web-1.cpu 3",
+			Expected: Point{Path: "servers.web-1.cpu", Value: 3, Time: now},
+		},
+		{
+			Name:     "timestamp -1 means now",
+			Line:     "servers.web-1.cpu 3 -1",
+			Expected: Point{Path: "servers.web-1.cpu", Value: 3, Time: now},
+		},
+		{
+			Name:     "fractional timestamp",
+			Line:     "cpu\t1e3   1700000001.5",
+			Expected: Point{Path: "cpu", Value: 1000, Time: time.Unix(1700000001, int64(time.Second/2))},
+		},
+		{
+			Name:     "tags",
+			Line:     "cpu;host=web-1;dc=eu 1 1700000001",
+			Expected: Point{Path: "cpu", Tags: map[string]string{"host": "web-1", "dc": "eu"}, Value: 1, Time: time.Unix(1700000001, 0)},
+		},
+		{Name: "missing value", Line: "cpu", ExpectedError: ErrInvalidLine},
+		{Name: "too many parts", Line: "cpu 1 2 3", ExpectedError: ErrInvalidLine},
+		{Name: "missing path", Line: ";host=a 1", ExpectedError: ErrInvalidLine},
+		{Name: "invalid tag", Line: "cpu;host 1", ExpectedError: ErrInvalidLine},
+		{Name: "invalid value", Line: "cpu abc", ExpectedError: ErrInvalidLine},
+		{Name: "not finite value", Line: "cpu Inf", ExpectedError: ErrInvalidLine},
+		{Name: "invalid timestamp", Line: "cpu 1 yesterday", ExpectedError: ErrInvalidLine},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			p, err := ParseLine(tt.Line, now)
+			if tt.ExpectedError != nil {
+				assert.ErrorIs(t, err, tt.ExpectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Expected.Path, p.Path)
+			assert.Equal(t, tt.Expected.Tags, p.Tags)
+			assert.Equal(t, tt.Expected.Value, p.Value)
+			assert.True(t, tt.Expected.Time.Equal(p.Time))
+		})
+	}
+}
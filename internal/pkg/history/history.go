@@ -0,0 +1,166 @@
+// Package history keeps recent samples of metric values in memory, e.g. for sparklines and rates.
+// Every series keeps a fixed number of the latest samples, older ones are overwritten.
+package history
+
+import (
+	"sync"
+	"time"
+)
+
+// Default number of samples kept for every series.
+const DefaultSize = 60
+
+// Sample is value of metric at given time. Counters are sampled by accumulated value.
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// series is ring buffer of samples.
+type series struct {
+	samples []Sample
+	next    int
+	full    bool
+}
+
+// add puts sample to buffer overwriting the oldest one if buffer is full.
+func (s *series) add(sample Sample) {
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % len(s.samples)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// list gives samples from the oldest to the latest.
+func (s *series) list() []Sample {
+	if !s.full {
+		return append([]Sample{}, s.samples[:s.next]...)
+	}
+
+	return append(append([]Sample{}, s.samples[s.next:]...), s.samples[:s.next]...)
+}
+
+type key struct {
+	tenant string
+	id     string
+}
+
+// History keeps recent samples of metrics of every tenant.
+type History struct {
+	series map[key]*series
+	size   int
+	mu     sync.RWMutex
+}
+
+// New gives history keeping size latest samples of every series. Non-positive size means DefaultSize.
+func New(size int) *History {
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	return &History{
+		series: map[key]*series{},
+		size:   size,
+	}
+}
+
+// Add records value of tenant metric at given time.
+func (h *History) Add(tenant, id string, t time.Time, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := key{tenant: tenant, id: id}
+
+	s, ok := h.series[k]
+	if !ok {
+		s = &series{samples: make([]Sample, h.size)}
+		h.series[k] = s
+	}
+
+	s.add(Sample{Time: t, Value: value})
+}
+
+// Get gives samples of tenant metric from the oldest to the latest. History is nil-safe.
+func (h *History) Get(tenant, id string) []Sample {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s, ok := h.series[key{tenant: tenant, id: id}]
+	if !ok {
+		return nil
+	}
+
+	return s.list()
+}
+
+// Restore replaces samples of tenant metric by given ones, e.g. restored from backup. Only the latest samples
+// fitting size of history are kept. History is nil-safe.
+func (h *History) Restore(tenant, id string, samples []Sample) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &series{samples: make([]Sample, h.size)}
+	if len(samples) > h.size {
+		samples = samples[len(samples)-h.size:]
+	}
+
+	for _, sample := range samples {
+		s.add(sample)
+	}
+
+	h.series[key{tenant: tenant, id: id}] = s
+}
+
+// Forget removes samples of tenant metric, e.g. of deleted one. History is nil-safe.
+func (h *History) Forget(tenant, id string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.series, key{tenant: tenant, id: id})
+}
+
+// Rate gives per-second increase of counter by samples taken since given time. Decrease of value is
+// treated as counter reset, so increase after it is counted from zero. Less than two samples give false.
+func Rate(samples []Sample, since time.Time) (float64, bool) {
+	first := len(samples)
+	for i := range samples {
+		if !samples[i].Time.Before(since) {
+			first = i
+			break
+		}
+	}
+
+	samples = samples[first:]
+	if len(samples) < 2 {
+		return 0, false
+	}
+
+	var increase float64
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Value < samples[i-1].Value {
+			increase += samples[i].Value
+		} else {
+			increase += samples[i].Value - samples[i-1].Value
+		}
+	}
+
+	seconds := samples[len(samples)-1].Time.Sub(samples[0].Time).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+
+	return increase / seconds, true
+}
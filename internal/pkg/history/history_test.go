@@ -0,0 +1,161 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func values(samples []Sample) []float64 {
+	res := []float64{}
+	for _, s := range samples {
+		res = append(res, s.Value)
+	}
+
+	return res
+}
+
+func Test_History(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Expected []float64
+		Added    int
+	}{
+		{
+			Name:     "empty series",
+			Expected: nil,
+		},
+		{
+			Name:     "partially filled",
+			Added:    2,
+			Expected: []float64{0, 1},
+		},
+		{
+			Name:     "exactly filled",
+			Added:    3,
+			Expected: []float64{0, 1, 2},
+		},
+		{
+			Name:     "overwritten",
+			Added:    7,
+			Expected: []float64{4, 5, 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := New(3)
+			start := time.Now()
+
+			for i := 0; i < tt.Added; i++ {
+				h.Add("team", "Alloc", start.Add(time.Duration(i)*time.Second), float64(i))
+			}
+
+			samples := h.Get("team", "Alloc")
+			if tt.Expected == nil {
+				assert.Nil(t, samples)
+				return
+			}
+
+			assert.Equal(t, tt.Expected, values(samples))
+			assert.True(t, samples[0].Time.Before(samples[len(samples)-1].Time))
+		})
+	}
+}
+
+func Test_HistoryTenantsAndForget(t *testing.T) {
+	h := New(0)
+	now := time.Now()
+
+	h.Add("", "Alloc", now, 1)
+	h.Add("team", "Alloc", now, 2)
+
+	assert.Equal(t, []float64{1}, values(h.Get("", "Alloc")))
+	assert.Equal(t, []float64{2}, values(h.Get("team", "Alloc")))
+
+	h.Forget("team", "Alloc")
+	assert.Nil(t, h.Get("team", "Alloc"))
+	assert.Len(t, h.Get("", "Alloc"), 1)
+
+	var disabled *History
+	assert.Nil(t, disabled.Get("", "Alloc"))
+	disabled.Forget("", "Alloc")
+}
+
+func Test_HistoryRestore(t *testing.T) {
+	h := New(3)
+	now := time.Now()
+
+	h.Add("team", "Alloc", now, 9)
+
+	samples := []Sample{}
+	for i := 0; i < 5; i++ {
+		samples = append(samples, Sample{Time: now.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	h.Restore("team", "Alloc", samples)
+	assert.Equal(t, []float64{2, 3, 4}, values(h.Get("team", "Alloc")))
+
+	// Restored series keeps recording.
+	h.Add("team", "Alloc", now.Add(5*time.Second), 5)
+	assert.Equal(t, []float64{3, 4, 5}, values(h.Get("team", "Alloc")))
+
+	var disabled *History
+	disabled.Restore("team", "Alloc", samples)
+}
+
+func Test_Rate(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int, value float64) Sample {
+		return Sample{Time: start.Add(time.Duration(seconds) * time.Second), Value: value}
+	}
+
+	tests := []struct {
+		Since      time.Time
+		Name       string
+		Samples    []Sample
+		Expected   float64
+		ExpectedOK bool
+	}{
+		{
+			Name:       "steady increase",
+			Samples:    []Sample{at(0, 10), at(10, 30), at(20, 50)},
+			Since:      start,
+			Expected:   2,
+			ExpectedOK: true,
+		},
+		{
+			Name:       "old samples are skipped",
+			Samples:    []Sample{at(0, 0), at(10, 100), at(20, 100)},
+			Since:      start.Add(5 * time.Second),
+			Expected:   0,
+			ExpectedOK: true,
+		},
+		{
+			Name:       "reset",
+			Samples:    []Sample{at(0, 100), at(10, 110), at(20, 5)},
+			Since:      start,
+			Expected:   0.75,
+			ExpectedOK: true,
+		},
+		{
+			Name:    "single sample",
+			Samples: []Sample{at(0, 1)},
+			Since:   start,
+		},
+		{
+			Name:    "no samples since",
+			Samples: []Sample{at(0, 1), at(10, 2)},
+			Since:   start.Add(time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			rate, ok := Rate(tt.Samples, tt.Since)
+			assert.Equal(t, tt.ExpectedOK, ok)
+			assert.Equal(t, tt.Expected, rate)
+		})
+	}
+}
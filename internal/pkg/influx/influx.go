@@ -0,0 +1,285 @@
+// Package influx parses InfluxDB line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Measurements, tag keys, tag values and field keys may escape commas, spaces and equal signs by backslash.
+// Field values are floats, integers with suffix "i", unsigned integers with suffix "u", booleans or quoted strings.
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidLine      = errors.New("invalid line")
+	ErrInvalidPrecision = errors.New("invalid precision")
+)
+
+// Point is a parsed line. Line is number of line in parsed data, starting from 1.
+type Point struct {
+	Time        time.Time
+	Tags        map[string]string
+	Measurement string
+	Fields      []Field
+	Line        int
+}
+
+// Field is a field of point. Value is float64, int64, uint64, bool or string.
+type Field struct {
+	Value interface{}
+	Key   string
+}
+
+// Float gives numeric value of field, booleans are 1 and 0. Strings have no numeric value.
+func (f Field) Float() (float64, bool) {
+	switch v := f.Value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// LineError is error of parsing line. Lines are numbered from 1.
+type LineError struct {
+	Err  error
+	Line int
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Precisions of timestamps.
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// Precision gives unit of timestamps by its name, e.g. "ms". Empty name means nanoseconds.
+func Precision(name string) (time.Duration, error) {
+	unit, ok := precisions[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidPrecision, name)
+	}
+
+	return unit, nil
+}
+
+// Parse parses lines of data. Points without timestamp get time now. Empty lines and comments are skipped,
+// invalid lines are reported by errors and don't stop parsing.
+func Parse(data []byte, unit time.Duration, now time.Time) ([]Point, []error) {
+	points := []Point{}
+	errs := []error{}
+
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		s := strings.TrimSpace(string(line))
+		if s == "" || s[0] == '#' {
+			continue
+		}
+
+		p, err := ParseLine(s, unit, now)
+		if err != nil {
+			errs = append(errs, &LineError{Line: i + 1, Err: err})
+			continue
+		}
+		p.Line = i + 1
+
+		points = append(points, p)
+	}
+
+	return points, errs
+}
+
+// ParseLine parses single line without line break.
+func ParseLine(line string, unit time.Duration, now time.Time) (Point, error) {
+	p := Point{Time: now}
+
+	measurement, i := scan(line, 0, ", ")
+	if measurement == "" {
+		return p, fmt.Errorf("%w: missing measurement", ErrInvalidLine)
+	}
+	p.Measurement = measurement
+
+	for i < len(line) && line[i] == ',' {
+		var key, value string
+
+		key, i = scan(line, i+1, ",= ")
+		if i >= len(line) || line[i] != '=' || key == "" {
+			return p, fmt.Errorf("%w: invalid tag at %d", ErrInvalidLine, i)
+		}
+
+		value, i = scan(line, i+1, ", =")
+		if value == "" || i < len(line) && line[i] == '=' {
+			return p, fmt.Errorf("%w: invalid value of tag %q", ErrInvalidLine, key)
+		}
+
+		if p.Tags == nil {
+			p.Tags = map[string]string{}
+		}
+		p.Tags[key] = value
+	}
+
+	i = skipSpaces(line, i)
+	if i >= len(line) {
+		return p, fmt.Errorf("%w: missing fields", ErrInvalidLine)
+	}
+
+	for {
+		var key string
+
+		key, i = scan(line, i, ",= ")
+		if i >= len(line) || line[i] != '=' || key == "" {
+			return p, fmt.Errorf("%w: invalid field at %d", ErrInvalidLine, i)
+		}
+
+		value, next, err := fieldValue(line, i+1)
+		if err != nil {
+			return p, fmt.Errorf("%w: field %q: %v", ErrInvalidLine, key, err)
+		}
+
+		p.Fields = append(p.Fields, Field{Key: key, Value: value})
+
+		i = next
+		if i >= len(line) || line[i] != ',' {
+			break
+		}
+		i++
+	}
+
+	i = skipSpaces(line, i)
+	if i >= len(line) {
+		return p, nil
+	}
+
+	ts, err := strconv.ParseInt(line[i:], 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("%w: invalid timestamp %q", ErrInvalidLine, line[i:])
+	}
+	p.Time = time.Unix(0, 0).Add(time.Duration(ts) * unit)
+
+	return p, nil
+}
+
+// scan reads token starting at i until one of unescaped stop symbols. Backslash escapes stop symbols,
+// before other symbols it is kept. Gives unescaped token and position of stop symbol or end of line.
+func scan(line string, i int, stops string) (string, int) {
+	var b strings.Builder
+
+	for ; i < len(line); i++ {
+		c := line[i]
+
+		if c == '\\' && i+1 < len(line) && strings.IndexByte(stops, line[i+1]) >= 0 {
+			b.WriteByte(line[i+1])
+			i++
+			continue
+		}
+
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String(), i
+}
+
+// skipSpaces gives position of the first symbol after spaces.
+func skipSpaces(line string, i int) int {
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+
+	return i
+}
+
+// fieldValue parses field value starting at i. Gives value and position after it.
+func fieldValue(line string, i int) (interface{}, int, error) {
+	if i < len(line) && line[i] == '"' {
+		var b strings.Builder
+
+		for i++; i < len(line); i++ {
+			c := line[i]
+
+			if c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+				b.WriteByte(line[i+1])
+				i++
+				continue
+			}
+
+			if c == '"' {
+				return b.String(), i + 1, nil
+			}
+
+			b.WriteByte(c)
+		}
+
+		return nil, i, errors.New("unterminated string")
+	}
+
+	j := i
+	for j < len(line) && line[j] != ',' && line[j] != ' ' {
+		j++
+	}
+
+	token := line[i:j]
+	if token == "" {
+		return nil, j, errors.New("missing value")
+	}
+
+	switch token {
+	case "t", "T", "true", "True", "TRUE":
+		return true, j, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, j, nil
+	}
+
+	switch token[len(token)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(token[:len(token)-1], 10, 64)
+		if err != nil {
+			return nil, j, fmt.Errorf("invalid integer %q", token)
+		}
+		return v, j, nil
+	case 'u':
+		v, err := strconv.ParseUint(token[:len(token)-1], 10, 64)
+		if err != nil {
+			return nil, j, fmt.Errorf("invalid unsigned integer %q", token)
+		}
+		return v, j, nil
+	}
+
+	v, err := strconv.ParseFloat(token, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, j, fmt.Errorf("invalid float %q", token)
+	}
+
+	return v, j, nil
+}
@@ -0,0 +1,148 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseLine(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Line          string
+		Expected      Point
+		Unit          time.Duration
+	}{
+		{
+			Name:     "float field without timestamp",
+			Line:     "cpu value=0.5",
+			Expected: Point{Measurement: "cpu", Fields: []Field{{Key: "value", Value: 0.5}}, Time: now},
+		},
+		{
+			Name: "tags, typed fields and timestamp",
+			Line: `cpu,host=web-1,region=eu load=1.5,procs=12i,rx=7u,up=t,state="ok" 1700000001000000000`,
+			Expected: Point{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "web-1", "region": "eu"},
+				Fields: []Field{
+					{Key: "load", Value: 1.5},
+					{Key: "procs", Value: int64(12)},
+					{Key: "rx", Value: uint64(7)},
+					{Key: "up", Value: true},
+					{Key: "state", Value: "ok"},
+				},
+				Time: time.Unix(1700000001, 0),
+			},
+		},
+		{
+			Name:     "timestamp in seconds",
+			Line:     "cpu value=1 1700000002",
+			Unit:     time.Second,
+			Expected: Point{Measurement: "cpu", Fields: []Field{{Key: "value", Value: 1.0}}, Time: time.Unix(1700000002, 0)},
+		},
+		{
+			Name: "escaped symbols",
+			Line: `disk\ io,path=/var\,lib,mount\=point=a\ b used\ bytes=1,note="say \"hi\", ok" `,
+			Expected: Point{
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": "/var,lib", "mount=point": "a b"},
+				Fields:      []Field{{Key: "used bytes", Value: 1.0}, {Key: "note", Value: `say "hi", ok`}},
+				Time:        now,
+			},
+		},
+		{Name: "missing measurement", Line: ",host=a value=1", ExpectedError: ErrInvalidLine},
+		{Name: "missing fields", Line: "cpu,host=a", ExpectedError: ErrInvalidLine},
+		{Name: "tag without value", Line: "cpu,host value=1", ExpectedError: ErrInvalidLine},
+		{Name: "field without value", Line: "cpu value=", ExpectedError: ErrInvalidLine},
+		{Name: "invalid float", Line: "cpu value=abc", ExpectedError: ErrInvalidLine},
+		{Name: "not finite float", Line: "cpu value=NaN", ExpectedError: ErrInvalidLine},
+		{Name: "invalid integer", Line: "cpu value=1.5i", ExpectedError: ErrInvalidLine},
+		{Name: "negative unsigned", Line: "cpu value=-1u", ExpectedError: ErrInvalidLine},
+		{Name: "unterminated string", Line: `cpu value="abc`, ExpectedError: ErrInvalidLine},
+		{Name: "invalid timestamp", Line: "cpu value=1 yesterday", ExpectedError: ErrInvalidLine},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			unit := tt.Unit
+			if unit == 0 {
+				unit = time.Nanosecond
+			}
+
+			p, err := ParseLine(tt.Line, unit, now)
+			if tt.ExpectedError != nil {
+				assert.ErrorIs(t, err, tt.ExpectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Expected.Measurement, p.Measurement)
+			assert.Equal(t, tt.Expected.Tags, p.Tags)
+			assert.Equal(t, tt.Expected.Fields, p.Fields)
+			assert.True(t, tt.Expected.Time.Equal(p.Time))
+		})
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	data := []byte("# comment\ncpu value=1\n\nmem used=\ndisk free=2i\r\nnet\n")
+
+	points, errs := Parse(data, time.Nanosecond, time.Now())
+
+	assert.Len(t, points, 2)
+	assert.Equal(t, "cpu", points[0].Measurement)
+	assert.Equal(t, "disk", points[1].Measurement)
+	assert.Equal(t, 2, points[0].Line)
+	assert.Equal(t, 5, points[1].Line)
+
+	lines := []int{}
+	for _, err := range errs {
+		var lineErr *LineError
+		if assert.ErrorAs(t, err, &lineErr) {
+			lines = append(lines, lineErr.Line)
+		}
+		assert.ErrorIs(t, err, ErrInvalidLine)
+	}
+	assert.Equal(t, []int{4, 6}, lines)
+}
+
+func Test_Precision(t *testing.T) {
+	unit, err := Precision("ms")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Millisecond, unit)
+
+	unit, err = Precision("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Nanosecond, unit)
+
+	_, err = Precision("d")
+	assert.ErrorIs(t, err, ErrInvalidPrecision)
+}
+
+func Test_FieldFloat(t *testing.T) {
+	tests := []struct {
+		Value         interface{}
+		Name          string
+		Expected      float64
+		ExpectedValid bool
+	}{
+		{Name: "float", Value: 1.5, Expected: 1.5, ExpectedValid: true},
+		{Name: "integer", Value: int64(-3), Expected: -3, ExpectedValid: true},
+		{Name: "unsigned", Value: uint64(3), Expected: 3, ExpectedValid: true},
+		{Name: "true", Value: true, Expected: 1, ExpectedValid: true},
+		{Name: "false", Value: false, Expected: 0, ExpectedValid: true},
+		{Name: "string", Value: "ok", ExpectedValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			v, ok := Field{Key: "f", Value: tt.Value}.Float()
+			assert.Equal(t, tt.ExpectedValid, ok)
+			assert.Equal(t, tt.Expected, v)
+		})
+	}
+}
@@ -6,14 +6,36 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var (
 	ErrStorageIsNotInitialized   = errors.New("storage is not initialized")
 	ErrMetricDoesntExist         = errors.New("metric doesn't exist")
 	ErrCannotUpdateInvalidFormat = errors.New("cannot update metric: invalid format")
+	ErrUnsupportedHashVersion    = errors.New("unsupported hash version")
 )
 
+// Versions of metric encoding used as HMAC input.
+const (
+	// Legacy encoding: "id:type:delta" and "id:type:value" with value rounded to 6 decimal places.
+	HashV1 = 1
+
+	// Canonical encoding: escaped id and type, exact float bits and sorted labels.
+	HashV2 = 2
+
+	LatestHashVersion = HashV2
+)
+
+// List of hash versions which could be calculated and checked.
+var SupportedHashVersions = [...]int{
+	HashV1,
+	HashV2,
+}
+
 // General interface of metric storages used by Agent and Server.
 type MetricStorage interface {
 	// Returns existing metric by it's name.
@@ -22,12 +44,18 @@ type MetricStorage interface {
 	// Returns all storaged metrics in slice.
 	GetBatch() ([]*Metric, error)
 
+	// Returns existing metrics by their names. Missing names are skipped.
+	GetMetrics(ids []string) ([]*Metric, error)
+
 	// Updates metric valuable fields: overrides Value and increments Delta.
 	UpdateMetric(m *Metric) error
 
 	// Updates metrics collected in input batch by valuable fields: overrides Values and increments Deltas.
 	UpdateBatch(batch []*Metric) error
 
+	// Removes existing metric by it's name.
+	DeleteMetric(id string) error
+
 	// Checks if storage is initialized.
 	AccessCheck() error
 
@@ -35,21 +63,79 @@ type MetricStorage interface {
 	Close() error
 }
 
+// Name of tenant used when request doesn't define any.
+const DefaultTenant = ""
+
+// Storage which keeps metrics of several tenants isolated from each other.
+type TenantStorage interface {
+	MetricStorage
+
+	// Returns storage of given tenant. Default tenant storage is the storage itself.
+	ForTenant(tenant string) MetricStorage
+
+	// Returns names of tenants having metrics, including default one.
+	Tenants() ([]string, error)
+}
+
 type Metric struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Delta  *int64            `json:"delta,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+	Hash   string            `json:"hash,omitempty"`
+
+	// Unix time in milliseconds the reporting process started at. Its change means reset of counter.
+	// Isn't covered by hash and isn't kept by storages.
+	Epoch int64 `json:"epoch,omitempty"`
+}
+
+// Checks if hash version could be calculated.
+func IsHashVersionSupported(version int) bool {
+	for _, v := range SupportedHashVersions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
 }
 
-// Refreshes metric's hash by given key.
+// Refreshes metric's hash by given key using legacy encoding.
 func (m *Metric) UpdateHash(key string) error {
+	return m.UpdateHashVersion(key, HashV1)
+}
+
+// Refreshes metric's hash by given key using encoding of given version.
+func (m *Metric) UpdateHashVersion(key string, version int) error {
 	if key == "" {
 		m.Hash = ""
 		return nil
 	}
 
+	var encoded string
+
+	switch version {
+	case HashV1:
+		encoded = m.encodeV1()
+	case HashV2:
+		encoded = m.encodeV2()
+	default:
+		return ErrUnsupportedHashVersion
+	}
+
+	h := hmac.New(sha256.New, []byte(key))
+
+	if _, err := h.Write([]byte(encoded)); err != nil {
+		return err
+	}
+
+	m.Hash = hex.EncodeToString(h.Sum(nil))
+
+	return nil
+}
+
+func (m *Metric) encodeV1() string {
 	var deltaPart, valuePart string
 
 	if m.Delta != nil {
@@ -59,13 +145,74 @@ func (m *Metric) UpdateHash(key string) error {
 		valuePart = fmt.Sprintf("%s:%s:%f", m.ID, m.MType, *m.Value)
 	}
 
-	h := hmac.New(sha256.New, []byte(key))
+	return deltaPart + valuePart
+}
 
-	if _, err := h.Write([]byte(deltaPart + valuePart)); err != nil {
-		return err
+// encodeV2 gives lossless representation of metric: every part is escaped, so different
+// metrics could never be encoded the same way.
+func (m *Metric) encodeV2() string {
+	var b strings.Builder
+
+	b.WriteString("v2:")
+	b.WriteString(escapeHashPart(m.ID))
+	b.WriteByte(':')
+	b.WriteString(escapeHashPart(m.MType))
+
+	b.WriteString(":d=")
+	if m.Delta != nil {
+		b.WriteString(strconv.FormatInt(*m.Delta, 10))
 	}
 
-	m.Hash = hex.EncodeToString(h.Sum(nil))
+	b.WriteString(":v=")
+	if m.Value != nil {
+		b.WriteString(strconv.FormatUint(math.Float64bits(*m.Value), 16))
+	}
 
-	return nil
+	b.WriteString(":l=")
+
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(escapeHashPart(k))
+		b.WriteByte('=')
+		b.WriteString(escapeHashPart(m.Labels[k]))
+	}
+
+	return b.String()
+}
+
+var hashPartEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`:`, `\:`,
+	`,`, `\,`,
+	`=`, `\=`,
+)
+
+func escapeHashPart(s string) string {
+	return hashPartEscaper.Replace(s)
+}
+
+// Checksum gives hex-encoded sha256 of content of metrics: names, types, values and labels. Doesn't depend
+// on order of metrics and on storage they are read from, so storages could be compared by it.
+func Checksum(batch []*Metric) string {
+	encoded := make([]string, 0, len(batch))
+	for _, m := range batch {
+		encoded = append(encoded, m.encodeV2())
+	}
+	sort.Strings(encoded)
+
+	h := sha256.New()
+	for _, e := range encoded {
+		h.Write([]byte(e))
+		h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
 }
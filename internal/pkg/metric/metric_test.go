@@ -71,3 +71,77 @@ func Test_UpdateHash(t *testing.T) {
 		})
 	}
 }
+
+func Test_UpdateHashVersion(t *testing.T) {
+	key := "key"
+
+	hashOf := func(m *Metric, version int) string {
+		assert.NoError(t, m.UpdateHashVersion(key, version))
+		return m.Hash
+	}
+
+	value1 := 0.0000011
+	value2 := 0.0000012
+
+	t.Run("legacy version equals UpdateHash", func(t *testing.T) {
+		m := &Metric{ID: "id", MType: "gauge", Value: &value1}
+		assert.NoError(t, m.UpdateHash(key))
+
+		assert.Equal(t, m.Hash, hashOf(m, HashV1))
+	})
+
+	t.Run("small values are distinguished", func(t *testing.T) {
+		m1 := &Metric{ID: "GCCPUFraction", MType: "gauge", Value: &value1}
+		m2 := &Metric{ID: "GCCPUFraction", MType: "gauge", Value: &value2}
+
+		assert.Equal(t, hashOf(m1, HashV1), hashOf(m2, HashV1))
+		assert.NotEqual(t, hashOf(m1, HashV2), hashOf(m2, HashV2))
+	})
+
+	t.Run("id and type are escaped", func(t *testing.T) {
+		m1 := &Metric{ID: "a:b", MType: "c", Value: &value1}
+		m2 := &Metric{ID: "a", MType: "b:c", Value: &value1}
+
+		assert.Equal(t, hashOf(m1, HashV1), hashOf(m2, HashV1))
+		assert.NotEqual(t, hashOf(m1, HashV2), hashOf(m2, HashV2))
+	})
+
+	t.Run("labels are included", func(t *testing.T) {
+		m1 := &Metric{ID: "id", MType: "gauge", Value: &value1, Labels: map[string]string{"host": "a", "dc": "b"}}
+		m2 := &Metric{ID: "id", MType: "gauge", Value: &value1, Labels: map[string]string{"dc": "b", "host": "a"}}
+		m3 := &Metric{ID: "id", MType: "gauge", Value: &value1, Labels: map[string]string{"host": "a,dc=b"}}
+
+		assert.Equal(t, hashOf(m1, HashV2), hashOf(m2, HashV2))
+		assert.NotEqual(t, hashOf(m1, HashV2), hashOf(m3, HashV2))
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		m := &Metric{ID: "id", MType: "gauge", Value: &value1}
+
+		assert.ErrorIs(t, m.UpdateHashVersion(key, 100), ErrUnsupportedHashVersion)
+		assert.False(t, IsHashVersionSupported(100))
+	})
+}
+
+func Test_Checksum(t *testing.T) {
+	delta, value, other := int64(5), 0.5, 0.25
+
+	batch := []*Metric{
+		{ID: "jobs", MType: "counter", Delta: &delta},
+		{ID: "load", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}, Hash: "abc"},
+	}
+	reordered := []*Metric{
+		{ID: "load", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}},
+		{ID: "jobs", MType: "counter", Delta: &delta},
+	}
+
+	assert.Equal(t, Checksum(batch), Checksum(reordered))
+	assert.Len(t, Checksum(nil), 64)
+
+	reordered[0].Value = &other
+	assert.NotEqual(t, Checksum(batch), Checksum(reordered))
+
+	reordered[0].Value = &value
+	reordered[0].Labels = nil
+	assert.NotEqual(t, Checksum(batch), Checksum(reordered))
+}
@@ -0,0 +1,278 @@
+package metric
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Operators of label matchers. Missing label is matched as empty string.
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// Sort orders of query results. Ties are broken by metric name.
+const (
+	SortByName  = "name"
+	SortByType  = "type"
+	SortByValue = "value"
+)
+
+// Storage which could select metrics by query itself, e.g. by database.
+type Querier interface {
+	Query(q Query) ([]*Metric, error)
+}
+
+// LabelMatcher selects metrics by label value.
+type LabelMatcher struct {
+	Name  string
+	Op    string
+	Value string
+}
+
+// Cursor is position in query results. Results start after metric it points to.
+type Cursor struct {
+	ID    string  `json:"id"`
+	MType string  `json:"type"`
+	Value float64 `json:"value"`
+}
+
+// Query selects, sorts and paginates metrics. Empty fields don't restrict selection.
+type Query struct {
+	Cursor *Cursor
+
+	// Shell-like pattern of names: "*" matches any sequence, "?" matches any symbol.
+	NameGlob string
+
+	// Regular expression which whole name must match.
+	NameRegexp string
+
+	MType  string
+	SortBy string
+	Labels []LabelMatcher
+
+	// Maximal number of results. Zero means no limit.
+	Limit int
+	Desc  bool
+}
+
+// Validate checks query fields. Errors are wrapped by ErrInvalidQuery.
+func (q *Query) Validate() error {
+	switch q.SortBy {
+	case "", SortByName, SortByType, SortByValue:
+	default:
+		return fmt.Errorf("%w: unknown sort order %q", ErrInvalidQuery, q.SortBy)
+	}
+
+	if q.Limit < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+
+	if q.NameRegexp != "" {
+		if _, err := regexp.Compile(AnchorRegexp(q.NameRegexp)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+	}
+
+	for _, lm := range q.Labels {
+		switch lm.Op {
+		case MatchEqual, MatchNotEqual:
+		case MatchRegexp, MatchNotRegexp:
+			if _, err := regexp.Compile(AnchorRegexp(lm.Value)); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+			}
+		default:
+			return fmt.Errorf("%w: unknown label matcher %q", ErrInvalidQuery, lm.Op)
+		}
+	}
+
+	return nil
+}
+
+// AnchorRegexp makes regular expression which must match whole string.
+func AnchorRegexp(expr string) string {
+	return "^(?:" + expr + ")$"
+}
+
+// GlobRegexp converts name pattern to anchored regular expression.
+func GlobRegexp(glob string) string {
+	var b strings.Builder
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return AnchorRegexp(b.String())
+}
+
+// SortValue gives numeric value of metric used for sorting: value of gauge or delta of counter.
+func (m *Metric) SortValue() float64 {
+	switch {
+	case m.MType == "counter" && m.Delta != nil:
+		return float64(*m.Delta)
+	case m.Value != nil:
+		return *m.Value
+	case m.Delta != nil:
+		return float64(*m.Delta)
+	default:
+		return 0
+	}
+}
+
+// CursorOf gives cursor pointing to metric.
+func CursorOf(m *Metric) *Cursor {
+	return &Cursor{
+		ID:    m.ID,
+		MType: m.MType,
+		Value: m.SortValue(),
+	}
+}
+
+// QueryBatch selects metrics of batch by query in memory. It is used by storages which can't query themselves.
+func QueryBatch(batch []*Metric, q Query) ([]*Metric, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	match, err := q.matcher()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	res := []*Metric{}
+	for i := range batch {
+		if match(batch[i]) {
+			res = append(res, batch[i])
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return q.compare(CursorOf(res[i]), CursorOf(res[j])) < 0
+	})
+
+	if q.Limit != 0 && len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+
+	return res, nil
+}
+
+// matcher gives function which checks if metric is selected by query filters and cursor.
+func (q *Query) matcher() (func(m *Metric) bool, error) {
+	var nameGlob, nameRegexp *regexp.Regexp
+	var err error
+
+	if q.NameGlob != "" {
+		if nameGlob, err = regexp.Compile(GlobRegexp(q.NameGlob)); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.NameRegexp != "" {
+		if nameRegexp, err = regexp.Compile(AnchorRegexp(q.NameRegexp)); err != nil {
+			return nil, err
+		}
+	}
+
+	labelRegexps := make([]*regexp.Regexp, len(q.Labels))
+	for i, lm := range q.Labels {
+		if lm.Op == MatchRegexp || lm.Op == MatchNotRegexp {
+			if labelRegexps[i], err = regexp.Compile(AnchorRegexp(lm.Value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return func(m *Metric) bool {
+		if m == nil {
+			return false
+		}
+
+		if q.MType != "" && m.MType != q.MType {
+			return false
+		}
+
+		if (nameGlob != nil && !nameGlob.MatchString(m.ID)) || (nameRegexp != nil && !nameRegexp.MatchString(m.ID)) {
+			return false
+		}
+
+		for i, lm := range q.Labels {
+			value := m.Labels[lm.Name]
+
+			var ok bool
+			switch lm.Op {
+			case MatchEqual:
+				ok = value == lm.Value
+			case MatchNotEqual:
+				ok = value != lm.Value
+			case MatchRegexp:
+				ok = labelRegexps[i].MatchString(value)
+			case MatchNotRegexp:
+				ok = !labelRegexps[i].MatchString(value)
+			}
+
+			if !ok {
+				return false
+			}
+		}
+
+		return q.Cursor == nil || q.compare(q.Cursor, CursorOf(m)) < 0
+	}, nil
+}
+
+// compare compares positions of metrics in query results.
+func (q *Query) compare(a, b *Cursor) int {
+	var c int
+
+	switch q.SortBy {
+	case SortByType:
+		c = strings.Compare(a.MType, b.MType)
+	case SortByValue:
+		c = compareValues(a.Value, b.Value)
+	}
+
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+
+	if q.Desc {
+		return -c
+	}
+
+	return c
+}
+
+// compareValues orders NaN after all numbers, as Postgres does, so NaN values are paginated too.
+func compareValues(a, b float64) int {
+	aNaN, bNaN := math.IsNaN(a), math.IsNaN(b)
+
+	switch {
+	case aNaN && bNaN:
+		return 0
+	case aNaN:
+		return 1
+	case bNaN:
+		return -1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,160 @@
+package metric
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func queryTestBatch() []*Metric {
+	gauge := func(id string, value float64, labels map[string]string) *Metric {
+		return &Metric{ID: id, MType: "gauge", Value: &value, Labels: labels}
+	}
+	counter := func(id string, delta int64) *Metric {
+		var zero float64
+		return &Metric{ID: id, MType: "counter", Delta: &delta, Value: &zero}
+	}
+
+	return []*Metric{
+		gauge("HeapAlloc", 30, map[string]string{"host": "a"}),
+		gauge("HeapSys", 10, map[string]string{"host": "b"}),
+		gauge("Alloc", 20, nil),
+		counter("PollCount", 5),
+		counter("Heap.Count", 50),
+	}
+}
+
+func ids(batch []*Metric) []string {
+	res := []string{}
+	for i := range batch {
+		res = append(res, batch[i].ID)
+	}
+
+	return res
+}
+
+func Test_QueryBatch(t *testing.T) {
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Expected      []string
+		Query         Query
+	}{
+		{
+			Name:     "everything sorted by name",
+			Query:    Query{},
+			Expected: []string{"Alloc", "Heap.Count", "HeapAlloc", "HeapSys", "PollCount"},
+		},
+		{
+			Name:     "glob",
+			Query:    Query{NameGlob: "Heap*"},
+			Expected: []string{"Heap.Count", "HeapAlloc", "HeapSys"},
+		},
+		{
+			Name:     "glob with single symbol and escaped dot",
+			Query:    Query{NameGlob: "Heap?Count"},
+			Expected: []string{"Heap.Count"},
+		},
+		{
+			Name:     "regexp is anchored",
+			Query:    Query{NameRegexp: "Heap(Alloc|Sys)"},
+			Expected: []string{"HeapAlloc", "HeapSys"},
+		},
+		{
+			Name:     "type",
+			Query:    Query{MType: "counter"},
+			Expected: []string{"Heap.Count", "PollCount"},
+		},
+		{
+			Name:     "label equal",
+			Query:    Query{Labels: []LabelMatcher{{Name: "host", Op: MatchEqual, Value: "a"}}},
+			Expected: []string{"HeapAlloc"},
+		},
+		{
+			Name:     "label not equal matches missing label",
+			Query:    Query{Labels: []LabelMatcher{{Name: "host", Op: MatchNotEqual, Value: "a"}}},
+			Expected: []string{"Alloc", "Heap.Count", "HeapSys", "PollCount"},
+		},
+		{
+			Name:     "label regexp",
+			Query:    Query{Labels: []LabelMatcher{{Name: "host", Op: MatchRegexp, Value: "a|b"}}},
+			Expected: []string{"HeapAlloc", "HeapSys"},
+		},
+		{
+			Name:     "label not regexp",
+			Query:    Query{Labels: []LabelMatcher{{Name: "host", Op: MatchNotRegexp, Value: ".+"}}},
+			Expected: []string{"Alloc", "Heap.Count", "PollCount"},
+		},
+		{
+			Name:     "sort by value descending",
+			Query:    Query{SortBy: SortByValue, Desc: true},
+			Expected: []string{"Heap.Count", "HeapAlloc", "Alloc", "HeapSys", "PollCount"},
+		},
+		{
+			Name:     "sort by type with ties by name",
+			Query:    Query{SortBy: SortByType},
+			Expected: []string{"Heap.Count", "PollCount", "Alloc", "HeapAlloc", "HeapSys"},
+		},
+		{
+			Name:     "limit",
+			Query:    Query{Limit: 2},
+			Expected: []string{"Alloc", "Heap.Count"},
+		},
+		{
+			Name:     "cursor",
+			Query:    Query{SortBy: SortByValue, Cursor: &Cursor{ID: "Alloc", MType: "gauge", Value: 20}, Limit: 2},
+			Expected: []string{"HeapAlloc", "Heap.Count"},
+		},
+		{
+			Name:     "cursor descending",
+			Query:    Query{Desc: true, Cursor: &Cursor{ID: "HeapAlloc"}},
+			Expected: []string{"Heap.Count", "Alloc"},
+		},
+		{
+			Name:          "invalid regexp",
+			Query:         Query{NameRegexp: "("},
+			ExpectedError: ErrInvalidQuery,
+		},
+		{
+			Name:          "unknown sort",
+			Query:         Query{SortBy: "hash"},
+			ExpectedError: ErrInvalidQuery,
+		},
+		{
+			Name:          "unknown matcher",
+			Query:         Query{Labels: []LabelMatcher{{Name: "host", Op: "~"}}},
+			ExpectedError: ErrInvalidQuery,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			res, err := QueryBatch(queryTestBatch(), tt.Query)
+			assert.ErrorIs(t, err, tt.ExpectedError)
+
+			if tt.ExpectedError == nil {
+				assert.Equal(t, tt.Expected, ids(res))
+			}
+		})
+	}
+}
+
+func Test_QueryBatchNaN(t *testing.T) {
+	batch := queryTestBatch()
+	nan := math.NaN()
+	batch = append(batch, &Metric{ID: "Broken", MType: "gauge", Value: &nan})
+
+	// NaN follows all numbers, so pages after it and before it are consistent.
+	res, err := QueryBatch(batch, Query{SortBy: SortByValue})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PollCount", "HeapSys", "Alloc", "HeapAlloc", "Heap.Count", "Broken"}, ids(res))
+
+	res, err = QueryBatch(batch, Query{SortBy: SortByValue, Cursor: CursorOf(batch[0])})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Heap.Count", "Broken"}, ids(res))
+
+	res, err = QueryBatch(batch, Query{SortBy: SortByValue, Desc: true, Cursor: CursorOf(batch[len(batch)-1])})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Heap.Count", "HeapAlloc", "Alloc", "HeapSys", "PollCount"}, ids(res))
+}
@@ -0,0 +1,356 @@
+// Package migrate copies metrics of all tenants between storages of different backends, e.g. from file to Postgres.
+//
+// Metrics are copied in batches. Destination gets values of source: gauges are overwritten and counters are
+// incremented by difference with source, so copying batch twice keeps the same state. Progress is saved after
+// every batch, so interrupted migration continues from the last copied batch. After copying every tenant of
+// destination is compared with source by number of metrics and their checksum.
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/filestorage"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/pgxstorage"
+)
+
+var (
+	ErrInvalidLocation = errors.New("invalid storage location")
+	ErrStateMismatch   = errors.New("migration state belongs to other storages")
+	ErrVerification    = errors.New("migrated metrics don't match source")
+)
+
+const (
+	DefaultBatchSize = 500
+
+	// Prefix of location of file storage, e.g. "file:./tmp/metricStorage.json".
+	FilePrefix = "file:"
+)
+
+// Storage is storage of migration. Flush persists changes of storages keeping them in memory.
+type Storage struct {
+	metric.TenantStorage
+	flush    func() error
+	Location string
+}
+
+// Open opens storage by location: "file:<path>" or Postgres URL "postgres://...". Missing file is opened
+// as empty storage only if create is set, e.g. for destination.
+func Open(location string, create bool) (*Storage, error) {
+	switch {
+	case strings.HasPrefix(location, FilePrefix):
+		path := strings.TrimPrefix(location, FilePrefix)
+		if path == "" {
+			return nil, fmt.Errorf("%w: %q: path is not defined", ErrInvalidLocation, location)
+		}
+
+		st := filestorage.New(path)
+		if err := st.DownloadStorage(); err != nil && !(create && errors.Is(err, os.ErrNotExist)) {
+			return nil, err
+		}
+
+		return &Storage{TenantStorage: st, Location: location, flush: st.UploadStorage}, nil
+	case strings.HasPrefix(location, "postgres://"), strings.HasPrefix(location, "postgresql://"):
+		st, err := pgxstorage.New(location, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Storage{TenantStorage: st, Location: location}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidLocation, location)
+	}
+}
+
+// Flush persists changes of storage.
+func (s *Storage) Flush() error {
+	if s.flush == nil {
+		return nil
+	}
+
+	return s.flush()
+}
+
+// Options of migration.
+type Options struct {
+	// File progress is saved to. If is empty, migration starts from the beginning every time.
+	StatePath string
+
+	// Number of metrics copied at once. If is not positive, DefaultBatchSize is used.
+	BatchSize int
+
+	// Defines if source is only read and compared with destination, nothing is changed.
+	DryRun bool
+}
+
+// TenantReport describes migration of tenant. Existing is number of metrics of destination before migration
+// in dry run and after it otherwise.
+type TenantReport struct {
+	Tenant   string `json:"tenant"`
+	Checksum string `json:"checksum"`
+	Metrics  int    `json:"metrics"`
+	Copied   int    `json:"copied"`
+	Existing int    `json:"existing"`
+	Verified bool   `json:"verified"`
+}
+
+// Report describes migration.
+type Report struct {
+	Tenants []TenantReport `json:"tenants"`
+	Metrics int            `json:"metrics"`
+	Copied  int            `json:"copied"`
+	Batches int            `json:"batches"`
+	Resumed bool           `json:"resumed"`
+	DryRun  bool           `json:"dry_run"`
+}
+
+// state is progress of migration: tenants are copied in order of names, metrics of tenant in order of ids.
+type state struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Tenant string `json:"tenant"`
+	Last   string `json:"last"`
+}
+
+// copied checks if metric of tenant is copied already.
+func (s *state) copied(tenant, id string) bool {
+	return tenant < s.Tenant || (tenant == s.Tenant && id <= s.Last)
+}
+
+// Run copies metrics of every tenant from source to destination and verifies them. Gives ErrVerification
+// if destination differs from source after migration, e.g. because destination had other metrics already.
+// State file is removed after successful migration.
+func Run(from, to *Storage, opts Options) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	progress, err := loadState(opts.StatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if progress != nil && (progress.From != from.Location || progress.To != to.Location) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrStateMismatch, progress.From, progress.To)
+	}
+
+	report := &Report{DryRun: opts.DryRun, Resumed: progress != nil && !opts.DryRun}
+	if progress == nil || opts.DryRun {
+		progress = &state{From: from.Location, To: to.Location}
+	}
+
+	tenants, err := from.Tenants()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tenants)
+
+	for _, tenant := range tenants {
+		batch, err := from.ForTenant(tenant).GetBatch()
+		if err != nil {
+			return report, err
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		sort.Slice(batch, func(i, j int) bool {
+			return batch[i].ID < batch[j].ID
+		})
+
+		tr := TenantReport{Tenant: tenant, Metrics: len(batch), Checksum: metric.Checksum(batch)}
+		report.Metrics += len(batch)
+
+		if !opts.DryRun {
+			if tr.Copied, err = copyTenant(to, tenant, batch, progress, opts, report); err != nil {
+				return report, err
+			}
+			report.Copied += tr.Copied
+		}
+
+		stored, err := to.ForTenant(tenant).GetBatch()
+		if err != nil {
+			return report, err
+		}
+
+		tr.Existing = len(stored)
+		tr.Verified = len(stored) == len(batch) && metric.Checksum(stored) == tr.Checksum
+
+		report.Tenants = append(report.Tenants, tr)
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	failed := []string{}
+	for _, tr := range report.Tenants {
+		if !tr.Verified {
+			failed = append(failed, fmt.Sprintf("%q: %d of %d metrics", tr.Tenant, tr.Existing, tr.Metrics))
+		}
+	}
+
+	if len(failed) > 0 {
+		return report, fmt.Errorf("%w: %s", ErrVerification, strings.Join(failed, ", "))
+	}
+
+	if opts.StatePath != "" {
+		if err := os.Remove(opts.StatePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// copyTenant copies metrics of tenant which are not copied yet by batches and saves progress after every batch.
+// Gives number of copied metrics.
+func copyTenant(to *Storage, tenant string, batch []*metric.Metric, progress *state, opts Options, report *Report) (int, error) {
+	pending := make([]*metric.Metric, 0, len(batch))
+	for _, m := range batch {
+		if !progress.copied(tenant, m.ID) {
+			pending = append(pending, m)
+		}
+	}
+
+	st := to.ForTenant(tenant)
+
+	for start := 0; start < len(pending); start += opts.BatchSize {
+		end := start + opts.BatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+
+		if err := copyBatch(st, pending[start:end]); err != nil {
+			return start, err
+		}
+
+		if err := to.Flush(); err != nil {
+			return start, err
+		}
+
+		progress.Tenant, progress.Last = tenant, pending[end-1].ID
+		if err := saveState(opts.StatePath, progress); err != nil {
+			return end, err
+		}
+
+		report.Batches++
+		log.Printf("tenant %q: %d of %d metrics are copied", tenant, len(batch)-len(pending)+end, len(batch))
+	}
+
+	return len(pending), nil
+}
+
+// copyBatch sets metrics of destination to values of source. Counters are incremented by difference with source.
+func copyBatch(st metric.MetricStorage, batch []*metric.Metric) error {
+	counterIDs := []string{}
+	for _, m := range batch {
+		if m.Delta != nil {
+			counterIDs = append(counterIDs, m.ID)
+		}
+	}
+
+	current := map[string]int64{}
+	if len(counterIDs) > 0 {
+		stored, err := st.GetMetrics(counterIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range stored {
+			if m.Delta != nil {
+				current[m.ID] = *m.Delta
+			}
+		}
+	}
+
+	copied := make([]*metric.Metric, 0, len(batch))
+	for _, m := range batch {
+		c := *m
+		c.Hash = ""
+
+		if m.Delta != nil {
+			increment := *m.Delta - current[m.ID]
+			c.Delta = &increment
+		}
+
+		copied = append(copied, &c)
+	}
+
+	return st.UpdateBatch(copied)
+}
+
+// loadState reads progress of migration. Gives nil if migration wasn't started.
+func loadState(path string) (*state, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &state{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid migration state %q: %v", path, err)
+	}
+
+	return s, nil
+}
+
+// saveState writes progress of migration. File is replaced at once, so interruption never leaves it broken.
+func saveState(path string, s *state) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		if errClose := tmp.Close(); errClose != nil {
+			log.Println(errClose)
+		}
+		removeTemp(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		removeTemp(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		removeTemp(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// removeTemp removes temporary file of failed save.
+func removeTemp(name string) {
+	if errRemove := os.Remove(name); errRemove != nil {
+		log.Println(errRemove)
+	}
+}
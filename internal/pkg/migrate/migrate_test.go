@@ -0,0 +1,181 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+// failing is destination which fails updates after given number of them, e.g. because of lost connection.
+type failing struct {
+	metric.TenantStorage
+	left *int
+}
+
+func (f failing) ForTenant(tenant string) metric.MetricStorage {
+	return failingTenant{MetricStorage: f.TenantStorage.ForTenant(tenant), left: f.left}
+}
+
+type failingTenant struct {
+	metric.MetricStorage
+	left *int
+}
+
+func (f failingTenant) UpdateBatch(batch []*metric.Metric) error {
+	if *f.left == 0 {
+		return errors.New("connection is lost")
+	}
+	*f.left--
+
+	return f.MetricStorage.UpdateBatch(batch)
+}
+
+// sourceFile writes file storage with counters "c0".."c<n-1>" of tenant "team" and gauge "up" of default tenant.
+func sourceFile(t *testing.T, n int) string {
+	location := FilePrefix + filepath.Join(t.TempDir(), "source.json")
+
+	src, err := Open(location, true)
+	assert.NoError(t, err)
+
+	for i := 0; i < n; i++ {
+		delta := int64(i)
+		assert.NoError(t, src.ForTenant("team").UpdateMetric(&metric.Metric{ID: "c" + strconv.Itoa(i), MType: "counter", Delta: &delta}))
+	}
+
+	up := 1.0
+	assert.NoError(t, src.UpdateMetric(&metric.Metric{ID: "up", MType: "gauge", Value: &up, Labels: map[string]string{"host": "a"}}))
+	assert.NoError(t, src.Flush())
+
+	return location
+}
+
+func Test_Run(t *testing.T) {
+	from, err := Open(sourceFile(t, 25), false)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	toLocation := FilePrefix + filepath.Join(dir, "dest.json")
+	statePath := filepath.Join(dir, "state.json")
+
+	// Destination has one of counters already, its value is kept equal to source.
+	to, err := Open(toLocation, true)
+	assert.NoError(t, err)
+	delta := int64(100)
+	assert.NoError(t, to.ForTenant("team").UpdateMetric(&metric.Metric{ID: "c3", MType: "counter", Delta: &delta}))
+
+	report, err := Run(from, to, Options{StatePath: statePath, BatchSize: 10, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 26, report.Metrics)
+	assert.Equal(t, 0, report.Copied)
+	if assert.Len(t, report.Tenants, 2) {
+		assert.Equal(t, "team", report.Tenants[1].Tenant)
+		assert.Equal(t, 1, report.Tenants[1].Existing)
+		assert.False(t, report.Tenants[1].Verified)
+	}
+	_, err = os.Stat(statePath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The first run is interrupted after two batches.
+	left := 2
+	interrupted := &Storage{TenantStorage: failing{TenantStorage: to.TenantStorage, left: &left}, Location: to.Location, flush: to.flush}
+
+	_, err = Run(from, interrupted, Options{StatePath: statePath, BatchSize: 10})
+	assert.Error(t, err)
+	_, err = os.Stat(statePath)
+	assert.NoError(t, err)
+
+	// Migration continues from saved progress in other process.
+	to, err = Open(toLocation, false)
+	assert.NoError(t, err)
+
+	report, err = Run(from, to, Options{StatePath: statePath, BatchSize: 10})
+	assert.NoError(t, err)
+	assert.True(t, report.Resumed)
+	assert.Equal(t, 15, report.Copied)
+	assert.Equal(t, 2, report.Batches)
+
+	for _, tr := range report.Tenants {
+		assert.True(t, tr.Verified, tr.Tenant)
+	}
+	_, err = os.Stat(statePath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	to, err = Open(toLocation, false)
+	assert.NoError(t, err)
+
+	m, err := to.ForTenant("team").GetMetric("c3")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), *m.Delta)
+
+	m, err = to.GetMetric("up")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", m.Labels["host"])
+
+	// Repeated migration changes nothing.
+	report, err = Run(from, to, Options{StatePath: statePath})
+	assert.NoError(t, err)
+	assert.Equal(t, 26, report.Copied)
+	m, err = to.ForTenant("team").GetMetric("c3")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), *m.Delta)
+}
+
+func Test_RunVerification(t *testing.T) {
+	from, err := Open(sourceFile(t, 3), false)
+	assert.NoError(t, err)
+
+	to, err := Open(FilePrefix+filepath.Join(t.TempDir(), "dest.json"), true)
+	assert.NoError(t, err)
+
+	extra := 1.0
+	assert.NoError(t, to.ForTenant("team").UpdateMetric(&metric.Metric{ID: "extra", MType: "gauge", Value: &extra}))
+
+	report, err := Run(from, to, Options{})
+	assert.ErrorIs(t, err, ErrVerification)
+	assert.Equal(t, 4, report.Copied)
+}
+
+func Test_RunStateMismatch(t *testing.T) {
+	from, err := Open(sourceFile(t, 1), false)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	to, err := Open(FilePrefix+filepath.Join(dir, "dest.json"), true)
+	assert.NoError(t, err)
+
+	statePath := filepath.Join(dir, "state.json")
+	assert.NoError(t, saveState(statePath, &state{From: "file:other.json", To: to.Location}))
+
+	_, err = Run(from, to, Options{StatePath: statePath})
+	assert.ErrorIs(t, err, ErrStateMismatch)
+}
+
+func Test_Open(t *testing.T) {
+	for _, location := range []string{"", "file:", "mysql://localhost/metrics", "./metrics.json"} {
+		_, err := Open(location, true)
+		assert.ErrorIs(t, err, ErrInvalidLocation, location)
+	}
+
+	_, err := Open(FilePrefix+filepath.Join(t.TempDir(), "missing.json"), false)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func Test_saveStateFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	// State can't replace directory, temporary file is removed.
+	statePath := filepath.Join(dir, "state")
+	assert.NoError(t, os.Mkdir(statePath, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(statePath, "file"), nil, 0o644))
+
+	assert.Error(t, saveState(statePath, &state{}))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
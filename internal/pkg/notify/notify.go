@@ -0,0 +1,304 @@
+// Package notify sends alert state changes to webhook targets.
+//
+// Changes are deduplicated: the same state of the same alert is sent at most once per dedup window,
+// so flapping metrics don't spam targets. Changes of every tenant are grouped during group window
+// and sent as one request per target.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/goslammu/yp_go_devops/internal/pkg/delivery"
+)
+
+var (
+	ErrInvalidTarget = errors.New("invalid webhook target")
+	ErrInvalidBody   = errors.New("webhook template produced invalid json")
+	ErrDelivery      = errors.New("webhook delivery failed")
+)
+
+const (
+	DefaultRetries = 3
+	DefaultBackoff = time.Second
+	DefaultTimeout = 10 * time.Second
+)
+
+// Target is webhook receiving alerts. Template is text/template rendering Payload to json body,
+// function "json" encodes any value. Without template Payload is sent as is.
+// Zero Retries and Backoff mean defaults, negative Retries turn retries off.
+type Target struct {
+	tmpl     *template.Template
+	Headers  map[string]string `json:"headers,omitempty"`
+	Name     string            `json:"name"`
+	URL      string            `json:"url"`
+	Template string            `json:"template,omitempty"`
+	Tenants  []string          `json:"tenants,omitempty"`
+	Backoff  time.Duration     `json:"backoff,omitempty"`
+	Retries  int               `json:"retries,omitempty"`
+}
+
+// Payload is data of one webhook request: alerts of tenant changed during group window.
+type Payload struct {
+	Time   time.Time        `json:"time"`
+	Target string           `json:"target"`
+	Tenant string           `json:"tenant,omitempty"`
+	Alerts []alerting.Alert `json:"alerts"`
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Compile checks target and parses its template.
+func (t *Target) Compile() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is not defined", ErrInvalidTarget)
+	}
+
+	if t.URL == "" {
+		return fmt.Errorf("%w %q: url is not defined", ErrInvalidTarget, t.Name)
+	}
+
+	t.tmpl = nil
+
+	if t.Template != "" {
+		tmpl, err := template.New(t.Name).Funcs(funcs).Option("missingkey=error").Parse(t.Template)
+		if err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidTarget, t.Name, err)
+		}
+		t.tmpl = tmpl
+	}
+
+	return nil
+}
+
+// Body renders json body of payload.
+func (t *Target) Body(p Payload) ([]byte, error) {
+	if t.tmpl == nil {
+		return json.Marshal(p)
+	}
+
+	buf := bytes.Buffer{}
+	if err := t.tmpl.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("%w: target %q", ErrInvalidBody, t.Name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// accepts defines if target receives alerts of tenant.
+func (t *Target) accepts(tenant string) bool {
+	if len(t.Tenants) == 0 {
+		return true
+	}
+
+	for _, tn := range t.Tenants {
+		if tn == tenant {
+			return true
+		}
+	}
+
+	return false
+}
+
+type dedupKey struct {
+	tenant string
+	rule   string
+	state  string
+}
+
+// group is alerts of tenant waiting to be sent to target.
+type group struct {
+	opened time.Time
+	alerts []alerting.Alert
+}
+
+type groupKey struct {
+	tenant string
+	target int
+}
+
+// Notifier groups alert changes and sends them to targets. Is concurrent-safe.
+type Notifier struct {
+	client *http.Client
+	sent   map[dedupKey]time.Time
+	groups map[groupKey]*group
+	// ctx is cancelled by Close, so pending deliveries and their retries end.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wait        func(time.Duration) bool
+	key         string
+	targets     []Target
+	groupWindow time.Duration
+	dedupWindow time.Duration
+	mu          sync.Mutex
+}
+
+// Constructor. Requests are signed by key if it is not empty.
+func New(targets []Target, key string, groupWindow, dedupWindow time.Duration) (*Notifier, error) {
+	n := &Notifier{
+		client:      &http.Client{Timeout: DefaultTimeout},
+		sent:        map[dedupKey]time.Time{},
+		groups:      map[groupKey]*group{},
+		key:         key,
+		targets:     make([]Target, len(targets)),
+		groupWindow: groupWindow,
+		dedupWindow: dedupWindow,
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.wait = n.sleep
+
+	for i := range targets {
+		n.targets[i] = targets[i]
+		if err := n.targets[i].Compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// Close cancels pending deliveries. Groups flushed later aren't delivered.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+
+	n.cancel()
+}
+
+// sleep waits for duration. Gives false if notifier is closed.
+func (n *Notifier) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-n.ctx.Done():
+		return false
+	}
+}
+
+// Add puts changed alerts into groups of targets. Alerts having the same state sent during dedup window are skipped.
+func (n *Notifier) Add(now time.Time, alerts []alerting.Alert) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for k, t := range n.sent {
+		if now.Sub(t) >= n.dedupWindow {
+			delete(n.sent, k)
+		}
+	}
+
+	for _, a := range alerts {
+		k := dedupKey{tenant: a.Tenant, rule: a.Rule, state: a.State}
+		if _, ok := n.sent[k]; ok {
+			continue
+		}
+
+		if n.dedupWindow > 0 {
+			n.sent[k] = now
+		}
+
+		for i := range n.targets {
+			if !n.targets[i].accepts(a.Tenant) {
+				continue
+			}
+
+			gk := groupKey{tenant: a.Tenant, target: i}
+
+			g, ok := n.groups[gk]
+			if !ok {
+				g = &group{opened: now}
+				n.groups[gk] = g
+			}
+			g.alerts = append(g.alerts, a)
+		}
+	}
+}
+
+// Flush sends groups opened at least group window ago. Gives errors of undelivered groups, they are dropped.
+func (n *Notifier) Flush(now time.Time) []error {
+	n.mu.Lock()
+
+	ready := []groupKey{}
+	for k, g := range n.groups {
+		if now.Sub(g.opened) >= n.groupWindow {
+			ready = append(ready, k)
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		if ready[i].target != ready[j].target {
+			return ready[i].target < ready[j].target
+		}
+		return ready[i].tenant < ready[j].tenant
+	})
+
+	payloads := make([]Payload, len(ready))
+	for i, k := range ready {
+		payloads[i] = Payload{
+			Time:   now,
+			Target: n.targets[k.target].Name,
+			Tenant: k.tenant,
+			Alerts: n.groups[k].alerts,
+		}
+		delete(n.groups, k)
+	}
+
+	n.mu.Unlock()
+
+	errs := []error{}
+
+	for i, k := range ready {
+		if err := n.send(&n.targets[k.target], payloads[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// send posts payload to target, failed attempts are retried with exponential backoff.
+// Client errors except of 429 aren't retried.
+func (n *Notifier) send(t *Target, p Payload) error {
+	body, err := t.Body(p)
+	if err != nil {
+		return err
+	}
+
+	retries, backoff := t.Retries, t.Backoff
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+
+	err = delivery.Retry(t.Name, retries, backoff, n.wait, func() (bool, error) {
+		return delivery.Post(n.ctx, n.client, t.URL, "application/json", t.Headers, body, n.key)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: target %q: %v", ErrDelivery, t.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "secret"
+
+// receiver is webhook target recording delivered bodies. First failures requests are answered by status.
+type receiver struct {
+	*httptest.Server
+	bodies   [][]byte
+	status   int
+	failures int
+	attempts int
+	mu       sync.Mutex
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rc := &receiver{}
+
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(signer.HeaderTimestamp), 10, 64)
+		assert.NoError(t, err)
+
+		sign, err := signer.Sign(testKey, r.Method, r.URL.Path, r.URL.RawQuery, timestamp, r.Header.Get(signer.HeaderNonce), body)
+		assert.NoError(t, err)
+		assert.Equal(t, sign, r.Header.Get(signer.HeaderSignature))
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		rc.attempts++
+		if rc.attempts <= rc.failures {
+			w.WriteHeader(rc.status)
+			return
+		}
+
+		rc.bodies = append(rc.bodies, body)
+	}))
+	t.Cleanup(rc.Close)
+
+	return rc
+}
+
+func (rc *receiver) payloads(t *testing.T) []Payload {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	res := []Payload{}
+	for _, b := range rc.bodies {
+		p := Payload{}
+		assert.NoError(t, json.Unmarshal(b, &p))
+		res = append(res, p)
+	}
+
+	return res
+}
+
+func newTestNotifier(t *testing.T, targets []Target, groupWindow, dedupWindow time.Duration) *Notifier {
+	n, err := New(targets, testKey, groupWindow, dedupWindow)
+	assert.NoError(t, err)
+	n.wait = func(time.Duration) bool { return true }
+
+	return n
+}
+
+func alert(tenant, rule, state string) alerting.Alert {
+	return alerting.Alert{Tenant: tenant, Rule: rule, State: state, Expr: rule + " > 1", Value: 2}
+}
+
+func Test_NotifierGrouping(t *testing.T) {
+	rc := newReceiver(t)
+	n := newTestNotifier(t, []Target{{Name: "ops", URL: rc.URL}}, time.Minute, 0)
+
+	start := time.Now()
+	n.Add(start, []alerting.Alert{alert("team", "heap", alerting.StateFiring)})
+	n.Add(start.Add(30*time.Second), []alerting.Alert{
+		alert("team", "stuck", alerting.StatePending),
+		alert("", "absent", alerting.StateFiring),
+	})
+
+	assert.Empty(t, n.Flush(start.Add(59*time.Second)))
+	assert.Empty(t, rc.payloads(t))
+
+	assert.Empty(t, n.Flush(start.Add(time.Minute)))
+	payloads := rc.payloads(t)
+	assert.Len(t, payloads, 1)
+	assert.Equal(t, "ops", payloads[0].Target)
+	assert.Equal(t, "team", payloads[0].Tenant)
+	assert.Len(t, payloads[0].Alerts, 2)
+
+	assert.Empty(t, n.Flush(start.Add(90*time.Second)))
+	payloads = rc.payloads(t)
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, "", payloads[1].Tenant)
+	assert.Equal(t, "absent", payloads[1].Alerts[0].Rule)
+}
+
+func Test_NotifierDedup(t *testing.T) {
+	rc := newReceiver(t)
+	n := newTestNotifier(t, []Target{{Name: "ops", URL: rc.URL}}, 0, 10*time.Minute)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		now := start.Add(time.Duration(i) * time.Minute)
+		n.Add(now, []alerting.Alert{alert("", "heap", alerting.StateFiring)})
+		n.Add(now.Add(30*time.Second), []alerting.Alert{alert("", "heap", alerting.StateResolved)})
+		assert.Empty(t, n.Flush(now.Add(30*time.Second)))
+	}
+	assert.Len(t, rc.payloads(t), 1)
+
+	n.Add(start.Add(10*time.Minute), []alerting.Alert{alert("", "heap", alerting.StateFiring)})
+	assert.Empty(t, n.Flush(start.Add(10*time.Minute)))
+	assert.Len(t, rc.payloads(t), 2)
+}
+
+func Test_NotifierRetries(t *testing.T) {
+	tests := []struct {
+		Name             string
+		Status           int
+		Failures         int
+		Retries          int
+		ExpectedAttempts int
+		ExpectedError    bool
+	}{
+		{Name: "recovered", Status: http.StatusServiceUnavailable, Failures: 2, ExpectedAttempts: 3},
+		{Name: "retries exhausted", Status: http.StatusInternalServerError, Failures: 5, Retries: 1, ExpectedAttempts: 2, ExpectedError: true},
+		{Name: "rate limited", Status: http.StatusTooManyRequests, Failures: 1, ExpectedAttempts: 2},
+		{Name: "client error", Status: http.StatusBadRequest, Failures: 1, ExpectedAttempts: 1, ExpectedError: true},
+		{Name: "retries off", Status: http.StatusBadGateway, Failures: 1, Retries: -1, ExpectedAttempts: 1, ExpectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			rc := newReceiver(t)
+			rc.status, rc.failures = tt.Status, tt.Failures
+
+			n := newTestNotifier(t, []Target{{Name: "ops", URL: rc.URL, Retries: tt.Retries}}, 0, 0)
+
+			backoffs := []time.Duration{}
+			n.wait = func(d time.Duration) bool {
+				backoffs = append(backoffs, d)
+				return true
+			}
+
+			now := time.Now()
+			n.Add(now, []alerting.Alert{alert("", "heap", alerting.StateFiring)})
+			errs := n.Flush(now)
+
+			assert.Equal(t, tt.ExpectedAttempts, rc.attempts)
+			if tt.ExpectedError {
+				assert.Len(t, errs, 1)
+				assert.ErrorIs(t, errs[0], ErrDelivery)
+				return
+			}
+
+			assert.Empty(t, errs)
+			assert.Len(t, rc.payloads(t), 1)
+			for i := 1; i < len(backoffs); i++ {
+				assert.Equal(t, 2*backoffs[i-1], backoffs[i])
+			}
+		})
+	}
+}
+
+func Test_NotifierClose(t *testing.T) {
+	// Receiver answers slowly, then asks to retry after long backoff.
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer hanging.Close()
+
+	for _, delay := range []time.Duration{0, 200 * time.Millisecond} {
+		n, err := New([]Target{{Name: "ops", URL: hanging.URL, Retries: 10, Backoff: time.Hour}}, "", 0, 0)
+		assert.NoError(t, err)
+
+		now := time.Now()
+		n.Add(now, []alerting.Alert{alert("", "heap", alerting.StateFiring)})
+
+		done := make(chan []error, 1)
+		go func() {
+			done <- n.Flush(now)
+		}()
+
+		// Delivery is closed either during request or during backoff.
+		time.Sleep(10*time.Millisecond + delay)
+		n.Close()
+
+		select {
+		case errs := <-done:
+			if assert.Len(t, errs, 1) {
+				assert.ErrorIs(t, errs[0], ErrDelivery)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("delivery isn't cancelled")
+		}
+	}
+
+	(*Notifier)(nil).Close()
+}
+
+func Test_TargetTemplate(t *testing.T) {
+	rc := newReceiver(t)
+	n := newTestNotifier(t, []Target{
+		{
+			Name:     "chat",
+			URL:      rc.URL,
+			Template: `{"text":{{json (printf "%d alerts of %s" (len .Alerts) .Tenant)}},"first":{{json (index .Alerts 0).Rule}}}`,
+			Tenants:  []string{"team"},
+		},
+	}, 0, 0)
+
+	now := time.Now()
+	n.Add(now, []alerting.Alert{alert("team", "heap", alerting.StateFiring), alert("other", "heap", alerting.StateFiring)})
+	assert.Empty(t, n.Flush(now))
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	assert.Len(t, rc.bodies, 1)
+	assert.JSONEq(t, `{"text":"1 alerts of team","first":"heap"}`, string(rc.bodies[0]))
+}
+
+func Test_TargetCompile(t *testing.T) {
+	tests := []struct {
+		ExpectedError     error
+		ExpectedBodyError error
+		Name              string
+		Target            Target
+	}{
+		{Name: "valid", Target: Target{Name: "ops", URL: "http://localhost", Template: `{"n":{{len .Alerts}}}`}},
+		{Name: "missing name", Target: Target{URL: "http://localhost"}, ExpectedError: ErrInvalidTarget},
+		{Name: "missing url", Target: Target{Name: "ops"}, ExpectedError: ErrInvalidTarget},
+		{Name: "invalid template", Target: Target{Name: "ops", URL: "http://localhost", Template: "{{"}, ExpectedError: ErrInvalidTarget},
+		{Name: "invalid json", Target: Target{Name: "ops", URL: "http://localhost", Template: "{{.Tenant}}"}, ExpectedBodyError: ErrInvalidBody},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := tt.Target.Compile()
+			if tt.ExpectedError != nil {
+				assert.ErrorIs(t, err, tt.ExpectedError)
+				return
+			}
+			assert.NoError(t, err)
+
+			_, err = tt.Target.Body(Payload{Tenant: "team"})
+			if tt.ExpectedBodyError != nil {
+				assert.ErrorIs(t, err, tt.ExpectedBodyError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
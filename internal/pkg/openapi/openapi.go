@@ -0,0 +1,387 @@
+// Package openapi keeps OpenAPI document of the server and validates JSON request bodies against it.
+// Only the subset of JSON Schema used by the server specification is supported:
+// type, properties, required, items, additionalProperties (as schema), enum, nullable, minimum and $ref
+// to "#/components/schemas/".
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidBody   = errors.New("request body doesn't match specification")
+	ErrInvalidSchema = errors.New("specification is invalid")
+)
+
+const (
+	jsonContentType = "application/json"
+	schemaRefPrefix = "#/components/schemas/"
+)
+
+// Document is parsed OpenAPI document. Fields not needed for validation are ignored.
+type Document struct {
+	Paths      map[string]*PathItem `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// PathItem keeps operations of path.
+type PathItem struct {
+	Get    *Operation `json:"get"`
+	Put    *Operation `json:"put"`
+	Post   *Operation `json:"post"`
+	Delete *Operation `json:"delete"`
+	Patch  *Operation `json:"patch"`
+}
+
+// Operations gives defined operations of path by upper-case http methods.
+func (p *PathItem) Operations() map[string]*Operation {
+	ops := map[string]*Operation{}
+
+	for method, op := range map[string]*Operation{
+		"GET":    p.Get,
+		"PUT":    p.Put,
+		"POST":   p.Post,
+		"DELETE": p.Delete,
+		"PATCH":  p.Patch,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+
+	return ops
+}
+
+// Operation is a method of path. If ItemResults is set, request body is array which items are validated
+// one by one by ValidateItem, so invalid items are reported without rejecting the whole request.
+type Operation struct {
+	RequestBody *RequestBody `json:"requestBody"`
+	OperationID string       `json:"operationId"`
+	ItemResults bool         `json:"x-item-results"`
+}
+
+// RequestBody describes request body of operation.
+type RequestBody struct {
+	Content  map[string]MediaType `json:"content"`
+	Required bool                 `json:"required"`
+}
+
+// MediaType keeps schema of request body content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is JSON Schema object.
+type Schema struct {
+	Properties           map[string]*Schema `json:"properties"`
+	Items                *Schema            `json:"items"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+	Minimum              *float64           `json:"minimum"`
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Required             []string           `json:"required"`
+	Enum                 []interface{}      `json:"enum"`
+	Nullable             bool               `json:"nullable"`
+}
+
+// Parse parses OpenAPI document and checks that all schema references are defined.
+func Parse(data []byte) (*Document, error) {
+	doc := &Document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+
+	for path, item := range doc.Paths {
+		if item == nil {
+			continue
+		}
+
+		for method, op := range item.Operations() {
+			if op.RequestBody == nil {
+				continue
+			}
+
+			for _, mt := range op.RequestBody.Content {
+				if err := doc.checkRefs(mt.Schema); err != nil {
+					return nil, fmt.Errorf("%w: %s %s: %v", ErrInvalidSchema, method, path, err)
+				}
+			}
+		}
+	}
+
+	for name, s := range doc.Components.Schemas {
+		if err := doc.checkRefs(s); err != nil {
+			return nil, fmt.Errorf("%w: schema %s: %v", ErrInvalidSchema, name, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// Operation gives operation of method and path template, e.g. "/value/{type}/{name}", or nil if it is not defined.
+func (d *Document) Operation(method, path string) *Operation {
+	item, ok := d.Paths[path]
+	if !ok || item == nil {
+		return nil
+	}
+
+	return item.Operations()[strings.ToUpper(method)]
+}
+
+// ValidateRequest validates JSON body of request to operation. Operations without JSON body schema accept any body.
+func (d *Document) ValidateRequest(method, path string, body []byte) error {
+	op, schema := d.bodySchema(method, path)
+	if schema == nil {
+		return nil
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return fmt.Errorf("%w: body is required", ErrInvalidBody)
+		}
+
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBody, err)
+	}
+
+	if op.ItemResults {
+		if _, ok := v.([]interface{}); !ok {
+			return fmt.Errorf("%w: $: must be array", ErrInvalidBody)
+		}
+
+		return nil
+	}
+
+	if err := d.validate(schema, v, "$"); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBody, err)
+	}
+
+	return nil
+}
+
+// ValidateItem validates item of array body of request to operation by its index.
+func (d *Document) ValidateItem(method, path string, index int, item []byte) error {
+	_, schema := d.bodySchema(method, path)
+	if schema == nil {
+		return nil
+	}
+
+	schema, err := d.resolve(schema)
+	if err != nil || schema.Items == nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(item))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBody, err)
+	}
+
+	if err := d.validate(schema.Items, v, fmt.Sprintf("$[%d]", index)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBody, err)
+	}
+
+	return nil
+}
+
+// bodySchema gives operation and schema of its JSON body, or nil schema if body isn't described.
+func (d *Document) bodySchema(method, path string) (*Operation, *Schema) {
+	op := d.Operation(method, path)
+	if op == nil || op.RequestBody == nil {
+		return op, nil
+	}
+
+	mt, ok := op.RequestBody.Content[jsonContentType]
+	if !ok {
+		return op, nil
+	}
+
+	return op, mt.Schema
+}
+
+func (d *Document) validate(s *Schema, v interface{}, at string) error {
+	s, err := d.resolve(s)
+	if err != nil {
+		return err
+	}
+
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+
+		return fmt.Errorf("%s: must not be null", at)
+	}
+
+	if len(s.Enum) != 0 && !inEnum(s.Enum, v) {
+		return fmt.Errorf("%s: must be one of %v", at, s.Enum)
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		return d.validateObject(s, v, at)
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be array", at)
+		}
+
+		if s.Items == nil {
+			return nil
+		}
+
+		for i := range items {
+			if err := d.validate(s.Items, items[i], fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: must be string", at)
+		}
+
+		return nil
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: must be boolean", at)
+		}
+
+		return nil
+	case "integer", "number":
+		return validateNumber(s, v, at)
+	default:
+		return fmt.Errorf("%s: unsupported schema type %q", at, s.Type)
+	}
+}
+
+func (d *Document) validateObject(s *Schema, v interface{}, at string) error {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: must be object", at)
+	}
+
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s.%s: is required", at, name)
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ps, ok := s.Properties[name]
+		if !ok {
+			ps = s.AdditionalProperties
+		}
+
+		if ps == nil {
+			continue
+		}
+
+		if err := d.validate(ps, obj[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateNumber(s *Schema, v interface{}, at string) error {
+	n, ok := v.(json.Number)
+	if !ok {
+		return fmt.Errorf("%s: must be %s", at, s.Type)
+	}
+
+	if s.Type == "integer" {
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%s: must be integer", at)
+		}
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("%s: must be number", at)
+	}
+
+	if s.Minimum != nil && f < *s.Minimum {
+		return fmt.Errorf("%s: must be not less than %v", at, *s.Minimum)
+	}
+
+	return nil
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve gives schema referenced by s, or s itself if it is not a reference.
+func (d *Document) resolve(s *Schema) (*Schema, error) {
+	if s == nil || s.Ref == "" {
+		return s, nil
+	}
+
+	name := strings.TrimPrefix(s.Ref, schemaRefPrefix)
+	if name == s.Ref {
+		return nil, fmt.Errorf("unsupported reference %q", s.Ref)
+	}
+
+	resolved, ok := d.Components.Schemas[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined schema %q", s.Ref)
+	}
+
+	return resolved, nil
+}
+
+func (d *Document) checkRefs(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+
+	if s.Ref != "" {
+		_, err := d.resolve(s)
+		return err
+	}
+
+	for _, ps := range s.Properties {
+		if err := d.checkRefs(ps); err != nil {
+			return err
+		}
+	}
+
+	if err := d.checkRefs(s.Items); err != nil {
+		return err
+	}
+
+	return d.checkRefs(s.AdditionalProperties)
+}
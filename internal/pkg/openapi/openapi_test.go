@@ -0,0 +1,194 @@
+package openapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSpec = `{
+	"openapi": "3.0.3",
+	"paths": {
+		"/update": {
+			"post": {
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Metric"}}}
+				}
+			}
+		},
+		"/updates": {
+			"post": {
+				"requestBody": {
+					"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Metric"}}}}
+				}
+			}
+		},
+		"/api/updates": {
+			"post": {
+				"x-item-results": true,
+				"requestBody": {
+					"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Metric"}}}}
+				}
+			}
+		},
+		"/ping": {"get": {}}
+	},
+	"components": {
+		"schemas": {
+			"Metric": {
+				"type": "object",
+				"required": ["id", "type"],
+				"properties": {
+					"id": {"type": "string"},
+					"type": {"type": "string", "enum": ["gauge", "counter"]},
+					"delta": {"type": "integer", "minimum": 0},
+					"value": {"type": "number"},
+					"labels": {"type": "object", "additionalProperties": {"type": "string"}}
+				}
+			}
+		}
+	}
+}`
+
+func Test_Parse(t *testing.T) {
+	doc, err := Parse([]byte(testSpec))
+	assert.NoError(t, err)
+	assert.NotNil(t, doc.Operation("POST", "/update"))
+	assert.Nil(t, doc.Operation("GET", "/update"))
+
+	_, err = Parse([]byte(`{"components": {"schemas": {"A": {"items": {"$ref": "#/components/schemas/B"}}}}}`))
+	assert.ErrorIs(t, err, ErrInvalidSchema)
+
+	_, err = Parse([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func Test_ValidateRequest(t *testing.T) {
+	doc, err := Parse([]byte(testSpec))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		Name          string
+		Method        string
+		Path          string
+		Body          string
+		ExpectedError bool
+	}{
+		{
+			Name:   "valid metric",
+			Method: "POST",
+			Path:   "/update",
+			Body:   `{"id":"Alloc","type":"gauge","value":1.5,"labels":{"host":"a"}}`,
+		},
+		{
+			Name:          "missing required property",
+			Method:        "POST",
+			Path:          "/update",
+			Body:          `{"type":"gauge"}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "value out of enum",
+			Method:        "POST",
+			Path:          "/update",
+			Body:          `{"id":"Alloc","type":"hist"}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "fractional integer",
+			Method:        "POST",
+			Path:          "/update",
+			Body:          `{"id":"PollCount","type":"counter","delta":1.5}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "below minimum",
+			Method:        "POST",
+			Path:          "/update",
+			Body:          `{"id":"PollCount","type":"counter","delta":-1}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "wrong additional property",
+			Method:        "POST",
+			Path:          "/update",
+			Body:          `{"id":"Alloc","type":"gauge","labels":{"host":1}}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "required body is empty",
+			Method:        "POST",
+			Path:          "/update",
+			ExpectedError: true,
+		},
+		{
+			Name:          "invalid json",
+			Method:        "POST",
+			Path:          "/update",
+			Body:          `{`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "wrong array item",
+			Method:        "POST",
+			Path:          "/updates",
+			Body:          `[{"id":"Alloc","type":"gauge"},{"id":1,"type":"gauge"}]`,
+			ExpectedError: true,
+		},
+		{
+			Name:   "wrong item of operation with item results",
+			Method: "POST",
+			Path:   "/api/updates",
+			Body:   `[{"id":"Alloc","type":"gauge"},{"id":1,"type":"gauge"},null]`,
+		},
+		{
+			Name:          "object to operation with item results",
+			Method:        "POST",
+			Path:          "/api/updates",
+			Body:          `{"id":"Alloc","type":"gauge"}`,
+			ExpectedError: true,
+		},
+		{
+			Name:   "optional body is empty",
+			Method: "POST",
+			Path:   "/updates",
+		},
+		{
+			Name:   "operation without body",
+			Method: "GET",
+			Path:   "/ping",
+			Body:   "anything",
+		},
+		{
+			Name:   "undefined operation",
+			Method: "GET",
+			Path:   "/unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := doc.ValidateRequest(tt.Method, tt.Path, []byte(tt.Body))
+			if tt.ExpectedError {
+				assert.ErrorIs(t, err, ErrInvalidBody)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_ValidateItem(t *testing.T) {
+	doc, err := Parse([]byte(testSpec))
+	assert.NoError(t, err)
+
+	assert.NoError(t, doc.ValidateItem("POST", "/api/updates", 0, []byte(`{"id":"Alloc","type":"gauge"}`)))
+	assert.NoError(t, doc.ValidateItem("POST", "/ping", 0, []byte(`{`)))
+
+	err = doc.ValidateItem("POST", "/api/updates", 1, []byte(`{"id":1,"type":"gauge"}`))
+	assert.ErrorIs(t, err, ErrInvalidBody)
+	assert.Contains(t, err.Error(), "$[1].id")
+
+	assert.ErrorIs(t, doc.ValidateItem("POST", "/api/updates", 2, []byte(`null`)), ErrInvalidBody)
+}
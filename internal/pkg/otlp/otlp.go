@@ -0,0 +1,269 @@
+// Package otlp decodes OTLP/HTTP metrics export requests in JSON and protobuf encodings into the same types.
+// Only fields needed for storing metrics are kept, others are skipped.
+package otlp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/protowire"
+)
+
+var (
+	ErrInvalidRequest         = errors.New("invalid otlp request")
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+)
+
+// Content types of OTLP/HTTP encodings.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Aggregation temporalities of sums and histograms.
+const (
+	TemporalityUnspecified Temporality = 0
+	TemporalityDelta       Temporality = 1
+	TemporalityCumulative  Temporality = 2
+)
+
+// Request is ExportMetricsServiceRequest.
+type Request struct {
+	ResourceMetrics []ResourceMetrics `json:"resourceMetrics"`
+}
+
+// ResourceMetrics is metrics of resource, e.g. of service instance.
+type ResourceMetrics struct {
+	Resource     Resource       `json:"resource"`
+	ScopeMetrics []ScopeMetrics `json:"scopeMetrics"`
+}
+
+// Resource is described by attributes.
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeMetrics is metrics of instrumentation scope. Scope itself isn't kept.
+type ScopeMetrics struct {
+	Metrics []Metric `json:"metrics"`
+}
+
+// Metric keeps data of one of types. Data points of unsupported types are only counted.
+type Metric struct {
+	Gauge                *Gauge       `json:"gauge,omitempty"`
+	Sum                  *Sum         `json:"sum,omitempty"`
+	Histogram            *Histogram   `json:"histogram,omitempty"`
+	ExponentialHistogram *Unsupported `json:"exponentialHistogram,omitempty"`
+	Summary              *Unsupported `json:"summary,omitempty"`
+	Name                 string       `json:"name"`
+	Unit                 string       `json:"unit,omitempty"`
+}
+
+// Gauge is data of gauge metric.
+type Gauge struct {
+	DataPoints []NumberDataPoint `json:"dataPoints"`
+}
+
+// Sum is data of sum metric. Monotonic sums are counters.
+type Sum struct {
+	DataPoints             []NumberDataPoint `json:"dataPoints"`
+	AggregationTemporality Temporality       `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+// Histogram is data of histogram metric with explicit buckets.
+type Histogram struct {
+	DataPoints             []HistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality Temporality          `json:"aggregationTemporality"`
+}
+
+// Unsupported is data of metric type which isn't supported.
+type Unsupported struct {
+	DataPoints []json.RawMessage `json:"dataPoints"`
+}
+
+// NumberDataPoint is value of gauge or sum. Exactly one of AsDouble and AsInt is expected.
+type NumberDataPoint struct {
+	AsDouble          *float64   `json:"asDouble,omitempty"`
+	AsInt             *Int64     `json:"asInt,omitempty"`
+	Attributes        []KeyValue `json:"attributes"`
+	StartTimeUnixNano Uint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      Uint64     `json:"timeUnixNano"`
+}
+
+// Value gives value of data point. Point without value is invalid.
+func (p *NumberDataPoint) Value() (float64, bool) {
+	switch {
+	case p.AsDouble != nil:
+		return *p.AsDouble, true
+	case p.AsInt != nil:
+		return float64(*p.AsInt), true
+	default:
+		return 0, false
+	}
+}
+
+// HistogramDataPoint is counts of values in buckets. Bucket i counts values in (ExplicitBounds[i-1], ExplicitBounds[i]],
+// the last bucket counts values greater than all bounds.
+type HistogramDataPoint struct {
+	Sum               *float64   `json:"sum,omitempty"`
+	Attributes        []KeyValue `json:"attributes"`
+	BucketCounts      []Uint64   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+	StartTimeUnixNano Uint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      Uint64     `json:"timeUnixNano"`
+	Count             Uint64     `json:"count"`
+}
+
+// KeyValue is attribute.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue is value of attribute. Arrays, maps and bytes are kept as raw JSON or skipped in protobuf.
+type AnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *Int64          `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  json.RawMessage `json:"arrayValue,omitempty"`
+	KvlistValue json.RawMessage `json:"kvlistValue,omitempty"`
+}
+
+// String gives value as string. Complex values are given in JSON.
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.ArrayValue != nil:
+		return string(v.ArrayValue)
+	case v.KvlistValue != nil:
+		return string(v.KvlistValue)
+	default:
+		return ""
+	}
+}
+
+// Int64 is int64 given in JSON as string or number.
+type Int64 int64
+
+func (i *Int64) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	*i = Int64(v)
+
+	return nil
+}
+
+// Uint64 is uint64 given in JSON as string or number.
+type Uint64 uint64
+
+func (u *Uint64) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseUint(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	*u = Uint64(v)
+
+	return nil
+}
+
+// Temporality is aggregation temporality given in JSON as number or enum name.
+type Temporality int
+
+var temporalityNames = map[string]Temporality{
+	"AGGREGATION_TEMPORALITY_UNSPECIFIED": TemporalityUnspecified,
+	"AGGREGATION_TEMPORALITY_DELTA":       TemporalityDelta,
+	"AGGREGATION_TEMPORALITY_CUMULATIVE":  TemporalityCumulative,
+}
+
+func (t *Temporality) UnmarshalJSON(b []byte) error {
+	if v, ok := temporalityNames[strings.Trim(string(b), `"`)]; ok {
+		*t = v
+		return nil
+	}
+
+	v, err := strconv.Atoi(string(b))
+	if err != nil {
+		return fmt.Errorf("%w: unknown aggregation temporality %s", ErrInvalidRequest, b)
+	}
+	*t = Temporality(v)
+
+	return nil
+}
+
+// Decode decodes request body of given content type.
+func Decode(contentType string, body []byte) (*Request, error) {
+	req := &Request{}
+
+	switch MediaType(contentType) {
+	case ContentTypeJSON:
+		if err := json.Unmarshal(body, req); err != nil {
+			if errors.Is(err, ErrInvalidRequest) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	case ContentTypeProtobuf:
+		if err := req.unmarshal(body); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+
+	return req, nil
+}
+
+// Response is ExportMetricsServiceResponse. Rejected data points are reported as partial success.
+type Response struct {
+	PartialSuccess *PartialSuccess `json:"partialSuccess,omitempty"`
+}
+
+// PartialSuccess reports number of rejected data points and the reason.
+type PartialSuccess struct {
+	ErrorMessage       string `json:"errorMessage,omitempty"`
+	RejectedDataPoints int64  `json:"rejectedDataPoints,string"`
+}
+
+// Encode encodes response in given content type.
+func (res *Response) Encode(contentType string) ([]byte, error) {
+	switch MediaType(contentType) {
+	case ContentTypeJSON:
+		return json.Marshal(res)
+	case ContentTypeProtobuf:
+		if res.PartialSuccess == nil {
+			return []byte{}, nil
+		}
+
+		ps := protowire.AppendVarint(nil, 1, uint64(res.PartialSuccess.RejectedDataPoints))
+		if res.PartialSuccess.ErrorMessage != "" {
+			ps = protowire.AppendString(ps, 2, res.PartialSuccess.ErrorMessage)
+		}
+
+		return protowire.AppendBytes(nil, 1, ps), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+}
+
+// MediaType gives content type without parameters.
+func MediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
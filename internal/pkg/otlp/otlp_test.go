@@ -0,0 +1,109 @@
+package otlp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/protowire"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DecodeJSON(t *testing.T) {
+	body := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"pid","value":{"intValue":"42"}}]},
+		"scopeMetrics":[{"metrics":[{"name":"requests","sum":{"aggregationTemporality":"AGGREGATION_TEMPORALITY_CUMULATIVE",
+		"isMonotonic":true,"dataPoints":[{"timeUnixNano":1700000000000000000,"asInt":"7"}]}}]}]}]}`
+
+	req, err := Decode("application/json; charset=utf-8", []byte(body))
+	assert.NoError(t, err)
+
+	rm := req.ResourceMetrics[0]
+	assert.Equal(t, "42", rm.Resource.Attributes[0].Value.String())
+
+	s := rm.ScopeMetrics[0].Metrics[0].Sum
+	assert.Equal(t, TemporalityCumulative, s.AggregationTemporality)
+	assert.True(t, s.IsMonotonic)
+	assert.Equal(t, Uint64(1700000000000000000), s.DataPoints[0].TimeUnixNano)
+
+	v, ok := s.DataPoints[0].Value()
+	assert.True(t, ok)
+	assert.Equal(t, 7.0, v)
+}
+
+func Test_DecodeProtobuf(t *testing.T) {
+	attr := protowire.AppendString(nil, 1, "enabled")
+	attr = protowire.AppendBytes(attr, 2, protowire.AppendVarint(nil, 2, 1))
+
+	point := protowire.AppendFixed64(nil, 3, 1700000000000000000)
+	point = protowire.AppendDouble(point, 4, 0.5)
+	point = protowire.AppendBytes(point, 7, attr)
+
+	m := protowire.AppendString(nil, 1, "load")
+	m = protowire.AppendBytes(m, 5, protowire.AppendBytes(nil, 1, point))
+
+	rm := protowire.AppendBytes(nil, 2, protowire.AppendBytes(nil, 2, m))
+
+	req, err := Decode(ContentTypeProtobuf, protowire.AppendBytes(nil, 1, rm))
+	assert.NoError(t, err)
+
+	metric := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "load", metric.Name)
+	assert.NotNil(t, metric.Gauge)
+
+	p := metric.Gauge.DataPoints[0]
+	assert.Equal(t, "true", p.Attributes[0].Value.String())
+
+	v, ok := p.Value()
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, v)
+}
+
+func Test_DecodeErrors(t *testing.T) {
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		ContentType   string
+		Body          string
+	}{
+		{Name: "unsupported content type", ContentType: "text/plain", Body: "{}", ExpectedError: ErrUnsupportedContentType},
+		{Name: "invalid json", ContentType: ContentTypeJSON, Body: "{", ExpectedError: ErrInvalidRequest},
+		{Name: "invalid integer", ContentType: ContentTypeJSON, Body: `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"gauge":{"dataPoints":[{"asInt":"x"}]}}]}]}]}`, ExpectedError: ErrInvalidRequest},
+		{Name: "unknown temporality", ContentType: ContentTypeJSON, Body: `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"sum":{"aggregationTemporality":"SOMETIMES"}}]}]}]}`, ExpectedError: ErrInvalidRequest},
+		{Name: "truncated protobuf", ContentType: ContentTypeProtobuf, Body: "\x0a\x05\x01", ExpectedError: ErrInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := Decode(tt.ContentType, []byte(tt.Body))
+			assert.ErrorIs(t, err, tt.ExpectedError)
+		})
+	}
+}
+
+func Test_ResponseEncode(t *testing.T) {
+	res := Response{}
+
+	b, err := res.Encode(ContentTypeJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(b))
+
+	b, err = res.Encode(ContentTypeProtobuf)
+	assert.NoError(t, err)
+	assert.Empty(t, b)
+
+	res.PartialSuccess = &PartialSuccess{RejectedDataPoints: 2, ErrorMessage: "unsupported"}
+
+	b, err = res.Encode(ContentTypeJSON)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"partialSuccess":{"rejectedDataPoints":"2","errorMessage":"unsupported"}}`, string(b))
+
+	decoded := Response{}
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, res, decoded)
+
+	b, err = res.Encode(ContentTypeProtobuf)
+	assert.NoError(t, err)
+	assert.Equal(t, protowire.AppendBytes(nil, 1, protowire.AppendString(protowire.AppendVarint(nil, 1, 2), 2, "unsupported")), b)
+
+	_, err = res.Encode("text/plain")
+	assert.ErrorIs(t, err, ErrUnsupportedContentType)
+}
@@ -0,0 +1,374 @@
+package otlp
+
+import (
+	"math"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/protowire"
+)
+
+// fields calls f for every field of encoded message. Fields which f doesn't handle are skipped.
+func fields(data []byte, f func(r *protowire.Reader, num, typ int) (bool, error)) error {
+	r := protowire.NewReader(data)
+
+	for r.More() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+
+		handled, err := f(r, num, typ)
+		if err != nil {
+			return err
+		}
+
+		if !handled {
+			if err := r.Skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// message reads embedded message field and decodes it by f.
+func message(r *protowire.Reader, num, typ int, f func(data []byte) error) (bool, error) {
+	if err := protowire.Expect(num, typ, protowire.TypeBytes); err != nil {
+		return false, err
+	}
+
+	data, err := r.Bytes()
+	if err != nil {
+		return false, err
+	}
+
+	return true, f(data)
+}
+
+// str reads string field.
+func str(r *protowire.Reader, num, typ int, s *string) (bool, error) {
+	if err := protowire.Expect(num, typ, protowire.TypeBytes); err != nil {
+		return false, err
+	}
+
+	b, err := r.Bytes()
+	*s = string(b)
+
+	return true, err
+}
+
+// fixed64 reads fixed64 field.
+func fixed64(r *protowire.Reader, num, typ int, v *uint64) (bool, error) {
+	if err := protowire.Expect(num, typ, protowire.TypeFixed64); err != nil {
+		return false, err
+	}
+
+	var err error
+	*v, err = r.Fixed64()
+
+	return true, err
+}
+
+// varint reads varint field.
+func varint(r *protowire.Reader, num, typ int, v *uint64) (bool, error) {
+	if err := protowire.Expect(num, typ, protowire.TypeVarint); err != nil {
+		return false, err
+	}
+
+	var err error
+	*v, err = r.Varint()
+
+	return true, err
+}
+
+// repeatedFixed64 reads element of repeated fixed64 or double field, packed or not.
+func repeatedFixed64(r *protowire.Reader, num, typ int, f func(v uint64)) (bool, error) {
+	if typ == protowire.TypeFixed64 {
+		v, err := r.Fixed64()
+		f(v)
+		return true, err
+	}
+
+	return message(r, num, typ, func(data []byte) error {
+		packed := protowire.NewReader(data)
+		for packed.More() {
+			v, err := packed.Fixed64()
+			if err != nil {
+				return err
+			}
+			f(v)
+		}
+
+		return nil
+	})
+}
+
+func (req *Request) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		if num != 1 {
+			return false, nil
+		}
+
+		return message(r, num, typ, func(data []byte) error {
+			req.ResourceMetrics = append(req.ResourceMetrics, ResourceMetrics{})
+			return req.ResourceMetrics[len(req.ResourceMetrics)-1].unmarshal(data)
+		})
+	})
+}
+
+func (rm *ResourceMetrics) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		switch num {
+		case 1:
+			return message(r, num, typ, rm.Resource.unmarshal)
+		case 2:
+			return message(r, num, typ, func(data []byte) error {
+				rm.ScopeMetrics = append(rm.ScopeMetrics, ScopeMetrics{})
+				return rm.ScopeMetrics[len(rm.ScopeMetrics)-1].unmarshal(data)
+			})
+		default:
+			return false, nil
+		}
+	})
+}
+
+func (res *Resource) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		if num != 1 {
+			return false, nil
+		}
+
+		return message(r, num, typ, func(data []byte) error {
+			res.Attributes = append(res.Attributes, KeyValue{})
+			return res.Attributes[len(res.Attributes)-1].unmarshal(data)
+		})
+	})
+}
+
+func (sm *ScopeMetrics) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		if num != 2 {
+			return false, nil
+		}
+
+		return message(r, num, typ, func(data []byte) error {
+			sm.Metrics = append(sm.Metrics, Metric{})
+			return sm.Metrics[len(sm.Metrics)-1].unmarshal(data)
+		})
+	})
+}
+
+func (m *Metric) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		switch num {
+		case 1:
+			return str(r, num, typ, &m.Name)
+		case 3:
+			return str(r, num, typ, &m.Unit)
+		case 5:
+			m.Gauge = &Gauge{}
+			return message(r, num, typ, m.Gauge.unmarshal)
+		case 7:
+			m.Sum = &Sum{}
+			return message(r, num, typ, m.Sum.unmarshal)
+		case 9:
+			m.Histogram = &Histogram{}
+			return message(r, num, typ, m.Histogram.unmarshal)
+		case 10:
+			m.ExponentialHistogram = &Unsupported{}
+			return message(r, num, typ, m.ExponentialHistogram.unmarshal)
+		case 11:
+			m.Summary = &Unsupported{}
+			return message(r, num, typ, m.Summary.unmarshal)
+		default:
+			return false, nil
+		}
+	})
+}
+
+func (g *Gauge) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		if num != 1 {
+			return false, nil
+		}
+
+		return message(r, num, typ, func(data []byte) error {
+			g.DataPoints = append(g.DataPoints, NumberDataPoint{})
+			return g.DataPoints[len(g.DataPoints)-1].unmarshal(data)
+		})
+	})
+}
+
+func (s *Sum) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		var v uint64
+
+		switch num {
+		case 1:
+			return message(r, num, typ, func(data []byte) error {
+				s.DataPoints = append(s.DataPoints, NumberDataPoint{})
+				return s.DataPoints[len(s.DataPoints)-1].unmarshal(data)
+			})
+		case 2:
+			handled, err := varint(r, num, typ, &v)
+			s.AggregationTemporality = Temporality(v)
+			return handled, err
+		case 3:
+			handled, err := varint(r, num, typ, &v)
+			s.IsMonotonic = v != 0
+			return handled, err
+		default:
+			return false, nil
+		}
+	})
+}
+
+func (h *Histogram) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		switch num {
+		case 1:
+			return message(r, num, typ, func(data []byte) error {
+				h.DataPoints = append(h.DataPoints, HistogramDataPoint{})
+				return h.DataPoints[len(h.DataPoints)-1].unmarshal(data)
+			})
+		case 2:
+			var v uint64
+			handled, err := varint(r, num, typ, &v)
+			h.AggregationTemporality = Temporality(v)
+			return handled, err
+		default:
+			return false, nil
+		}
+	})
+}
+
+func (u *Unsupported) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		if num != 1 {
+			return false, nil
+		}
+
+		return message(r, num, typ, func([]byte) error {
+			u.DataPoints = append(u.DataPoints, nil)
+			return nil
+		})
+	})
+}
+
+func (p *NumberDataPoint) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		var v uint64
+
+		switch num {
+		case 2:
+			handled, err := fixed64(r, num, typ, &v)
+			p.StartTimeUnixNano = Uint64(v)
+			return handled, err
+		case 3:
+			handled, err := fixed64(r, num, typ, &v)
+			p.TimeUnixNano = Uint64(v)
+			return handled, err
+		case 4:
+			handled, err := fixed64(r, num, typ, &v)
+			d := math.Float64frombits(v)
+			p.AsDouble = &d
+			return handled, err
+		case 6:
+			handled, err := fixed64(r, num, typ, &v)
+			i := Int64(v)
+			p.AsInt = &i
+			return handled, err
+		case 7:
+			return message(r, num, typ, func(data []byte) error {
+				p.Attributes = append(p.Attributes, KeyValue{})
+				return p.Attributes[len(p.Attributes)-1].unmarshal(data)
+			})
+		default:
+			return false, nil
+		}
+	})
+}
+
+func (p *HistogramDataPoint) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		var v uint64
+
+		switch num {
+		case 2:
+			handled, err := fixed64(r, num, typ, &v)
+			p.StartTimeUnixNano = Uint64(v)
+			return handled, err
+		case 3:
+			handled, err := fixed64(r, num, typ, &v)
+			p.TimeUnixNano = Uint64(v)
+			return handled, err
+		case 4:
+			handled, err := fixed64(r, num, typ, &v)
+			p.Count = Uint64(v)
+			return handled, err
+		case 5:
+			handled, err := fixed64(r, num, typ, &v)
+			d := math.Float64frombits(v)
+			p.Sum = &d
+			return handled, err
+		case 6:
+			return repeatedFixed64(r, num, typ, func(v uint64) {
+				p.BucketCounts = append(p.BucketCounts, Uint64(v))
+			})
+		case 7:
+			return repeatedFixed64(r, num, typ, func(v uint64) {
+				p.ExplicitBounds = append(p.ExplicitBounds, math.Float64frombits(v))
+			})
+		case 9:
+			return message(r, num, typ, func(data []byte) error {
+				p.Attributes = append(p.Attributes, KeyValue{})
+				return p.Attributes[len(p.Attributes)-1].unmarshal(data)
+			})
+		default:
+			return false, nil
+		}
+	})
+}
+
+func (kv *KeyValue) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		switch num {
+		case 1:
+			return str(r, num, typ, &kv.Key)
+		case 2:
+			return message(r, num, typ, kv.Value.unmarshal)
+		default:
+			return false, nil
+		}
+	})
+}
+
+func (v *AnyValue) unmarshal(data []byte) error {
+	return fields(data, func(r *protowire.Reader, num, typ int) (bool, error) {
+		var u uint64
+
+		switch num {
+		case 1:
+			s := ""
+			v.StringValue = &s
+			return str(r, num, typ, v.StringValue)
+		case 2:
+			handled, err := varint(r, num, typ, &u)
+			b := u != 0
+			v.BoolValue = &b
+			return handled, err
+		case 3:
+			handled, err := varint(r, num, typ, &u)
+			i := Int64(u)
+			v.IntValue = &i
+			return handled, err
+		case 4:
+			handled, err := fixed64(r, num, typ, &u)
+			d := math.Float64frombits(u)
+			v.DoubleValue = &d
+			return handled, err
+		default:
+			return false, nil
+		}
+	})
+}
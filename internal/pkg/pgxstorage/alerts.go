@@ -0,0 +1,99 @@
+package pgxstorage
+
+import (
+	"database/sql"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+)
+
+const (
+	alertsMigration = `
+	CREATE TABLE IF NOT EXISTS alerts (
+		atenant CHARACTER VARYING NOT NULL DEFAULT '',
+		arule CHARACTER VARYING NOT NULL,
+		astate CHARACTER VARYING NOT NULL,
+		avalue DOUBLE PRECISION NOT NULL DEFAULT 0,
+		aactive_at TIMESTAMPTZ,
+		afired_at TIMESTAMPTZ,
+		aresolved_at TIMESTAMPTZ,
+		PRIMARY KEY (atenant, arule)
+	)`
+
+	stGetAlerts = `
+	SELECT atenant, arule, astate, avalue, aactive_at, afired_at, aresolved_at
+	FROM alerts`
+
+	stDeleteAlerts = `
+	DELETE FROM alerts`
+
+	stInsertAlert = `
+	INSERT INTO alerts (atenant, arule, astate, avalue, aactive_at, afired_at, aresolved_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+)
+
+// Returns alert states of all tenants saved by SaveAlerts.
+func (st *pgxStorage) GetAlerts() ([]alerting.Alert, error) {
+	rows, err := st.DB.Query(stGetAlerts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errRowsClose := rows.Close(); errRowsClose != nil {
+			log.Println(errRowsClose)
+		}
+	}()
+
+	alerts := []alerting.Alert{}
+	for rows.Next() {
+		a := alerting.Alert{}
+		var activeAt, firedAt, resolvedAt sql.NullTime
+
+		if err := rows.Scan(&a.Tenant, &a.Rule, &a.State, &a.Value, &activeAt, &firedAt, &resolvedAt); err != nil {
+			return nil, err
+		}
+
+		a.ActiveAt, a.FiredAt, a.ResolvedAt = activeAt.Time, firedAt.Time, resolvedAt.Time
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// Replaces saved alert states of all tenants in a single transaction.
+func (st *pgxStorage) SaveAlerts(alerts []alerting.Alert) (err error) {
+	tx, err := st.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if errRollback := tx.Rollback(); errRollback != nil {
+				log.Println(errRollback)
+			}
+		}
+	}()
+
+	if _, err = tx.Exec(stDeleteAlerts); err != nil {
+		return err
+	}
+
+	for _, a := range alerts {
+		if _, err = tx.Exec(stInsertAlert, a.Tenant, a.Rule, a.State, a.Value,
+			nullTime(a.ActiveAt), nullTime(a.FiredAt), nullTime(a.ResolvedAt)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// nullTime gives NULL for zero time.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
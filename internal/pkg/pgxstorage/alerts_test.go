@@ -0,0 +1,36 @@
+package pgxstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SaveAlerts(t *testing.T) {
+	ms, err := New(config.DBAddress, true)
+	if err != nil {
+		t.Logf("unable to connect to postgre: %v\n", err)
+		t.SkipNow()
+	}
+	assert.NotNil(t, ms)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	alerts := []alerting.Alert{
+		{Tenant: "team", Rule: "heap", State: alerting.StateFiring, Value: 2e9, ActiveAt: now, FiredAt: now.Add(time.Minute)},
+		{Rule: "absent", State: alerting.StatePending, ActiveAt: now},
+	}
+
+	assert.NoError(t, ms.SaveAlerts(alerts))
+	assert.NoError(t, ms.SaveAlerts(alerts[:1]))
+
+	saved, err := ms.GetAlerts()
+	assert.NoError(t, err)
+	assert.Len(t, saved, 1)
+	assert.Equal(t, alerts[0].State, saved[0].State)
+	assert.True(t, alerts[0].FiredAt.Equal(saved[0].FiredAt))
+	assert.True(t, saved[0].ResolvedAt.IsZero())
+
+	assert.NoError(t, ms.DB.Close())
+}
@@ -2,11 +2,13 @@ package pgxstorage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"os"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
 )
 
@@ -19,38 +21,86 @@ var (
 
 	migration = `
 	CREATE TABLE IF NOT EXISTS metrics (
-		mname CHARACTER VARYING PRIMARY KEY,
+		mtenant CHARACTER VARYING NOT NULL DEFAULT '',
+		mname CHARACTER VARYING NOT NULL,
 		mtype CHARACTER VARYING,
 		mval DOUBLE PRECISION,
-		mdel BIGINT
-	)`
+		mdel BIGINT,
+		mlabels JSONB,
+		PRIMARY KEY (mtenant, mname)
+	);
+	ALTER TABLE metrics ADD COLUMN IF NOT EXISTS mlabels JSONB;
+	ALTER TABLE metrics ADD COLUMN IF NOT EXISTS mtenant CHARACTER VARYING NOT NULL DEFAULT '';
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1
+			FROM information_schema.key_column_usage
+			WHERE table_name = 'metrics' AND constraint_name = 'metrics_pkey' AND column_name = 'mtenant'
+		) THEN
+			ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+			ALTER TABLE metrics ADD PRIMARY KEY (mtenant, mname);
+		END IF;
+	END $$`
 )
 
 const (
 	stUpdateMetric = `
-	INSERT INTO metrics
-	VALUES ($1, $2, $3, $4) 
-	ON CONFLICT (mname)
+	INSERT INTO metrics (mtenant, mname, mtype, mval, mdel, mlabels)
+	VALUES ($1, $2, $3, $4, $5, $6) 
+	ON CONFLICT (mtenant, mname)
 	DO
 	UPDATE
-	SET mtype = $2, mval = $3, mdel = metrics.mdel + $4`
+	SET mtype = $3, mval = $4, mdel = metrics.mdel + $5, mlabels = $6`
 
 	stGetMetric = `
-	SELECT * 
+	SELECT mname, mtype, mval, mdel, mlabels
 	FROM metrics 
-	WHERE mname = $1`
+	WHERE mtenant = $1 AND mname = $2`
 
 	stGetBatch = `
-	SELECT * 
+	SELECT mname, mtype, mval, mdel, mlabels
+	FROM metrics
+	WHERE mtenant = $1`
+
+	stGetMetrics = `
+	SELECT mname, mtype, mval, mdel, mlabels
+	FROM metrics
+	WHERE mtenant = $1 AND mname = ANY($2)`
+
+	stGetTenants = `
+	SELECT DISTINCT mtenant
 	FROM metrics`
 
+	stDeleteMetric = `
+	DELETE FROM metrics
+	WHERE mtenant = $1 AND mname = $2`
+
 	stDropTableIfExisis = `
 	DROP TABLE IF EXISTS metrics`
+
+	tokensMigration = `
+	CREATE TABLE IF NOT EXISTS tokens (
+		token CHARACTER VARYING PRIMARY KEY,
+		name CHARACTER VARYING,
+		scopes JSONB,
+		write_prefix CHARACTER VARYING NOT NULL DEFAULT '',
+		requests_per_second DOUBLE PRECISION NOT NULL DEFAULT 0,
+		metrics_per_second DOUBLE PRECISION NOT NULL DEFAULT 0,
+		tenant CHARACTER VARYING NOT NULL DEFAULT ''
+	);
+	ALTER TABLE tokens ADD COLUMN IF NOT EXISTS tenant CHARACTER VARYING NOT NULL DEFAULT ''`
+
+	stGetTokens = `
+	SELECT token, name, scopes, write_prefix, requests_per_second, metrics_per_second, tenant
+	FROM tokens`
 )
 
 // Realization of metrics storage based on Postgesql.
+// Every instance works with metrics of one tenant, other tenants are reachable by ForTenant.
 type pgxStorage struct {
-	DB *sql.DB
+	DB     *sql.DB
+	tenant string
 }
 
 // Constructor. Existing metrics table could be dropped on demand.
@@ -81,9 +131,63 @@ func New(dbAddr string, drop bool) (*pgxStorage, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	_, err = ms.DB.Exec(tokensMigration)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ms.DB.Exec(alertsMigration)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ms.DB.Exec(rollupsMigration)
+	if err != nil {
+		return nil, err
+	}
 	return ms, nil
 }
 
+// Returns storage of given tenant sharing database connection with this one.
+// Must not be closed separately.
+func (st *pgxStorage) ForTenant(tenant string) metric.MetricStorage {
+	return &pgxStorage{
+		DB:     st.DB,
+		tenant: tenant,
+	}
+}
+
+// Returns names of tenants having metrics, including default one.
+func (st *pgxStorage) Tenants() ([]string, error) {
+	rows, err := st.DB.Query(stGetTenants)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errRowsClose := rows.Close(); errRowsClose != nil {
+			log.Println(errRowsClose)
+		}
+	}()
+
+	tenants := []string{metric.DefaultTenant}
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+
+		if tenant != metric.DefaultTenant {
+			tenants = append(tenants, tenant)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
+
 // Needed to call this by defer after using Cconstructor.
 func (st *pgxStorage) Close() error {
 	return st.DB.Close()
@@ -96,7 +200,7 @@ func (st *pgxStorage) AccessCheck() error {
 
 // Returns existing metric by it's name.
 func (st *pgxStorage) GetMetric(name string) (*metric.Metric, error) {
-	rows, err := st.DB.Query(stGetMetric, name)
+	rows, err := st.DB.Query(stGetMetric, st.tenant, name)
 	if err != nil {
 		return nil, err
 	}
@@ -106,9 +210,9 @@ func (st *pgxStorage) GetMetric(name string) (*metric.Metric, error) {
 		}
 	}()
 
-	m := metric.Metric{}
+	m := &metric.Metric{}
 	for rows.Next() {
-		if err := rows.Scan(&m.ID, &m.MType, &m.Value, &m.Delta); err != nil {
+		if m, err = scanMetric(rows); err != nil {
 			return nil, err
 		}
 	}
@@ -119,12 +223,12 @@ func (st *pgxStorage) GetMetric(name string) (*metric.Metric, error) {
 		return nil, err
 	}
 
-	return &m, nil
+	return m, nil
 }
 
 // Returns all storaged metrics in slice.
 func (st *pgxStorage) GetBatch() ([]*metric.Metric, error) {
-	rows, err := st.DB.Query(stGetBatch)
+	rows, err := st.DB.Query(stGetBatch, st.tenant)
 	if err != nil {
 		return nil, err
 	}
@@ -136,11 +240,11 @@ func (st *pgxStorage) GetBatch() ([]*metric.Metric, error) {
 
 	allMetrics := []*metric.Metric{}
 	for rows.Next() {
-		m := metric.Metric{}
-		if err := rows.Scan(&m.ID, &m.MType, &m.Value, &m.Delta); err != nil {
+		m, err := scanMetric(rows)
+		if err != nil {
 			return nil, err
 		}
-		allMetrics = append(allMetrics, &m)
+		allMetrics = append(allMetrics, m)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -150,6 +254,33 @@ func (st *pgxStorage) GetBatch() ([]*metric.Metric, error) {
 	return allMetrics, nil
 }
 
+// Returns existing metrics by their names in a single query. Missing names are skipped.
+func (st *pgxStorage) GetMetrics(ids []string) ([]*metric.Metric, error) {
+	rows, err := st.DB.Query(stGetMetrics, st.tenant, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errRowsClose := rows.Close(); errRowsClose != nil {
+			log.Println(errRowsClose)
+		}
+	}()
+
+	found := []*metric.Metric{}
+	for rows.Next() {
+		m, err := scanMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
 // Updates metric valuable fields: overrides Value and increments Delta.
 func (st *pgxStorage) UpdateMetric(m *metric.Metric) error {
 	if m == nil {
@@ -160,7 +291,12 @@ func (st *pgxStorage) UpdateMetric(m *metric.Metric) error {
 		return metric.ErrCannotUpdateInvalidFormat
 	}
 
-	if _, err := st.DB.Exec(stUpdateMetric, m.ID, m.MType, m.Value, m.Delta); err != nil {
+	labels, err := encodeLabels(m.Labels)
+	if err != nil {
+		return err
+	}
+
+	if _, err := st.DB.Exec(stUpdateMetric, st.tenant, m.ID, m.MType, m.Value, m.Delta, labels); err != nil {
 		return err
 	}
 	return nil
@@ -198,7 +334,12 @@ func (st *pgxStorage) UpdateBatch(batch []*metric.Metric) (err error) {
 			return
 		}
 
-		if _, err = txStUpdateMetric.Exec(batch[i].ID, batch[i].MType, batch[i].Value, batch[i].Delta); err != nil {
+		var labels interface{}
+		if labels, err = encodeLabels(batch[i].Labels); err != nil {
+			return
+		}
+
+		if _, err = txStUpdateMetric.Exec(st.tenant, batch[i].ID, batch[i].MType, batch[i].Value, batch[i].Delta, labels); err != nil {
 			return
 		}
 	}
@@ -215,3 +356,97 @@ func (st *pgxStorage) UpdateBatch(batch []*metric.Metric) (err error) {
 
 	return
 }
+
+// Removes existing metric by it's name.
+func (st *pgxStorage) DeleteMetric(name string) error {
+	res, err := st.DB.Exec(stDeleteMetric, st.tenant, name)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return metric.ErrMetricDoesntExist
+	}
+
+	return nil
+}
+
+// Returns API tokens defined in tokens table.
+func (st *pgxStorage) GetTokens() ([]auth.Token, error) {
+	rows, err := st.DB.Query(stGetTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errRowsClose := rows.Close(); errRowsClose != nil {
+			log.Println(errRowsClose)
+		}
+	}()
+
+	tokens := []auth.Token{}
+	for rows.Next() {
+		t := auth.Token{}
+		var name sql.NullString
+		var scopes []byte
+
+		if err := rows.Scan(&t.Token, &name, &scopes, &t.WritePrefix, &t.RequestsPerSecond, &t.MetricsPerSecond, &t.Tenant); err != nil {
+			return nil, err
+		}
+
+		t.Name = name.String
+
+		if len(scopes) != 0 {
+			if err := json.Unmarshal(scopes, &t.Scopes); err != nil {
+				return nil, err
+			}
+		}
+
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scans metric from row selected in order: mname, mtype, mval, mdel, mlabels.
+func scanMetric(row scanner) (*metric.Metric, error) {
+	m := &metric.Metric{}
+	var labels []byte
+
+	if err := row.Scan(&m.ID, &m.MType, &m.Value, &m.Delta, &labels); err != nil {
+		return nil, err
+	}
+
+	if len(labels) != 0 {
+		if err := json.Unmarshal(labels, &m.Labels); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Gives labels in form suitable for JSONB column. Empty labels are stored as NULL.
+func encodeLabels(labels map[string]string) (interface{}, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	lj, err := json.Marshal(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(lj), nil
+}
@@ -45,13 +45,10 @@ func Test_New(t *testing.T) {
 		}
 		assert.NotNil(t, ms)
 
-		_, err = ms.DB.Exec(stUpdateMetric, id, "", 0, 0)
+		_, err = ms.DB.Exec(stUpdateMetric, metric.DefaultTenant, id, "", 0, 0, nil)
 		assert.NoError(t, err)
 
-		m := metric.Metric{}
-
-		row := ms.DB.QueryRow(stGetMetric, id)
-		err = row.Scan(&m.ID, &m.MType, &m.Value, &m.Delta)
+		m, err := scanMetric(ms.DB.QueryRow(stGetMetric, metric.DefaultTenant, id))
 		assert.NoError(t, err)
 		assert.Equal(t, id, m.ID)
 
@@ -66,10 +63,7 @@ func Test_New(t *testing.T) {
 		}
 		assert.NotNil(t, ms)
 
-		m := metric.Metric{}
-
-		row := ms.DB.QueryRow(stGetMetric, id)
-		err = row.Scan(&m.ID, &m.MType, &m.Value, &m.Delta)
+		_, err = scanMetric(ms.DB.QueryRow(stGetMetric, metric.DefaultTenant, id))
 		assert.Error(t, err)
 
 		assert.NoError(t, ms.DB.Close())
@@ -127,7 +121,7 @@ func Test_GetMetric(t *testing.T) {
 		Value: &value,
 	}
 
-	_, err = ms.DB.Exec(stUpdateMetric, &m.ID, &m.MType, &m.Value, &m.Delta)
+	_, err = ms.DB.Exec(stUpdateMetric, metric.DefaultTenant, &m.ID, &m.MType, &m.Value, &m.Delta, nil)
 	assert.NoError(t, err)
 
 	tests := []struct {
@@ -183,7 +177,7 @@ func Test_GetBatch(t *testing.T) {
 		}
 
 		expectedMetrics = append(expectedMetrics, &m)
-		_, err = ms.DB.Exec(stUpdateMetric, &m.ID, &m.MType, &m.Value, &m.Delta)
+		_, err = ms.DB.Exec(stUpdateMetric, metric.DefaultTenant, &m.ID, &m.MType, &m.Value, &m.Delta, nil)
 		assert.NoError(t, err)
 	}
 	sort.Slice(expectedMetrics, func(i, j int) bool {
@@ -202,6 +196,39 @@ func Test_GetBatch(t *testing.T) {
 	assert.NoError(t, ms.DB.Close())
 }
 
+func Test_GetMetrics(t *testing.T) {
+	ms, err := New(config.DBAddress, true)
+	if err != nil {
+		t.Logf("unable to connect to postgre: %v\n", err)
+		t.SkipNow()
+	}
+	assert.NotNil(t, ms)
+
+	var delta int64 = 3
+	var value float64 = 4
+	assert.NoError(t, ms.UpdateBatch([]*metric.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+	}))
+
+	found, err := ms.GetMetrics([]string{"Alloc", "Missing", "PollCount"})
+	assert.NoError(t, err)
+
+	ids := []string{}
+	for _, m := range found {
+		ids = append(ids, m.ID)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"Alloc", "PollCount"}, ids)
+
+	found, err = ms.ForTenant("team").GetMetrics([]string{"Alloc"})
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+
+	assert.NoError(t, ms.DB.Close())
+}
+
 func Test_UpdateMetric(t *testing.T) {
 	ms, err := New(config.DBAddress, true)
 	if err != nil {
@@ -265,10 +292,7 @@ func Test_UpdateMetric(t *testing.T) {
 			if tt.Name == "empty id" || tt.Name == "nil input" {
 				t.Skip()
 			}
-			m := metric.Metric{}
-
-			row := ms.DB.QueryRow(stGetMetric, tt.Input.ID)
-			err = row.Scan(&m.ID, &m.MType, &m.Value, &m.Delta)
+			m, err := scanMetric(ms.DB.QueryRow(stGetMetric, metric.DefaultTenant, tt.Input.ID))
 			assert.NoError(t, err)
 			assert.Equal(t, tt.Input.ID, m.ID)
 
@@ -307,13 +331,10 @@ func Test_UpdateBatch(t *testing.T) {
 	assert.NoError(t, ms.UpdateBatch(expectedMetrics))
 
 	for i := 0; i < 10; i++ {
-		m := metric.Metric{}
-
-		row := ms.DB.QueryRow(stGetMetric, "metric"+fmt.Sprint(i))
-		err = row.Scan(&m.ID, &m.MType, &m.Value, &m.Delta)
+		m, err := scanMetric(ms.DB.QueryRow(stGetMetric, metric.DefaultTenant, "metric"+fmt.Sprint(i)))
 		assert.NoError(t, err)
 
-		actualMetrics = append(actualMetrics, &m)
+		actualMetrics = append(actualMetrics, m)
 	}
 
 	assert.Equal(t, expectedMetrics, actualMetrics)
@@ -322,3 +343,62 @@ func Test_UpdateBatch(t *testing.T) {
 
 	assert.NoError(t, ms.DB.Close())
 }
+
+func Test_DeleteMetric(t *testing.T) {
+	ms, err := New(config.DBAddress, true)
+	if err != nil {
+		t.Logf("unable to connect to postgre: %v\n", err)
+		t.SkipNow()
+	}
+	assert.NotNil(t, ms)
+
+	var value float64 = 200
+
+	assert.NoError(t, ms.UpdateMetric(&metric.Metric{
+		ID:    "metric",
+		MType: "gauge",
+		Value: &value,
+	}))
+
+	assert.NoError(t, ms.DeleteMetric("metric"))
+
+	_, err = ms.GetMetric("metric")
+	assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+
+	assert.ErrorIs(t, ms.DeleteMetric("metric"), metric.ErrMetricDoesntExist)
+
+	assert.NoError(t, ms.DB.Close())
+}
+
+func Test_ForTenant(t *testing.T) {
+	ms, err := New(config.DBAddress, true)
+	if err != nil {
+		t.Logf("unable to connect to postgre: %v\n", err)
+		t.SkipNow()
+	}
+	assert.NotNil(t, ms)
+
+	var value float64 = 200
+
+	assert.NoError(t, ms.ForTenant("team").UpdateMetric(&metric.Metric{
+		ID:    "metric",
+		MType: "gauge",
+		Value: &value,
+	}))
+
+	_, err = ms.GetMetric("metric")
+	assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+
+	m, err := ms.ForTenant("team").GetMetric("metric")
+	assert.NoError(t, err)
+	assert.Equal(t, value, *m.Value)
+
+	tenants, err := ms.Tenants()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{metric.DefaultTenant, "team"}, tenants)
+
+	assert.ErrorIs(t, ms.DeleteMetric("metric"), metric.ErrMetricDoesntExist)
+	assert.NoError(t, ms.ForTenant("team").DeleteMetric("metric"))
+
+	assert.NoError(t, ms.DB.Close())
+}
@@ -0,0 +1,162 @@
+package pgxstorage
+
+import (
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+const (
+	stQueryMetrics = `
+	SELECT mname, mtype, mval, mdel, mlabels
+	FROM metrics
+	WHERE mtenant = $1`
+
+	// Names and types are compared bytewise, the same way as by other storages, regardless of database locale.
+	nameKey = `mname COLLATE "C"`
+	typeKey = `COALESCE(mtype, '') COLLATE "C"`
+
+	// Value of gauge or delta of counter, see metric.SortValue.
+	valueKey = `COALESCE(CASE WHEN mtype = 'counter' THEN mdel::DOUBLE PRECISION END, mval, mdel::DOUBLE PRECISION, 0)`
+)
+
+// Selects metrics by query. Filters, sorting and pagination are made by database.
+// Regular expressions are matched by Postgresql, so only syntax common with Go is portable.
+func (st *pgxStorage) Query(q metric.Query) ([]*metric.Metric, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	statement, args := buildQuery(st.tenant, q)
+
+	rows, err := st.DB.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errRowsClose := rows.Close(); errRowsClose != nil {
+			log.Println(errRowsClose)
+		}
+	}()
+
+	res := []*metric.Metric{}
+	for rows.Next() {
+		m, err := scanMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// buildQuery gives statement selecting metrics of tenant by query and its arguments.
+func buildQuery(tenant string, q metric.Query) (string, []interface{}) {
+	args := []interface{}{tenant}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var b strings.Builder
+	b.WriteString(stQueryMetrics)
+
+	if q.MType != "" {
+		b.WriteString(" AND mtype = " + arg(q.MType))
+	}
+
+	if q.NameGlob != "" {
+		b.WriteString(" AND mname LIKE " + arg(globLike(q.NameGlob)) + ` ESCAPE '\'`)
+	}
+
+	if q.NameRegexp != "" {
+		b.WriteString(" AND mname ~ " + arg(metric.AnchorRegexp(q.NameRegexp)))
+	}
+
+	for _, lm := range q.Labels {
+		label := "COALESCE(mlabels->>" + arg(lm.Name) + ", '')"
+
+		switch lm.Op {
+		case metric.MatchEqual:
+			b.WriteString(" AND " + label + " = " + arg(lm.Value))
+		case metric.MatchNotEqual:
+			b.WriteString(" AND " + label + " <> " + arg(lm.Value))
+		case metric.MatchRegexp:
+			b.WriteString(" AND " + label + " ~ " + arg(metric.AnchorRegexp(lm.Value)))
+		case metric.MatchNotRegexp:
+			b.WriteString(" AND " + label + " !~ " + arg(metric.AnchorRegexp(lm.Value)))
+		}
+	}
+
+	direction, after := "ASC", ">"
+	if q.Desc {
+		direction, after = "DESC", "<"
+	}
+
+	var key string
+	var cursorKey interface{}
+
+	switch q.SortBy {
+	case metric.SortByType:
+		key = typeKey
+		if q.Cursor != nil {
+			cursorKey = q.Cursor.MType
+		}
+	case metric.SortByValue:
+		key = valueKey
+		if q.Cursor != nil {
+			cursorKey = q.Cursor.Value
+		}
+	}
+
+	if q.Cursor != nil {
+		id := arg(q.Cursor.ID)
+
+		if key == "" {
+			b.WriteString(" AND " + nameKey + " " + after + " " + id)
+		} else {
+			k := arg(cursorKey)
+			b.WriteString(" AND (" + key + " " + after + " " + k + " OR (" + key + " = " + k + " AND " + nameKey + " " + after + " " + id + "))")
+		}
+	}
+
+	b.WriteString(" ORDER BY ")
+	if key != "" {
+		b.WriteString(key + " " + direction + ", ")
+	}
+	b.WriteString(nameKey + " " + direction)
+
+	if q.Limit > 0 {
+		b.WriteString(" LIMIT " + arg(q.Limit))
+	}
+
+	return b.String(), args
+}
+
+// globLike converts name pattern to LIKE pattern escaped by backslash.
+func globLike(glob string) string {
+	var b strings.Builder
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package pgxstorage
+
+import (
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_buildQuery(t *testing.T) {
+	tests := []struct {
+		Name          string
+		ExpectedWhere string
+		ExpectedOrder string
+		ExpectedArgs  []interface{}
+		Query         metric.Query
+	}{
+		{
+			Name:          "empty query",
+			ExpectedWhere: "WHERE mtenant = $1",
+			ExpectedOrder: ` ORDER BY mname COLLATE "C" ASC`,
+			ExpectedArgs:  []interface{}{"team"},
+		},
+		{
+			Name: "filters",
+			Query: metric.Query{
+				MType:      "gauge",
+				NameGlob:   "Heap_*",
+				NameRegexp: "Heap.+",
+				Labels: []metric.LabelMatcher{
+					{Name: "host", Op: metric.MatchEqual, Value: "a"},
+					{Name: "dc", Op: metric.MatchNotRegexp, Value: "x|y"},
+				},
+				Limit: 10,
+			},
+			ExpectedWhere: `WHERE mtenant = $1 AND mtype = $2 AND mname LIKE $3 ESCAPE '\' AND mname ~ $4` +
+				` AND COALESCE(mlabels->>$5, '') = $6 AND COALESCE(mlabels->>$7, '') !~ $8`,
+			ExpectedOrder: ` ORDER BY mname COLLATE "C" ASC LIMIT $9`,
+			ExpectedArgs:  []interface{}{"team", "gauge", `Heap\_%`, "^(?:Heap.+)$", "host", "a", "dc", "^(?:x|y)$", 10},
+		},
+		{
+			Name: "cursor by name descending",
+			Query: metric.Query{
+				Cursor: &metric.Cursor{ID: "HeapAlloc"},
+				Desc:   true,
+			},
+			ExpectedWhere: `WHERE mtenant = $1 AND mname COLLATE "C" < $2`,
+			ExpectedOrder: ` ORDER BY mname COLLATE "C" DESC`,
+			ExpectedArgs:  []interface{}{"team", "HeapAlloc"},
+		},
+		{
+			Name: "cursor by value",
+			Query: metric.Query{
+				Cursor: &metric.Cursor{ID: "HeapAlloc", Value: 5},
+				SortBy: metric.SortByValue,
+			},
+			ExpectedWhere: "WHERE mtenant = $1 AND (" + valueKey + " > $3 OR (" + valueKey + ` = $3 AND mname COLLATE "C" > $2))`,
+			ExpectedOrder: " ORDER BY " + valueKey + ` ASC, mname COLLATE "C" ASC`,
+			ExpectedArgs:  []interface{}{"team", "HeapAlloc", float64(5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			statement, args := buildQuery("team", tt.Query)
+
+			assert.Contains(t, statement, tt.ExpectedWhere+tt.ExpectedOrder)
+			assert.Equal(t, tt.ExpectedArgs, args)
+		})
+	}
+}
+
+func Test_Query(t *testing.T) {
+	ms, err := New(config.DBAddress, true)
+	if err != nil {
+		t.Logf("unable to connect to postgre: %v\n", err)
+		t.SkipNow()
+	}
+	assert.NotNil(t, ms)
+
+	var value1, value2 float64 = 2, 1
+	var delta int64 = 3
+
+	assert.NoError(t, ms.UpdateBatch([]*metric.Metric{
+		{ID: "HeapAlloc", MType: "gauge", Value: &value1, Labels: map[string]string{"host": "a"}},
+		{ID: "HeapSys", MType: "gauge", Value: &value2},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}))
+
+	res, err := ms.Query(metric.Query{NameGlob: "Heap*", SortBy: metric.SortByValue, Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "HeapSys", res[0].ID)
+
+	res, err = ms.Query(metric.Query{NameGlob: "Heap*", SortBy: metric.SortByValue, Cursor: metric.CursorOf(res[0])})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "HeapAlloc", res[0].ID)
+
+	res, err = ms.Query(metric.Query{Labels: []metric.LabelMatcher{{Name: "host", Op: metric.MatchNotEqual, Value: "a"}}})
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+
+	_, err = ms.Query(metric.Query{NameRegexp: "("})
+	assert.ErrorIs(t, err, metric.ErrInvalidQuery)
+
+	assert.NoError(t, ms.DB.Close())
+}
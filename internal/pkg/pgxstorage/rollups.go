@@ -0,0 +1,131 @@
+package pgxstorage
+
+import (
+	"database/sql"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
+)
+
+const (
+	rollupsMigration = `
+	CREATE TABLE IF NOT EXISTS rollups (
+		rresolution BIGINT NOT NULL,
+		rtenant CHARACTER VARYING NOT NULL DEFAULT '',
+		rname CHARACTER VARYING NOT NULL,
+		rtime TIMESTAMPTZ NOT NULL,
+		rmin DOUBLE PRECISION NOT NULL,
+		rmax DOUBLE PRECISION NOT NULL,
+		rsum DOUBLE PRECISION NOT NULL,
+		rlast DOUBLE PRECISION NOT NULL,
+		rcount BIGINT NOT NULL,
+		PRIMARY KEY (rresolution, rtenant, rname, rtime)
+	);
+	CREATE INDEX IF NOT EXISTS rollups_time ON rollups (rresolution, rtime)`
+
+	stSavePoint = `
+	INSERT INTO rollups (rresolution, rtenant, rname, rtime, rmin, rmax, rsum, rlast, rcount)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (rresolution, rtenant, rname, rtime) DO UPDATE
+	SET rmin = EXCLUDED.rmin, rmax = EXCLUDED.rmax, rsum = EXCLUDED.rsum, rlast = EXCLUDED.rlast, rcount = EXCLUDED.rcount`
+
+	stGetPoints = `
+	SELECT rtenant, rname, rtime, rmin, rmax, rsum, rlast, rcount
+	FROM rollups
+	WHERE rresolution = $1 AND rtenant = $2 AND rname = $3 AND rtime >= $4 AND rtime < $5
+	ORDER BY rtime`
+
+	stRangePoints = `
+	SELECT rtenant, rname, rtime, rmin, rmax, rsum, rlast, rcount
+	FROM rollups
+	WHERE rresolution = $1 AND rtime >= $2 AND rtime < $3
+	ORDER BY rtenant, rname, rtime`
+
+	stLatestPoint = `
+	SELECT MAX(rtime) FROM rollups WHERE rresolution = $1`
+
+	stDeletePoints = `
+	DELETE FROM rollups WHERE rresolution = $1 AND rtime < $2`
+)
+
+// Saves points of tier in a single transaction, existing ones are replaced.
+func (st *pgxStorage) SavePoints(resolution time.Duration, points []rollup.Point) (err error) {
+	tx, err := st.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if errRollback := tx.Rollback(); errRollback != nil {
+				log.Println(errRollback)
+			}
+		}
+	}()
+
+	txStSavePoint, err := tx.Prepare(stSavePoint)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		if _, err = txStSavePoint.Exec(int64(resolution), p.Tenant, p.ID, p.Time, p.Min, p.Max, p.Sum, p.Last, p.Count); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Returns points of metric of tenant in range [from, to) sorted by time.
+func (st *pgxStorage) GetPoints(resolution time.Duration, tenant, id string, from, to time.Time) ([]rollup.Point, error) {
+	return st.queryPoints(stGetPoints, int64(resolution), tenant, id, from, to)
+}
+
+// Returns points of all metrics in range [from, to) sorted by tenant, name and time.
+func (st *pgxStorage) RangePoints(resolution time.Duration, from, to time.Time) ([]rollup.Point, error) {
+	return st.queryPoints(stRangePoints, int64(resolution), from, to)
+}
+
+// Returns time of the latest point of tier. Zero time means there are no points.
+func (st *pgxStorage) LatestPoint(resolution time.Duration) (time.Time, error) {
+	var latest sql.NullTime
+	if err := st.DB.QueryRow(stLatestPoint, int64(resolution)).Scan(&latest); err != nil {
+		return time.Time{}, err
+	}
+
+	return latest.Time, nil
+}
+
+// Removes points of tier older than given time.
+func (st *pgxStorage) DeletePoints(resolution time.Duration, before time.Time) error {
+	_, err := st.DB.Exec(stDeletePoints, int64(resolution), before)
+	return err
+}
+
+func (st *pgxStorage) queryPoints(query string, args ...interface{}) ([]rollup.Point, error) {
+	rows, err := st.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errRowsClose := rows.Close(); errRowsClose != nil {
+			log.Println(errRowsClose)
+		}
+	}()
+
+	points := []rollup.Point{}
+	for rows.Next() {
+		p := rollup.Point{}
+		if err := rows.Scan(&p.Tenant, &p.ID, &p.Time, &p.Min, &p.Max, &p.Sum, &p.Last, &p.Count); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
@@ -0,0 +1,46 @@
+package pgxstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Rollups(t *testing.T) {
+	ms, err := New(config.DBAddress, true)
+	if err != nil {
+		t.Logf("unable to connect to postgre: %v\n", err)
+		t.SkipNow()
+	}
+	assert.NotNil(t, ms)
+
+	start := time.Now().UTC().Truncate(time.Minute)
+	s := rollup.NewSampler()
+
+	points := []rollup.Point{}
+	for i := 0; i < 6; i++ {
+		points = append(points, s.Gauge("team", "Alloc", start.Add(time.Duration(i)*10*time.Second), float64(i)))
+	}
+
+	assert.NoError(t, ms.DeletePoints(10*time.Second, start.Add(time.Hour)))
+	assert.NoError(t, ms.SavePoints(10*time.Second, points[:4]))
+	assert.NoError(t, ms.SavePoints(10*time.Second, points[3:]))
+	assert.NoError(t, ms.DeletePoints(10*time.Second, start.Add(20*time.Second)))
+
+	raw, err := ms.GetPoints(10*time.Second, "team", "Alloc", start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, raw, 4)
+	assert.Equal(t, 2.0, raw[0].Last)
+
+	all, err := ms.RangePoints(10*time.Second, start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	latest, err := ms.LatestPoint(10 * time.Second)
+	assert.NoError(t, err)
+	assert.True(t, start.Add(50*time.Second).Equal(latest))
+
+	assert.NoError(t, ms.DB.Close())
+}
@@ -0,0 +1,359 @@
+// Package prompb decodes and encodes Prometheus remote_write requests: snappy-compressed protobuf WriteRequest
+// messages. Only fields needed for storing samples are kept, others are skipped.
+package prompb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/snappy"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/protowire"
+)
+
+var (
+	ErrInvalidRequest = errors.New("invalid remote write request")
+	ErrTooLarge       = errors.New("decoded remote write request is too large")
+)
+
+// Name of label keeping metric name.
+const NameLabel = "__name__"
+
+// Metric types of metadata.
+const (
+	TypeUnknown        = 0
+	TypeCounter        = 1
+	TypeGauge          = 2
+	TypeHistogram      = 3
+	TypeGaugeHistogram = 4
+	TypeSummary        = 5
+	TypeInfo           = 6
+	TypeStateset       = 7
+)
+
+// WriteRequest is the body of remote_write request.
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+// TimeSeries is samples of series identified by labels.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label is a name-value pair of series.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of series at timestamp in unix milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// MetricMetadata describes metric family.
+type MetricMetadata struct {
+	MetricFamilyName string
+	Help             string
+	Unit             string
+	Type             int
+}
+
+// Decode decompresses and decodes request body. Decompressed body must not exceed maxSize if it is positive.
+func Decode(body []byte, maxSize int) (*WriteRequest, error) {
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	if maxSize > 0 && size > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	req := &WriteRequest{}
+	if err := req.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	return req, nil
+}
+
+// Encode encodes and compresses request.
+func Encode(req *WriteRequest) []byte {
+	return snappy.Encode(nil, req.Marshal())
+}
+
+// Name gives metric name of series.
+func (ts *TimeSeries) Name() string {
+	for _, l := range ts.Labels {
+		if l.Name == NameLabel {
+			return l.Value
+		}
+	}
+
+	return ""
+}
+
+// Unmarshal decodes protobuf message.
+func (req *WriteRequest) Unmarshal(data []byte) error {
+	r := protowire.NewReader(data)
+
+	for r.More() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+
+		switch num {
+		case 1:
+			b, err := bytesField(r, num, typ)
+			if err != nil {
+				return err
+			}
+
+			ts := TimeSeries{}
+			if err := ts.unmarshal(b); err != nil {
+				return err
+			}
+			req.Timeseries = append(req.Timeseries, ts)
+		case 3:
+			b, err := bytesField(r, num, typ)
+			if err != nil {
+				return err
+			}
+
+			md := MetricMetadata{}
+			if err := md.unmarshal(b); err != nil {
+				return err
+			}
+			req.Metadata = append(req.Metadata, md)
+		default:
+			if err := r.Skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Marshal encodes protobuf message.
+func (req *WriteRequest) Marshal() []byte {
+	b := []byte{}
+
+	for i := range req.Timeseries {
+		b = protowire.AppendBytes(b, 1, req.Timeseries[i].marshal())
+	}
+
+	for i := range req.Metadata {
+		b = protowire.AppendBytes(b, 3, req.Metadata[i].marshal())
+	}
+
+	return b
+}
+
+func (ts *TimeSeries) unmarshal(data []byte) error {
+	r := protowire.NewReader(data)
+
+	for r.More() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+
+		switch num {
+		case 1:
+			b, err := bytesField(r, num, typ)
+			if err != nil {
+				return err
+			}
+
+			l := Label{}
+			if err := l.unmarshal(b); err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case 2:
+			b, err := bytesField(r, num, typ)
+			if err != nil {
+				return err
+			}
+
+			s := Sample{}
+			if err := s.unmarshal(b); err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		default:
+			if err := r.Skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ts *TimeSeries) marshal() []byte {
+	b := []byte{}
+
+	for _, l := range ts.Labels {
+		lb := protowire.AppendString(nil, 1, l.Name)
+		lb = protowire.AppendString(lb, 2, l.Value)
+		b = protowire.AppendBytes(b, 1, lb)
+	}
+
+	for _, s := range ts.Samples {
+		sb := protowire.AppendDouble(nil, 1, s.Value)
+		sb = protowire.AppendVarint(sb, 2, uint64(s.Timestamp))
+		b = protowire.AppendBytes(b, 2, sb)
+	}
+
+	return b
+}
+
+func (l *Label) unmarshal(data []byte) error {
+	r := protowire.NewReader(data)
+
+	for r.More() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+
+		switch num {
+		case 1, 2:
+			b, err := bytesField(r, num, typ)
+			if err != nil {
+				return err
+			}
+
+			if num == 1 {
+				l.Name = string(b)
+			} else {
+				l.Value = string(b)
+			}
+		default:
+			if err := r.Skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Sample) unmarshal(data []byte) error {
+	r := protowire.NewReader(data)
+
+	for r.More() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+
+		switch num {
+		case 1:
+			if err := protowire.Expect(num, typ, protowire.TypeFixed64); err != nil {
+				return err
+			}
+
+			if s.Value, err = r.Double(); err != nil {
+				return err
+			}
+		case 2:
+			if err := protowire.Expect(num, typ, protowire.TypeVarint); err != nil {
+				return err
+			}
+
+			v, err := r.Varint()
+			if err != nil {
+				return err
+			}
+			s.Timestamp = int64(v)
+		default:
+			if err := r.Skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (md *MetricMetadata) unmarshal(data []byte) error {
+	r := protowire.NewReader(data)
+
+	for r.More() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+
+		switch num {
+		case 1:
+			if err := protowire.Expect(num, typ, protowire.TypeVarint); err != nil {
+				return err
+			}
+
+			v, err := r.Varint()
+			if err != nil {
+				return err
+			}
+			md.Type = int(v)
+		case 2, 4, 5:
+			b, err := bytesField(r, num, typ)
+			if err != nil {
+				return err
+			}
+
+			switch num {
+			case 2:
+				md.MetricFamilyName = string(b)
+			case 4:
+				md.Help = string(b)
+			default:
+				md.Unit = string(b)
+			}
+		default:
+			if err := r.Skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (md *MetricMetadata) marshal() []byte {
+	b := protowire.AppendVarint(nil, 1, uint64(md.Type))
+	b = protowire.AppendString(b, 2, md.MetricFamilyName)
+
+	if md.Help != "" {
+		b = protowire.AppendString(b, 4, md.Help)
+	}
+
+	if md.Unit != "" {
+		b = protowire.AppendString(b, 5, md.Unit)
+	}
+
+	return b
+}
+
+// bytesField reads value of length-delimited field checking its wire type.
+func bytesField(r *protowire.Reader, num, typ int) ([]byte, error) {
+	if err := protowire.Expect(num, typ, protowire.TypeBytes); err != nil {
+		return nil, err
+	}
+
+	return r.Bytes()
+}
@@ -0,0 +1,47 @@
+package prompb
+
+import (
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EncodeDecode(t *testing.T) {
+	req := &WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{Name: NameLabel, Value: "up"}, {Name: "job", Value: "node"}},
+				Samples: []Sample{{Value: 1, Timestamp: 1700000000000}, {Value: -2.5, Timestamp: -1}},
+			},
+		},
+		Metadata: []MetricMetadata{{Type: TypeCounter, MetricFamilyName: "up", Help: "help", Unit: "seconds"}},
+	}
+
+	decoded, err := Decode(Encode(req), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, req, decoded)
+	assert.Equal(t, "up", decoded.Timeseries[0].Name())
+	assert.Equal(t, "", (&TimeSeries{}).Name())
+}
+
+func Test_DecodeErrors(t *testing.T) {
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Body          []byte
+		MaxSize       int
+	}{
+		{Name: "not snappy", Body: []byte{0xff, 0xff, 0xff}, ExpectedError: ErrInvalidRequest},
+		{Name: "not protobuf", Body: snappy.Encode(nil, []byte{0x0a, 0x05, 0x01}), ExpectedError: ErrInvalidRequest},
+		{Name: "sample of wrong wire type", Body: snappy.Encode(nil, []byte{0x0a, 0x04, 0x12, 0x02, 0x08, 0x01}), ExpectedError: ErrInvalidRequest},
+		{Name: "too large", Body: snappy.Encode(nil, make([]byte, 100)), MaxSize: 10, ExpectedError: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := Decode(tt.Body, tt.MaxSize)
+			assert.ErrorIs(t, err, tt.ExpectedError)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Package protowire reads and writes protocol buffers wire format. It's enough for decoding and encoding
+// messages of known schemas field by field without generated code.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidWire = errors.New("invalid protobuf wire format")
+
+// Wire types.
+const (
+	TypeVarint  = 0
+	TypeFixed64 = 1
+	TypeBytes   = 2
+	TypeFixed32 = 5
+)
+
+// Reader reads fields of encoded message one by one.
+type Reader struct {
+	data []byte
+	pos  int
+}
+
+// Constructor.
+func NewReader(data []byte) *Reader {
+	return &Reader{
+		data: data,
+	}
+}
+
+// More checks if there are unread fields.
+func (r *Reader) More() bool {
+	return r.pos < len(r.data)
+}
+
+// Next reads tag of the next field and gives its number and wire type.
+func (r *Reader) Next() (int, int, error) {
+	tag, err := r.Varint()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	num, typ := int(tag>>3), int(tag&7)
+	if num <= 0 {
+		return 0, 0, fmt.Errorf("%w: field number %d", ErrInvalidWire, num)
+	}
+
+	return num, typ, nil
+}
+
+// Varint reads value of varint field.
+func (r *Reader) Varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: truncated varint at %d", ErrInvalidWire, r.pos)
+	}
+	r.pos += n
+
+	return v, nil
+}
+
+// Fixed64 reads value of fixed64 field.
+func (r *Reader) Fixed64() (uint64, error) {
+	if len(r.data)-r.pos < 8 {
+		return 0, fmt.Errorf("%w: truncated fixed64 at %d", ErrInvalidWire, r.pos)
+	}
+
+	v := binary.LittleEndian.Uint64(r.data[r.pos:])
+	r.pos += 8
+
+	return v, nil
+}
+
+// Double reads value of double field.
+func (r *Reader) Double() (float64, error) {
+	v, err := r.Fixed64()
+	return math.Float64frombits(v), err
+}
+
+// Bytes reads value of length-delimited field. Result refers to the read data.
+func (r *Reader) Bytes() ([]byte, error) {
+	l, err := r.Varint()
+	if err != nil {
+		return nil, err
+	}
+
+	if l > uint64(len(r.data)-r.pos) {
+		return nil, fmt.Errorf("%w: truncated bytes at %d", ErrInvalidWire, r.pos)
+	}
+
+	b := r.data[r.pos : r.pos+int(l)]
+	r.pos += int(l)
+
+	return b, nil
+}
+
+// Skip skips value of field of given wire type.
+func (r *Reader) Skip(typ int) error {
+	var err error
+
+	switch typ {
+	case TypeVarint:
+		_, err = r.Varint()
+	case TypeFixed64:
+		_, err = r.Fixed64()
+	case TypeBytes:
+		_, err = r.Bytes()
+	case TypeFixed32:
+		if len(r.data)-r.pos < 4 {
+			return fmt.Errorf("%w: truncated fixed32 at %d", ErrInvalidWire, r.pos)
+		}
+		r.pos += 4
+	default:
+		return fmt.Errorf("%w: unsupported wire type %d", ErrInvalidWire, typ)
+	}
+
+	return err
+}
+
+// Expect checks wire type of field.
+func Expect(num, typ, expected int) error {
+	if typ != expected {
+		return fmt.Errorf("%w: field %d has wire type %d instead of %d", ErrInvalidWire, num, typ, expected)
+	}
+
+	return nil
+}
+
+// AppendTag appends tag of field.
+func AppendTag(b []byte, num, typ int) []byte {
+	return appendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// AppendVarint appends varint field.
+func AppendVarint(b []byte, num int, v uint64) []byte {
+	return appendUvarint(AppendTag(b, num, TypeVarint), v)
+}
+
+// AppendDouble appends double field.
+func AppendDouble(b []byte, num int, v float64) []byte {
+	return appendUint64(AppendTag(b, num, TypeFixed64), math.Float64bits(v))
+}
+
+// AppendFixed64 appends fixed64 field.
+func AppendFixed64(b []byte, num int, v uint64) []byte {
+	return appendUint64(AppendTag(b, num, TypeFixed64), v)
+}
+
+// AppendBytes appends length-delimited field, e.g. string or embedded message.
+func AppendBytes(b []byte, num int, v []byte) []byte {
+	b = appendUvarint(AppendTag(b, num, TypeBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendString appends string field.
+func AppendString(b []byte, num int, v string) []byte {
+	b = appendUvarint(AppendTag(b, num, TypeBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendUvarint appends unsigned varint.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendUint64 appends little-endian fixed-size value.
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
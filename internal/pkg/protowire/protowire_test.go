@@ -0,0 +1,72 @@
+package protowire
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Reader(t *testing.T) {
+	b := AppendVarint(nil, 1, 300)
+	b = AppendDouble(b, 2, 1.5)
+	b = AppendString(b, 3, "abc")
+	b = AppendFixed64(b, 4, 7)
+	b = AppendBytes(b, 5, AppendVarint(nil, 1, 1))
+
+	r := NewReader(b)
+
+	num, typ, err := r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, TypeVarint}, []int{num, typ})
+	v, err := r.Varint()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(300), v)
+
+	_, typ, err = r.Next()
+	assert.NoError(t, err)
+	assert.Error(t, Expect(2, typ, TypeBytes))
+	d, err := r.Double()
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, d)
+
+	_, _, err = r.Next()
+	assert.NoError(t, err)
+	s, err := r.Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(s))
+
+	for r.More() {
+		_, typ, err := r.Next()
+		assert.NoError(t, err)
+		assert.NoError(t, r.Skip(typ))
+	}
+}
+
+func Test_ReaderErrors(t *testing.T) {
+	tests := []struct {
+		Name string
+		Data []byte
+	}{
+		{Name: "truncated varint", Data: []byte{0x08, 0x80}},
+		{Name: "truncated bytes", Data: []byte{0x1a, 0x05, 'a'}},
+		{Name: "truncated fixed64", Data: []byte{0x11, 0x01}},
+		{Name: "zero field number", Data: []byte{0x00, 0x01}},
+		{Name: "unsupported wire type", Data: []byte{0x0b}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := NewReader(tt.Data)
+
+			var err error
+			for r.More() && err == nil {
+				var typ int
+				if _, typ, err = r.Next(); err == nil {
+					err = r.Skip(typ)
+				}
+			}
+
+			assert.ErrorIs(t, err, ErrInvalidWire)
+		})
+	}
+}
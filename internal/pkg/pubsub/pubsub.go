@@ -0,0 +1,242 @@
+// Package pubsub delivers accepted metric updates to subscribers, e.g. to streaming clients.
+// Publishing never blocks: if subscriber buffer is full, the oldest event is dropped for this subscriber and counted.
+package pubsub
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+// Default number of events buffered for every subscriber.
+const DefaultBufferSize = 256
+
+// Event is accepted update of metric. Counters keep delta of update, not accumulated value.
+type Event struct {
+	*metric.Metric
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+}
+
+// Filter selects events delivered to subscriber.
+type Filter struct {
+	Labels map[string]string
+	Tenant string
+	Prefix string
+	MType  string
+}
+
+// Match checks if event passes filter. Tenant always must be equal, other empty fields match everything.
+func (f *Filter) Match(e *Event) bool {
+	if e.Tenant != f.Tenant || !strings.HasPrefix(e.ID, f.Prefix) {
+		return false
+	}
+
+	if f.MType != "" && e.MType != f.MType {
+		return false
+	}
+
+	for k, v := range f.Labels {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Subscription receives events matching its filter.
+type Subscription struct {
+	ch      chan Event
+	filter  Filter
+	dropped int64
+}
+
+// Events gives channel of events. It is closed on unsubscribing or broker closing.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped gives number of events lost because subscriber was too slow.
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// send puts event to subscriber buffer. If buffer is full, the oldest events are dropped,
+// so subscriber always gets the latest updates.
+func (s *Subscription) send(e Event, brokerDropped *int64) {
+	for {
+		select {
+		case s.ch <- e:
+			return
+		default:
+		}
+
+		select {
+		case <-s.ch:
+			atomic.AddInt64(&s.dropped, 1)
+			atomic.AddInt64(brokerDropped, 1)
+		default:
+		}
+	}
+}
+
+// Broker fans events out to subscribers.
+type Broker struct {
+	subs       map[*Subscription]struct{}
+	published  int64
+	dropped    int64
+	bufferSize int
+	closed     bool
+	sync.RWMutex
+}
+
+// Broker constructor. Non-positive buffer size means default one.
+func New(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Broker{
+		subs:       map[*Subscription]struct{}{},
+		bufferSize: bufferSize,
+	}
+}
+
+// Subscribe registers subscriber with given filter. Subscription of closed broker gives closed channel.
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	s := &Subscription{
+		ch:     make(chan Event, b.bufferSize),
+		filter: filter,
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+
+	b.subs[s] = struct{}{}
+
+	return s
+}
+
+// Unsubscribe removes subscriber and closes its channel.
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Snapshot makes events of metrics of tenant. Must be called before storing metrics, since storages could change them.
+// Gives nothing if there are no subscribers.
+func (b *Broker) Snapshot(tenant string, batch ...*metric.Metric) []Event {
+	if b == nil {
+		return nil
+	}
+
+	b.RLock()
+	subscribers := len(b.subs)
+	b.RUnlock()
+
+	if subscribers == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	events := make([]Event, 0, len(batch))
+
+	for i := range batch {
+		events = append(events, Event{
+			Metric: copyMetric(batch[i]),
+			Time:   now,
+			Tenant: tenant,
+		})
+	}
+
+	return events
+}
+
+// Publish delivers events to matching subscribers without blocking.
+func (b *Broker) Publish(events ...Event) {
+	if b == nil || len(events) == 0 {
+		return
+	}
+
+	b.RLock()
+	defer b.RUnlock()
+
+	for i := range events {
+		atomic.AddInt64(&b.published, 1)
+
+		for s := range b.subs {
+			if !s.filter.Match(&events[i]) {
+				continue
+			}
+
+			s.send(events[i], &b.dropped)
+		}
+	}
+}
+
+// copyMetric gives deep copy of metric without hash. Storages could keep and change published metrics.
+func copyMetric(m *metric.Metric) *metric.Metric {
+	c := &metric.Metric{
+		ID:    m.ID,
+		MType: m.MType,
+	}
+
+	if m.Delta != nil {
+		delta := *m.Delta
+		c.Delta = &delta
+	}
+
+	if m.Value != nil {
+		value := *m.Value
+		c.Value = &value
+	}
+
+	if len(m.Labels) != 0 {
+		c.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			c.Labels[k] = v
+		}
+	}
+
+	return c
+}
+
+// Stats gives number of subscribers, published events and events dropped for slow subscribers.
+func (b *Broker) Stats() (subscribers int, published, dropped int64) {
+	b.RLock()
+	subscribers = len(b.subs)
+	b.RUnlock()
+
+	return subscribers, atomic.LoadInt64(&b.published), atomic.LoadInt64(&b.dropped)
+}
+
+// Close unsubscribes everybody. Following subscriptions are closed immediately.
+func (b *Broker) Close() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	for s := range b.subs {
+		close(s.ch)
+	}
+
+	b.subs = map[*Subscription]struct{}{}
+	b.closed = true
+}
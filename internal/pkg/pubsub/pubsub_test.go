@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FilterMatch(t *testing.T) {
+	var value float64 = 1
+
+	e := &Event{
+		Metric: &metric.Metric{
+			ID:     "HeapAlloc",
+			MType:  "gauge",
+			Value:  &value,
+			Labels: map[string]string{"host": "a"},
+		},
+		Tenant: "team",
+	}
+
+	tests := []struct {
+		Name     string
+		Filter   Filter
+		Expected bool
+	}{
+		{Name: "tenant only", Filter: Filter{Tenant: "team"}, Expected: true},
+		{Name: "other tenant", Filter: Filter{}, Expected: false},
+		{Name: "prefix", Filter: Filter{Tenant: "team", Prefix: "Heap"}, Expected: true},
+		{Name: "other prefix", Filter: Filter{Tenant: "team", Prefix: "Poll"}, Expected: false},
+		{Name: "type", Filter: Filter{Tenant: "team", MType: "gauge"}, Expected: true},
+		{Name: "other type", Filter: Filter{Tenant: "team", MType: "counter"}, Expected: false},
+		{Name: "label", Filter: Filter{Tenant: "team", Labels: map[string]string{"host": "a"}}, Expected: true},
+		{Name: "other label", Filter: Filter{Tenant: "team", Labels: map[string]string{"host": "b"}}, Expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.Expected, tt.Filter.Match(e))
+		})
+	}
+}
+
+func Test_Publish(t *testing.T) {
+	var delta int64 = 1
+
+	b := New(2)
+
+	all := b.Subscribe(Filter{})
+	counters := b.Subscribe(Filter{MType: "counter"})
+	other := b.Subscribe(Filter{Tenant: "other"})
+
+	m := &metric.Metric{ID: "PollCount", MType: "counter", Delta: &delta, Hash: "hash"}
+	events := b.Snapshot(metric.DefaultTenant, m, m, m)
+
+	delta = 100
+	b.Publish(events...)
+
+	assert.Len(t, all.Events(), 2)
+	assert.Len(t, counters.Events(), 2)
+	assert.Len(t, other.Events(), 0)
+
+	assert.Equal(t, int64(1), all.Dropped())
+	assert.Equal(t, int64(0), other.Dropped())
+
+	e := <-all.Events()
+	assert.Equal(t, "PollCount", e.ID)
+	assert.Equal(t, int64(1), *e.Delta)
+	assert.Empty(t, e.Hash)
+	assert.Equal(t, "hash", m.Hash)
+
+	subscribers, published, dropped := b.Stats()
+	assert.Equal(t, 3, subscribers)
+	assert.Equal(t, int64(3), published)
+	assert.Equal(t, int64(2), dropped)
+
+	b.Unsubscribe(counters)
+	b.Unsubscribe(counters)
+
+	b.Close()
+
+	_, ok := <-other.Events()
+	assert.False(t, ok)
+
+	_, ok = <-b.Subscribe(Filter{}).Events()
+	assert.False(t, ok)
+
+	var nilBroker *Broker
+	assert.Nil(t, nilBroker.Snapshot(metric.DefaultTenant, m))
+	nilBroker.Publish(events...)
+
+	assert.Nil(t, New(0).Snapshot(metric.DefaultTenant, m))
+}
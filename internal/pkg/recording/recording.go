@@ -0,0 +1,180 @@
+// Package recording evaluates recording rules computing new gauges from stored metrics,
+// e.g. "HeapUtil = HeapInuse / HeapSys" or "PollRate = rate(PollCount)".
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/expr"
+)
+
+var (
+	ErrInvalidRule   = errors.New("invalid recording rule")
+	ErrDuplicateRule = errors.New("duplicate recording rule")
+	ErrNotFinite     = errors.New("result is not finite")
+)
+
+// Rule records value of expression as gauge named Record. Rule could be also defined by expression only
+// in format "<record> = <expression>".
+type Rule struct {
+	expr   *expr.Expr
+	Record string `json:"record"`
+	Expr   string `json:"expr"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Compile parses rule expression. Expression must not refer to recorded metric.
+func (r *Rule) Compile() error {
+	if r.Record == "" {
+		r.Record, r.Expr = splitRecord(r.Expr)
+	}
+
+	if r.Record == "" {
+		return fmt.Errorf("%w: record is not defined", ErrInvalidRule)
+	}
+
+	e, err := expr.Parse(r.Expr)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidRule, r.Record, err)
+	}
+
+	for _, id := range e.Metrics() {
+		if id == r.Record {
+			return fmt.Errorf("%w %q: expression refers to recorded metric", ErrInvalidRule, r.Record)
+		}
+	}
+
+	r.expr = e
+
+	return nil
+}
+
+// splitRecord splits "<record> = <expression>" at assignment, which is "=" not being part of comparison.
+// Gives empty record if there is no assignment.
+func splitRecord(source string) (string, string) {
+	for i := 0; i < len(source); i++ {
+		if source[i] != '=' {
+			continue
+		}
+
+		if i > 0 && strings.IndexByte("=!<>", source[i-1]) >= 0 || i+1 < len(source) && source[i+1] == '=' {
+			i++
+			continue
+		}
+
+		return strings.TrimSpace(source[:i]), strings.TrimSpace(source[i+1:])
+	}
+
+	return "", source
+}
+
+// Result is the latest evaluation of rule. Error is empty if evaluation succeeded.
+type Result struct {
+	EvaluatedAt time.Time `json:"evaluated_at"`
+	Record      string    `json:"record"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Expr        string    `json:"expr"`
+	Error       string    `json:"error,omitempty"`
+	Value       float64   `json:"value"`
+}
+
+// Engine evaluates rules and keeps their latest results. Is concurrent-safe.
+type Engine struct {
+	results []Result
+	rules   []Rule
+	mu      sync.RWMutex
+}
+
+// NewEngine compiles rules. Recorded names must be unique within tenant.
+func NewEngine(rules []Rule) (*Engine, error) {
+	e := &Engine{
+		results: make([]Result, len(rules)),
+		rules:   make([]Rule, len(rules)),
+	}
+
+	type key struct {
+		tenant string
+		record string
+	}
+	names := map[key]bool{}
+
+	for i := range rules {
+		e.rules[i] = rules[i]
+		if err := e.rules[i].Compile(); err != nil {
+			return nil, err
+		}
+
+		r := &e.rules[i]
+
+		k := key{tenant: r.Tenant, record: r.Record}
+		if names[k] {
+			return nil, fmt.Errorf("%w %q", ErrDuplicateRule, r.Record)
+		}
+		names[k] = true
+
+		e.results[i] = Result{
+			Record: r.Record,
+			Tenant: r.Tenant,
+			Expr:   r.Expr,
+		}
+	}
+
+	return e, nil
+}
+
+// Eval evaluates every rule by data given by env of rule tenant. Gives successful results and errors
+// of rules which couldn't be evaluated. Results of rules are visible to other rules at the next evaluation.
+func (e *Engine) Eval(now time.Time, env func(tenant string) expr.Env) ([]Result, []error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results := []Result{}
+	errs := []error{}
+
+	for i := range e.rules {
+		r := &e.rules[i]
+
+		value, err := r.expr.Eval(env(r.Tenant))
+		if err == nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+			err = ErrNotFinite
+		}
+
+		res := &e.results[i]
+		res.EvaluatedAt = now
+
+		if err != nil {
+			res.Error = err.Error()
+			errs = append(errs, fmt.Errorf("recording rule %q: %w", r.Record, err))
+			continue
+		}
+
+		res.Error, res.Value = "", value
+		results = append(results, *res)
+	}
+
+	return results, errs
+}
+
+// Results gives the latest results of rules of all tenants sorted by tenant and record.
+func (e *Engine) Results() []Result {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	results := make([]Result, len(e.results))
+	copy(results, e.results)
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Tenant != results[j].Tenant {
+			return results[i].Tenant < results[j].Tenant
+		}
+		return results[i].Record < results[j].Record
+	})
+
+	return results
+}
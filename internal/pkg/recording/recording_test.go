@@ -0,0 +1,128 @@
+package recording
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/expr"
+	"github.com/stretchr/testify/assert"
+)
+
+// testEnv keeps values and rates of metrics in maps.
+type testEnv struct {
+	values map[string]float64
+	rates  map[string]float64
+}
+
+func (e testEnv) Value(id string) (float64, error) {
+	v, ok := e.values[id]
+	if !ok {
+		return 0, expr.ErrNoData
+	}
+
+	return v, nil
+}
+
+func (e testEnv) Rate(id string) (float64, error) {
+	v, ok := e.rates[id]
+	if !ok {
+		return 0, expr.ErrNoData
+	}
+
+	return v, nil
+}
+
+func Test_Compile(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Rule           Rule
+		ExpectedRecord string
+		ExpectedExpr   string
+		ExpectedError  bool
+	}{
+		{
+			Name:           "record and expression",
+			Rule:           Rule{Record: "HeapUtil", Expr: "HeapInuse / HeapSys"},
+			ExpectedRecord: "HeapUtil",
+			ExpectedExpr:   "HeapInuse / HeapSys",
+		},
+		{
+			Name:           "assignment",
+			Rule:           Rule{Expr: "MemUsedPct = 1 - FreeMemory/TotalMemory"},
+			ExpectedRecord: "MemUsedPct",
+			ExpectedExpr:   "1 - FreeMemory/TotalMemory",
+		},
+		{
+			Name:           "assignment of comparison",
+			Rule:           Rule{Expr: "Saturated = CPUutilization1 >= 90 or Alloc == Sys"},
+			ExpectedRecord: "Saturated",
+			ExpectedExpr:   "CPUutilization1 >= 90 or Alloc == Sys",
+		},
+		{Name: "missing record", Rule: Rule{Expr: "HeapInuse >= HeapSys"}, ExpectedError: true},
+		{Name: "invalid expression", Rule: Rule{Record: "HeapUtil", Expr: "HeapInuse /"}, ExpectedError: true},
+		{Name: "self reference", Rule: Rule{Expr: "PollCount = PollCount + 1"}, ExpectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := tt.Rule.Compile()
+			if tt.ExpectedError {
+				assert.ErrorIs(t, err, ErrInvalidRule)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ExpectedRecord, tt.Rule.Record)
+			assert.Equal(t, tt.ExpectedExpr, tt.Rule.Expr)
+		})
+	}
+}
+
+func Test_EngineEval(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{Expr: "HeapUtil = HeapInuse / HeapSys"},
+		{Expr: "MemUsedPct = 1 - FreeMemory/TotalMemory", Tenant: "team"},
+		{Expr: "PollRate = rate(PollCount)"},
+		{Expr: "Broken = HeapInuse / Zero"},
+	})
+	assert.NoError(t, err)
+
+	env := testEnv{
+		values: map[string]float64{"HeapInuse": 30, "HeapSys": 40, "FreeMemory": 25, "TotalMemory": 100, "Zero": 0},
+		rates:  map[string]float64{},
+	}
+	envOf := func(string) expr.Env { return env }
+
+	now := time.Now()
+	results, errs := e.Eval(now, envOf)
+
+	assert.Len(t, errs, 2)
+	assert.ErrorIs(t, errs[0], expr.ErrNoData)
+	assert.ErrorIs(t, errs[1], expr.ErrDivisionByZero)
+
+	values := map[string]float64{}
+	for _, r := range results {
+		values[r.Tenant+"/"+r.Record] = r.Value
+	}
+	assert.Equal(t, map[string]float64{"/HeapUtil": 0.75, "team/MemUsedPct": 0.75}, values)
+
+	env.rates["PollCount"] = 2
+	results, errs = e.Eval(now.Add(time.Minute), envOf)
+	assert.Len(t, results, 3)
+	assert.Len(t, errs, 1)
+
+	all := e.Results()
+	assert.Equal(t, []string{"Broken", "HeapUtil", "PollRate", "MemUsedPct"}, []string{all[0].Record, all[1].Record, all[2].Record, all[3].Record})
+	assert.Equal(t, "division by zero", all[0].Error)
+	assert.Empty(t, all[2].Error)
+	assert.Equal(t, 2.0, all[2].Value)
+	assert.Equal(t, now.Add(time.Minute), all[2].EvaluatedAt)
+}
+
+func Test_NewEngineDuplicates(t *testing.T) {
+	_, err := NewEngine([]Rule{{Expr: "HeapUtil = HeapInuse / HeapSys"}, {Record: "HeapUtil", Expr: "1"}})
+	assert.ErrorIs(t, err, ErrDuplicateRule)
+
+	_, err = NewEngine([]Rule{{Expr: "HeapUtil = HeapInuse / HeapSys"}, {Record: "HeapUtil", Expr: "1", Tenant: "team"}})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,479 @@
+// Package replication streams changes of primary server storage to standby servers.
+//
+// Primary keeps log of the latest changes. Standby reads stream of changes following its position in the log,
+// and if position is unknown to primary, e.g. primary was restarted or standby fell behind, stream starts
+// with snapshot of the whole storage. Entries keep values of metrics after change, counters keep accumulated
+// values, so applying entry twice, e.g. after reconnect, keeps the same state.
+package replication
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var (
+	ErrInvalidStream = errors.New("invalid replication stream")
+	ErrFellBehind    = errors.New("standby fell behind replication log")
+	ErrRequest       = errors.New("replication request failed")
+)
+
+const (
+	// Path of stream endpoint of primary.
+	Path = "/replication/stream"
+
+	DefaultLogSize   = 10000
+	DefaultBackoff   = time.Second
+	DefaultHeartbeat = 15 * time.Second
+
+	// Maximal size of stream line, i.e. of snapshot of one tenant.
+	maxLineSize = 256 << 20
+)
+
+// Header is the first line of stream. Log identifies log of primary, Seq is position stream continues from.
+// If Snapshot is set, header is followed by snapshots of all Tenants of primary, other tenants must be dropped.
+type Header struct {
+	Log      string   `json:"log"`
+	Tenants  []string `json:"tenants,omitempty"`
+	Seq      uint64   `json:"seq"`
+	Snapshot bool     `json:"snapshot,omitempty"`
+}
+
+// Entry is change of tenant storage: values of changed metrics and names of deleted ones.
+// If Snapshot is set, Metrics are the whole content of tenant.
+type Entry struct {
+	Tenant   string           `json:"tenant,omitempty"`
+	Metrics  []*metric.Metric `json:"metrics,omitempty"`
+	Deleted  []string         `json:"deleted,omitempty"`
+	Seq      uint64           `json:"seq"`
+	Snapshot bool             `json:"snapshot,omitempty"`
+}
+
+// Status describes replication state of server.
+type Status struct {
+	Role      string `json:"role"`
+	Log       string `json:"log,omitempty"`
+	Primary   string `json:"primary,omitempty"`
+	Seq       uint64 `json:"seq"`
+	Standbys  int64  `json:"standbys"`
+	Connected bool   `json:"connected,omitempty"`
+}
+
+// Log keeps the latest changes of storage. Is concurrent-safe, nil value logs nothing.
+type Log struct {
+	wait     chan struct{}
+	done     chan struct{}
+	id       string
+	entries  []Entry
+	seq      uint64
+	size     int
+	standbys int64
+	mu       sync.Mutex
+}
+
+// Constructor. Non-positive size means default one.
+func NewLog(size int) *Log {
+	if size <= 0 {
+		size = DefaultLogSize
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		log.Println(err)
+	}
+
+	return &Log{
+		wait: make(chan struct{}),
+		done: make(chan struct{}),
+		id:   hex.EncodeToString(id),
+		size: size,
+	}
+}
+
+// Append logs change of tenant. Changed metrics are given by read, which is called under lock, so entries
+// of concurrent changes are ordered as states they read. If read fails, the change is lost for standbys,
+// so log forgets kept changes and standbys restore storage from snapshot.
+func (l *Log) Append(tenant string, deleted []string, read func() ([]*metric.Metric, error)) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := Entry{Tenant: tenant, Deleted: deleted}
+
+	if read != nil {
+		batch, err := read()
+		if err != nil {
+			l.seq++
+			l.entries = nil
+			l.notify()
+
+			return err
+		}
+
+		e.Metrics = make([]*metric.Metric, 0, len(batch))
+		for _, m := range batch {
+			e.Metrics = append(e.Metrics, copyMetric(m))
+		}
+	}
+
+	if len(e.Metrics) == 0 && len(e.Deleted) == 0 {
+		return nil
+	}
+
+	l.seq++
+	e.Seq = l.seq
+
+	l.entries = append(l.entries, e)
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+
+	l.notify()
+
+	return nil
+}
+
+// notify wakes streams waiting for changes. Must be called under lock.
+func (l *Log) notify() {
+	close(l.wait)
+	l.wait = make(chan struct{})
+}
+
+// copyMetric gives copy of metric without hash. Storages could change metrics they gave.
+func copyMetric(m *metric.Metric) *metric.Metric {
+	c := &metric.Metric{ID: m.ID, MType: m.MType, Labels: m.Labels}
+
+	if m.Delta != nil {
+		delta := *m.Delta
+		c.Delta = &delta
+	}
+
+	if m.Value != nil {
+		value := *m.Value
+		c.Value = &value
+	}
+
+	return c
+}
+
+// Status gives position of log and number of connected standbys.
+func (l *Log) Status() Status {
+	if l == nil {
+		return Status{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return Status{Log: l.id, Seq: l.seq, Standbys: atomic.LoadInt64(&l.standbys)}
+}
+
+// since gives entries following seq and channel closed on the next change. Is false if log doesn't keep them.
+func (l *Log) since(seq uint64) ([]Entry, <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq > l.seq || l.seq-seq > uint64(len(l.entries)) {
+		return nil, nil, false
+	}
+
+	return l.entries[len(l.entries)-int(l.seq-seq):], l.wait, true
+}
+
+// Close ends streams being served and streams served later. Changes are still logged.
+func (l *Log) Close() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+}
+
+// Serve writes stream of changes following position of standby until ctx is done or log is closed. If position is unknown,
+// stream starts with snapshot of storage given by snapshot, which must read storage after it is called.
+// Data written is passed to client by flush.
+func (l *Log) Serve(ctx context.Context, w io.Writer, flush func(), position string, snapshot func() ([]Entry, error)) error {
+	atomic.AddInt64(&l.standbys, 1)
+	defer atomic.AddInt64(&l.standbys, -1)
+
+	enc := json.NewEncoder(w)
+
+	id, seq := parsePosition(position)
+	_, _, known := l.since(seq)
+
+	header := Header{Log: l.id, Seq: seq}
+	var entries []Entry
+
+	if id != l.id || !known {
+		l.mu.Lock()
+		header.Seq = l.seq
+		l.mu.Unlock()
+
+		var err error
+		if entries, err = snapshot(); err != nil {
+			return err
+		}
+
+		header.Snapshot = true
+		header.Tenants = make([]string, 0, len(entries))
+		for i := range entries {
+			header.Tenants = append(header.Tenants, entries[i].Tenant)
+			entries[i].Seq = header.Seq
+			entries[i].Snapshot = true
+		}
+	}
+
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	seq = header.Seq
+
+	heartbeat := time.NewTicker(DefaultHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		for i := range entries {
+			if err := enc.Encode(entries[i]); err != nil {
+				return err
+			}
+			seq = entries[i].Seq
+		}
+		flush()
+
+		changes, wait, ok := l.since(seq)
+		if !ok {
+			return ErrFellBehind
+		}
+
+		entries = changes
+		if len(entries) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-l.done:
+			return nil
+		case <-wait:
+		case <-heartbeat.C:
+			// Empty lines keep idle connection open.
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Position gives value of query parameter "position" of stream request, e.g. "3fa1c2d4e5b6a798:1024".
+func Position(id string, seq uint64) string {
+	return id + ":" + strconv.FormatUint(seq, 10)
+}
+
+// parsePosition parses position of standby. Invalid position is unknown to any log.
+func parsePosition(position string) (string, uint64) {
+	i := strings.LastIndexByte(position, ':')
+	if i < 0 {
+		return "", 0
+	}
+
+	seq, err := strconv.ParseUint(position[i+1:], 10, 64)
+	if err != nil {
+		return "", 0
+	}
+
+	return position[:i], seq
+}
+
+// Follower keeps storage of standby equal to storage of primary. Is concurrent-safe.
+type Follower struct {
+	client  *http.Client
+	apply   func(Entry) error
+	drop    func(tenants []string) error
+	headers map[string]string
+	primary string
+	log     string
+	seq     uint64
+	mu      sync.Mutex
+	online  bool
+}
+
+// Constructor. Primary is base URL of primary server, headers are added to its requests, e.g. authorization.
+// Changes are passed to apply, tenants missing in snapshot of primary are passed to drop.
+func NewFollower(primary string, headers map[string]string, apply func(Entry) error, drop func(tenants []string) error) *Follower {
+	return &Follower{
+		client:  &http.Client{},
+		apply:   apply,
+		drop:    drop,
+		headers: headers,
+		primary: strings.TrimSuffix(primary, "/"),
+	}
+}
+
+// Run follows primary until ctx is done. Broken stream is reopened from the last applied position.
+func (f *Follower) Run(ctx context.Context) {
+	for {
+		if err := f.follow(ctx); err != nil && ctx.Err() == nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DefaultBackoff):
+		}
+	}
+}
+
+// Status gives position of standby.
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return Status{Primary: f.primary, Log: f.log, Seq: f.seq, Connected: f.online}
+}
+
+// follow reads one stream of primary.
+func (f *Follower) follow(ctx context.Context) error {
+	f.mu.Lock()
+	position := Position(f.log, f.seq)
+	f.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.primary+Path+"?position="+url.QueryEscape(position), nil)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range f.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRequest, err)
+	}
+
+	defer func() {
+		if errBodyClose := res.Body.Close(); errBodyClose != nil {
+			log.Println(errBodyClose)
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%w: unexpected status %d: %s", ErrRequest, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	f.setOnline(true)
+	defer f.setOnline(false)
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+
+	header := Header{}
+	if !nextLine(scanner) {
+		return streamError(scanner)
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidStream, err)
+	}
+
+	// Position of standby is changed only after the whole snapshot is applied.
+	pending := 0
+	if header.Snapshot {
+		if err := f.drop(header.Tenants); err != nil {
+			return err
+		}
+
+		pending = len(header.Tenants)
+	}
+
+	if pending == 0 {
+		f.setPosition(header.Log, header.Seq)
+	}
+
+	for nextLine(scanner) {
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidStream, err)
+		}
+
+		if err := f.apply(e); err != nil {
+			// Storage of standby is unknown now, so it is restored from snapshot.
+			f.setPosition("", 0)
+			return err
+		}
+
+		if pending > 0 {
+			pending--
+			if pending > 0 {
+				continue
+			}
+		}
+
+		f.setPosition(header.Log, e.Seq)
+	}
+
+	return streamError(scanner)
+}
+
+func (f *Follower) setPosition(id string, seq uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.log, f.seq = id, seq
+}
+
+func (f *Follower) setOnline(online bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.online = online
+}
+
+// nextLine skips empty lines of heartbeats.
+func nextLine(scanner *bufio.Scanner) bool {
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// streamError gives error of ended stream.
+func streamError(scanner *bufio.Scanner) error {
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrRequest, err)
+	}
+
+	return fmt.Errorf("%w: stream is closed by primary", ErrRequest)
+}
@@ -0,0 +1,198 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func gauge(id string, value float64) *metric.Metric {
+	return &metric.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+func metrics(batch ...*metric.Metric) func() ([]*metric.Metric, error) {
+	return func() ([]*metric.Metric, error) {
+		return batch, nil
+	}
+}
+
+func Test_Log(t *testing.T) {
+	l := NewLog(2)
+
+	assert.NoError(t, (*Log)(nil).Append("", nil, metrics(gauge("a", 1))))
+	assert.NoError(t, l.Append("", nil, metrics()))
+	assert.Equal(t, uint64(0), l.Status().Seq)
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, l.Append("team", nil, metrics(gauge("a", float64(i)))))
+	}
+	assert.NoError(t, l.Append("team", []string{"a"}, nil))
+
+	entries, _, ok := l.since(2)
+	assert.True(t, ok)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, uint64(3), entries[0].Seq)
+		assert.Equal(t, 3.0, *entries[0].Metrics[0].Value)
+		assert.Equal(t, []string{"a"}, entries[1].Deleted)
+	}
+
+	// Older changes are not kept anymore, the next ones are not made yet.
+	_, _, ok = l.since(1)
+	assert.False(t, ok)
+	_, _, ok = l.since(5)
+	assert.False(t, ok)
+
+	// Change which can't be read is lost, so standbys have to get snapshot.
+	assert.Error(t, l.Append("team", nil, func() ([]*metric.Metric, error) {
+		return nil, errors.New("storage is closed")
+	}))
+	_, _, ok = l.since(4)
+	assert.False(t, ok)
+}
+
+func Test_LogClose(t *testing.T) {
+	l := NewLog(0)
+	snapshot := func() ([]Entry, error) { return nil, nil }
+
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Serve(context.Background(), io.Discard, func() {}, "", snapshot)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return l.Status().Standbys == 1
+	}, time.Second, 5*time.Millisecond)
+
+	l.Close()
+	l.Close()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("stream isn't ended")
+	}
+
+	// Streams requested after close end at once, changes are still logged.
+	assert.NoError(t, l.Serve(context.Background(), io.Discard, func() {}, "", snapshot))
+	assert.NoError(t, l.Append("", nil, metrics(gauge("a", 1))))
+	assert.Equal(t, uint64(1), l.Status().Seq)
+
+	(*Log)(nil).Close()
+}
+
+func Test_Position(t *testing.T) {
+	id, seq := parsePosition(Position("3fa1", 42))
+	assert.Equal(t, "3fa1", id)
+	assert.Equal(t, uint64(42), seq)
+
+	id, seq = parsePosition("invalid")
+	assert.Equal(t, "", id)
+	assert.Equal(t, uint64(0), seq)
+}
+
+// replica is in-memory storage of standby.
+type replica struct {
+	values  map[string]map[string]float64
+	dropped [][]string
+	mu      sync.Mutex
+}
+
+func (r *replica) apply(e Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e.Snapshot || r.values[e.Tenant] == nil {
+		r.values[e.Tenant] = map[string]float64{}
+	}
+	for _, m := range e.Metrics {
+		r.values[e.Tenant][m.ID] = *m.Value
+	}
+	for _, id := range e.Deleted {
+		delete(r.values[e.Tenant], id)
+	}
+
+	return nil
+}
+
+func (r *replica) drop(tenants []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dropped = append(r.dropped, tenants)
+
+	return nil
+}
+
+func (r *replica) value(tenant, id string) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.values[tenant][id]
+
+	return v, ok
+}
+
+func Test_Follower(t *testing.T) {
+	l := NewLog(0)
+	assert.NoError(t, l.Append("team", nil, metrics(gauge("old", 1))))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, Path, r.URL.Path)
+		assert.Equal(t, "Bearer admin", r.Header.Get("Authorization"))
+
+		flusher := w.(http.Flusher)
+		err := l.Serve(r.Context(), w, flusher.Flush, r.URL.Query().Get("position"), func() ([]Entry, error) {
+			return []Entry{{Tenant: "team", Metrics: []*metric.Metric{gauge("old", 1)}}}, nil
+		})
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	rp := &replica{values: map[string]map[string]float64{"stale": {"x": 1}}}
+	f := NewFollower(ts.URL+"/", map[string]string{"Authorization": "Bearer admin"}, rp.apply, rp.drop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+
+	// Standby without position gets snapshot first.
+	assert.Eventually(t, func() bool {
+		s := f.Status()
+		return s.Connected && s.Log == l.Status().Log && s.Seq == 1
+	}, time.Second, 5*time.Millisecond)
+
+	v, ok := rp.value("team", "old")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, v)
+	assert.Equal(t, [][]string{{"team"}}, rp.dropped)
+	assert.Equal(t, int64(1), l.Status().Standbys)
+
+	assert.NoError(t, l.Append("team", nil, metrics(gauge("new", 2))))
+	assert.NoError(t, l.Append("team", []string{"old"}, nil))
+
+	assert.Eventually(t, func() bool {
+		return f.Status().Seq == 3
+	}, time.Second, 5*time.Millisecond)
+
+	v, ok = rp.value("team", "new")
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, v)
+	_, ok = rp.value("team", "old")
+	assert.False(t, ok)
+
+	cancel()
+	<-done
+	assert.False(t, f.Status().Connected)
+}
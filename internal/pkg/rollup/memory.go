@@ -0,0 +1,154 @@
+package rollup
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type seriesKey struct {
+	tenant string
+	id     string
+}
+
+// Memory is Storage keeping points in memory. Is concurrent-safe.
+type Memory struct {
+	tiers map[time.Duration]map[seriesKey][]Point
+	mu    sync.RWMutex
+}
+
+// Constructor.
+func NewMemory() *Memory {
+	return &Memory{
+		tiers: map[time.Duration]map[seriesKey][]Point{},
+	}
+}
+
+// SavePoints saves points of tier, existing ones are replaced.
+func (m *Memory) SavePoints(resolution time.Duration, points []Point) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tier, ok := m.tiers[resolution]
+	if !ok {
+		tier = map[seriesKey][]Point{}
+		m.tiers[resolution] = tier
+	}
+
+	for _, p := range points {
+		k := seriesKey{tenant: p.Tenant, id: p.ID}
+		series := tier[k]
+
+		i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(p.Time) })
+		switch {
+		case i < len(series) && series[i].Time.Equal(p.Time):
+			series[i] = p
+		case i == len(series):
+			series = append(series, p)
+		default:
+			series = append(series, Point{})
+			copy(series[i+1:], series[i:])
+			series[i] = p
+		}
+
+		tier[k] = series
+	}
+
+	return nil
+}
+
+// GetPoints gives points of metric of tenant in range [from, to) sorted by time.
+func (m *Memory) GetPoints(resolution time.Duration, tenant, id string, from, to time.Time) ([]Point, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return between(m.tiers[resolution][seriesKey{tenant: tenant, id: id}], from, to), nil
+}
+
+// RangePoints gives points of all metrics in range [from, to) sorted by tenant, name and time.
+func (m *Memory) RangePoints(resolution time.Duration, from, to time.Time) ([]Point, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	points := []Point{}
+	for _, series := range m.tiers[resolution] {
+		points = append(points, between(series, from, to)...)
+	}
+	Sort(points)
+
+	return points, nil
+}
+
+// LatestPoint gives time of the latest point of tier. Zero time means there are no points.
+func (m *Memory) LatestPoint(resolution time.Duration) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	latest := time.Time{}
+	for _, series := range m.tiers[resolution] {
+		if n := len(series); n > 0 && series[n-1].Time.After(latest) {
+			latest = series[n-1].Time
+		}
+	}
+
+	return latest, nil
+}
+
+// DeletePoints removes points of tier older than given time.
+func (m *Memory) DeletePoints(resolution time.Duration, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, series := range m.tiers[resolution] {
+		i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(before) })
+		if i == len(series) {
+			delete(m.tiers[resolution], k)
+			continue
+		}
+
+		m.tiers[resolution][k] = append([]Point{}, series[i:]...)
+	}
+
+	return nil
+}
+
+// Len gives number of kept points of all tiers.
+func (m *Memory) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for _, tier := range m.tiers {
+		for _, series := range tier {
+			n += len(series)
+		}
+	}
+
+	return n
+}
+
+// Each calls f for every kept point.
+func (m *Memory) Each(f func(resolution time.Duration, p Point)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for resolution, tier := range m.tiers {
+		for _, series := range tier {
+			for _, p := range series {
+				f(resolution, p)
+			}
+		}
+	}
+}
+
+// between gives copy of points of series in range [from, to).
+func between(series []Point, from, to time.Time) []Point {
+	i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(from) })
+	j := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(to) })
+
+	if i >= j {
+		return []Point{}
+	}
+
+	return append([]Point{}, series[i:j]...)
+}
@@ -0,0 +1,304 @@
+// Package rollup downsamples history of metrics into tiers for long-term retention.
+//
+// The first tier keeps raw samples taken at its resolution, every next tier keeps aggregates of the previous
+// one over its coarser resolution, e.g. "10s:1d,1m:30d,1h:1y". Every point keeps min, max, sum, count and last
+// value: for gauges they are aggregates of sampled values, for counters min, max and sum are aggregates of
+// increments between samples, and last is the accumulated value.
+package rollup
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTiers = errors.New("invalid rollup tiers")
+
+// Tier keeps points of given resolution during retention.
+type Tier struct {
+	Resolution time.Duration `json:"resolution"`
+	Retention  time.Duration `json:"retention"`
+}
+
+// String gives tier in format "<resolution>:<retention>".
+func (t Tier) String() string {
+	return formatDuration(t.Resolution) + ":" + formatDuration(t.Retention)
+}
+
+// ParseTiers parses tiers in format "<resolution>:<retention>,...", durations could have units "d" and "y"
+// additionally to ones of time.ParseDuration. Resolution of every next tier must be a multiple of the previous one.
+func ParseTiers(s string) ([]Tier, error) {
+	tiers := []Tier{}
+
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: %q is not in format <resolution>:<retention>", ErrInvalidTiers, part)
+		}
+
+		resolution, err := parseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTiers, err)
+		}
+
+		retention, err := parseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTiers, err)
+		}
+
+		t := Tier{Resolution: resolution, Retention: retention}
+
+		if resolution <= 0 || retention < resolution {
+			return nil, fmt.Errorf("%w: %s: retention must be not shorter than positive resolution", ErrInvalidTiers, t)
+		}
+
+		if n := len(tiers); n > 0 {
+			prev := tiers[n-1]
+			if resolution <= prev.Resolution || resolution%prev.Resolution != 0 {
+				return nil, fmt.Errorf("%w: %s: resolution must be a multiple of the previous one", ErrInvalidTiers, t)
+			}
+			if retention <= prev.Retention {
+				return nil, fmt.Errorf("%w: %s: retention must be longer than the previous one", ErrInvalidTiers, t)
+			}
+		}
+
+		tiers = append(tiers, t)
+	}
+
+	return tiers, nil
+}
+
+// Long units supported by parseDuration.
+var longUnits = []struct {
+	suffix   string
+	duration time.Duration
+}{
+	{suffix: "y", duration: 365 * 24 * time.Hour},
+	{suffix: "d", duration: 24 * time.Hour},
+}
+
+// parseDuration parses duration like time.ParseDuration, or integer number of days or years, e.g. "30d" or "1y".
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	for _, u := range longUnits {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, u.suffix)); err == nil && strings.HasSuffix(s, u.suffix) {
+			return time.Duration(n) * u.duration, nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
+
+// formatDuration gives duration in the longest unit it is a multiple of.
+func formatDuration(d time.Duration) string {
+	for _, u := range longUnits {
+		if d > 0 && d%u.duration == 0 {
+			return strconv.FormatInt(int64(d/u.duration), 10) + u.suffix
+		}
+	}
+
+	return d.String()
+}
+
+// Point is aggregate of metric over period of tier resolution starting at Time.
+type Point struct {
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+	ID     string    `json:"id"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Sum    float64   `json:"sum"`
+	Last   float64   `json:"last"`
+	Count  int64     `json:"count"`
+}
+
+// Avg gives average of aggregated values.
+func (p Point) Avg() float64 {
+	if p.Count == 0 {
+		return 0
+	}
+
+	return p.Sum / float64(p.Count)
+}
+
+// merge adds later point to aggregate.
+func (p *Point) merge(o Point) {
+	p.Min = math.Min(p.Min, o.Min)
+	p.Max = math.Max(p.Max, o.Max)
+	p.Sum += o.Sum
+	p.Count += o.Count
+	p.Last = o.Last
+}
+
+// Aggregate merges points into periods of given resolution. Points of every series must be sorted by time.
+// Gives points sorted by tenant, name and time.
+func Aggregate(points []Point, resolution time.Duration) []Point {
+	type key struct {
+		time   time.Time
+		tenant string
+		id     string
+	}
+
+	index := map[key]int{}
+	res := []Point{}
+
+	for _, p := range points {
+		k := key{tenant: p.Tenant, id: p.ID, time: p.Time.Truncate(resolution)}
+
+		i, ok := index[k]
+		if !ok {
+			p.Time = k.time
+			index[k] = len(res)
+			res = append(res, p)
+			continue
+		}
+
+		res[i].merge(p)
+	}
+
+	Sort(res)
+
+	return res
+}
+
+// Sort sorts points by tenant, name and time.
+func Sort(points []Point) {
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].Tenant != points[j].Tenant {
+			return points[i].Tenant < points[j].Tenant
+		}
+		if points[i].ID != points[j].ID {
+			return points[i].ID < points[j].ID
+		}
+		return points[i].Time.Before(points[j].Time)
+	})
+}
+
+// Choose gives tier to answer query since given time with given step: the coarsest tier with resolution
+// not exceeding step among tiers keeping data since then. If there are no such tiers, the finest one keeping data
+// is chosen, and if no tier keeps data since then, the coarsest one.
+func Choose(tiers []Tier, since, now time.Time, step time.Duration) Tier {
+	chosen := -1
+
+	for i, t := range tiers {
+		if now.Sub(since) > t.Retention {
+			continue
+		}
+
+		if chosen < 0 || t.Resolution <= step {
+			chosen = i
+		}
+	}
+
+	if chosen < 0 {
+		return tiers[len(tiers)-1]
+	}
+
+	return tiers[chosen]
+}
+
+// Storage keeps points of tiers. Points are identified by resolution, tenant, name and time.
+type Storage interface {
+	// SavePoints saves points of tier, existing ones are replaced.
+	SavePoints(resolution time.Duration, points []Point) error
+
+	// GetPoints gives points of metric of tenant in range [from, to) sorted by time.
+	GetPoints(resolution time.Duration, tenant, id string, from, to time.Time) ([]Point, error)
+
+	// RangePoints gives points of all metrics in range [from, to) sorted by tenant, name and time.
+	RangePoints(resolution time.Duration, from, to time.Time) ([]Point, error)
+
+	// LatestPoint gives time of the latest point of tier. Zero time means there are no points.
+	LatestPoint(resolution time.Duration) (time.Time, error)
+
+	// DeletePoints removes points of tier older than given time.
+	DeletePoints(resolution time.Duration, before time.Time) error
+}
+
+// Compact aggregates complete periods of every tier from points of the previous one.
+func Compact(st Storage, tiers []Tier, now time.Time) error {
+	for i := 1; i < len(tiers); i++ {
+		resolution := tiers[i].Resolution
+		to := now.Truncate(resolution)
+
+		latest, err := st.LatestPoint(resolution)
+		if err != nil {
+			return err
+		}
+
+		from := latest.Add(resolution)
+		if latest.IsZero() {
+			from = to.Add(-tiers[i-1].Retention).Truncate(resolution)
+		}
+
+		if !from.Before(to) {
+			continue
+		}
+
+		points, err := st.RangePoints(tiers[i-1].Resolution, from, to)
+		if err != nil {
+			return err
+		}
+
+		if len(points) == 0 {
+			continue
+		}
+
+		if err := st.SavePoints(resolution, Aggregate(points, resolution)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prune removes points kept longer than retention of their tiers.
+func Prune(st Storage, tiers []Tier, now time.Time) error {
+	for _, t := range tiers {
+		if err := st.DeletePoints(t.Resolution, now.Add(-t.Retention)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sampler makes raw points of samples. It remembers the latest accumulated values of counters to get their
+// increments. Isn't concurrent-safe.
+type Sampler struct {
+	totals map[[2]string]float64
+}
+
+// Constructor.
+func NewSampler() *Sampler {
+	return &Sampler{
+		totals: map[[2]string]float64{},
+	}
+}
+
+// Gauge gives point of sampled gauge value.
+func (s *Sampler) Gauge(tenant, id string, t time.Time, value float64) Point {
+	return Point{Time: t, Tenant: tenant, ID: id, Min: value, Max: value, Sum: value, Last: value, Count: 1}
+}
+
+// Counter gives point of increment of counter since the previous sample. The first sample of counter has
+// zero increment. Decrease of accumulated value, e.g. after deletion, is treated as increment from zero.
+func (s *Sampler) Counter(tenant, id string, t time.Time, total float64) Point {
+	k := [2]string{tenant, id}
+
+	increment := 0.0
+	if prev, ok := s.totals[k]; ok {
+		increment = total - prev
+		if increment < 0 {
+			increment = total
+		}
+	}
+	s.totals[k] = total
+
+	return Point{Time: t, Tenant: tenant, ID: id, Min: increment, Max: increment, Sum: increment, Last: total, Count: 1}
+}
@@ -0,0 +1,166 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseTiers(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Tiers         string
+		Expected      []Tier
+		ExpectedError bool
+	}{
+		{
+			Name:  "default-like tiers",
+			Tiers: "10s:1d, 1m:30d, 1h:1y",
+			Expected: []Tier{
+				{Resolution: 10 * time.Second, Retention: 24 * time.Hour},
+				{Resolution: time.Minute, Retention: 30 * 24 * time.Hour},
+				{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+			},
+		},
+		{
+			Name:     "single tier",
+			Tiers:    "1s:90m",
+			Expected: []Tier{{Resolution: time.Second, Retention: 90 * time.Minute}},
+		},
+		{Name: "missing retention", Tiers: "10s", ExpectedError: true},
+		{Name: "invalid duration", Tiers: "10s:forever", ExpectedError: true},
+		{Name: "retention shorter than resolution", Tiers: "1h:1m", ExpectedError: true},
+		{Name: "resolution is not a multiple", Tiers: "10s:1d,15s:2d", ExpectedError: true},
+		{Name: "retention is not longer", Tiers: "10s:1d,1m:1d", ExpectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			tiers, err := ParseTiers(tt.Tiers)
+			if tt.ExpectedError {
+				assert.ErrorIs(t, err, ErrInvalidTiers)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Expected, tiers)
+		})
+	}
+
+	assert.Equal(t, "1m0s:30d", Tier{Resolution: time.Minute, Retention: 30 * 24 * time.Hour}.String())
+}
+
+func Test_Aggregate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSampler()
+
+	points := []Point{}
+	for i, v := range []float64{4, 2, 9, 5, 7} {
+		points = append(points, s.Gauge("", "Alloc", start.Add(time.Duration(i)*20*time.Second), v))
+	}
+	for i, v := range []float64{10, 15, 15, 30, 2} {
+		points = append(points, s.Counter("team", "PollCount", start.Add(time.Duration(i)*20*time.Second), v))
+	}
+
+	agg := Aggregate(points, time.Minute)
+	assert.Equal(t, []Point{
+		{Time: start, ID: "Alloc", Min: 2, Max: 9, Sum: 15, Last: 9, Count: 3},
+		{Time: start.Add(time.Minute), ID: "Alloc", Min: 5, Max: 7, Sum: 12, Last: 7, Count: 2},
+		{Time: start, Tenant: "team", ID: "PollCount", Min: 0, Max: 5, Sum: 5, Last: 15, Count: 3},
+		{Time: start.Add(time.Minute), Tenant: "team", ID: "PollCount", Min: 2, Max: 15, Sum: 17, Last: 2, Count: 2},
+	}, agg)
+	assert.Equal(t, 5.0, agg[0].Avg())
+	assert.Zero(t, Point{}.Avg())
+}
+
+func Test_Choose(t *testing.T) {
+	tiers, err := ParseTiers("10s:1d,1m:30d,1h:1y")
+	assert.NoError(t, err)
+
+	now := time.Now()
+
+	tests := []struct {
+		Name     string
+		Since    time.Duration
+		Step     time.Duration
+		Expected time.Duration
+	}{
+		{Name: "recent data by raw step", Since: time.Hour, Step: 10 * time.Second, Expected: 10 * time.Second},
+		{Name: "recent data by coarse step", Since: time.Hour, Step: 5 * time.Minute, Expected: time.Minute},
+		{Name: "step finer than raw tier", Since: time.Hour, Step: time.Second, Expected: 10 * time.Second},
+		{Name: "old data", Since: 7 * 24 * time.Hour, Step: 10 * time.Second, Expected: time.Minute},
+		{Name: "very old data", Since: 90 * 24 * time.Hour, Step: 10 * time.Hour, Expected: time.Hour},
+		{Name: "expired data", Since: 2 * 365 * 24 * time.Hour, Step: time.Second, Expected: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.Expected, Choose(tiers, now.Add(-tt.Since), now, tt.Step).Resolution)
+		})
+	}
+}
+
+func Test_CompactAndPrune(t *testing.T) {
+	tiers, err := ParseTiers("10s:10m,1m:1h,5m:1d")
+	assert.NoError(t, err)
+
+	st := NewMemory()
+	s := NewSampler()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 60; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		assert.NoError(t, st.SavePoints(10*time.Second, []Point{s.Gauge("", "Alloc", now, float64(i))}))
+		assert.NoError(t, Compact(st, tiers, now))
+	}
+
+	minutes, err := st.GetPoints(time.Minute, "", "Alloc", start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, minutes, 9)
+	assert.Equal(t, Point{Time: start, ID: "Alloc", Min: 0, Max: 5, Sum: 15, Last: 5, Count: 6}, minutes[0])
+
+	fives, err := st.GetPoints(5*time.Minute, "", "Alloc", start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, fives, 1)
+	assert.Equal(t, Point{Time: start, ID: "Alloc", Min: 0, Max: 29, Sum: 435, Last: 29, Count: 30}, fives[0])
+
+	// Compaction of the same periods doesn't duplicate points.
+	assert.NoError(t, Compact(st, tiers, start.Add(10*time.Minute)))
+	minutes, err = st.GetPoints(time.Minute, "", "Alloc", start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, minutes, 10)
+	assert.Equal(t, 60+10+2, st.Len())
+
+	assert.NoError(t, Prune(st, tiers, start.Add(15*time.Minute)))
+	raw, err := st.GetPoints(10*time.Second, "", "Alloc", start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, raw, 30)
+	assert.Equal(t, start.Add(5*time.Minute), raw[0].Time)
+}
+
+func Test_MemoryOrder(t *testing.T) {
+	st := NewMemory()
+	start := time.Now()
+	s := NewSampler()
+
+	assert.NoError(t, st.SavePoints(time.Second, []Point{
+		s.Gauge("", "Alloc", start.Add(2*time.Second), 2),
+		s.Gauge("", "Alloc", start, 0),
+		s.Gauge("", "Alloc", start.Add(time.Second), 1),
+		s.Gauge("", "Alloc", start.Add(time.Second), 10),
+	}))
+
+	points, err := st.RangePoints(time.Second, start, start.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, points, 3)
+	assert.Equal(t, []float64{0, 10, 2}, []float64{points[0].Last, points[1].Last, points[2].Last})
+
+	latest, err := st.LatestPoint(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(2*time.Second), latest)
+
+	latest, err = st.LatestPoint(time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, latest.IsZero())
+}
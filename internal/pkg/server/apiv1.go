@@ -0,0 +1,506 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/go-chi/chi"
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var (
+	errInvalidJSON  = errors.New("invalid json")
+	errValueMissing = errors.New("metric value is missing")
+	errTypeMismatch = errors.New("metric has another type")
+)
+
+// Statuses of individual metrics of batch update.
+const (
+	ItemStatusOK      = "ok"
+	ItemStatusDropped = "dropped"
+	ItemStatusError   = "error"
+)
+
+// apiError is the body of every error response of API v1.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	ID      string `json:"id,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// itemResult is the outcome of individual metric of batch update.
+type itemResult struct {
+	Error  *apiError `json:"error,omitempty"`
+	ID     string    `json:"id"`
+	Status string    `json:"status"`
+	Index  int       `json:"index"`
+}
+
+// batchResult is the body of batch update response.
+type batchResult struct {
+	Results  []itemResult `json:"results"`
+	Accepted int          `json:"accepted"`
+	Dropped  int          `json:"dropped"`
+	Rejected int          `json:"rejected"`
+}
+
+// routeAPIv1 defines versioned JSON API. Every error of it is given in the same JSON envelope.
+func (srv *server) routeAPIv1(r chi.Router) {
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(jsonErrors)
+		r.With(srv.authenticator.Authorize(auth.ScopeRead), srv.validateBody, srv.routeToOwner).Post("/value", srv.handlerAPIGetMetricJSON)
+		r.With(srv.authenticator.Authorize(auth.ScopeRead), srv.routeToOwner).Get("/value/{type}/{name}", srv.handlerAPIGetMetric)
+		r.With(srv.authenticator.Authorize(auth.ScopeAdmin), srv.verifier.Middleware, srv.routeToOwner).Delete("/value/{type}/{name}", srv.handlerAPIDeleteMetric)
+		r.With(srv.authenticator.Authorize(auth.ScopeWrite), srv.verifier.Middleware, srv.validateBody, srv.routeToOwner).Post("/update", srv.handlerAPIUpdate)
+		r.With(srv.authenticator.Authorize(auth.ScopeWrite), srv.verifier.Middleware, srv.validateBody).Post("/updates", srv.handlerAPIUpdateBatch)
+		r.With(srv.authenticator.Authorize(auth.ScopeWrite), srv.verifier.Middleware).Post("/write", srv.handlerRemoteWrite)
+	})
+}
+
+// Updates individual metric kept in request body and outputs its stored state.
+func (srv *server) handlerAPIUpdate(w http.ResponseWriter, r *http.Request) {
+	m := &metric.Metric{}
+	if err := decodeJSON(r, m); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	hashVersion, err := srv.hashVersion(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if err := srv.validateMetric(m, hashVersion); err != nil {
+		writeAPIError(w, err, m)
+		return
+	}
+
+	errs := []error{nil}
+	if err := srv.storeEach(r, []*metric.Metric{m}, errs); err != nil {
+		writeAPIError(w, err, m)
+		return
+	}
+
+	if errs[0] != nil {
+		writeAPIError(w, errs[0], m)
+		return
+	}
+
+	srv.writeMetric(w, r, m.MType, m.ID, hashVersion)
+}
+
+// Updates batch of metrics kept in request body. Valid metrics are stored even if others are rejected,
+// response contains result of every metric.
+func (srv *server) handlerAPIUpdateBatch(w http.ResponseWriter, r *http.Request) {
+	items := []json.RawMessage{}
+	if err := decodeJSON(r, &items); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if err := srv.limits.checkBatchLength(len(items)); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	hashVersion, err := srv.hashVersion(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	path := specPath(chi.RouteContext(r.Context()).RoutePattern())
+
+	batch := make([]*metric.Metric, len(items))
+	errs := make([]error, len(items))
+	for i := range items {
+		// Null items are rejected by validateMetric as metrics of invalid format.
+		if !bytes.Equal(items[i], []byte("null")) {
+			if errs[i] = srv.spec.ValidateItem(r.Method, path, i, items[i]); errs[i] != nil {
+				continue
+			}
+		}
+
+		if err := json.Unmarshal(items[i], &batch[i]); err != nil {
+			errs[i] = fmt.Errorf("%w: %v", errInvalidJSON, err)
+			continue
+		}
+
+		errs[i] = srv.validateMetric(batch[i], hashVersion)
+	}
+
+	if err := srv.storeEach(r, batch, errs); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	res := batchResult{
+		Results: make([]itemResult, len(batch)),
+	}
+
+	for i := range batch {
+		item := itemResult{
+			Index:  i,
+			Status: ItemStatusOK,
+		}
+		if batch[i] != nil {
+			item.ID = batch[i].ID
+		}
+
+		switch {
+		case errs[i] == nil:
+			res.Accepted++
+		case errors.Is(errs[i], errMetricDropped):
+			item.Status = ItemStatusDropped
+			res.Dropped++
+		default:
+			item.Status = ItemStatusError
+			item.Error = &apiError{
+				Code:    errorCode(errs[i]),
+				Message: errs[i].Error(),
+			}
+			res.Rejected++
+		}
+
+		res.Results[i] = item
+	}
+
+	status := http.StatusOK
+	switch {
+	case res.Rejected == 0:
+	case res.Accepted+res.Dropped == 0:
+		status = http.StatusUnprocessableEntity
+	default:
+		status = http.StatusMultiStatus
+	}
+
+	writeJSON(w, status, res)
+}
+
+// Outputs individual metric requested by id and type in request body.
+func (srv *server) handlerAPIGetMetricJSON(w http.ResponseWriter, r *http.Request) {
+	mReq := metric.Metric{}
+	if err := decodeJSON(r, &mReq); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	hashVersion, err := srv.hashVersion(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	srv.writeMetric(w, r, mReq.MType, mReq.ID, hashVersion)
+}
+
+// Outputs individual metric requested in URL in format "/type/name".
+func (srv *server) handlerAPIGetMetric(w http.ResponseWriter, r *http.Request) {
+	hashVersion, err := srv.hashVersion(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	srv.writeMetric(w, r, chi.URLParam(r, "type"), chi.URLParam(r, "name"), hashVersion)
+}
+
+// Deletes metric defined in URL in format "/type/name".
+func (srv *server) handlerAPIDeleteMetric(w http.ResponseWriter, r *http.Request) {
+	mType, mName := chi.URLParam(r, "type"), chi.URLParam(r, "name")
+
+	st, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if err := srv.checkWritable(); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if _, err := lookupMetric(st, mType, mName); err != nil {
+		writeAPIError(w, err, &metric.Metric{ID: mName})
+		return
+	}
+
+	if err := st.DeleteMetric(mName); err != nil {
+		writeAPIError(w, err, &metric.Metric{ID: mName})
+		return
+	}
+
+	srv.forgetMetric(tenant, mName)
+	srv.replicateDeletion(tenant, mName)
+
+	if srv.config.StoreInterval == 0 {
+		srv.fileUpload()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeMetric outputs stored metric of request tenant in json-format, hashed by requested hash version.
+func (srv *server) writeMetric(w http.ResponseWriter, r *http.Request, mType, id string, hashVersion int) {
+	st, _, err := srv.tenantStorage(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	stored, err := lookupMetric(st, mType, id)
+	if err != nil {
+		writeAPIError(w, err, &metric.Metric{ID: id})
+		return
+	}
+
+	m := *stored
+	m.Hash = ""
+
+	if srv.config.HashKey != "" {
+		if err := m.UpdateHashVersion(srv.config.HashKey, hashVersion); err != nil {
+			writeAPIError(w, err, &m)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, &m)
+}
+
+// lookupMetric gives stored metric if it has requested type.
+func lookupMetric(st metric.MetricStorage, mType, id string) (*metric.Metric, error) {
+	if err := checkTypeSupport(mType); err != nil {
+		return nil, err
+	}
+
+	m, err := st.GetMetric(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.MType != mType {
+		return nil, fmt.Errorf("%w: metric <%s> is not <%s>", errTypeMismatch, id, mType)
+	}
+
+	return m, nil
+}
+
+// validateMetric checks type, value and, if server has hash key, hash of metric sent to API v1.
+func (srv *server) validateMetric(m *metric.Metric, hashVersion int) error {
+	if err := checkMetricValue(m); err != nil {
+		return err
+	}
+
+	if srv.config.HashKey != "" {
+		if _, err := srv.checkHash(m, hashVersion); err != nil {
+			return err
+		}
+	}
+
+	m.Hash = ""
+
+	return nil
+}
+
+// checkMetricValue checks type and value of metric.
+func checkMetricValue(m *metric.Metric) error {
+	if m == nil {
+		return metric.ErrCannotUpdateInvalidFormat
+	}
+
+	if err := checkTypeSupport(m.MType); err != nil {
+		return err
+	}
+
+	if (m.MType == Gauge && m.Value == nil) || (m.MType == Counter && m.Delta == nil) {
+		return errValueMissing
+	}
+
+	return nil
+}
+
+// storeEach stores metrics of batch which have no errors yet and fills errors of rejected ones.
+// Returns error only if whole request fails, e.g. because of tenant or storage errors.
+func (srv *server) storeEach(r *http.Request, batch []*metric.Metric, errs []error) error {
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		return err
+	}
+
+	return srv.storeTenantEach(r.Context(), tenant, batch, errs)
+}
+
+// storeTenantEach is storeEach for tenant given without request. Access of context token is checked if it has one.
+func (srv *server) storeTenantEach(ctx context.Context, tenant string, batch []*metric.Metric, errs []error) error {
+	if err := srv.checkWritable(); err != nil {
+		return err
+	}
+
+	candidates := make([]*metric.Metric, 0, len(batch))
+	indexes := make([]int, 0, len(batch))
+
+	for i := range batch {
+		if errs[i] != nil {
+			continue
+		}
+
+		if err := auth.CheckWrite(ctx, batch[i].ID); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if err := srv.limits.checkName(batch[i].ID); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		candidates = append(candidates, batch[i])
+		indexes = append(indexes, i)
+	}
+
+	candidates, indexes = srv.storeForeignEach(ctx, tenant, candidates, indexes, errs)
+
+	admitErrs, reserved := srv.limits.reserve(tenant, srv.tenantQuota(tenant), candidates)
+
+	admitted := make([]*metric.Metric, 0, len(candidates))
+	for i := range candidates {
+		if admitErrs[i] != nil {
+			errs[indexes[i]] = admitErrs[i]
+			continue
+		}
+
+		admitted = append(admitted, candidates[i])
+	}
+
+	return srv.storeAdmitted(ctx, tenant, admitted, reserved)
+}
+
+// decodeJSON reads request body to v. Decoding errors are wrapped by errInvalidJSON.
+func decodeJSON(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidJSON, err)
+	}
+
+	return nil
+}
+
+// Gives error code of response status, which is written by handlers outside of API v1, e.g. by middlewares.
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusRequestEntityTooLarge:
+		return "body_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	default:
+		return "internal"
+	}
+}
+
+// writeAPIError logs error and outputs it in JSON envelope. Metric m, if defined, identifies failed metric.
+func writeAPIError(w http.ResponseWriter, err error, m *metric.Metric) {
+	log.Println(err)
+
+	res := apiErrorResponse{
+		Error: apiError{
+			Code:    errorCode(err),
+			Message: err.Error(),
+		},
+	}
+	if m != nil {
+		res.Error.ID = m.ID
+	}
+
+	writeJSON(w, errorStatus(err), res)
+}
+
+// writeJSON outputs v in json-format with given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", JSONCT)
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		log.Println(err)
+	}
+}
+
+// jsonErrorWriter catches plain text errors, written e.g. by http.Error, to rewrite them in JSON envelope.
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *jsonErrorWriter) WriteHeader(status int) {
+	if status >= http.StatusBadRequest && !strings.HasPrefix(w.Header().Get("Content-Type"), JSONCT) {
+		w.status = status
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *jsonErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware component which gives every error of wrapped handlers in JSON envelope.
+func jsonErrors(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &jsonErrorWriter{ResponseWriter: w}
+
+		handler.ServeHTTP(ew, r)
+
+		if ew.status == 0 {
+			return
+		}
+
+		w.Header().Del("X-Content-Type-Options")
+
+		writeJSON(w, ew.status, apiErrorResponse{
+			Error: apiError{
+				Code:    statusCode(ew.status),
+				Message: strings.TrimSpace(ew.body.String()),
+			},
+		})
+	})
+}
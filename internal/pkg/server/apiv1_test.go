@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_apiUpdateBatch(t *testing.T) {
+	tests := []struct {
+		Name             string
+		Body             string
+		ExpectedStatuses []string
+		ExpectedCodes    []string
+		ExpectedStatus   int
+		ExpectedAccepted int
+	}{
+		{
+			Name:             "all metrics are valid",
+			Body:             `[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":2}]`,
+			ExpectedStatus:   http.StatusOK,
+			ExpectedAccepted: 2,
+			ExpectedStatuses: []string{ItemStatusOK, ItemStatusOK},
+			ExpectedCodes:    []string{"", ""},
+		},
+		{
+			Name: "partial success",
+			Body: `[{"id":"Alloc","type":"gauge","value":1},{"id":"A","type":"hist","value":1},` +
+				`{"id":"PollCount","type":"counter"},{"id":"bad name","type":"gauge","value":1}]`,
+			ExpectedStatus:   http.StatusMultiStatus,
+			ExpectedAccepted: 1,
+			ExpectedStatuses: []string{ItemStatusOK, ItemStatusError, ItemStatusError, ItemStatusError},
+			ExpectedCodes:    []string{"", "unsupported_type", "invalid_format", "invalid_name"},
+		},
+		{
+			Name:             "all metrics are invalid",
+			Body:             `[{"id":"A","type":"hist","value":1},null]`,
+			ExpectedStatus:   http.StatusUnprocessableEntity,
+			ExpectedAccepted: 0,
+			ExpectedStatuses: []string{ItemStatusError, ItemStatusError},
+			ExpectedCodes:    []string{"unsupported_type", "invalid_format"},
+		},
+		{
+			Name:             "item doesn't match schema",
+			Body:             `[{"id":"Alloc","type":"gauge","value":1},{"id":1,"type":"gauge","value":1}]`,
+			ExpectedStatus:   http.StatusMultiStatus,
+			ExpectedAccepted: 1,
+			ExpectedStatuses: []string{ItemStatusOK, ItemStatusError},
+			ExpectedCodes:    []string{"", "invalid_body"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := newTestServer(t, serverConfig{NameCharset: testNameCharset})
+
+			rec := serve(srv, httptest.NewRequest("POST", "/api/v1/updates", strings.NewReader(tt.Body)))
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+			assert.Equal(t, JSONCT, rec.Header().Get("Content-Type"))
+
+			res := batchResult{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tt.ExpectedAccepted, res.Accepted)
+
+			statuses, codes := []string{}, []string{}
+			for i, item := range res.Results {
+				assert.Equal(t, i, item.Index)
+
+				statuses = append(statuses, item.Status)
+				if item.Error != nil {
+					codes = append(codes, item.Error.Code)
+				} else {
+					codes = append(codes, "")
+				}
+			}
+
+			assert.Equal(t, tt.ExpectedStatuses, statuses)
+			assert.Equal(t, tt.ExpectedCodes, codes)
+		})
+	}
+}
+
+func Test_apiUpdateBatchDropNew(t *testing.T) {
+	srv := newTestServer(t, serverConfig{MaxMetrics: 1, OverflowPolicy: OverflowDropNew})
+
+	body := `[{"id":"Alloc","type":"gauge","value":1},{"id":"Sys","type":"gauge","value":1}]`
+	rec := serve(srv, httptest.NewRequest("POST", "/api/v1/updates", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := batchResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.Accepted)
+	assert.Equal(t, 1, res.Dropped)
+	assert.Equal(t, ItemStatusDropped, res.Results[1].Status)
+}
+
+func Test_apiUpdate(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	for i := 0; i < 2; i++ {
+		body := `{"id":"PollCount","type":"counter","delta":5}`
+		rec := serve(srv, httptest.NewRequest("POST", "/t/team/api/v1/update", strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := serve(srv, httptest.NewRequest("GET", "/t/team/api/v1/value/counter/PollCount", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":"PollCount","type":"counter","delta":10}`, rec.Body.String())
+
+	rec = serve(srv, httptest.NewRequest("POST", "/t/team/api/v1/value", strings.NewReader(`{"id":"PollCount","type":"counter"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(srv, httptest.NewRequest("DELETE", "/t/team/api/v1/value/counter/PollCount", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = serve(srv, httptest.NewRequest("GET", "/t/team/value/counter/PollCount", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func Test_apiErrors(t *testing.T) {
+	srv := newTestServer(t, serverConfig{
+		Tokens: []auth.Token{{Token: "reader", Scopes: []string{auth.ScopeRead}}},
+	})
+
+	tests := []struct {
+		Name           string
+		Method         string
+		URL            string
+		Body           string
+		Token          string
+		ExpectedCode   string
+		ExpectedID     string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "invalid json",
+			Method:         "POST",
+			URL:            "/api/v1/value",
+			Body:           "{",
+			Token:          "reader",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_json",
+		},
+		{
+			Name:           "body doesn't match schema",
+			Method:         "POST",
+			URL:            "/api/v1/value",
+			Body:           `{"id":1,"type":"gauge"}`,
+			Token:          "reader",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_body",
+		},
+		{
+			Name:           "metric not found",
+			Method:         "GET",
+			URL:            "/api/v1/value/gauge/Alloc",
+			Token:          "reader",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedCode:   "not_found",
+			ExpectedID:     "Alloc",
+		},
+		{
+			Name:           "missing token",
+			Method:         "GET",
+			URL:            "/api/v1/value/gauge/Alloc",
+			ExpectedStatus: http.StatusUnauthorized,
+			ExpectedCode:   "unauthorized",
+		},
+		{
+			Name:           "scope denied",
+			Method:         "POST",
+			URL:            "/api/v1/update",
+			Body:           `{"id":"Alloc","type":"gauge","value":1}`,
+			Token:          "reader",
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedCode:   "forbidden",
+		},
+		{
+			Name:           "unknown route",
+			Method:         "GET",
+			URL:            "/api/v1/unknown",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedCode:   "not_found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.Method, tt.URL, strings.NewReader(tt.Body))
+			if tt.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.Token)
+			}
+
+			rec := serve(srv, req)
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+			assert.Equal(t, JSONCT, rec.Header().Get("Content-Type"))
+
+			res := apiErrorResponse{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tt.ExpectedCode, res.Error.Code)
+			assert.Equal(t, tt.ExpectedID, res.Error.ID)
+			assert.NotEmpty(t, res.Error.Message)
+		})
+	}
+}
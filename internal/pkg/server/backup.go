@@ -0,0 +1,295 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/dump"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/replication"
+)
+
+var errRestoreMode = errors.New("unknown restore mode")
+
+// Modes of restoring dump.
+const (
+	// Metrics of dump overwrite stored ones with the same names, other stored metrics are kept.
+	RestoreMerge = "merge"
+
+	// Storage gets exactly the content of dump, metrics missing in it are deleted.
+	RestoreReplace = "replace"
+)
+
+// Path of restore endpoint. Dumps are streamed, so body limit isn't applied to it.
+const restorePath = "/admin/restore"
+
+// Number of metrics of dump stored at once.
+const restoreBatchSize = 500
+
+// restoreResult describes restored dump.
+type restoreResult struct {
+	Created time.Time `json:"created"`
+	Mode    string    `json:"mode"`
+	Tenants int       `json:"tenants"`
+	Metrics int       `json:"metrics"`
+	Samples int       `json:"samples"`
+}
+
+// Streams dump of all metrics of every tenant with their history, if it is kept.
+func (srv *server) handlerBackup(w http.ResponseWriter, r *http.Request) {
+	tenants, err := srv.storage.Tenants()
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", dump.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="metrics-%s.dump.gz"`, time.Now().UTC().Format("20060102T150405Z")))
+
+	// Status is sent already, so failed dump is left without trailer and is rejected by restore.
+	if err := srv.writeDump(w, tenants); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeDump writes metrics of tenants with their history to w.
+func (srv *server) writeDump(w io.Writer, tenants []string) error {
+	dw, err := dump.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	for _, tenant := range tenants {
+		batch, err := srv.storage.ForTenant(tenant).GetBatch()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range batch {
+			if err := dw.Write(dump.Record{Tenant: tenant, Metric: m, History: srv.history.Get(tenant, m.ID)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return dw.Close()
+}
+
+// Restores dump given in request body. Query parameter "mode" is "merge" (default) or "replace".
+// Dump is applied only if it is complete and intact and its metrics pass limits of names, distinct metrics
+// and tenant quotas. Counters are set to dumped values.
+func (srv *server) handlerRestore(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "":
+		mode = RestoreMerge
+	case RestoreMerge, RestoreReplace:
+	default:
+		writeAPIError(w, fmt.Errorf("%w: %q", errRestoreMode, mode), nil)
+		return
+	}
+
+	if err := srv.checkWritable(); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	// Dump is kept in temporary file while it is checked, so it is read twice without keeping it in memory.
+	tmp, err := os.CreateTemp("", "restore-*.dump")
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+	defer func() {
+		if errClose := tmp.Close(); errClose != nil {
+			log.Println(errClose)
+		}
+		if errRemove := os.Remove(tmp.Name()); errRemove != nil {
+			log.Println(errRemove)
+		}
+	}()
+
+	tenants, ids, err := srv.checkDump(io.TeeReader(r.Body, tmp))
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if err := srv.limits.fits(ids, srv.tenantQuota, mode == RestoreReplace); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if mode == RestoreReplace {
+		if err := srv.replaceTenants(tenants, ids); err != nil {
+			writeAPIError(w, err, nil)
+			return
+		}
+	}
+
+	res, err := srv.applyDump(tmp)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	res.Mode = mode
+	res.Tenants = len(tenants)
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// checkDump reads dump to the end and checks its integrity, values and names of metrics.
+// Gives tenants in order of dump and names of their metrics.
+func (srv *server) checkDump(r io.Reader) ([]string, map[string]map[string]struct{}, error) {
+	dr, err := dump.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tenants := []string{}
+	ids := map[string]map[string]struct{}{}
+
+	for {
+		rec, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			return tenants, ids, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := checkMetricValue(rec.Metric); err != nil {
+			return nil, nil, fmt.Errorf("%w: metric <%s>: %v", dump.ErrInvalidDump, rec.Metric.ID, err)
+		}
+
+		if err := srv.limits.checkName(rec.Metric.ID); err != nil {
+			return nil, nil, fmt.Errorf("%w: metric <%s> of tenant %q", err, rec.Metric.ID, rec.Tenant)
+		}
+
+		if _, ok := ids[rec.Tenant]; !ok {
+			tenants = append(tenants, rec.Tenant)
+			ids[rec.Tenant] = map[string]struct{}{}
+		}
+		ids[rec.Tenant][rec.Metric.ID] = struct{}{}
+	}
+}
+
+// replaceTenants deletes stored metrics missing in dump, including all metrics of tenants missing in it.
+func (srv *server) replaceTenants(tenants []string, ids map[string]map[string]struct{}) error {
+	if err := srv.dropTenants(tenants); err != nil {
+		return err
+	}
+
+	for _, tenant := range tenants {
+		st := srv.storage.ForTenant(tenant)
+
+		stored, err := st.GetBatch()
+		if err != nil {
+			return err
+		}
+
+		deleted := []string{}
+		for _, m := range stored {
+			if _, ok := ids[tenant][m.ID]; !ok {
+				deleted = append(deleted, m.ID)
+			}
+		}
+
+		if err := srv.deleteReplicated(st, tenant, deleted); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyDump stores metrics of checked dump by batches of one tenant and restores their history.
+func (srv *server) applyDump(r io.Reader) (restoreResult, error) {
+	res := restoreResult{}
+
+	dr, err := dump.NewReader(r)
+	if err != nil {
+		return res, err
+	}
+
+	res.Created = dr.Header().Created
+
+	batch := make([]dump.Record, 0, restoreBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := srv.applyRecords(batch); err != nil {
+			return err
+		}
+
+		for _, rec := range batch {
+			res.Metrics++
+			if srv.history != nil && len(rec.History) > 0 {
+				srv.history.Restore(rec.Tenant, rec.Metric.ID, rec.History)
+				res.Samples += len(rec.History)
+			}
+		}
+
+		batch = batch[:0]
+
+		return nil
+	}
+
+	for {
+		rec, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			return res, flush()
+		}
+		if err != nil {
+			return res, err
+		}
+
+		if len(batch) == restoreBatchSize || (len(batch) > 0 && batch[0].Tenant != rec.Tenant) {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+
+		batch = append(batch, rec)
+	}
+}
+
+// applyRecords stores metrics of records of one tenant within limits.
+func (srv *server) applyRecords(records []dump.Record) error {
+	tenant := records[0].Tenant
+
+	batch := make([]*metric.Metric, 0, len(records))
+	for _, rec := range records {
+		batch = append(batch, rec.Metric)
+	}
+
+	errs, reserved := srv.limits.reserve(tenant, srv.tenantQuota(tenant), batch)
+	for _, err := range errs {
+		if err != nil {
+			srv.limits.release(tenant, reserved...)
+			return err
+		}
+	}
+
+	if err := srv.applyReplication(replication.Entry{Tenant: tenant, Metrics: batch}); err != nil {
+		srv.limits.release(tenant, reserved...)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/dump"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_backupRestore(t *testing.T) {
+	source := newTestServer(t, serverConfig{})
+	source.initHistory()
+
+	for _, target := range []string{"/t/team/update/counter/jobs/3", "/t/team/update/gauge/load/0.5", "/update/gauge/up/1"} {
+		assert.Equal(t, http.StatusOK, serve(source, httptest.NewRequest("POST", target, nil)).Code)
+	}
+
+	now := time.Now().UTC()
+	source.history.Add("team", "load", now.Add(-time.Second), 0.25)
+	source.history.Add("team", "load", now, 0.5)
+
+	rec := serve(source, httptest.NewRequest("GET", "/admin/backup", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, dump.ContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), ".dump.gz")
+
+	backup := rec.Body.Bytes()
+
+	// Target has its own metrics and small body limit, which isn't applied to dumps.
+	target := newTestServer(t, serverConfig{MaxBodySize: 64})
+	target.initHistory()
+
+	for _, url := range []string{"/t/team/update/counter/jobs/10", "/t/other/update/gauge/extra/1", "/update/gauge/kept/2"} {
+		assert.Equal(t, http.StatusOK, serve(target, httptest.NewRequest("POST", url, nil)).Code)
+	}
+
+	value := func(tenant, id string) float64 {
+		m, err := target.storage.ForTenant(tenant).GetMetric(id)
+		if err != nil {
+			return -1
+		}
+
+		return m.SortValue()
+	}
+
+	rec = serve(target, httptest.NewRequest("POST", "/admin/restore", bytes.NewReader(backup)))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	res := restoreResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, RestoreMerge, res.Mode)
+	assert.Equal(t, 2, res.Tenants)
+	assert.Equal(t, 3, res.Metrics)
+	assert.Equal(t, 2, res.Samples)
+
+	// Merge sets dumped metrics and keeps others.
+	assert.Equal(t, 3.0, value("team", "jobs"))
+	assert.Equal(t, 0.5, value("team", "load"))
+	assert.Equal(t, 1.0, value("", "up"))
+	assert.Equal(t, 2.0, value("", "kept"))
+	assert.Equal(t, 1.0, value("other", "extra"))
+	assert.Len(t, target.history.Get("team", "load"), 2)
+
+	// Counters keep counting from restored values.
+	assert.Equal(t, http.StatusOK, serve(target, httptest.NewRequest("POST", "/t/team/update/counter/jobs/1", nil)).Code)
+	assert.Equal(t, 4.0, value("team", "jobs"))
+
+	// Replace deletes metrics missing in dump.
+	rec = serve(target, httptest.NewRequest("POST", "/admin/restore?mode=replace", bytes.NewReader(backup)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 3.0, value("team", "jobs"))
+	assert.Equal(t, -1.0, value("", "kept"))
+	assert.Equal(t, -1.0, value("other", "extra"))
+
+	tenants, err := target.storage.Tenants()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"", "team"}, tenants)
+}
+
+func Test_restoreErrors(t *testing.T) {
+	source := newTestServer(t, serverConfig{})
+	assert.Equal(t, http.StatusOK, serve(source, httptest.NewRequest("POST", "/update/gauge/up/1", nil)).Code)
+	backup := serve(source, httptest.NewRequest("GET", "/admin/backup", nil)).Body.Bytes()
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedCode   string
+		Body           []byte
+		Config         serverConfig
+		ExpectedStatus int
+	}{
+		{
+			Name:           "unknown mode",
+			URL:            "/admin/restore?mode=append",
+			Body:           backup,
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_mode",
+		},
+		{
+			Name:           "not a dump",
+			URL:            "/admin/restore",
+			Body:           []byte(`[{"id":"up","type":"gauge","value":1}]`),
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_dump",
+		},
+		{
+			Name:           "truncated dump",
+			URL:            "/admin/restore?mode=replace",
+			Body:           backup[:len(backup)-10],
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_dump",
+		},
+		{
+			Name:           "name out of charset",
+			URL:            "/admin/restore",
+			Body:           backup,
+			Config:         serverConfig{NameCharset: "^kept$"},
+			ExpectedStatus: http.StatusUnprocessableEntity,
+			ExpectedCode:   "invalid_name",
+		},
+		{
+			Name:           "tenant quota",
+			URL:            "/admin/restore",
+			Body:           backup,
+			Config:         serverConfig{TenantQuota: 1},
+			ExpectedStatus: http.StatusTooManyRequests,
+			ExpectedCode:   "quota_exceeded",
+		},
+		{
+			Name:           "distinct metrics limit",
+			URL:            "/admin/restore",
+			Body:           backup,
+			Config:         serverConfig{MaxMetrics: 1},
+			ExpectedStatus: http.StatusTooManyRequests,
+			ExpectedCode:   "cardinality_exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := newTestServer(t, tt.Config)
+			assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/gauge/kept/2", nil)).Code)
+
+			rec := serve(srv, httptest.NewRequest("POST", tt.URL, bytes.NewReader(tt.Body)))
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"`+tt.ExpectedCode+`"`)
+
+			// Rejected dump changes nothing.
+			batch, err := srv.storage.GetBatch()
+			assert.NoError(t, err)
+			if assert.Len(t, batch, 1) {
+				assert.Equal(t, "kept", batch[0].ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,709 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/go-chi/chi"
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/cluster"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var (
+	errNotClustered = errors.New("server is not clustered")
+	errNotMember    = errors.New("node is not member of cluster")
+)
+
+// Period of retrying rebalance on start while other nodes are unavailable.
+const rebalanceRetryInterval = 5 * time.Second
+
+// shards keeps membership of cluster and passes requests and metrics of other nodes to their owners.
+type shards struct {
+	ring    *cluster.Ring
+	client  *cluster.Client
+	proxies map[string]*httputil.ReverseProxy
+	self    string
+	mu      sync.RWMutex
+}
+
+// shardResult is outcome of part of batch owned by one node.
+type shardResult struct {
+	Node   string `json:"node"`
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// clusterState is the body of cluster membership responses.
+type clusterState struct {
+	Node  string         `json:"node"`
+	Nodes []cluster.Node `json:"nodes"`
+	Moved int            `json:"moved"`
+}
+
+// Key of context of requests whose metrics are stored by this node regardless of their owners.
+type clusterLocalKey struct{}
+
+// initCluster initializes sharding of metrics between nodes of cluster if config defines them.
+func (srv *server) initCluster() error {
+	if len(srv.config.ClusterNodes) == 0 {
+		return nil
+	}
+
+	ring, err := cluster.NewRing(srv.config.ClusterNodes)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ring.Node(srv.config.ClusterNode); !ok {
+		return fmt.Errorf("%w: %q", errNotMember, srv.config.ClusterNode)
+	}
+
+	srv.cluster = &shards{
+		client: cluster.NewClient(srv.config.ClusterNode, srv.config.HashKey, srv.config.ClusterHeaders),
+		self:   srv.config.ClusterNode,
+	}
+
+	return srv.cluster.setRing(ring)
+}
+
+// setRing changes membership of cluster.
+func (s *shards) setRing(ring *cluster.Ring) error {
+	proxies := map[string]*httputil.ReverseProxy{}
+
+	for _, node := range ring.Nodes() {
+		target, err := url.Parse(node.URL)
+		if err != nil {
+			return err
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		direct := proxy.Director
+		proxy.Director = func(r *http.Request) {
+			direct(r)
+			// Transport decompresses response itself, so it is compressed again for client only once.
+			r.Header.Del("Accept-Encoding")
+		}
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			err = fmt.Errorf("%w: %v", cluster.ErrNodeUnavailable, err)
+			log.Println(err)
+			http.Error(w, err.Error(), errorStatus(err))
+		}
+
+		proxies[node.Name] = proxy
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ring, s.proxies = ring, proxies
+
+	return nil
+}
+
+// current gives ring of the current membership.
+func (s *shards) current() *cluster.Ring {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ring
+}
+
+// owners groups positions of batch metrics by names of their owners.
+func (s *shards) owners(ring *cluster.Ring, tenant string, batch []*metric.Metric) map[string][]int {
+	groups := map[string][]int{}
+	for i := range batch {
+		owner := ring.Owner(tenant, batch[i].ID).Name
+		groups[owner] = append(groups[owner], i)
+	}
+
+	return groups
+}
+
+// clusterLocal marks context of metrics which must be stored by this node, e.g. passed by other nodes.
+func clusterLocal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, clusterLocalKey{}, true)
+}
+
+// isClusterLocal checks if metrics of context are stored by this node regardless of their owners.
+func (srv *server) isClusterLocal(ctx context.Context) bool {
+	local, _ := ctx.Value(clusterLocalKey{}).(bool)
+
+	return srv.cluster == nil || local
+}
+
+// storeForeignEach passes metrics of batch owned by other nodes to their owners and fills errors of them
+// by their indexes. Gives metrics of this node and their indexes.
+func (srv *server) storeForeignEach(ctx context.Context, tenant string, batch []*metric.Metric, indexes []int, errs []error) ([]*metric.Metric, []int) {
+	if srv.isClusterLocal(ctx) {
+		return batch, indexes
+	}
+
+	ring := srv.cluster.current()
+	groups := srv.cluster.owners(ring, tenant, batch)
+
+	local := make([]*metric.Metric, 0, len(groups[srv.cluster.self]))
+	localIndexes := make([]int, 0, len(groups[srv.cluster.self]))
+
+	for _, node := range ring.Nodes() {
+		positions := groups[node.Name]
+		if len(positions) == 0 {
+			continue
+		}
+
+		if node.Name == srv.cluster.self {
+			for _, p := range positions {
+				local = append(local, batch[p])
+				localIndexes = append(localIndexes, indexes[p])
+			}
+			continue
+		}
+
+		sub := make([]*metric.Metric, 0, len(positions))
+		for _, p := range positions {
+			sub = append(sub, batch[p])
+		}
+
+		// Owner is requested by node, so budget of requesting token is spent here.
+		subErrs, err := []error(nil), auth.AllowMetrics(ctx, len(sub))
+		if err == nil {
+			subErrs, err = srv.cluster.client.Update(ctx, node, tenant, sub, false)
+		}
+
+		for i, p := range positions {
+			if err != nil {
+				errs[indexes[p]] = err
+				continue
+			}
+			errs[indexes[p]] = remoteItemError(subErrs[i])
+		}
+	}
+
+	return local, localIndexes
+}
+
+// storeForeign passes metrics of batch owned by other nodes to their owners. Gives metrics of this node
+// and the first error of other nodes.
+func (srv *server) storeForeign(ctx context.Context, tenant string, batch []*metric.Metric) ([]*metric.Metric, error) {
+	if srv.isClusterLocal(ctx) {
+		return batch, nil
+	}
+
+	indexes := make([]int, len(batch))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	errs := make([]error, len(batch))
+	local, _ := srv.storeForeignEach(ctx, tenant, batch, indexes, errs)
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return local, nil
+}
+
+// remoteItemError restores errors given by owners which change results of batches.
+func remoteItemError(err error) error {
+	re := &cluster.RemoteError{}
+	if errors.As(err, &re) && re.Code == errorCode(errMetricDropped) {
+		return errMetricDropped
+	}
+
+	return err
+}
+
+// tenantMetrics gives function getting stored metrics of tenant from their owners.
+func (srv *server) tenantMetrics(ctx context.Context, tenant string) func(ids []string) ([]*metric.Metric, error) {
+	st := srv.storage.ForTenant(tenant)
+
+	if srv.isClusterLocal(ctx) {
+		return st.GetMetrics
+	}
+
+	return func(ids []string) ([]*metric.Metric, error) {
+		ring := srv.cluster.current()
+
+		groups := map[string][]string{}
+		for _, id := range ids {
+			owner := ring.Owner(tenant, id).Name
+			groups[owner] = append(groups[owner], id)
+		}
+
+		res := []*metric.Metric{}
+		for _, node := range ring.Nodes() {
+			if len(groups[node.Name]) == 0 {
+				continue
+			}
+
+			var batch []*metric.Metric
+			var err error
+
+			if node.Name == srv.cluster.self {
+				batch, err = st.GetMetrics(groups[node.Name])
+			} else {
+				batch, err = srv.cluster.client.Values(ctx, node, tenant, groups[node.Name])
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			res = append(res, batch...)
+		}
+
+		return res, nil
+	}
+}
+
+// storeShards stores batch spanning several nodes by parts of owners. Gives result of every part, or false
+// if batch is owned by one node.
+func (srv *server) storeShards(r *http.Request, batch []*metric.Metric) ([]shardResult, bool) {
+	if srv.isClusterLocal(r.Context()) || r.Header.Get(cluster.NodeHeader) != "" {
+		return nil, false
+	}
+
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		return nil, false
+	}
+
+	ring := srv.cluster.current()
+	groups := srv.cluster.owners(ring, tenant, batch)
+	if len(groups) < 2 {
+		return nil, false
+	}
+
+	// Batch which can't be stored by any node is rejected as a whole.
+	if err := srv.checkWritable(); err != nil {
+		return nil, false
+	}
+	for i := range batch {
+		if err := auth.CheckWrite(r.Context(), batch[i].ID); err != nil {
+			return nil, false
+		}
+	}
+	if err := srv.limits.checkNames(batch); err != nil {
+		return nil, false
+	}
+
+	results := make([]shardResult, 0, len(groups))
+	for _, node := range ring.Nodes() {
+		positions := groups[node.Name]
+		if len(positions) == 0 {
+			continue
+		}
+
+		sub := make([]*metric.Metric, 0, len(positions))
+		for _, p := range positions {
+			sub = append(sub, batch[p])
+		}
+
+		res := shardResult{Node: node.Name, Count: len(sub), Status: http.StatusOK}
+
+		if node.Name == srv.cluster.self {
+			err = srv.storeTenantMetrics(clusterLocal(r.Context()), tenant, sub...)
+		} else if err = auth.AllowMetrics(r.Context(), len(sub)); err == nil {
+			var errs []error
+			if errs, err = srv.cluster.client.Update(r.Context(), node, tenant, sub, false); err == nil {
+				err = firstError(errs)
+			}
+		}
+
+		if err != nil {
+			log.Println(err)
+			res.Status, res.Error = errorStatus(err), err.Error()
+		}
+
+		results = append(results, res)
+	}
+
+	return results, true
+}
+
+// shardsStatus gives status of response to batch stored by parts: OK if all parts are stored, status of the first
+// failure if none is, multi-status otherwise.
+func shardsStatus(results []shardResult) int {
+	failed := []int{}
+	for _, res := range results {
+		if res.Status != http.StatusOK {
+			failed = append(failed, res.Status)
+		}
+	}
+
+	switch len(failed) {
+	case 0:
+		return http.StatusOK
+	case len(results):
+		return failed[0]
+	default:
+		return http.StatusMultiStatus
+	}
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// routeToOwner passes requests of metrics owned by other nodes to their owners. Metric is defined by URL parameter
+// "name" or by field "id" of json body.
+func (srv *server) routeToOwner(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if srv.cluster == nil || r.Header.Get(cluster.NodeHeader) != "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Body is decompressed already, so it is passed further as it is.
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Del("Content-Encoding")
+
+		id := chi.URLParam(r, "name")
+		if id == "" {
+			req := struct {
+				ID string `json:"id"`
+			}{}
+
+			// Invalid bodies are reported by handler.
+			if err := json.Unmarshal(body, &req); err == nil {
+				id = req.ID
+			}
+		}
+
+		tenant, err := srv.tenant(r)
+		if err != nil || id == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		srv.cluster.mu.RLock()
+		owner := srv.cluster.ring.Owner(tenant, id)
+		proxy := srv.cluster.proxies[owner.Name]
+		srv.cluster.mu.RUnlock()
+
+		if owner.Name == srv.cluster.self {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		r.Header.Set(cluster.NodeHeader, srv.cluster.self)
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// rebalance moves metrics owned by other nodes to their owners. Moved counters are added to values of owners,
+// moved gauges are kept by owners if they have them already. Gives number of moved metrics.
+func (srv *server) rebalance(ctx context.Context) (int, error) {
+	ring := srv.cluster.current()
+
+	tenants, err := srv.storage.Tenants()
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, tenant := range tenants {
+		st := srv.storage.ForTenant(tenant)
+
+		batch, err := st.GetBatch()
+		if err != nil {
+			return moved, err
+		}
+
+		groups := srv.cluster.owners(ring, tenant, batch)
+
+		for _, node := range ring.Nodes() {
+			positions := groups[node.Name]
+			if node.Name == srv.cluster.self || len(positions) == 0 {
+				continue
+			}
+
+			sub := make([]*metric.Metric, 0, len(positions))
+			for _, p := range positions {
+				m := *batch[p]
+				m.Hash = ""
+				sub = append(sub, &m)
+			}
+
+			errs, err := srv.cluster.client.Update(ctx, node, tenant, sub, true)
+			if err != nil {
+				return moved, err
+			}
+
+			for i, m := range sub {
+				if errs[i] != nil {
+					log.Println(errs[i])
+					continue
+				}
+
+				if err := st.DeleteMetric(m.ID); err != nil && !errors.Is(err, metric.ErrMetricDoesntExist) {
+					return moved, err
+				}
+
+				srv.forgetMetric(tenant, m.ID)
+				srv.replicateDeletion(tenant, m.ID)
+				moved++
+			}
+		}
+	}
+
+	if moved > 0 && srv.config.StoreInterval == 0 {
+		srv.fileUpload()
+	}
+
+	return moved, nil
+}
+
+// rebalanceOnStart moves metrics stored before membership changed, e.g. after restart with new nodes.
+// Retries while other nodes are unavailable.
+func (srv *server) rebalanceOnStart() {
+	ticker := time.NewTicker(rebalanceRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		moved, err := srv.rebalance(context.Background())
+		if err == nil {
+			if moved > 0 {
+				log.Printf("%d metrics are moved to their owners", moved)
+			}
+			return
+		}
+
+		log.Println(err)
+
+		select {
+		case <-ticker.C:
+		case <-srv.shutdown:
+			return
+		}
+	}
+}
+
+// Stores metrics passed by other nodes of cluster to their owner. Valid metrics are stored even if others
+// are rejected. If query parameter "move" is set, gauges known to node are kept, since they are newer.
+func (srv *server) handlerClusterUpdates(w http.ResponseWriter, r *http.Request) {
+	if srv.cluster == nil {
+		writeAPIError(w, errNotClustered, nil)
+		return
+	}
+
+	batch := []*metric.Metric{}
+	if err := decodeJSON(r, &batch); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	st, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	errs := make([]error, len(batch))
+	for i := range batch {
+		errs[i] = checkMetricValue(batch[i])
+	}
+
+	if r.URL.Query().Get("move") != "" {
+		if err := keepKnownGauges(st, batch, errs); err != nil {
+			writeAPIError(w, err, nil)
+			return
+		}
+	}
+
+	if err := srv.storeTenantEach(clusterLocal(r.Context()), tenant, batch, errs); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	res := cluster.Response{}
+	for i, err := range errs {
+		if err == nil {
+			res.Accepted++
+			continue
+		}
+
+		res.Errors = append(res.Errors, cluster.ItemError{
+			Index:   i,
+			Status:  errorStatus(err),
+			Code:    errorCode(err),
+			Message: err.Error(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// keepKnownGauges replaces values of valid gauges of batch by stored ones.
+func keepKnownGauges(st metric.MetricStorage, batch []*metric.Metric, errs []error) error {
+	ids := []string{}
+	for i := range batch {
+		if errs[i] == nil && batch[i].MType == Gauge {
+			ids = append(ids, batch[i].ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	stored, err := st.GetMetrics(ids)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]float64{}
+	for _, m := range stored {
+		if m.MType == Gauge && m.Value != nil {
+			values[m.ID] = *m.Value
+		}
+	}
+
+	for i := range batch {
+		if value, ok := values[batch[i].ID]; ok && errs[i] == nil && batch[i].MType == Gauge {
+			batch[i].Value = &value
+		}
+	}
+
+	return nil
+}
+
+// Outputs stored metrics of tenant by names in request body for other nodes of cluster.
+func (srv *server) handlerClusterValues(w http.ResponseWriter, r *http.Request) {
+	ids := []string{}
+	if err := decodeJSON(r, &ids); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	st, _, err := srv.tenantStorage(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	batch, err := st.GetMetrics(ids)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	res := make([]*metric.Metric, 0, len(batch))
+	for i := range batch {
+		m := *batch[i]
+		m.Hash = ""
+		res = append(res, &m)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Outputs name of node and membership of cluster.
+func (srv *server) handlerGetCluster(w http.ResponseWriter, r *http.Request) {
+	if srv.cluster == nil {
+		writeAPIError(w, errNotClustered, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, clusterState{Node: srv.cluster.self, Nodes: srv.cluster.current().Nodes()})
+}
+
+// Changes membership of cluster and moves metrics to their new owners. Membership is passed to every node
+// of previous and new membership unless request came from other node. Node missing in new membership
+// moves all its metrics away.
+func (srv *server) handlerPutClusterNodes(w http.ResponseWriter, r *http.Request) {
+	if srv.cluster == nil {
+		writeAPIError(w, errNotClustered, nil)
+		return
+	}
+
+	nodes := []cluster.Node{}
+	if err := decodeJSON(r, &nodes); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	ring, err := cluster.NewRing(nodes)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	previous := srv.cluster.current()
+	if err := srv.cluster.setRing(ring); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if r.Header.Get(cluster.NodeHeader) == "" {
+		if err := srv.announceNodes(r.Context(), previous, ring); err != nil {
+			writeAPIError(w, err, nil)
+			return
+		}
+	}
+
+	moved, err := srv.rebalance(r.Context())
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, clusterState{Node: srv.cluster.self, Nodes: ring.Nodes(), Moved: moved})
+}
+
+// announceNodes passes new membership to other nodes of previous and new membership.
+func (srv *server) announceNodes(ctx context.Context, previous, ring *cluster.Ring) error {
+	targets := map[string]cluster.Node{}
+	for _, node := range append(previous.Nodes(), ring.Nodes()...) {
+		if node.Name != srv.cluster.self {
+			targets[node.Name] = node
+		}
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failed error
+	for _, name := range names {
+		if err := srv.cluster.client.SetNodes(ctx, targets[name], ring.Nodes()); err != nil {
+			log.Println(err)
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+
+	return failed
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/cluster"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+const clusterTestKey = "key"
+
+// newTestCluster starts nodes of cluster on localhost ports. Metrics are hashed by clusterTestKey.
+func newTestCluster(t *testing.T, names ...string) (map[string]*server, []cluster.Node, func()) {
+	listeners := map[string]*httptest.Server{}
+	nodes := []cluster.Node{}
+
+	for _, name := range names {
+		ts := httptest.NewUnstartedServer(nil)
+		listeners[name] = ts
+		nodes = append(nodes, cluster.Node{Name: name, URL: "http://" + ts.Listener.Addr().String()})
+	}
+
+	servers := map[string]*server{}
+	for _, name := range names {
+		srv := newTestServer(t, serverConfig{HashKey: clusterTestKey, ClusterNode: name, ClusterNodes: nodes})
+		assert.NoError(t, srv.initCluster())
+
+		listeners[name].Config.Handler = srv.server.Handler
+		listeners[name].Start()
+		servers[name] = srv
+	}
+
+	return servers, nodes, func() {
+		for _, ts := range listeners {
+			ts.Close()
+		}
+	}
+}
+
+// hashedGauges makes gauges "m0"..."m<n-1>" with values equal to their numbers.
+func hashedGauges(t *testing.T, n int) []*metric.Metric {
+	batch := make([]*metric.Metric, 0, n)
+	for i := 0; i < n; i++ {
+		value := float64(i)
+		m := &metric.Metric{ID: "m" + strconv.Itoa(i), MType: Gauge, Value: &value}
+		assert.NoError(t, m.UpdateHashVersion(clusterTestKey, metric.LatestHashVersion))
+		batch = append(batch, m)
+	}
+
+	return batch
+}
+
+func hashedRequest(t *testing.T, target string, v interface{}) *http.Request {
+	body, err := json.Marshal(v)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", target, bytes.NewReader(body))
+	req.Header.Set(HashVersionHeader, strconv.Itoa(metric.LatestHashVersion))
+
+	return req
+}
+
+func Test_clusterUpdateBatch(t *testing.T) {
+	servers, nodes, stop := newTestCluster(t, "a", "b", "c")
+	defer stop()
+
+	ring, err := cluster.NewRing(nodes)
+	assert.NoError(t, err)
+
+	batch := hashedGauges(t, 30)
+
+	rec := serve(servers["a"], hashedRequest(t, "/t/team/updates/", batch))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	results := []shardResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+	assert.Len(t, results, 3)
+
+	count := 0
+	for _, res := range results {
+		assert.Equal(t, http.StatusOK, res.Status, res.Error)
+		count += res.Count
+	}
+	assert.Equal(t, len(batch), count)
+
+	// Every metric is stored only by its owner.
+	for i, m := range batch {
+		owner := ring.Owner("team", m.ID).Name
+
+		for name, srv := range servers {
+			stored, err := srv.storage.ForTenant("team").GetMetric(m.ID)
+			if name != owner {
+				assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+				continue
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, float64(i), *stored.Value)
+			}
+		}
+	}
+
+	// Batch owned by one node is stored as before.
+	single := []*metric.Metric{}
+	for _, m := range batch {
+		if ring.Owner("team", m.ID).Name == "b" {
+			single = append(single, m)
+		}
+	}
+
+	rec = serve(servers["b"], hashedRequest(t, "/t/team/updates/", single))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
+
+func Test_clusterRouting(t *testing.T) {
+	servers, nodes, stop := newTestCluster(t, "a", "b", "c")
+	defer stop()
+
+	ring, err := cluster.NewRing(nodes)
+	assert.NoError(t, err)
+
+	// Counter is updated through every node, reads of every node give value of owner.
+	for _, name := range []string{"a", "b", "c"} {
+		rec := serve(servers[name], httptest.NewRequest("POST", "/update/counter/jobs/2", nil))
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+	}
+
+	owner := ring.Owner("", "jobs").Name
+	stored, err := servers[owner].storage.GetMetric("jobs")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(6), *stored.Delta)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		rec := serve(servers[name], httptest.NewRequest("GET", "/value/counter/jobs", nil))
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+		assert.Equal(t, "6", rec.Body.String(), name)
+
+		rec = serve(servers[name], hashedRequest(t, "/api/v1/value", metric.Metric{ID: "jobs", MType: Counter}))
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+
+		m := metric.Metric{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &m))
+		if assert.NotNil(t, m.Delta, name) {
+			assert.Equal(t, int64(6), *m.Delta, name)
+		}
+	}
+
+	// API v1 batch spanning nodes reports results of individual metrics.
+	rec := serve(servers["a"], hashedRequest(t, "/api/v1/updates", hashedGauges(t, 10)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := batchResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, 10, res.Accepted)
+
+	total := 0
+	for _, srv := range servers {
+		batch, err := srv.storage.GetBatch()
+		assert.NoError(t, err)
+		total += len(batch)
+	}
+	assert.Equal(t, 11, total)
+
+	// Deletion is passed to owner too.
+	for _, name := range []string{"a", "b", "c"} {
+		if name != owner {
+			rec = serve(servers[name], httptest.NewRequest("DELETE", "/value/counter/jobs", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			break
+		}
+	}
+	_, err = servers[owner].storage.GetMetric("jobs")
+	assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+}
+
+func Test_clusterRebalance(t *testing.T) {
+	servers, nodes, stop := newTestCluster(t, "a", "b", "c", "d")
+	defer stop()
+
+	// Cluster starts without node "d", which joins it later.
+	for _, name := range []string{"a", "b", "c"} {
+		ring, err := cluster.NewRing(nodes[:3])
+		assert.NoError(t, err)
+		assert.NoError(t, servers[name].cluster.setRing(ring))
+	}
+
+	const n = 40
+	for i := 0; i < n; i++ {
+		rec := serve(servers["a"], httptest.NewRequest("POST", "/update/counter/c"+strconv.Itoa(i)+"/"+strconv.Itoa(i), nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, 0, len(mustBatch(t, servers["d"])))
+
+	body, err := json.Marshal(nodes)
+	assert.NoError(t, err)
+
+	rec := serve(servers["a"], httptest.NewRequest("PUT", "/cluster/nodes", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	state := clusterState{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	assert.Equal(t, nodes, state.Nodes)
+
+	// Every node knows new membership and keeps only its own metrics, values are preserved.
+	ring, err := cluster.NewRing(nodes)
+	assert.NoError(t, err)
+
+	total := 0
+	for name, srv := range servers {
+		assert.Equal(t, nodes, srv.cluster.current().Nodes(), name)
+
+		for _, m := range mustBatch(t, srv) {
+			assert.Equal(t, name, ring.Owner("", m.ID).Name, m.ID)
+
+			i, err := strconv.Atoi(m.ID[1:])
+			assert.NoError(t, err)
+			assert.Equal(t, int64(i), *m.Delta, m.ID)
+			total++
+		}
+	}
+	assert.Equal(t, n, total)
+	assert.NotEmpty(t, mustBatch(t, servers["d"]))
+
+	rec = serve(servers["d"], httptest.NewRequest("GET", "/cluster", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	assert.Equal(t, "d", state.Node)
+
+	// Server without cluster has no membership.
+	rec = serve(newTestServer(t, serverConfig{}), httptest.NewRequest("GET", "/cluster", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"cluster_off"`)
+}
+
+func Test_clusterReads(t *testing.T) {
+	servers, nodes, stop := newTestCluster(t, "a", "b", "c")
+	defer stop()
+
+	ring, err := cluster.NewRing(nodes)
+	assert.NoError(t, err)
+
+	batch := hashedGauges(t, 30)
+	rec := serve(servers["a"], hashedRequest(t, "/t/team/updates/", batch))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	owned := map[string]int{}
+	for _, m := range batch {
+		owned[ring.Owner("team", m.ID).Name]++
+	}
+
+	requested := make([]metric.Metric, 0, len(batch))
+	for _, m := range batch {
+		requested = append(requested, metric.Metric{ID: m.ID, MType: Gauge})
+	}
+	requested = append(requested, metric.Metric{ID: "missing", MType: Gauge})
+
+	for _, name := range []string{"a", "b", "c"} {
+		// Batch read gives metrics of every owner.
+		rec = serve(servers[name], hashedRequest(t, "/t/team/values/", requested))
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+
+		res := valuesResult{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Len(t, res.Metrics, len(batch), name)
+		assert.Equal(t, []string{"missing"}, res.Missing, name)
+
+		// Rules read metrics of every owner too.
+		value, err := servers[name].ruleEnv("team", time.Now()).Value("m7")
+		assert.NoError(t, err, name)
+		assert.Equal(t, 7.0, value, name)
+
+		// Listings are node-local.
+		rec = serve(servers[name], httptest.NewRequest("GET", "/t/team/api/query", nil))
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+
+		query := queryResult{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &query))
+		assert.Len(t, query.Metrics, owned[name], name)
+	}
+
+	// Results of recording rules are stored by their owners.
+	for i := 0; i < 10; i++ {
+		id := "recorded" + strconv.Itoa(i)
+		value := float64(i)
+		assert.NoError(t, servers["a"].storeRecorded("team", []*metric.Metric{{ID: id, MType: Gauge, Value: &value}}))
+
+		owner := ring.Owner("team", id).Name
+		for name, srv := range servers {
+			_, err := srv.storage.ForTenant("team").GetMetric(id)
+			if name == owner {
+				assert.NoError(t, err, id)
+			} else {
+				assert.ErrorIs(t, err, metric.ErrMetricDoesntExist, id)
+			}
+		}
+	}
+}
+
+func mustBatch(t *testing.T, srv *server) []*metric.Metric {
+	batch, err := srv.storage.GetBatch()
+	assert.NoError(t, err)
+
+	return batch
+}
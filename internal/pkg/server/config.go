@@ -10,6 +10,11 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/caarlos0/env"
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/cluster"
+	"github.com/goslammu/yp_go_devops/internal/pkg/federation"
+	"github.com/goslammu/yp_go_devops/internal/pkg/forward"
+	"github.com/goslammu/yp_go_devops/internal/pkg/notify"
 )
 
 const (
@@ -56,13 +61,124 @@ type serverConfig struct {
 	// Destination of TLS certification data.
 	CertDestination string `env:"CRYPTO_KEY" json:"crypto_key"`
 
+	// Destination of json-file with alerting and recording rules. If is empty, rules are not evaluated.
+	RulesFile string `env:"RULES_FILE" json:"rules_file"`
+
+	// Rollup tiers of metric history in format "<resolution>:<retention>,...", e.g. "10s:1d,1m:30d,1h:1y".
+	// If is empty, rollups are not kept.
+	RollupTiers string `env:"ROLLUP_TIERS" json:"rollup_tiers"`
+
+	// TCP address of Graphite plaintext listener, e.g. ":2003". If is empty, listener is not started.
+	GraphiteAddress string `env:"GRAPHITE_ADDRESS" json:"graphite_address"`
+
+	// Tenant lines of Graphite listener are stored to. If is empty, default tenant is used.
+	GraphiteTenant string `env:"GRAPHITE_TENANT" json:"graphite_tenant"`
+
+	// Base URL of parent metrics are pushed to, e.g. "http://global:8080". If is empty, metrics are not pushed.
+	FederatePush string `env:"FEDERATE_PUSH" json:"federate_push"`
+
+	// Source name of pushed metrics. If is empty, host name is used.
+	FederateSource string `env:"FEDERATE_SOURCE" json:"federate_source"`
+
+	// Base URL of primary server, e.g. "http://primary:8080". If is defined, server starts as standby: it follows
+	// storage of primary and rejects writes until it is promoted.
+	ReplicaOf string `env:"REPLICA_OF" json:"replica_of"`
+
+	// Name of server in ClusterNodes.
+	ClusterNode string `env:"CLUSTER_NODE" json:"cluster_node"`
+
+	// Regular expression which metric names must match. If is empty, names aren't checked.
+	NameCharset string `env:"NAME_CHARSET" json:"name_charset"`
+
+	// Policy of handling metrics exceeding MaxMetrics or tenant quota: "reject" or "drop-new".
+	OverflowPolicy string `env:"OVERFLOW_POLICY" json:"overflow_policy"`
+
+	// Maximal number of metrics of individual tenants. Overrides TenantQuota.
+	TenantQuotas map[string]int `json:"tenant_quotas"`
+
+	// Headers of pushes to parent, e.g. authorization.
+	FederatePushHeaders map[string]string `json:"federate_push_headers"`
+
+	// Headers of requests to primary, e.g. authorization by admin token.
+	ReplicaHeaders map[string]string `json:"replica_headers"`
+
+	// Headers of requests to other nodes of cluster, e.g. authorization.
+	ClusterHeaders map[string]string `json:"cluster_headers"`
+
+	// Bearer tokens with scopes and budgets. If neither these nor database tokens are defined,
+	// authentication is turned off.
+	Tokens []auth.Token `json:"tokens"`
+
+	// Webhooks receiving alert state changes. Requests are signed by HashKey if it is defined.
+	Webhooks []notify.Target `json:"webhooks"`
+
+	// Upstream targets accepted updates are forwarded to. Updates sent to other servers are hashed and signed
+	// by HashKey if it is defined.
+	Forward []forward.Target `json:"forward"`
+
+	// Children whose metrics are pulled and merged with label "source".
+	FederateChildren []federation.Child `json:"federate_children"`
+
+	// Nodes of cluster sharing metrics by consistent hashing. If is empty, server stores all metrics itself.
+	// Dashboard, /metrics, /api/query and /federate give only metrics owned by the node.
+	ClusterNodes []cluster.Node `json:"cluster_nodes"`
+
 	// Time interval between to-file storing actions (for filestorage only).
 	// If not defined, storing will be made in sync way.
 	StoreInterval time.Duration `env:"STORE_INTERVAL" json:"store_interval"`
 
+	// Period of sampling metrics to history. If not defined, default period is used.
+	HistoryInterval time.Duration `env:"HISTORY_INTERVAL" json:"history_interval"`
+
+	// Period of pulling children and pushing to parent. If not defined, default period is used.
+	FederateInterval time.Duration `env:"FEDERATE_INTERVAL" json:"federate_interval"`
+
+	// Period of rules evaluation. If not defined, default period is used.
+	RuleInterval time.Duration `env:"RULE_INTERVAL" json:"rule_interval"`
+
+	// Periods of grouping alert changes into one webhook request and of skipping repeated changes.
+	// Zero value means default period, negative one turns grouping or deduplication off.
+	NotifyGroupWindow time.Duration `env:"NOTIFY_GROUP_WINDOW" json:"notify_group_window"`
+	NotifyDedupWindow time.Duration `env:"NOTIFY_DEDUP_WINDOW" json:"notify_dedup_window"`
+
+	// Allowed clock skew for signed requests. Nonces are remembered for the same period.
+	SignatureWindow time.Duration `env:"SIGNATURE_WINDOW" json:"signature_window"`
+
+	// Minimal accepted version of metric hashes encoding. Allows to turn legacy agents off after migration.
+	MinHashVersion int `env:"MIN_HASH_VERSION" json:"min_hash_version"`
+
+	// Maximal number of metrics of every tenant. If is zero, number of metrics is unlimited.
+	TenantQuota int `env:"TENANT_QUOTA" json:"tenant_quota"`
+
+	// Payload and cardinality limits. If is zero or negative, limit is off.
+	MaxBodySize    int64 `env:"MAX_BODY_SIZE" json:"max_body_size"`
+	MaxBatchLength int   `env:"MAX_BATCH_LENGTH" json:"max_batch_length"`
+	MaxNameLength  int   `env:"MAX_NAME_LENGTH" json:"max_name_length"`
+	MaxMetrics     int   `env:"MAX_METRICS" json:"max_metrics"`
+
+	// Number of the latest changes kept for standbys catching up. If is zero, default size is used.
+	ReplicationLogSize int `env:"REPLICATION_LOG_SIZE" json:"replication_log_size"`
+
+	// Number of updates buffered for every stream subscriber. Updates exceeding it are lost for slow subscribers.
+	StreamBuffer int `env:"STREAM_BUFFER" json:"stream_buffer"`
+
+	// Number of samples of every metric kept in history for dashboard sparklines.
+	// Zero value means default size, negative one turns history off.
+	HistorySize int `env:"HISTORY_SIZE" json:"history_size"`
+
 	// Defines if needed to download storage on server init (for filestorage only).
 	InitialDownload bool `env:"RESTORE" json:"restore"`
 
+	// Defines if changes of storage are logged, so standbys could follow server.
+	EnableReplication bool `env:"REPLICATION" json:"replication"`
+
+	// Defines if every mutating request must be signed by HashKey with timestamp and nonce.
+	RequireSignature bool `env:"REQUIRE_SIGNATURE" json:"require_signature"`
+
+	// Graphite protocol has no authentication, so anyone reaching listener writes to GraphiteTenant.
+	// If tokens are defined, listener is started only if this is set.
+	GraphiteUnauthenticated bool `env:"GRAPHITE_UNAUTHENTICATED" json:"graphite_unauthenticated"`
+
 	// Defines if is needed to drop database on server init (for pgxstorage only).
 	InitialDatabaseDrop bool `json:"-"`
 
@@ -8,15 +8,15 @@ import (
 
 func Test_serverConfiguration(t *testing.T) {
 	expectedConfig := serverConfig{
-		"127.0.0.1:8080",
-		"",
-		"",
-		"key",
-		"privateCryptoKey",
-		0,
-		InitialDownloadOn,
-		DropDatabaseOn,
-		ModeHTTP,
+		ServerAddress:       "127.0.0.1:8080",
+		DatabaseAddress:     "",
+		FileDestination:     "",
+		HashKey:             "key",
+		CertDestination:     "privateCryptoKey",
+		StoreInterval:       0,
+		InitialDownload:     InitialDownloadOn,
+		InitialDatabaseDrop: DropDatabaseOn,
+		EnableHTTPS:         ModeHTTP,
 	}
 
 	actualConfig := NewConfig(
@@ -60,16 +60,12 @@ func Test_serverConfiguration(t *testing.T) {
 	srv.turnedOn = true
 
 	t.Run("server shutdown actions", func(t *testing.T) {
-		ok := true
-
 		assert.NoError(t, srv.Shutdown())
 
 		select {
-		case _, ok = <-srv.uploadSig:
+		case <-srv.shutdown:
 		default:
+			t.Error("background loops aren't stopped")
 		}
-
-		assert.False(t, ok)
-
 	})
 }
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/counters"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+// Content type of Prometheus text exposition format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// initCounters initializes tracking of counter rates and resets, of accumulated values of counters
+// reported by external systems, and of metric update times.
+func (srv *server) initCounters() {
+	srv.counters = counters.New()
+	srv.totals = newTotals()
+	srv.updated = newUpdateTimes()
+}
+
+// takeEpochs gives epochs of reporting processes of batch metrics and clears them, so storages don't keep them.
+func takeEpochs(batch []*metric.Metric) []int64 {
+	epochs := make([]int64, len(batch))
+	for i := range batch {
+		epochs[i], batch[i].Epoch = batch[i].Epoch, 0
+	}
+
+	return epochs
+}
+
+// trackCounters passes accumulated values of stored counters of batch to tracker.
+func (srv *server) trackCounters(st metric.MetricStorage, tenant string, batch []*metric.Metric, epochs []int64) {
+	if srv.counters == nil {
+		return
+	}
+
+	ids := []string{}
+	epochOf := map[string]int64{}
+
+	for i := range batch {
+		if batch[i].MType != Counter {
+			continue
+		}
+
+		ids = append(ids, batch[i].ID)
+		if epochs[i] != 0 {
+			epochOf[batch[i].ID] = epochs[i]
+		}
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	stored, err := st.GetMetrics(ids)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	now := time.Now()
+	for _, m := range stored {
+		if m.MType == Counter && m.Delta != nil {
+			srv.counters.Observe(tenant, m.ID, float64(*m.Delta), epochOf[m.ID], now)
+		}
+	}
+}
+
+// Outputs metrics of request tenant in Prometheus text exposition format. Every counter is followed by gauge
+// "<name>_rate" with its per-second rate and counter "<name>_resets" with number of its detected resets.
+func (srv *server) handlerPrometheus(w http.ResponseWriter, r *http.Request) {
+	st, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	allMetrics, err := st.GetBatch()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(allMetrics, func(i, j int) bool {
+		return allMetrics[i].ID < allMetrics[j].ID
+	})
+
+	families := &prometheusFamilies{byName: map[string]*prometheusFamily{}}
+
+	for _, m := range allMetrics {
+		name := prometheusName(seriesName(m))
+		labels := prometheusLabels(m.Labels)
+
+		switch {
+		case m.MType == Gauge && m.Value != nil:
+			families.add(name, "gauge", labels, *m.Value)
+		case m.MType == Counter && m.Delta != nil:
+			families.add(name, "counter", labels, float64(*m.Delta))
+
+			if s, ok := srv.counters.Get(tenant, m.ID); ok {
+				if s.Rate != nil {
+					families.add(name+"_rate", "gauge", labels, *s.Rate)
+				}
+				families.add(name+"_resets", "counter", labels, float64(s.Resets))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+
+	bw := bufio.NewWriter(w)
+	families.write(bw)
+
+	if err := bw.Flush(); err != nil {
+		log.Println(err)
+	}
+}
+
+// prometheusFamily is metric family of exposition: samples sharing name and type, e.g. series of different labels.
+type prometheusFamily struct {
+	name, mType string
+	samples     []string
+}
+
+// prometheusFamilies keeps families in order of their first samples.
+type prometheusFamilies struct {
+	byName   map[string]*prometheusFamily
+	families []*prometheusFamily
+}
+
+// add appends sample to its family. Sample of type other than type of family is skipped, since exposition
+// allows only one type of name.
+func (f *prometheusFamilies) add(name, mType, labels string, value float64) {
+	family, ok := f.byName[name]
+	if !ok {
+		family = &prometheusFamily{name: name, mType: mType}
+		f.byName[name] = family
+		f.families = append(f.families, family)
+	}
+
+	if family.mType != mType {
+		log.Printf("prometheus: %s%s of type %s conflicts with family of type %s", name, labels, mType, family.mType)
+		return
+	}
+
+	family.samples = append(family.samples, name+labels+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// write writes every family with its type once. Samples of family are sorted by labels.
+func (f *prometheusFamilies) write(w *bufio.Writer) {
+	for _, family := range f.families {
+		sort.Strings(family.samples)
+
+		w.WriteString("# TYPE " + family.name + " " + family.mType + "\n")
+		for _, sample := range family.samples {
+			w.WriteString(sample + "\n")
+		}
+	}
+}
+
+// prometheusName replaces symbols not allowed in Prometheus names by underscores.
+func prometheusName(id string) string {
+	b := []byte(id)
+	for i, c := range b {
+		if !(c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
+
+// prometheusLabels formats labels sorted by name, e.g. `{host="a",zone="b"}`.
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		// Colons are reserved in label names.
+		name := strings.ReplaceAll(prometheusName(k), ":", "_")
+		pairs = append(pairs, name+`="`+prometheusEscaper.Replace(v)+`"`)
+	}
+	sort.Strings(pairs)
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// totals converts accumulated values of counters reported by external systems, e.g. Prometheus, into increments
+// of stored counters. Is concurrent-safe: batches of different counters are stored in parallel, batches sharing
+// counters wait for each other.
+type totals struct {
+	last map[[2]string]int64
+	// Counters being stored, their channel is closed when storing ends.
+	held map[[2]string]chan struct{}
+	mu   sync.Mutex
+}
+
+// Constructor.
+func newTotals() *totals {
+	return &totals{
+		last: map[[2]string]int64{},
+		held: map[[2]string]chan struct{}{},
+	}
+}
+
+// store replaces accumulated values of counters of batch by their increments and stores batch. The first value
+// of counter is counted from its stored value given by get, decrease of value is treated as restart of reporting process.
+// Values are remembered only if storing succeeds. If cumulative is defined, only counters marked in it keep
+// accumulated values, others keep increments already.
+func (t *totals) store(get func(ids []string) ([]*metric.Metric, error), tenant string, batch []*metric.Metric, cumulative []bool, store func() error) error {
+	isTotal := func(i int) bool {
+		return batch[i].MType == Counter && batch[i].Delta != nil && (cumulative == nil || cumulative[i])
+	}
+
+	keys := [][2]string{}
+	for i, m := range batch {
+		if isTotal(i) {
+			keys = append(keys, [2]string{tenant, m.ID})
+		}
+	}
+
+	release := t.hold(keys)
+	defer release()
+
+	t.mu.Lock()
+	unknown := []string{}
+	prevs := map[string]int64{}
+	for _, key := range keys {
+		if prev, ok := t.last[key]; ok {
+			prevs[key[1]] = prev
+		} else {
+			unknown = append(unknown, key[1])
+		}
+	}
+	t.mu.Unlock()
+
+	if len(unknown) > 0 {
+		stored, err := get(unknown)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range stored {
+			if _, ok := prevs[m.ID]; !ok && m.MType == Counter && m.Delta != nil {
+				prevs[m.ID] = *m.Delta
+			}
+		}
+	}
+
+	reported := map[string]int64{}
+	for i, m := range batch {
+		if !isTotal(i) {
+			continue
+		}
+
+		total := *m.Delta
+		reported[m.ID] = total
+
+		increment := total - prevs[m.ID]
+		if increment < 0 {
+			increment = total
+		}
+		m.Delta = &increment
+	}
+
+	if err := store(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, total := range reported {
+		t.last[[2]string{tenant, id}] = total
+	}
+
+	return nil
+}
+
+// hold waits until none of counters is stored by other batches and marks them as stored. Counters are held
+// all at once, so batches can't deadlock. Gives function releasing them.
+func (t *totals) hold(keys [][2]string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for {
+		var busy chan struct{}
+		for _, key := range keys {
+			if ch, ok := t.held[key]; ok {
+				busy = ch
+				break
+			}
+		}
+
+		if busy == nil {
+			break
+		}
+
+		t.mu.Unlock()
+		<-busy
+		t.mu.Lock()
+	}
+
+	done := make(chan struct{})
+	for _, key := range keys {
+		t.held[key] = done
+	}
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		for _, key := range keys {
+			delete(t.held, key)
+		}
+		close(done)
+	}
+}
+
+// forget removes remembered value of counter, e.g. after deletion.
+func (t *totals) forget(tenant, id string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.last, [2]string{tenant, id})
+}
+
+// roundTotal gives accumulated value of counter reported as float, e.g. by Prometheus, as integer.
+func roundTotal(v float64) int64 {
+	return int64(math.Round(v))
+}
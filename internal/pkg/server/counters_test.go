@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_trackCounters(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	epoch := time.Now().Add(-time.Hour).UnixMilli()
+	for i, body := range []string{
+		`{"id":"PollCount","type":"counter","delta":5,"epoch":` + strconv.FormatInt(epoch, 10) + `}`,
+		`{"id":"PollCount","type":"counter","delta":3,"epoch":` + strconv.FormatInt(epoch, 10) + `}`,
+		`{"id":"PollCount","type":"counter","delta":1,"epoch":` + strconv.FormatInt(epoch+1, 10) + `}`,
+	} {
+		url := "/t/team/update/"
+		if i == 2 {
+			url = "/t/team/api/v1/update"
+		}
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", url, strings.NewReader(body))).Code)
+	}
+
+	rec := serve(srv, httptest.NewRequest("GET", "/t/team/api/query?type=counter", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := queryResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Len(t, res.Metrics, 1)
+
+	s := res.Metrics[0].Counter
+	assert.NotNil(t, s)
+	assert.Equal(t, 9.0, s.Value)
+	assert.Equal(t, 8.0, s.Prev)
+	assert.Equal(t, int64(1), s.Resets)
+	assert.Equal(t, epoch+1, s.Epoch)
+	assert.NotNil(t, s.Rate)
+	assert.Zero(t, res.Metrics[0].Epoch)
+
+	// Epochs aren't kept by storage.
+	m, err := srv.storage.ForTenant("team").GetMetric("PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), *m.Delta)
+	assert.Zero(t, m.Epoch)
+
+	rec = serve(srv, httptest.NewRequest("DELETE", "/t/team/value/counter/PollCount", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	_, ok := srv.counters.Get("team", "PollCount")
+	assert.False(t, ok)
+}
+
+func Test_handlerPrometheus(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	body := `[{"id":"HeapAlloc","type":"gauge","value":1.5,"labels":{"host":"a\"b","zone":"x"}},` +
+		`{"id":"cpu.util-1","type":"gauge","value":20},` +
+		`{"id":"PollCount","type":"counter","delta":5,"epoch":1}]`
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/api/v1/updates", strings.NewReader(body))).Code)
+
+	// Rate is known after the second update.
+	srv.counters.Observe("", "PollCount", 0, 1, time.Now().Add(-2*time.Second))
+	srv.counters.Observe("", "PollCount", 5, 1, time.Now())
+
+	rec := serve(srv, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, prometheusContentType, rec.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	assert.Equal(t, []string{
+		"# TYPE HeapAlloc gauge",
+		`HeapAlloc{host="a\"b",zone="x"} 1.5`,
+		"# TYPE PollCount counter",
+		"PollCount 5",
+		"# TYPE PollCount_rate gauge",
+		lines[5],
+		"# TYPE PollCount_resets counter",
+		"PollCount_resets 0",
+		"# TYPE cpu_util_1 gauge",
+		"cpu_util_1 20",
+	}, lines)
+	assert.True(t, strings.HasPrefix(lines[5], "PollCount_rate 2."), lines[5])
+
+	rec = serve(srv, httptest.NewRequest("GET", "/t/other/metrics", nil))
+	assert.Empty(t, rec.Body.String())
+}
+
+func Test_handlerPrometheusFamilies(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	body := "cpu,host=b usage=2\ncpu,host=a usage=1\nmem free=3"
+	assert.Equal(t, http.StatusNoContent, serve(srv, httptest.NewRequest("POST", "/write", strings.NewReader(body))).Code)
+
+	rec := serve(srv, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Series of the same name are one family sorted by labels.
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	assert.Equal(t, []string{
+		"# TYPE cpu_usage gauge",
+		`cpu_usage{host="a"} 1`,
+		`cpu_usage{host="b"} 2`,
+		"# TYPE mem_free gauge",
+		"mem_free 3",
+	}, lines)
+}
+
+func Test_takeEpochs(t *testing.T) {
+	batch := []*metric.Metric{{ID: "PollCount", Epoch: 7}, {ID: "Alloc"}}
+
+	assert.Equal(t, []int64{7, 0}, takeEpochs(batch))
+	assert.Zero(t, batch[0].Epoch)
+}
+
+func Test_totalsStore(t *testing.T) {
+	tt := newTotals()
+	none := func(ids []string) ([]*metric.Metric, error) { return nil, nil }
+	total := func(id string, v int64) []*metric.Metric {
+		return []*metric.Metric{{ID: id, MType: Counter, Delta: &v}}
+	}
+
+	// Storing of counter a is blocked until released.
+	blocked, release := make(chan struct{}), make(chan struct{})
+	first := total("a", 5)
+	stored := make(chan error, 1)
+	go func() {
+		stored <- tt.store(none, "team", first, nil, func() error {
+			close(blocked)
+			<-release
+			return nil
+		})
+	}()
+	<-blocked
+
+	// Other counters are stored meanwhile.
+	other := total("b", 2)
+	assert.NoError(t, tt.store(none, "team", other, nil, func() error { return nil }))
+	assert.Equal(t, int64(2), *other[0].Delta)
+
+	// The same counter waits for the first batch and is counted from its value.
+	second := total("a", 7)
+	waited := make(chan error, 1)
+	go func() {
+		waited <- tt.store(none, "team", second, nil, func() error { return nil })
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("counter is stored concurrently")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-stored)
+	assert.NoError(t, <-waited)
+	assert.Equal(t, int64(5), *first[0].Delta)
+	assert.Equal(t, int64(2), *second[0].Delta)
+}
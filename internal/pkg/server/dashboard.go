@@ -0,0 +1,280 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/history"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+const (
+	// Period of dashboard auto-refresh.
+	dashboardRefresh = 10 * time.Second
+
+	sparklineWidth  = 120
+	sparklineHeight = 24
+
+	// Default period of sampling metrics to history.
+	defaultHistoryInterval = 10 * time.Second
+)
+
+// Runtime metrics measured in bytes, shown in human-readable units.
+var byteMetrics = map[string]bool{
+	"Alloc":        true,
+	"BuckHashSys":  true,
+	"FreeMemory":   true,
+	"GCSys":        true,
+	"HeapAlloc":    true,
+	"HeapIdle":     true,
+	"HeapInuse":    true,
+	"HeapReleased": true,
+	"HeapSys":      true,
+	"MCacheInuse":  true,
+	"MCacheSys":    true,
+	"MSpanInuse":   true,
+	"MSpanSys":     true,
+	"NextGC":       true,
+	"OtherSys":     true,
+	"StackInuse":   true,
+	"StackSys":     true,
+	"Sys":          true,
+	"TotalAlloc":   true,
+	"TotalMemory":  true,
+}
+
+// dashboardRow is metric as shown by dashboard.
+type dashboardRow struct {
+	ID        string
+	MType     string
+	Prefix    string
+	Value     string
+	Labels    string
+	Sparkline string
+	Raw       float64
+}
+
+// dashboardView is the data of dashboard page.
+type dashboardView struct {
+	Tenant          string
+	Updated         string
+	Rows            []dashboardRow
+	Refresh         int
+	SparklineWidth  int
+	SparklineHeight int
+}
+
+// Outputs dashboard of request tenant metrics as HTML page.
+func (srv *server) handlerGetAll(w http.ResponseWriter, r *http.Request) {
+	st, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	allMetrics, err := st.GetBatch()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(allMetrics, func(i, j int) bool {
+		return allMetrics[i].ID < allMetrics[j].ID
+	})
+
+	view := dashboardView{
+		Tenant:          tenant,
+		Updated:         time.Now().Format("15:04:05"),
+		Rows:            make([]dashboardRow, 0, len(allMetrics)),
+		Refresh:         int(dashboardRefresh / time.Second),
+		SparklineWidth:  sparklineWidth,
+		SparklineHeight: sparklineHeight,
+	}
+
+	for _, m := range allMetrics {
+		view.Rows = append(view.Rows, dashboardRow{
+			ID:        m.ID,
+			MType:     m.MType,
+			Prefix:    metricPrefix(m.ID),
+			Value:     formatValue(m),
+			Labels:    formatLabels(m.Labels),
+			Sparkline: sparkline(srv.history.Get(tenant, m.ID)),
+			Raw:       m.SortValue(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := dashboardTemplate.Execute(w, view); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// initHistory initializes history of metric values. Negative HistorySize turns history off.
+func (srv *server) initHistory() {
+	if srv.config.HistorySize < 0 {
+		return
+	}
+
+	srv.history = history.New(srv.config.HistorySize)
+}
+
+// recordHistory samples metrics of all tenants to history until server shutdown.
+func (srv *server) recordHistory() {
+	interval := srv.config.HistoryInterval
+	if interval <= 0 {
+		interval = defaultHistoryInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := srv.sampleHistory(now); err != nil {
+				log.Println(err)
+			}
+		case <-srv.shutdown:
+			return
+		}
+	}
+}
+
+// sampleHistory records current values of metrics of all tenants. Counters are recorded by accumulated value.
+func (srv *server) sampleHistory(now time.Time) error {
+	tenants, err := srv.storage.Tenants()
+	if err != nil {
+		return err
+	}
+
+	for _, tenant := range tenants {
+		batch, err := srv.storage.ForTenant(tenant).GetBatch()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range batch {
+			srv.history.Add(tenant, m.ID, now, m.SortValue())
+		}
+	}
+
+	return nil
+}
+
+// formatValue gives human-readable value of metric. Byte-sized runtime metrics are given in binary units.
+func formatValue(m *metric.Metric) string {
+	if m.MType == Counter && m.Delta != nil {
+		return strconv.FormatInt(*m.Delta, 10)
+	}
+
+	if m.Value == nil {
+		return ""
+	}
+
+	if byteMetrics[m.ID] {
+		return formatBytes(*m.Value)
+	}
+
+	return formatFloat(*m.Value)
+}
+
+// formatFloat gives integers as they are and other numbers with 3 decimals.
+func formatFloat(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
+
+// formatBytes gives size in binary units, e.g. "1.5 MiB".
+func formatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+	i := 0
+	for math.Abs(v) >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return formatFloat(v) + " " + units[i]
+	}
+
+	return strconv.FormatFloat(v, 'f', 1, 64) + " " + units[i]
+}
+
+// formatLabels gives labels sorted by key in format "k1=v1, k2=v2".
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ", ")
+}
+
+// metricPrefix gives group of metric name: part before the first separator, digit or lower-to-upper case boundary,
+// e.g. "Heap" for "HeapAlloc", "CPUutilization" for "CPUutilization1" and "db" for "db.queries".
+func metricPrefix(id string) string {
+	runes := []rune(id)
+
+	for i, r := range runes {
+		switch {
+		case i == 0:
+		case strings.ContainsRune("._:/-", r),
+			unicode.IsDigit(r),
+			unicode.IsUpper(r) && unicode.IsLower(runes[i-1]):
+			return string(runes[:i])
+		}
+	}
+
+	return id
+}
+
+// sparkline gives points of SVG polyline drawing samples. Less than two samples give no line.
+func sparkline(samples []history.Sample) string {
+	if len(samples) < 2 {
+		return ""
+	}
+
+	lo, hi := samples[0].Value, samples[0].Value
+	for _, s := range samples {
+		lo = math.Min(lo, s.Value)
+		hi = math.Max(hi, s.Value)
+	}
+
+	step := float64(sparklineWidth) / float64(len(samples)-1)
+	points := make([]string, 0, len(samples))
+
+	for i, s := range samples {
+		y := float64(sparklineHeight) / 2
+		if hi > lo {
+			y = float64(sparklineHeight) - (s.Value-lo)/(hi-lo)*float64(sparklineHeight)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", float64(i)*step, y))
+	}
+
+	return strings.Join(points, " ")
+}
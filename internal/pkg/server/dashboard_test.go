@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/history"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handlerGetAll(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+	srv.history = history.New(3)
+
+	for _, url := range []string{
+		"/t/team/update/gauge/HeapAlloc/1048576",
+		"/t/team/update/counter/PollCount/2",
+		"/t/team/update/gauge/Random/0.5",
+	} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", url, nil)).Code)
+	}
+
+	now := time.Now()
+	assert.NoError(t, srv.sampleHistory(now))
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/team/update/counter/PollCount/3", nil)).Code)
+	assert.NoError(t, srv.sampleHistory(now.Add(time.Second)))
+
+	rec := serve(srv, httptest.NewRequest("GET", "/t/team/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `data-id="HeapAlloc" data-type="gauge" data-prefix="Heap"`)
+	assert.Contains(t, body, ">1.0 MiB<")
+	assert.Contains(t, body, ">5<")
+	assert.Contains(t, body, ">0.500<")
+	assert.Equal(t, 3, strings.Count(body, "<polyline"))
+	assert.Contains(t, body, `points="0.0,24.0 120.0,0.0"`)
+	assert.NotContains(t, body, "0xc0")
+	assert.NotContains(t, body, "http://")
+	assert.NotContains(t, body, "https://")
+
+	rec = serve(srv, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "No metrics yet.")
+}
+
+func Test_handlerGetAllEscaping(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	value := 1.0
+	assert.NoError(t, srv.storage.UpdateMetric(&metric.Metric{
+		ID:     "Alloc",
+		MType:  Gauge,
+		Value:  &value,
+		Labels: map[string]string{"host": "<script>alert(1)</script>"},
+	}))
+
+	rec := serve(srv, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "<script>alert(1)</script>")
+	assert.Contains(t, rec.Body.String(), "&lt;script&gt;")
+}
+
+func Test_formatValue(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	delta := func(d int64) *int64 { return &d }
+
+	tests := []struct {
+		Metric   *metric.Metric
+		Name     string
+		Expected string
+	}{
+		{Name: "bytes", Metric: &metric.Metric{ID: "HeapSys", MType: Gauge, Value: value(1536)}, Expected: "1.5 KiB"},
+		{Name: "small bytes", Metric: &metric.Metric{ID: "Alloc", MType: Gauge, Value: value(512)}, Expected: "512 B"},
+		{Name: "large bytes", Metric: &metric.Metric{ID: "TotalMemory", MType: Gauge, Value: value(16 << 30)}, Expected: "16.0 GiB"},
+		{Name: "integer gauge", Metric: &metric.Metric{ID: "NumGC", MType: Gauge, Value: value(42)}, Expected: "42"},
+		{Name: "fractional gauge", Metric: &metric.Metric{ID: "GCCPUFraction", MType: Gauge, Value: value(0.12345)}, Expected: "0.123"},
+		{Name: "counter", Metric: &metric.Metric{ID: "PollCount", MType: Counter, Delta: delta(7), Value: value(0)}, Expected: "7"},
+		{Name: "no value", Metric: &metric.Metric{ID: "Alloc", MType: Gauge}, Expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.Expected, formatValue(tt.Metric))
+		})
+	}
+}
+
+func Test_metricPrefix(t *testing.T) {
+	tests := []struct {
+		ID       string
+		Expected string
+	}{
+		{ID: "HeapAlloc", Expected: "Heap"},
+		{ID: "GCSys", Expected: "GCSys"},
+		{ID: "NumGC", Expected: "Num"},
+		{ID: "CPUutilization12", Expected: "CPUutilization"},
+		{ID: "db.queries", Expected: "db"},
+		{ID: "http_requests_total", Expected: "http"},
+		{ID: "Alloc", Expected: "Alloc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ID, func(t *testing.T) {
+			assert.Equal(t, tt.Expected, metricPrefix(tt.ID))
+		})
+	}
+}
+
+func Test_sparkline(t *testing.T) {
+	now := time.Now()
+
+	assert.Empty(t, sparkline(nil))
+	assert.Empty(t, sparkline([]history.Sample{{Time: now, Value: 1}}))
+	assert.Equal(t, "0.0,12.0 60.0,12.0 120.0,12.0", sparkline([]history.Sample{{Value: 2}, {Value: 2}, {Value: 2}}))
+	assert.Equal(t, "0.0,24.0 60.0,0.0 120.0,12.0", sparkline([]history.Sample{{Value: 0}, {Value: 10}, {Value: 5}}))
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/federation"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var errSourceMissing = errors.New("federation source is not defined")
+
+const defaultFederateInterval = 15 * time.Second
+
+// initFederation initializes pulling metrics from children and pushing them to parent if config defines them.
+// Source name of pushes is host name by default.
+func (srv *server) initFederation() error {
+	for i := range srv.config.FederateChildren {
+		if err := srv.config.FederateChildren[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if len(srv.config.FederateChildren) == 0 && srv.config.FederatePush == "" {
+		return nil
+	}
+
+	if srv.config.FederateSource == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		srv.config.FederateSource = host
+	}
+
+	srv.federation = federation.NewClient(srv.config.HashKey)
+
+	return nil
+}
+
+// federate periodically pulls metrics of children and pushes metrics of default tenant to parent.
+func (srv *server) federate() {
+	interval := srv.config.FederateInterval
+	if interval <= 0 {
+		interval = defaultFederateInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Requests to children and parent are cancelled on shutdown.
+	ctx, cancel := srv.shutdownContext()
+	defer cancel()
+
+	for {
+		select {
+		case <-ticker.C:
+			srv.pullChildren(ctx)
+			srv.pushParent(ctx)
+		case <-srv.shutdown:
+			return
+		}
+	}
+}
+
+// pullChildren merges metrics of every child into its tenant.
+func (srv *server) pullChildren(ctx context.Context) {
+	for _, child := range srv.config.FederateChildren {
+		if ctx.Err() != nil {
+			return
+		}
+
+		p, err := srv.federation.Pull(ctx, child)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		batch, err := federationBatch(child.Name, p.Metrics)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if err := srv.storeTenantTotals(ctx, child.Tenant, batch, nil); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// pushParent sends metrics of default tenant to parent.
+func (srv *server) pushParent(ctx context.Context) {
+	if srv.config.FederatePush == "" {
+		return
+	}
+
+	p, err := srv.federationPayload(srv.storage.ForTenant(metric.DefaultTenant), metric.DefaultTenant, metric.Query{SortBy: metric.SortByName})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	p.Source = srv.config.FederateSource
+
+	endpoint := strings.TrimSuffix(srv.config.FederatePush, "/") + federation.Path
+	if err := srv.federation.Push(ctx, endpoint, srv.config.FederatePushHeaders, p); err != nil {
+		log.Println(err)
+	}
+}
+
+// Outputs metrics of request tenant with labels and times of their latest updates for federation. Metrics are
+// selected by the same parameters as of query, but all matching metrics are given unless "limit" is defined.
+func (srv *server) handlerGetFederate(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if r.URL.Query().Get("limit") == "" {
+		q.Limit = 0
+	}
+
+	st, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	p, err := srv.federationPayload(st, tenant, q)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p)
+}
+
+// Merges pushed payload of child into request tenant. Counters keep accumulated values, so only their increase
+// since the previous payload of the same source is applied.
+func (srv *server) handlerPostFederate(w http.ResponseWriter, r *http.Request) {
+	p := federation.Payload{}
+	if err := decodeJSON(r, &p); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if p.Source == "" {
+		writeAPIError(w, errSourceMissing, nil)
+		return
+	}
+
+	batch, err := federationBatch(p.Source, p.Metrics)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if err := srv.storeTotals(r, batch, nil); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// federationPayload gives metrics of tenant selected by query.
+func (srv *server) federationPayload(st metric.MetricStorage, tenant string, q metric.Query) (*federation.Payload, error) {
+	batch, err := queryStorage(st, q)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &federation.Payload{
+		Time:    time.Now().UnixMilli(),
+		Metrics: make([]federation.Sample, 0, len(batch)),
+	}
+
+	for _, m := range batch {
+		s := federation.Sample{
+			Name:   seriesName(m),
+			ID:     m.ID,
+			MType:  m.MType,
+			Labels: m.Labels,
+			Delta:  m.Delta,
+			Value:  m.Value,
+		}
+
+		if t, ok := srv.updated.get(tenant, m.ID); ok {
+			s.Timestamp = t.UnixMilli()
+		}
+
+		p.Metrics = append(p.Metrics, s)
+	}
+
+	return p, nil
+}
+
+// federationBatch maps samples of source to metrics labeled by source. Counters keep accumulated values.
+// Repeated series keep the last sample.
+func federationBatch(source string, samples []federation.Sample) ([]*metric.Metric, error) {
+	batch := make([]*metric.Metric, 0, len(samples))
+	index := map[string]int{}
+
+	for _, s := range samples {
+		name := s.Name
+		if name == "" {
+			name = s.ID
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%w: sample without name", federation.ErrInvalidPayload)
+		}
+
+		labels := federation.Labels(source, s.Labels)
+		m := &metric.Metric{ID: seriesID(name, labels), MType: s.MType, Labels: labels}
+
+		switch {
+		case s.MType == Counter && s.Delta != nil:
+			total := *s.Delta
+			m.Delta = &total
+		case s.MType == Gauge && s.Value != nil:
+			value := *s.Value
+			m.Value = &value
+		default:
+			return nil, fmt.Errorf("%w: sample %q of type %q without value", federation.ErrInvalidPayload, name, s.MType)
+		}
+
+		if i, ok := index[m.ID]; ok {
+			batch[i] = m
+			continue
+		}
+
+		index[m.ID] = len(batch)
+		batch = append(batch, m)
+	}
+
+	return batch, nil
+}
+
+// updateTimes keeps times of the latest updates of metrics. Is concurrent-safe, nil value keeps nothing.
+type updateTimes struct {
+	times map[[2]string]time.Time
+	mu    sync.RWMutex
+}
+
+// Constructor.
+func newUpdateTimes() *updateTimes {
+	return &updateTimes{
+		times: map[[2]string]time.Time{},
+	}
+}
+
+// touch remembers update time of metrics of batch.
+func (u *updateTimes) touch(tenant string, batch []*metric.Metric, now time.Time) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, m := range batch {
+		u.times[[2]string{tenant, m.ID}] = now
+	}
+}
+
+// get gives time of the latest update of metric.
+func (u *updateTimes) get(tenant, id string) (time.Time, bool) {
+	if u == nil {
+		return time.Time{}, false
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	t, ok := u.times[[2]string{tenant, id}]
+
+	return t, ok
+}
+
+// forget removes update time of metric, e.g. after deletion.
+func (u *updateTimes) forget(tenant, id string) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.times, [2]string{tenant, id})
+}
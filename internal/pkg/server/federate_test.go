@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/federation"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handlerGetFederate(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	for _, target := range []string{"/update/counter/jobs/3", "/update/gauge/load/0.5"} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", target, nil)).Code)
+	}
+
+	rec := serve(srv, httptest.NewRequest("GET", "/federate?type=counter", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	p := federation.Payload{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.NotZero(t, p.Time)
+	if assert.Len(t, p.Metrics, 1) {
+		assert.Equal(t, "jobs", p.Metrics[0].Name)
+		assert.Equal(t, int64(3), *p.Metrics[0].Delta)
+		assert.NotZero(t, p.Metrics[0].Timestamp)
+	}
+
+	rec = serve(srv, httptest.NewRequest("GET", "/federate?type=histogram", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func Test_federatePull(t *testing.T) {
+	child := newTestServer(t, serverConfig{})
+	ts := httptest.NewServer(child.server.Handler)
+	defer ts.Close()
+
+	srv := newTestServer(t, serverConfig{
+		FederateChildren: []federation.Child{{Name: "dc1", URL: ts.URL + "/t/team", Tenant: "global"}},
+	})
+	assert.NoError(t, srv.initFederation())
+
+	for _, target := range []string{"/t/team/update/counter/jobs/3", "/t/team/update/gauge/load/0.5"} {
+		assert.Equal(t, http.StatusOK, serve(child, httptest.NewRequest("POST", target, nil)).Code)
+	}
+
+	id := seriesID("jobs", map[string]string{federation.SourceLabel: "dc1"})
+	jobs := func() float64 {
+		m, err := srv.storage.ForTenant("global").GetMetric(id)
+		assert.NoError(t, err)
+
+		return m.SortValue()
+	}
+
+	// Repeated pulls of the same accumulated value don't add it again.
+	srv.pullChildren(context.Background())
+	srv.pullChildren(context.Background())
+	assert.Equal(t, 3.0, jobs())
+
+	assert.Equal(t, http.StatusOK, serve(child, httptest.NewRequest("POST", "/t/team/update/counter/jobs/4", nil)).Code)
+	srv.pullChildren(context.Background())
+	assert.Equal(t, 7.0, jobs())
+
+	m, err := srv.storage.ForTenant("global").GetMetric(seriesID("load", map[string]string{federation.SourceLabel: "dc1"}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0.5, m.SortValue())
+		assert.Equal(t, map[string]string{federation.SourceLabel: "dc1"}, m.Labels)
+	}
+
+	// Merged series are given to upper level with their names.
+	rec := serve(srv, httptest.NewRequest("GET", "/t/global/federate", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	p := federation.Payload{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	if assert.Len(t, p.Metrics, 2) {
+		assert.Equal(t, "jobs", p.Metrics[0].Name)
+		assert.Equal(t, "dc1", p.Metrics[0].Labels[federation.SourceLabel])
+	}
+}
+
+func Test_federatePush(t *testing.T) {
+	parent := newTestServer(t, serverConfig{})
+	ts := httptest.NewServer(parent.server.Handler)
+	defer ts.Close()
+
+	srv := newTestServer(t, serverConfig{FederatePush: ts.URL, FederateSource: "dc1"})
+	assert.NoError(t, srv.initFederation())
+
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/counter/jobs/3", nil)).Code)
+	srv.pushParent(context.Background())
+	srv.pushParent(context.Background())
+
+	id := seriesID("jobs", map[string]string{federation.SourceLabel: "dc1"})
+	jobs := func() float64 {
+		m, err := parent.storage.ForTenant("").GetMetric(id)
+		assert.NoError(t, err)
+
+		return m.SortValue()
+	}
+	assert.Equal(t, 3.0, jobs())
+
+	// Decrease of accumulated value means restart of child, so the whole value is added.
+	rec := serve(parent, httptest.NewRequest("POST", "/federate",
+		strings.NewReader(`{"source":"dc1","time":1,"metrics":[{"id":"jobs","name":"jobs","type":"counter","delta":2}]}`)))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, 5.0, jobs())
+
+	rec = serve(parent, httptest.NewRequest("POST", "/federate", strings.NewReader(`{"time":1,"metrics":[]}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(parent, httptest.NewRequest("POST", "/federate",
+		strings.NewReader(`{"source":"dc1","time":1,"metrics":[{"id":"jobs","type":"counter"}]}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_federateShutdown(t *testing.T) {
+	requested := make(chan struct{}, 10)
+
+	// Children answer only when request is cancelled.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	srv := newTestServer(t, serverConfig{
+		FederateChildren: []federation.Child{{Name: "dc1", URL: ts.URL}, {Name: "dc2", URL: ts.URL}},
+		FederateInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(t, srv.initFederation())
+
+	srv.goLoop(srv.federate)
+	<-requested
+
+	close(srv.shutdown)
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.loops.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("federation isn't stopped")
+	}
+
+	// Other children aren't requested after shutdown.
+	assert.Empty(t, requested)
+}
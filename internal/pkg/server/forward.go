@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/forward"
+)
+
+// initForwarder initializes forwarding of accepted updates to targets defined in config.
+func (srv *server) initForwarder() error {
+	if len(srv.config.Forward) == 0 {
+		return nil
+	}
+
+	forwarder, err := forward.New(srv.config.Forward, srv.config.HashKey, seriesName)
+	if err != nil {
+		return err
+	}
+
+	srv.forwarder = forwarder
+
+	return nil
+}
+
+// Outputs queue depth and delivery counters of every forwarding target.
+func (srv *server) handlerGetForwarding(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.forwarder.Stats())
+}
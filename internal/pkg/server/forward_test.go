@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/forward"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_forwardUpdates(t *testing.T) {
+	upstream := newTestServer(t, serverConfig{HashKey: "secret"})
+	ts := httptest.NewServer(upstream.server.Handler)
+	defer ts.Close()
+
+	srv := newTestServer(t, serverConfig{
+		HashKey: "secret",
+		Forward: []forward.Target{{
+			Name:          "global",
+			Kind:          forward.KindUpdates,
+			Address:       ts.URL + "/updates/",
+			FlushInterval: 10 * time.Millisecond,
+		}},
+	})
+	assert.NoError(t, srv.initForwarder())
+	srv.forwarder.Start()
+	defer srv.forwarder.Close()
+
+	jobs, more, load := int64(3), int64(4), 0.5
+	for _, batch := range [][]*metric.Metric{
+		{{ID: "jobs", MType: Counter, Delta: &jobs}, {ID: "load", MType: Gauge, Value: &load}},
+		{{ID: "jobs", MType: Counter, Delta: &more}},
+	} {
+		for _, m := range batch {
+			assert.NoError(t, m.UpdateHashVersion("secret", metric.LatestHashVersion))
+		}
+
+		body, err := json.Marshal(batch)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/t/team/api/v1/updates", bytes.NewReader(body))
+		req.Header.Set(HashVersionHeader, strconv.Itoa(metric.LatestHashVersion))
+		assert.Equal(t, http.StatusOK, serve(srv, req).Code)
+	}
+
+	// Upstream gets increments, so its counters are equal to local ones.
+	assert.Eventually(t, func() bool {
+		m, err := upstream.storage.ForTenant("team").GetMetric("jobs")
+		return err == nil && m.SortValue() == 7
+	}, time.Second, 10*time.Millisecond)
+
+	m, err := upstream.storage.ForTenant("team").GetMetric("load")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0.5, m.SortValue())
+	}
+
+	// Delivery is counted after upstream response.
+	assert.Eventually(t, func() bool {
+		return srv.forwarder.Stats()[0].Sent == 3
+	}, time.Second, 10*time.Millisecond)
+
+	rec := serve(srv, httptest.NewRequest("GET", "/forwarding", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	stats := []forward.Stats{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, "global", stats[0].Target)
+		assert.Equal(t, int64(3), stats[0].Sent)
+		assert.Equal(t, int64(0), stats[0].Dropped)
+	}
+
+	// Without targets nothing is forwarded.
+	rec = serve(newTestServer(t, serverConfig{}), httptest.NewRequest("GET", "/forwarding", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[]", rec.Body.String())
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/graphite"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+const (
+	// Maximal length of Graphite line. Longer lines are rejected.
+	graphiteMaxLine = 64 << 10
+
+	// Maximal number of Graphite lines stored at once.
+	graphiteBatchSize = 1000
+
+	// Connections sending nothing during this period are closed.
+	graphiteReadTimeout = 5 * time.Minute
+)
+
+var (
+	errLineTooLong             = errors.New("line is too long")
+	errGraphiteUnauthenticated = errors.New("graphite listener is unauthenticated, but tokens are defined")
+)
+
+// graphiteListener is listener of Graphite plaintext protocol keeping its open connections,
+// so they are closed with it.
+type graphiteListener struct {
+	net.Listener
+	conns  map[net.Conn]struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+// track adds connection to open ones. Gives false if listener is closed already.
+func (l *graphiteListener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (l *graphiteListener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.conns, conn)
+}
+
+// Close closes listener and its open connections.
+func (l *graphiteListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for conn := range l.conns {
+		if errClose := conn.Close(); errClose != nil {
+			log.Println(errClose)
+		}
+	}
+
+	return l.Listener.Close()
+}
+
+// deadlineReader reads connection with deadline renewed before every read.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+
+	return r.conn.Read(p)
+}
+
+// listenGraphite opens TCP listener of Graphite plaintext protocol. Listener isn't authenticated,
+// so it is refused if tokens are defined and it isn't allowed explicitly.
+func (srv *server) listenGraphite() error {
+	if srv.authenticator.Enabled() && !srv.config.GraphiteUnauthenticated {
+		return errGraphiteUnauthenticated
+	}
+
+	if !tenantNameRegexp.MatchString(srv.config.GraphiteTenant) {
+		return fmt.Errorf("%w: graphite tenant %q", errTenantInvalid, srv.config.GraphiteTenant)
+	}
+
+	ln, err := net.Listen("tcp", srv.config.GraphiteAddress)
+	if err != nil {
+		return err
+	}
+
+	srv.graphite = &graphiteListener{Listener: ln, conns: map[net.Conn]struct{}{}}
+
+	return nil
+}
+
+// acceptGraphite serves connections of listener until it is closed.
+func (srv *server) acceptGraphite(ln *graphiteListener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Println(err)
+			continue
+		}
+
+		if !ln.track(conn) {
+			if errClose := conn.Close(); errClose != nil {
+				log.Println(errClose)
+			}
+			return
+		}
+
+		srv.goLoop(func() {
+			defer ln.untrack(conn)
+			srv.serveGraphite(conn)
+		})
+	}
+}
+
+// serveGraphite stores lines of connection to Graphite tenant. Rejected lines are logged.
+func (srv *server) serveGraphite(conn net.Conn) {
+	defer func() {
+		if errClose := conn.Close(); errClose != nil && !errors.Is(errClose, net.ErrClosed) {
+			log.Println(errClose)
+		}
+	}()
+
+	remote := conn.RemoteAddr().String()
+
+	err := srv.readGraphite(deadlineReader{conn: conn, timeout: graphiteReadTimeout}, func(line int, err error) {
+		log.Printf("graphite %s: line %d: %v", remote, line, err)
+	})
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("graphite %s: %v", remote, err)
+	}
+}
+
+// readGraphite reads Graphite lines until the end of r and stores them in batches. Batch is stored when it is full
+// or when no more data is buffered. Every rejected line is reported with its number.
+func (srv *server) readGraphite(r io.Reader, report func(line int, err error)) error {
+	reader := bufio.NewReaderSize(r, graphiteMaxLine)
+
+	batch, lines := []*metric.Metric{}, []int{}
+	n := 0
+	skipping := false
+
+	for {
+		data, err := reader.ReadSlice('\n')
+
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			// Rest of too long line is skipped.
+			if !skipping {
+				n++
+				report(n, errLineTooLong)
+			}
+			skipping = true
+			continue
+		case skipping:
+			skipping = false
+		case len(data) > 0:
+			n++
+
+			line := strings.TrimSpace(string(data))
+			if line == "" {
+				break
+			}
+
+			p, parseErr := graphite.ParseLine(line, time.Now())
+			if parseErr != nil {
+				report(n, parseErr)
+				break
+			}
+
+			value := p.Value
+			batch = append(batch, &metric.Metric{
+				ID:     seriesID(p.Path, p.Tags),
+				MType:  Gauge,
+				Value:  &value,
+				Labels: p.Tags,
+			})
+			lines = append(lines, n)
+		}
+
+		if len(batch) > 0 && (err != nil || reader.Buffered() == 0 || len(batch) >= graphiteBatchSize) {
+			if storeErr := srv.storeGraphite(batch, lines, report); storeErr != nil {
+				return storeErr
+			}
+			batch, lines = batch[:0], lines[:0]
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// storeGraphite stores batch of Graphite lines to Graphite tenant. Lines of rejected metrics are reported.
+func (srv *server) storeGraphite(batch []*metric.Metric, lines []int, report func(line int, err error)) error {
+	errs := make([]error, len(batch))
+	if err := srv.storeTenantEach(context.Background(), srv.config.GraphiteTenant, batch, errs); err != nil {
+		return err
+	}
+
+	for i := range batch {
+		if errs[i] != nil && !errors.Is(errs[i], errMetricDropped) {
+			report(lines[i], fmt.Errorf("%s: %w", batch[i].ID, errs[i]))
+		}
+	}
+
+	return nil
+}
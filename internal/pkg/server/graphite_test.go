@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/graphite"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readGraphite(t *testing.T) {
+	srv := newTestServer(t, serverConfig{MaxMetrics: 3})
+
+	data := "servers.web-1.cpu 0.5 1700000000\n" +
+		"servers.web-1.mem\n" +
+		"\n" +
+		"servers.web-1.cpu;dc=eu 0.75\n" +
+		strings.Repeat("x", graphiteMaxLine+10) + " 1\n" +
+		"bad name 1\n" +
+		"servers.web-2.cpu 1 -1\n" +
+		"servers.web-3.cpu 1\n" +
+		"servers.web-1.cpu 0.6"
+
+	reported := map[int]error{}
+	err := srv.readGraphite(strings.NewReader(data), func(line int, err error) {
+		reported[line] = err
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, reported, 4)
+	assert.ErrorIs(t, reported[2], graphite.ErrInvalidLine)
+	assert.ErrorIs(t, reported[5], errLineTooLong)
+	assert.ErrorIs(t, reported[6], graphite.ErrInvalidLine)
+	assert.ErrorIs(t, reported[8], errCardinalityExceeded)
+
+	tests := []struct {
+		Labels   map[string]string
+		Name     string
+		Expected float64
+	}{
+		{Name: "servers.web-1.cpu", Expected: 0.6},
+		{Name: "servers.web-1.cpu", Labels: map[string]string{"dc": "eu"}, Expected: 0.75},
+		{Name: "servers.web-2.cpu", Expected: 1},
+	}
+
+	for _, tt := range tests {
+		m, err := srv.storage.GetMetric(seriesID(tt.Name, tt.Labels))
+		if assert.NoError(t, err, tt.Name) {
+			assert.Equal(t, Gauge, m.MType)
+			assert.Equal(t, tt.Expected, m.SortValue())
+			assert.Equal(t, tt.Labels, m.Labels)
+		}
+	}
+}
+
+func Test_graphiteListener(t *testing.T) {
+	srv := newTestServer(t, serverConfig{GraphiteAddress: "127.0.0.1:0", GraphiteTenant: "team"})
+
+	assert.NoError(t, srv.listenGraphite())
+	go srv.acceptGraphite(srv.graphite)
+	defer srv.graphite.Close()
+
+	conn, err := net.Dial("tcp", srv.graphite.Addr().String())
+	assert.NoError(t, err)
+
+	_, err = conn.Write([]byte("app.requests 42 1700000000\napp.errors oops\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	assert.Eventually(t, func() bool {
+		m, err := srv.storage.ForTenant("team").GetMetric("app.requests")
+		return err == nil && m.SortValue() == 42
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = srv.storage.ForTenant("team").GetMetric("app.errors")
+	assert.Error(t, err)
+
+	_, err = srv.storage.GetMetric("app.requests")
+	assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+}
+
+func Test_listenGraphite(t *testing.T) {
+	tokens := []auth.Token{{Token: "writer", Scopes: []string{auth.ScopeWrite}}}
+
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Config        serverConfig
+	}{
+		{Name: "without tokens", Config: serverConfig{}},
+		{Name: "with tokens", Config: serverConfig{Tokens: tokens}, ExpectedError: errGraphiteUnauthenticated},
+		{Name: "allowed with tokens", Config: serverConfig{Tokens: tokens, GraphiteUnauthenticated: true}},
+		{Name: "invalid tenant", Config: serverConfig{GraphiteTenant: "a b"}, ExpectedError: errTenantInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			tt.Config.GraphiteAddress = "127.0.0.1:0"
+			srv := newTestServer(t, tt.Config)
+
+			err := srv.listenGraphite()
+			if tt.ExpectedError != nil {
+				assert.ErrorIs(t, err, tt.ExpectedError)
+				assert.Nil(t, srv.graphite)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NoError(t, srv.graphite.Close())
+		})
+	}
+}
+
+func Test_graphiteListenerClose(t *testing.T) {
+	srv := newTestServer(t, serverConfig{GraphiteAddress: "127.0.0.1:0"})
+	assert.NoError(t, srv.listenGraphite())
+
+	done := make(chan struct{})
+	go func() {
+		srv.acceptGraphite(srv.graphite)
+		srv.loops.Wait()
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", srv.graphite.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("app.requests 42\n"))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := srv.storage.GetMetric("app.requests")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Open connection doesn't keep listener from closing.
+	assert.NoError(t, srv.graphite.Close())
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("graphite connection is not closed")
+	}
+}
+
+func Test_deadlineReader(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	_, err := deadlineReader{conn: server, timeout: 10 * time.Millisecond}.Read(make([]byte, 10))
+
+	var netErr net.Error
+	if assert.ErrorAs(t, err, &netErr) {
+		assert.True(t, netErr.Timeout())
+	}
+}
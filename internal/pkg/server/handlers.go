@@ -1,29 +1,40 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
-	"sort"
 	"strconv"
-	"text/template"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/go-chi/chi"
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/cluster"
+	"github.com/goslammu/yp_go_devops/internal/pkg/dump"
+	"github.com/goslammu/yp_go_devops/internal/pkg/federation"
+	"github.com/goslammu/yp_go_devops/internal/pkg/graphite"
+	"github.com/goslammu/yp_go_devops/internal/pkg/influx"
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/openapi"
+	"github.com/goslammu/yp_go_devops/internal/pkg/otlp"
+	"github.com/goslammu/yp_go_devops/internal/pkg/prompb"
 )
 
 var (
 	errUnsupportedType    = errors.New("unsupported type")
 	errInconsistentHashes = errors.New("inconsistent hashes")
 	errInvalidFormat      = errors.New("invalid format")
+	errHashVersionTooOld  = errors.New("hash version is not accepted anymore")
+	errHashMissing        = errors.New("hash is required")
 )
 
 const (
-	templateHandlerGetAll = "METRICS LIST: <p>{{range .}}{{.ID}}: {{.Value}}{{.Delta}} ({{.MType}})</p>{{end}}"
-	storageIsAvailable    = "STORAGE IS AVAILABLE"
+	storageIsAvailable = "STORAGE IS AVAILABLE"
 )
 
 // List of metric types which could be handled and storaged by server.
@@ -43,6 +54,14 @@ const (
 	HTTPStr     = "http://"
 )
 
+const (
+	// Request header with version of hashes encoding. If is not defined, legacy version is used.
+	HashVersionHeader = "Hash-Version"
+
+	// Response header with list of hash versions accepted by server.
+	AcceptHashVersionsHeader = "Accept-Hash-Versions"
+)
+
 // Checks connection from server to storage.
 func (srv *server) handlerCheckConnection(w http.ResponseWriter, r *http.Request) {
 	if err := srv.storage.AccessCheck(); err != nil {
@@ -75,13 +94,26 @@ func (srv *server) handlerUpdateBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := srv.limits.checkBatchLength(len(batch)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	hashVersion, err := srv.hashVersion(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	for i := range batch {
 		if batch[i].Hash == "" {
 			log.Println(errInvalidFormat)
 			http.Error(w, errInvalidFormat.Error(), http.StatusBadRequest)
 			return
 		}
-		if _, err := srv.checkHash(batch[i]); err != nil {
+		if _, err := srv.checkHash(batch[i], hashVersion); err != nil {
 			log.Println(err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -89,14 +121,16 @@ func (srv *server) handlerUpdateBatch(w http.ResponseWriter, r *http.Request) {
 		batch[i].Hash = ""
 	}
 
-	if err := srv.storage.UpdateBatch(batch); err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Batch spanning several nodes of cluster is stored by parts, result of every part is reported.
+	if results, ok := srv.storeShards(r, batch); ok {
+		writeJSON(w, shardsStatus(results), results)
 		return
 	}
 
-	if srv.config.StoreInterval == 0 {
-		srv.fileUpload()
+	if err := srv.storeMetrics(r, batch...); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
 	}
 }
 
@@ -116,8 +150,22 @@ func (srv *server) handlerUpdateJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Header.Get("Hash") != "" && srv.config.HashKey != "" {
-		resHash, err := srv.checkHash(&m)
+	// Server with key accepts signed metrics only, so minimal hash version can't be bypassed.
+	if srv.config.HashKey != "" {
+		if r.Header.Get("Hash") == "" {
+			log.Println(errHashMissing)
+			http.Error(w, errHashMissing.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hashVersion, err := srv.hashVersion(r)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resHash, err := srv.checkHash(&m, hashVersion)
 		w.Header().Set("Hash", resHash)
 		if err != nil {
 			log.Println(err)
@@ -133,15 +181,11 @@ func (srv *server) handlerUpdateJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := srv.storage.UpdateMetric(&m); err != nil {
+	if err := srv.storeMetrics(r, &m); err != nil {
 		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
-
-	if srv.config.StoreInterval == 0 {
-		srv.fileUpload()
-	}
 }
 
 // Updates individual metric kept in URL in format "/type/id/value".
@@ -169,48 +213,16 @@ func (srv *server) handlerUpdateDirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mName := chi.URLParam(r, "name")
-
-	if err := srv.storage.UpdateMetric(&metric.Metric{
-		ID:    mName,
+	m := &metric.Metric{
+		ID:    chi.URLParam(r, "name"),
 		MType: mType,
 		Value: &mValue,
 		Delta: &mDelta,
-	}); err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if srv.config.StoreInterval == 0 {
-		srv.fileUpload()
-	}
-}
-
-// Outputs all stored metrics.
-func (srv *server) handlerGetAll(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	t, err := template.New("").Parse(templateHandlerGetAll)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
 
-	allMetrics, err := srv.storage.GetBatch()
-	if err != nil {
+	if err := srv.storeMetrics(r, m); err != nil {
 		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	sort.Slice(allMetrics, func(i, j int) bool {
-		return allMetrics[i].ID < allMetrics[j].ID
-	})
-
-	if errExecute := t.Execute(w, allMetrics); errExecute != nil {
-		log.Println(errExecute)
-		http.Error(w, errExecute.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 }
@@ -237,7 +249,14 @@ func (srv *server) handlerGetMetricJSON(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	mRes, err := srv.storage.GetMetric(mReq.ID)
+	st, _, err := srv.tenantStorage(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	mRes, err := st.GetMetric(mReq.ID)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -248,7 +267,14 @@ func (srv *server) handlerGetMetricJSON(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if errUpdateHash := mRes.UpdateHash(srv.config.HashKey); errUpdateHash != nil {
+	hashVersion, err := srv.hashVersion(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if errUpdateHash := mRes.UpdateHashVersion(srv.config.HashKey, hashVersion); errUpdateHash != nil {
 		log.Println(errUpdateHash)
 		http.Error(w, errUpdateHash.Error(), http.StatusInternalServerError)
 		return
@@ -262,6 +288,7 @@ func (srv *server) handlerGetMetricJSON(w http.ResponseWriter, r *http.Request)
 	}
 
 	w.Header().Set("Content-Type", JSONCT)
+	w.Header().Set(HashVersionHeader, strconv.Itoa(hashVersion))
 
 	if _, errWrite := w.Write(mjRes); errWrite != nil {
 		log.Println(errWrite)
@@ -270,6 +297,110 @@ func (srv *server) handlerGetMetricJSON(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// valuesResult is the body of batch read response.
+type valuesResult struct {
+	Metrics []*metric.Metric `json:"metrics"`
+	Missing []string         `json:"missing"`
+}
+
+// Outputs metrics requested by list of ids and types in request body in json-format.
+// Metrics which don't exist or have another type are listed as missing.
+// In cluster metrics are read from their owners.
+func (srv *server) handlerGetMetrics(w http.ResponseWriter, r *http.Request) {
+	mjReq, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	batchReq := []metric.Metric{}
+	if err := json.Unmarshal(mjReq, &batchReq); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := srv.limits.checkBatchLength(len(batchReq)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	ids := make([]string, 0, len(batchReq))
+	for i := range batchReq {
+		if err := checkTypeSupport(batchReq[i].MType); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		ids = append(ids, batchReq[i].ID)
+	}
+
+	hashVersion, err := srv.hashVersion(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	found, err := srv.tenantMetrics(r.Context(), tenant)(ids)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stored := make(map[string]*metric.Metric, len(found))
+	for i := range found {
+		stored[found[i].ID] = found[i]
+	}
+
+	res := valuesResult{
+		Metrics: []*metric.Metric{},
+		Missing: []string{},
+	}
+
+	for i := range batchReq {
+		mStored, ok := stored[batchReq[i].ID]
+		if !ok || mStored.MType != batchReq[i].MType {
+			res.Missing = append(res.Missing, batchReq[i].ID)
+			continue
+		}
+
+		m := *mStored
+		if err := m.UpdateHashVersion(srv.config.HashKey, hashVersion); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Metrics = append(res.Metrics, &m)
+	}
+
+	mjRes, err := json.Marshal(res)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", JSONCT)
+	w.Header().Set(HashVersionHeader, strconv.Itoa(hashVersion))
+
+	if _, err := w.Write(mjRes); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // Outputs value of requested metric. Request is parsed from URL in format "/type/name".
 func (srv *server) handlerGetMetric(w http.ResponseWriter, r *http.Request) {
 	mType := chi.URLParam(r, "type")
@@ -279,8 +410,15 @@ func (srv *server) handlerGetMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	st, _, err := srv.tenantStorage(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
 	mName := chi.URLParam(r, "name")
-	m, err := srv.storage.GetMetric(mName)
+	m, err := st.GetMetric(mName)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -306,6 +444,216 @@ func (srv *server) handlerGetMetric(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Deletes metric defined in URL in format "/type/name".
+func (srv *server) handlerDeleteMetric(w http.ResponseWriter, r *http.Request) {
+	mType := chi.URLParam(r, "type")
+	if err := checkTypeSupport(mType); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	st, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if err := srv.checkWritable(); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	mName := chi.URLParam(r, "name")
+	m, err := st.GetMetric(mName)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if m.MType != mType {
+		errWrongType := errors.New("cannot delete: metric <" + mName + "> is not <" + mType + ">")
+		log.Println(errWrongType)
+		http.Error(w, errWrongType.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := st.DeleteMetric(mName); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	srv.forgetMetric(tenant, mName)
+	srv.replicateDeletion(tenant, mName)
+
+	if srv.config.StoreInterval == 0 {
+		srv.fileUpload()
+	}
+}
+
+// storeMetrics is the common path of all updates: checks token access, limits and tenant quota,
+// then updates metrics in request tenant storage and publishes them to streams.
+func (srv *server) storeMetrics(r *http.Request, batch ...*metric.Metric) error {
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		return err
+	}
+
+	return srv.storeTenantMetrics(r.Context(), tenant, batch...)
+}
+
+// storeTenantMetrics is storeMetrics for tenant given without request. Access of context token is checked if it has one.
+func (srv *server) storeTenantMetrics(ctx context.Context, tenant string, batch ...*metric.Metric) error {
+	if err := srv.checkWritable(); err != nil {
+		return err
+	}
+
+	for i := range batch {
+		if err := auth.CheckWrite(ctx, batch[i].ID); err != nil {
+			return err
+		}
+	}
+
+	if err := srv.limits.checkNames(batch); err != nil {
+		return err
+	}
+
+	batch, err := srv.storeForeign(ctx, tenant, batch)
+	if err != nil {
+		return err
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	batch, reserved, err := srv.limits.admit(tenant, srv.tenantQuota(tenant), batch)
+	if err != nil {
+		return err
+	}
+
+	return srv.storeAdmitted(ctx, tenant, batch, reserved)
+}
+
+// storeAdmitted is the common end of all updates: stores metrics admitted by limits in tenant storage within
+// metrics budget of context token, then publishes them to streams, forwards, tracks counters and replicates them.
+// Names reserved by admission are released if metrics aren't stored.
+func (srv *server) storeAdmitted(ctx context.Context, tenant string, batch []*metric.Metric, reserved []string) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := auth.AllowMetrics(ctx, len(batch)); err != nil {
+		srv.limits.release(tenant, reserved...)
+		return err
+	}
+
+	st := srv.storage.ForTenant(tenant)
+
+	epochs := takeEpochs(batch)
+	events := srv.broker.Snapshot(tenant, batch...)
+	forwarded := srv.forwarder.Snapshot(tenant, batch...)
+
+	var err error
+	if len(batch) == 1 {
+		err = st.UpdateMetric(batch[0])
+	} else {
+		err = st.UpdateBatch(batch)
+	}
+	if err != nil {
+		srv.limits.release(tenant, reserved...)
+		return err
+	}
+
+	srv.broker.Publish(events...)
+	srv.forwarder.Enqueue(forwarded...)
+	srv.updated.touch(tenant, batch, time.Now())
+	srv.trackCounters(st, tenant, batch, epochs)
+	srv.replicate(st, tenant, batch)
+
+	if srv.config.StoreInterval == 0 {
+		srv.fileUpload()
+	}
+
+	return nil
+}
+
+// errorKind is http status and machine-readable code of errors.
+type errorKind struct {
+	code   string
+	errs   []error
+	status int
+}
+
+// errorKinds maps errors to statuses and codes of responses. The first kind matching error is used.
+var errorKinds = []errorKind{
+	{code: "forbidden", status: http.StatusForbidden, errs: []error{auth.ErrPrefixDenied, errTenantForbidden}},
+	{code: "rate_limited", status: http.StatusTooManyRequests, errs: []error{auth.ErrMetricsExhausted}},
+	{code: "cardinality_exceeded", status: http.StatusTooManyRequests, errs: []error{errCardinalityExceeded}},
+	{code: "quota_exceeded", status: http.StatusTooManyRequests, errs: []error{errTenantQuotaExceeded}},
+	{code: "dropped", status: http.StatusTooManyRequests, errs: []error{errMetricDropped}},
+	{code: "batch_too_long", status: http.StatusRequestEntityTooLarge, errs: []error{errBatchTooLong}},
+	{code: "body_too_large", status: http.StatusRequestEntityTooLarge, errs: []error{errBodyTooLarge, prompb.ErrTooLarge}},
+	{code: "invalid_name", status: http.StatusUnprocessableEntity, errs: []error{errNameInvalid}},
+	{code: "invalid_tenant", status: http.StatusBadRequest, errs: []error{errTenantInvalid}},
+	{code: "invalid_json", status: http.StatusBadRequest, errs: []error{errInvalidJSON}},
+	{code: "invalid_body", status: http.StatusBadRequest, errs: []error{openapi.ErrInvalidBody, prompb.ErrInvalidRequest, otlp.ErrInvalidRequest, federation.ErrInvalidPayload, errSourceMissing}},
+	{code: "invalid_line", status: http.StatusBadRequest, errs: []error{influx.ErrInvalidLine, graphite.ErrInvalidLine, errNoNumericFields}},
+	{code: "invalid_precision", status: http.StatusBadRequest, errs: []error{influx.ErrInvalidPrecision}},
+	{code: "invalid_query", status: http.StatusBadRequest, errs: []error{metric.ErrInvalidQuery}},
+	{code: "invalid_cursor", status: http.StatusBadRequest, errs: []error{errInvalidCursor}},
+	{code: "invalid_state", status: http.StatusBadRequest, errs: []error{errUnknownAlertState}},
+	{code: "invalid_range", status: http.StatusBadRequest, errs: []error{errInvalidRange}},
+	{code: "rollups_off", status: http.StatusNotImplemented, errs: []error{errRollupsOff}},
+	{code: "replication_off", status: http.StatusNotImplemented, errs: []error{errReplicationOff}},
+	{code: "standby", status: http.StatusServiceUnavailable, errs: []error{errStandby}},
+	{code: "cluster_off", status: http.StatusNotImplemented, errs: []error{errNotClustered}},
+	{code: "node_unavailable", status: http.StatusBadGateway, errs: []error{cluster.ErrNodeUnavailable}},
+	{code: "invalid_node", status: http.StatusBadRequest, errs: []error{cluster.ErrInvalidNode}},
+	{code: "invalid_dump", status: http.StatusBadRequest, errs: []error{dump.ErrInvalidDump}},
+	{code: "unsupported_dump_version", status: http.StatusBadRequest, errs: []error{dump.ErrUnsupportedVersion}},
+	{code: "invalid_mode", status: http.StatusBadRequest, errs: []error{errRestoreMode}},
+	{code: "not_standby", status: http.StatusConflict, errs: []error{errNotStandby}},
+	{code: "unsupported_type", status: http.StatusNotImplemented, errs: []error{errUnsupportedType}},
+	{code: "unsupported_media_type", status: http.StatusUnsupportedMediaType, errs: []error{otlp.ErrUnsupportedContentType}},
+	{code: "invalid_format", status: http.StatusBadRequest, errs: []error{errValueMissing, errInvalidFormat, metric.ErrCannotUpdateInvalidFormat}},
+	{code: "invalid_hash", status: http.StatusBadRequest, errs: []error{errInconsistentHashes, errHashMissing}},
+	{code: "unsupported_hash_version", status: http.StatusBadRequest, errs: []error{metric.ErrUnsupportedHashVersion, errHashVersionTooOld}},
+	{code: "not_found", status: http.StatusNotFound, errs: []error{metric.ErrMetricDoesntExist}},
+	{code: "type_mismatch", status: http.StatusNotFound, errs: []error{errTypeMismatch}},
+}
+
+// Gives kind of error. Unknown errors are internal ones.
+func kindOf(err error) errorKind {
+	re := &cluster.RemoteError{}
+	if errors.As(err, &re) {
+		return errorKind{code: re.Code, status: re.Status}
+	}
+
+	for _, kind := range errorKinds {
+		for _, target := range kind.errs {
+			if errors.Is(err, target) {
+				return kind
+			}
+		}
+	}
+
+	return errorKind{code: "internal", status: http.StatusInternalServerError}
+}
+
+// Gives http status corresponding to error of storing metrics.
+func errorStatus(err error) int {
+	return kindOf(err).status
+}
+
+// Gives machine-readable code of error.
+func errorCode(err error) string {
+	return kindOf(err).code
+}
+
 // Checks if metric type is supported by server or not.
 func checkTypeSupport(mType string) error {
 	for _, v := range supportedTypes {
@@ -317,12 +665,53 @@ func checkTypeSupport(mType string) error {
 	return errUnsupportedType
 }
 
+// Gives hash version requested by client. Checks if it is supported and not older than configured minimum.
+func (srv *server) hashVersion(r *http.Request) (int, error) {
+	version := metric.HashV1
+
+	if header := r.Header.Get(HashVersionHeader); header != "" {
+		var err error
+
+		if version, err = strconv.Atoi(header); err != nil {
+			return 0, metric.ErrUnsupportedHashVersion
+		}
+	}
+
+	if !metric.IsHashVersionSupported(version) {
+		return 0, metric.ErrUnsupportedHashVersion
+	}
+
+	if version < srv.config.MinHashVersion {
+		return 0, errHashVersionTooOld
+	}
+
+	return version, nil
+}
+
+// Middleware component which advertises accepted hash versions, so agents could switch to the latest one.
+func (srv *server) advertiseHashVersions(handler http.Handler) http.Handler {
+	versions := []string{}
+
+	for _, v := range metric.SupportedHashVersions {
+		if v >= srv.config.MinHashVersion {
+			versions = append(versions, strconv.Itoa(v))
+		}
+	}
+
+	accepted := strings.Join(versions, ",")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(AcceptHashVersionsHeader, accepted)
+		handler.ServeHTTP(w, r)
+	})
+}
+
 // Calculates input metric's hash again by server own key and compares it with existing.
 // If hashes are inconsistent, returns corresponding error.
-func (srv *server) checkHash(m *metric.Metric) (string, error) {
+func (srv *server) checkHash(m *metric.Metric, version int) (string, error) {
 	h := m.Hash
 
-	if errUpdateHash := m.UpdateHash(srv.config.HashKey); errUpdateHash != nil {
+	if errUpdateHash := m.UpdateHashVersion(srv.config.HashKey, version); errUpdateHash != nil {
 		return "", errUpdateHash
 	}
 
@@ -333,6 +722,10 @@ func (srv *server) checkHash(m *metric.Metric) (string, error) {
 	return m.Hash, nil
 }
 
+// fileUpload signals uploader to save storage. Changes made after shutdown are saved by the last upload of Shutdown.
 func (srv *server) fileUpload() {
-	srv.uploadSig <- struct{}{}
+	select {
+	case srv.uploadSig <- struct{}{}:
+	case <-srv.shutdown:
+	}
 }
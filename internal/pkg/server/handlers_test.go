@@ -2,12 +2,17 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/goslammu/yp_go_devops/internal/pkg/cluster"
 	"github.com/goslammu/yp_go_devops/internal/pkg/filestorage"
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/prompb"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -81,6 +86,60 @@ func Test_handlerGetMetricJSON(t *testing.T) {
 	})
 }
 
+func Test_handlerGetMetrics(t *testing.T) {
+	srv := newTestServer(t, serverConfig{HashKey: "key"})
+
+	var delta int64 = 5
+	var value float64 = 1.5
+	assert.NoError(t, srv.storage.ForTenant("team").UpdateBatch([]*metric.Metric{
+		{ID: "PollCount", MType: Counter, Delta: &delta},
+		{ID: "Alloc", MType: Gauge, Value: &value},
+	}))
+
+	t.Run("found and missing metrics", func(t *testing.T) {
+		body := `[{"id":"Alloc","type":"gauge"},{"id":"PollCount","type":"counter"},` +
+			`{"id":"Missing","type":"gauge"},{"id":"PollCount","type":"gauge"}]`
+
+		rec := serve(srv, httptest.NewRequest("POST", "/t/team/values/", bytes.NewBufferString(body)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, JSONCT, rec.Header().Get("Content-Type"))
+
+		res := valuesResult{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, []string{"Missing", "PollCount"}, res.Missing)
+		assert.Len(t, res.Metrics, 2)
+
+		for _, m := range res.Metrics {
+			expected := *m
+			assert.NoError(t, expected.UpdateHash(srv.config.HashKey))
+			assert.NotEmpty(t, m.Hash)
+			assert.Equal(t, expected.Hash, m.Hash)
+		}
+		assert.Equal(t, "Alloc", res.Metrics[0].ID)
+		assert.Equal(t, delta, *res.Metrics[1].Delta)
+
+		stored, err := srv.storage.ForTenant("team").GetMetric("Alloc")
+		assert.NoError(t, err)
+		assert.Empty(t, stored.Hash)
+	})
+
+	t.Run("other tenant", func(t *testing.T) {
+		rec := serve(srv, httptest.NewRequest("POST", "/values/", bytes.NewBufferString(`[{"id":"Alloc","type":"gauge"}]`)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"metrics":[],"missing":["Alloc"]}`, rec.Body.String())
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		rec := serve(srv, httptest.NewRequest("POST", "/values/", bytes.NewBufferString(`[{"id":"Alloc","type":"hist"}]`)))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+
+	t.Run("not a list", func(t *testing.T) {
+		rec := serve(srv, httptest.NewRequest("POST", "/values/", bytes.NewBufferString(`{"id":"Alloc","type":"gauge"}`)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
 func Test_handlerUpdateBatch(t *testing.T) {
 	srv := server{}
 
@@ -204,6 +263,65 @@ func Test_checkTypeSupport(t *testing.T) {
 	}
 }
 
+func Test_handlerUpdateJSONHash(t *testing.T) {
+	srv := newTestServer(t, serverConfig{HashKey: "key", MinHashVersion: metric.HashV2})
+
+	signed := func(version int) string {
+		value := 1.5
+		m := metric.Metric{ID: "Alloc", MType: Gauge, Value: &value}
+		assert.NoError(t, m.UpdateHashVersion(srv.config.HashKey, version))
+
+		mj, err := json.Marshal(m)
+		assert.NoError(t, err)
+
+		return string(mj)
+	}
+
+	tests := []struct {
+		Name           string
+		Body           string
+		Version        string
+		Hash           string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "signed metric",
+			Body:           signed(metric.HashV2),
+			Version:        "2",
+			Hash:           "present",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "hash header is missing",
+			Body:           signed(metric.HashV1),
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "legacy version",
+			Body:           signed(metric.HashV1),
+			Hash:           "present",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/update/", bytes.NewBufferString(tt.Body))
+			if tt.Version != "" {
+				req.Header.Set(HashVersionHeader, tt.Version)
+			}
+			if tt.Hash != "" {
+				req.Header.Set("Hash", tt.Hash)
+			}
+
+			rec := httptest.NewRecorder()
+			http.HandlerFunc(srv.handlerUpdateJSON).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+		})
+	}
+}
+
 func Test_checkHash(t *testing.T) {
 	srv := server{
 		config: serverConfig{
@@ -263,10 +381,97 @@ func Test_checkHash(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.Name, func(t *testing.T) {
-			hash, err := srv.checkHash(tt.Input)
+			hash, err := srv.checkHash(tt.Input, metric.HashV1)
 
 			assert.Equal(t, tt.ExpectedHash, hash)
 			assert.ErrorIs(t, err, tt.ExpectedError)
 		})
 	}
 }
+
+func Test_hashVersion(t *testing.T) {
+	tests := []struct {
+		ExpectedError   error
+		Name            string
+		Header          string
+		MinVersion      int
+		ExpectedVersion int
+	}{
+		{
+			Name:            "legacy agent",
+			Header:          "",
+			ExpectedVersion: metric.HashV1,
+		},
+		{
+			Name:            "canonical version",
+			Header:          "2",
+			ExpectedVersion: metric.HashV2,
+		},
+		{
+			Name:          "unknown version",
+			Header:        "100",
+			ExpectedError: metric.ErrUnsupportedHashVersion,
+		},
+		{
+			Name:          "legacy version is turned off",
+			Header:        "",
+			MinVersion:    metric.HashV2,
+			ExpectedError: errHashVersionTooOld,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := server{config: serverConfig{MinHashVersion: tt.MinVersion}}
+
+			req, err := http.NewRequest("POST", "/update/", nil)
+			assert.NoError(t, err)
+			if tt.Header != "" {
+				req.Header.Set(HashVersionHeader, tt.Header)
+			}
+
+			version, err := srv.hashVersion(req)
+
+			assert.ErrorIs(t, err, tt.ExpectedError)
+			assert.Equal(t, tt.ExpectedVersion, version)
+		})
+	}
+}
+
+func Test_advertiseHashVersions(t *testing.T) {
+	srv := server{config: serverConfig{MinHashVersion: metric.HashV2}}
+
+	rec := httptest.NewRecorder()
+	srv.advertiseHashVersions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, "2", rec.Header().Get(AcceptHashVersionsHeader))
+}
+
+func Test_kindOf(t *testing.T) {
+	tests := []struct {
+		Err            error
+		Name           string
+		ExpectedCode   string
+		ExpectedStatus int
+	}{
+		{Name: "wrapped", Err: fmt.Errorf("%w: metric <a>", errNameInvalid), ExpectedCode: "invalid_name", ExpectedStatus: http.StatusUnprocessableEntity},
+		{Name: "one of kind", Err: prompb.ErrTooLarge, ExpectedCode: "body_too_large", ExpectedStatus: http.StatusRequestEntityTooLarge},
+		{Name: "remote", Err: &cluster.RemoteError{Code: "standby", Status: http.StatusServiceUnavailable}, ExpectedCode: "standby", ExpectedStatus: http.StatusServiceUnavailable},
+		{Name: "unknown", Err: errors.New("disk is full"), ExpectedCode: "internal", ExpectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.ExpectedCode, errorCode(tt.Err))
+			assert.Equal(t, tt.ExpectedStatus, errorStatus(tt.Err))
+		})
+	}
+
+	// Every kind has code and status of error response.
+	for _, kind := range errorKinds {
+		assert.NotEmpty(t, kind.code)
+		assert.NotEmpty(t, kind.errs, kind.code)
+		assert.GreaterOrEqual(t, kind.status, http.StatusBadRequest, kind.code)
+	}
+}
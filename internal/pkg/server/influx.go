@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/influx"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var errNoNumericFields = errors.New("line has no numeric fields")
+
+// lineError is error of individual line of line-based write request.
+type lineError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line"`
+}
+
+// lineResult is the body of line-based write response with rejected lines.
+type lineResult struct {
+	Errors   []lineError `json:"errors"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+}
+
+// Stores InfluxDB line protocol points. Numeric and boolean fields are gauges "<measurement>_<field>"
+// labeled by tags, string fields are skipped. Valid lines are stored even if other lines are rejected,
+// rejected lines are reported with their numbers.
+func (srv *server) handlerInfluxWrite(w http.ResponseWriter, r *http.Request) {
+	unit, err := influx.Precision(r.URL.Query().Get("precision"))
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	points, parseErrs := influx.Parse(body, unit, time.Now())
+
+	res := lineResult{Errors: []lineError{}}
+	for _, err := range parseErrs {
+		var lineErr *influx.LineError
+		if errors.As(err, &lineErr) {
+			res.reject(lineErr.Line, lineErr.Err)
+		}
+	}
+
+	batch, lines := []*metric.Metric{}, []int{}
+	for _, p := range points {
+		n := len(batch)
+
+		for _, f := range p.Fields {
+			value, ok := f.Float()
+			if !ok {
+				continue
+			}
+
+			batch = append(batch, &metric.Metric{
+				ID:     seriesID(p.Measurement+"_"+f.Key, p.Tags),
+				MType:  Gauge,
+				Value:  &value,
+				Labels: p.Tags,
+			})
+			lines = append(lines, p.Line)
+		}
+
+		if len(batch) == n {
+			res.reject(p.Line, errNoNumericFields)
+		}
+	}
+
+	if err := srv.limits.checkBatchLength(len(batch)); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	errs := make([]error, len(batch))
+	if err := srv.storeEach(r, batch, errs); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	for i := range batch {
+		switch {
+		case errs[i] == nil:
+			res.Accepted++
+		case errors.Is(errs[i], errMetricDropped):
+		default:
+			res.reject(lines[i], fmt.Errorf("%s: %w", batch[i].ID, errs[i]))
+		}
+	}
+
+	if res.Rejected == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusBadRequest, res)
+}
+
+// reject adds error of line.
+func (res *lineResult) reject(line int, err error) {
+	res.Errors = append(res.Errors, lineError{
+		Line:    line,
+		Code:    errorCode(err),
+		Message: err.Error(),
+	})
+	res.Rejected++
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handlerInfluxWrite(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	body := "# load of web servers\n" +
+		"cpu,host=web-1 usage=0.5,procs=12i,up=true,state=\"ok\" 1700000000\n" +
+		"cpu,host=web-2 usage=0.25 1700000000\n" +
+		"mem free=2048u\n"
+
+	req := httptest.NewRequest("POST", "/t/team/write?db=ignored&precision=s", strings.NewReader(body))
+	rec := serve(srv, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	web1 := map[string]string{"host": "web-1"}
+	expected := []struct {
+		Labels map[string]string
+		Name   string
+		Value  float64
+	}{
+		{Name: "cpu_usage", Value: 0.5, Labels: web1},
+		{Name: "cpu_procs", Value: 12, Labels: web1},
+		{Name: "cpu_up", Value: 1, Labels: web1},
+		{Name: "cpu_usage", Value: 0.25, Labels: map[string]string{"host": "web-2"}},
+		{Name: "mem_free", Value: 2048},
+	}
+
+	batch, err := srv.storage.ForTenant("team").GetBatch()
+	assert.NoError(t, err)
+	assert.Len(t, batch, len(expected))
+
+	for _, e := range expected {
+		m, err := srv.storage.ForTenant("team").GetMetric(seriesID(e.Name, e.Labels))
+		assert.NoError(t, err, e.Name)
+		if err == nil {
+			assert.Equal(t, Gauge, m.MType, e.Name)
+			assert.Equal(t, e.Value, m.SortValue(), e.Name)
+			assert.Equal(t, e.Labels, m.Labels, e.Name)
+		}
+	}
+}
+
+func Test_handlerInfluxWriteErrors(t *testing.T) {
+	tests := []struct {
+		Name           string
+		URL            string
+		Body           string
+		ExpectedLines  []int
+		ExpectedCodes  []string
+		ExpectedStored []string
+		Config         serverConfig
+		ExpectedStatus int
+	}{
+		{
+			Name:           "invalid lines are reported, valid ones are stored",
+			URL:            "/write",
+			Body:           "cpu usage=1\ncpu usage=\n\ncpu state=\"ok\"\nmem free=2",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedLines:  []int{2, 4},
+			ExpectedCodes:  []string{"invalid_line", "invalid_line"},
+			ExpectedStored: []string{"cpu_usage", "mem_free"},
+		},
+		{
+			Name:           "rejected metrics are reported by lines",
+			URL:            "/write",
+			Config:         serverConfig{NameCharset: testNameCharset},
+			Body:           "cpu usage=1\nbad\\ name value=1",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedLines:  []int{2},
+			ExpectedCodes:  []string{"invalid_name"},
+			ExpectedStored: []string{"cpu_usage"},
+		},
+		{
+			Name:           "limits of metrics",
+			Config:         serverConfig{MaxMetrics: 1},
+			URL:            "/write",
+			Body:           "cpu usage=1\nmem free=2",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedLines:  []int{2},
+			ExpectedCodes:  []string{"cardinality_exceeded"},
+			ExpectedStored: []string{"cpu_usage"},
+		},
+		{
+			Name:           "invalid precision",
+			URL:            "/write?precision=d",
+			Body:           "cpu usage=1",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedStored: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := newTestServer(t, tt.Config)
+
+			rec := serve(srv, httptest.NewRequest("POST", tt.URL, strings.NewReader(tt.Body)))
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+
+			if tt.ExpectedLines != nil {
+				res := lineResult{}
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+				assert.Equal(t, len(tt.ExpectedLines), res.Rejected)
+				assert.Equal(t, len(tt.ExpectedStored), res.Accepted)
+
+				lines, codes := []int{}, []string{}
+				for _, e := range res.Errors {
+					lines = append(lines, e.Line)
+					codes = append(codes, e.Code)
+				}
+				assert.Equal(t, tt.ExpectedLines, lines)
+				assert.Equal(t, tt.ExpectedCodes, codes)
+			}
+
+			batch, err := srv.storage.GetBatch()
+			assert.NoError(t, err)
+
+			stored := []string{}
+			for _, m := range batch {
+				stored = append(stored, m.ID)
+			}
+			assert.ElementsMatch(t, tt.ExpectedStored, stored)
+		})
+	}
+
+	// Write scope is required.
+	srv := newTestServer(t, serverConfig{
+		Tokens: []auth.Token{{Token: "reader", Scopes: []string{auth.ScopeRead}}},
+	})
+
+	req := httptest.NewRequest("POST", "/write", strings.NewReader("cpu usage=1"))
+	req.Header.Set("Authorization", "Bearer reader")
+	assert.Equal(t, http.StatusForbidden, serve(srv, req).Code)
+}
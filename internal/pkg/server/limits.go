@@ -0,0 +1,419 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var (
+	errBodyTooLarge        = errors.New("request body is too large")
+	errBatchTooLong        = errors.New("batch is too long")
+	errNameInvalid         = errors.New("metric name is invalid")
+	errCardinalityExceeded = errors.New("distinct metrics limit is exceeded")
+	errOverflowPolicy      = errors.New("unknown overflow policy")
+	errMetricDropped       = errors.New("new metric is dropped by overflow policy")
+)
+
+// Policies of handling metrics which exceed distinct metrics limit or tenant quota.
+const (
+	// Whole request is rejected.
+	OverflowReject = "reject"
+
+	// New metrics are dropped, existing ones are updated.
+	OverflowDropNew = "drop-new"
+)
+
+// limits protects server from unbounded payloads and cardinality. Keeps set of known metrics of every tenant.
+// Limits which config doesn't define are off.
+type limits struct {
+	nameRegexp     *regexp.Regexp
+	known          map[string]map[string]struct{}
+	stats          limitStats
+	maxBodySize    int64
+	maxBatchLength int
+	maxNameLength  int
+	maxMetrics     int
+	total          int
+	dropNew        bool
+	sync.Mutex
+}
+
+// Counters of rejected requests and dropped metrics.
+type limitStats struct {
+	BodyTooLarge        int64 `json:"body_too_large"`
+	BatchTooLong        int64 `json:"batch_too_long"`
+	InvalidName         int64 `json:"invalid_name"`
+	CardinalityRejected int64 `json:"cardinality_rejected"`
+	QuotaRejected       int64 `json:"quota_rejected"`
+	Dropped             int64 `json:"dropped"`
+}
+
+// initLimits initializes limits according to server configuration and loads known metrics from storage.
+func (srv *server) initLimits() error {
+	l := &limits{
+		known:          map[string]map[string]struct{}{},
+		maxBodySize:    limitOrOff(srv.config.MaxBodySize),
+		maxBatchLength: int(limitOrOff(int64(srv.config.MaxBatchLength))),
+		maxNameLength:  int(limitOrOff(int64(srv.config.MaxNameLength))),
+		maxMetrics:     int(limitOrOff(int64(srv.config.MaxMetrics))),
+	}
+
+	switch srv.config.OverflowPolicy {
+	case "", OverflowReject:
+	case OverflowDropNew:
+		l.dropNew = true
+	default:
+		return errOverflowPolicy
+	}
+
+	if srv.config.NameCharset != "" {
+		var err error
+		if l.nameRegexp, err = regexp.Compile(srv.config.NameCharset); err != nil {
+			return err
+		}
+	}
+
+	tenants, err := srv.storage.Tenants()
+	if err != nil {
+		return err
+	}
+
+	for _, tenant := range tenants {
+		batch, err := srv.storage.ForTenant(tenant).GetBatch()
+		if err != nil {
+			return err
+		}
+
+		for i := range batch {
+			l.add(tenant, batch[i].ID)
+		}
+	}
+
+	srv.limits = l
+
+	return nil
+}
+
+// Gives limit defined by config. Zero and negative values mean no limit.
+func limitOrOff(value int64) int64 {
+	if value < 0 {
+		return 0
+	}
+
+	return value
+}
+
+// Middleware component which rejects requests with too large bodies.
+func (l *limits) limitBody(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l == nil || l.maxBodySize == 0 || r.Body == nil || r.URL.Path == restorePath {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > l.maxBodySize {
+			l.rejectBody(w)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, l.maxBodySize+1))
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if int64(len(body)) > l.maxBodySize {
+			l.rejectBody(w)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (l *limits) rejectBody(w http.ResponseWriter) {
+	atomic.AddInt64(&l.stats.BodyTooLarge, 1)
+
+	log.Println(errBodyTooLarge)
+	http.Error(w, errBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+}
+
+// Checks if batch length is allowed.
+func (l *limits) checkBatchLength(length int) error {
+	if l == nil || l.maxBatchLength == 0 || length <= l.maxBatchLength {
+		return nil
+	}
+
+	atomic.AddInt64(&l.stats.BatchTooLong, 1)
+
+	return errBatchTooLong
+}
+
+// Checks metric names length and charset.
+func (l *limits) checkNames(batch []*metric.Metric) error {
+	for i := range batch {
+		if err := l.checkName(batch[i].ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks length and charset of individual metric name.
+func (l *limits) checkName(id string) error {
+	if l == nil {
+		return nil
+	}
+
+	if (l.maxNameLength != 0 && len(id) > l.maxNameLength) || (l.nameRegexp != nil && !l.nameRegexp.MatchString(id)) {
+		atomic.AddInt64(&l.stats.InvalidName, 1)
+
+		return errNameInvalid
+	}
+
+	return nil
+}
+
+// admit reserves place for new metrics of tenant according to distinct metrics limit and tenant quota.
+// Returns metrics which could be stored and list of reserved names, which must be released if storing fails.
+// If any metric is rejected, nothing is reserved.
+func (l *limits) admit(tenant string, quota int, batch []*metric.Metric) ([]*metric.Metric, []string, error) {
+	errs, reserved := l.reserve(tenant, quota, batch)
+	admitted := make([]*metric.Metric, 0, len(batch))
+
+	for i := range batch {
+		switch {
+		case errs[i] == nil:
+			admitted = append(admitted, batch[i])
+		case errors.Is(errs[i], errMetricDropped):
+		default:
+			l.release(tenant, reserved...)
+			return nil, nil, errs[i]
+		}
+	}
+
+	return admitted, reserved, nil
+}
+
+// reserve checks every metric of batch against distinct metrics limit and tenant quota and reserves place
+// for new ones. Gives errors of individual metrics (errMetricDropped for metrics dropped by policy)
+// and list of reserved names, which must be released if storing fails.
+func (l *limits) reserve(tenant string, quota int, batch []*metric.Metric) ([]error, []string) {
+	errs := make([]error, len(batch))
+
+	if l == nil {
+		return errs, nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	known := l.known[tenant]
+	reserved := []string{}
+	isReserved := map[string]bool{}
+
+	for i := range batch {
+		id := batch[i].ID
+
+		if _, ok := known[id]; ok || isReserved[id] {
+			continue
+		}
+
+		var err error
+		switch {
+		case l.maxMetrics != 0 && l.total+len(reserved) >= l.maxMetrics:
+			err = errCardinalityExceeded
+		case quota != 0 && len(known)+len(reserved) >= quota:
+			err = errTenantQuotaExceeded
+		}
+
+		if err != nil {
+			switch {
+			case l.dropNew:
+				atomic.AddInt64(&l.stats.Dropped, 1)
+				err = errMetricDropped
+			case errors.Is(err, errCardinalityExceeded):
+				atomic.AddInt64(&l.stats.CardinalityRejected, 1)
+			default:
+				atomic.AddInt64(&l.stats.QuotaRejected, 1)
+			}
+
+			errs[i] = err
+			continue
+		}
+
+		reserved = append(reserved, id)
+		isReserved[id] = true
+	}
+
+	for _, id := range reserved {
+		l.add(tenant, id)
+	}
+
+	return errs, reserved
+}
+
+// fits checks if metrics of tenants given by names could be stored within distinct metrics limit and tenant
+// quotas without reserving place for them. If replace is set, they replace all stored metrics.
+func (l *limits) fits(ids map[string]map[string]struct{}, quota func(tenant string) int, replace bool) error {
+	if l == nil {
+		return nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	total := l.total
+	if replace {
+		total = 0
+	}
+
+	for tenant, tenantIDs := range ids {
+		count := 0
+		if !replace {
+			count = len(l.known[tenant])
+		}
+
+		for id := range tenantIDs {
+			if _, ok := l.known[tenant][id]; ok && !replace {
+				continue
+			}
+
+			count++
+			total++
+		}
+
+		if q := quota(tenant); q != 0 && count > q {
+			atomic.AddInt64(&l.stats.QuotaRejected, 1)
+			return fmt.Errorf("%w: tenant %q", errTenantQuotaExceeded, tenant)
+		}
+	}
+
+	if l.maxMetrics != 0 && total > l.maxMetrics {
+		atomic.AddInt64(&l.stats.CardinalityRejected, 1)
+		return errCardinalityExceeded
+	}
+
+	return nil
+}
+
+// Forgets metrics of tenant, e.g. after deleting or failed storing.
+func (l *limits) release(tenant string, ids ...string) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	for _, id := range ids {
+		if _, ok := l.known[tenant][id]; ok {
+			delete(l.known[tenant], id)
+			l.total--
+		}
+	}
+}
+
+// keep remembers metrics of tenant stored without admission, e.g. changes replicated from primary.
+func (l *limits) keep(tenant string, ids ...string) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	for _, id := range ids {
+		l.add(tenant, id)
+	}
+}
+
+// add remembers metric of tenant. Must be called under lock or during initialization.
+func (l *limits) add(tenant, id string) {
+	known, ok := l.known[tenant]
+	if !ok {
+		known = map[string]struct{}{}
+		l.known[tenant] = known
+	}
+
+	if _, ok := known[id]; !ok {
+		known[id] = struct{}{}
+		l.total++
+	}
+}
+
+// Outputs configured limits and counters of rejections.
+func (srv *server) handlerGetLimits(w http.ResponseWriter, r *http.Request) {
+	l := srv.limits
+
+	l.Lock()
+	total := l.total
+	l.Unlock()
+
+	charset := ""
+	if l.nameRegexp != nil {
+		charset = l.nameRegexp.String()
+	}
+
+	policy := OverflowReject
+	if l.dropNew {
+		policy = OverflowDropNew
+	}
+
+	res := struct {
+		NameCharset    string     `json:"name_charset"`
+		OverflowPolicy string     `json:"overflow_policy"`
+		Rejections     limitStats `json:"rejections"`
+		MaxBodySize    int64      `json:"max_body_size"`
+		MaxBatchLength int        `json:"max_batch_length"`
+		MaxNameLength  int        `json:"max_name_length"`
+		MaxMetrics     int        `json:"max_metrics"`
+		Metrics        int        `json:"metrics"`
+	}{
+		NameCharset:    charset,
+		OverflowPolicy: policy,
+		Rejections: limitStats{
+			BodyTooLarge:        atomic.LoadInt64(&l.stats.BodyTooLarge),
+			BatchTooLong:        atomic.LoadInt64(&l.stats.BatchTooLong),
+			InvalidName:         atomic.LoadInt64(&l.stats.InvalidName),
+			CardinalityRejected: atomic.LoadInt64(&l.stats.CardinalityRejected),
+			QuotaRejected:       atomic.LoadInt64(&l.stats.QuotaRejected),
+			Dropped:             atomic.LoadInt64(&l.stats.Dropped),
+		},
+		MaxBodySize:    l.maxBodySize,
+		MaxBatchLength: l.maxBatchLength,
+		MaxNameLength:  l.maxNameLength,
+		MaxMetrics:     l.maxMetrics,
+		Metrics:        total,
+	}
+
+	resj, err := json.Marshal(res)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", JSONCT)
+
+	if _, err := w.Write(resj); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Charset of metric names used by tests of rejected names.
+const testNameCharset = `^[A-Za-z0-9_.:-]+$`
+
+func Test_payloadLimits(t *testing.T) {
+	srv := newTestServer(t, serverConfig{
+		MaxBodySize:    256,
+		MaxBatchLength: 2,
+		MaxNameLength:  16,
+		NameCharset:    testNameCharset,
+	})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		Body           string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "batch within limits",
+			URL:            "/updates/",
+			Body:           `[{"id":"Alloc","type":"gauge","value":1,"hash":"-"}]`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "too large body",
+			URL:            "/updates/",
+			Body:           `[` + strings.Repeat(`{"id":"Alloc","type":"gauge","value":1},`, 10) + `{}]`,
+			ExpectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			Name:           "too long batch",
+			URL:            "/updates/",
+			Body:           `[{"id":"a","type":"gauge"},{"id":"b","type":"gauge"},{"id":"c","type":"gauge"}]`,
+			ExpectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			Name:           "too long name",
+			URL:            "/update/gauge/" + strings.Repeat("a", 17) + "/1",
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "invalid charset",
+			URL:            "/update/gauge/Alloc%20Bytes/1",
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "valid name",
+			URL:            "/update/gauge/team1.Alloc/1",
+			ExpectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.URL, bytes.NewBufferString(tt.Body))
+
+			assert.Equal(t, tt.ExpectedStatus, serve(srv, req).Code)
+		})
+	}
+
+	t.Run("rejections are counted", func(t *testing.T) {
+		rec := serve(srv, httptest.NewRequest("GET", "/limits/", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := struct {
+			Rejections limitStats `json:"rejections"`
+			Metrics    int        `json:"metrics"`
+		}{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+
+		assert.Equal(t, limitStats{BodyTooLarge: 1, BatchTooLong: 1, InvalidName: 2}, res.Rejections)
+		assert.Equal(t, 1, res.Metrics)
+	})
+}
+
+func Test_limitsOff(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	body := `[` + strings.Repeat(`{"id":"Alloc Bytes","type":"gauge","value":1},`, 30000) + `{"id":"Alloc","type":"gauge","value":1}]`
+	rec := serve(srv, httptest.NewRequest("POST", "/api/v1/updates", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(srv, httptest.NewRequest("GET", "/limits/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name_charset":"","overflow_policy":"reject","rejections":{"body_too_large":0,"batch_too_long":0,`+
+		`"invalid_name":0,"cardinality_rejected":0,"quota_rejected":0,"dropped":0},"max_body_size":0,"max_batch_length":0,`+
+		`"max_name_length":0,"max_metrics":0,"metrics":2}`, rec.Body.String())
+}
+
+func Test_cardinalityLimits(t *testing.T) {
+	t.Run("reject policy", func(t *testing.T) {
+		srv := newTestServer(t, serverConfig{MaxMetrics: 2})
+
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/gauge/m1/1", nil)).Code)
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/team/update/gauge/m1/1", nil)).Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve(srv, httptest.NewRequest("POST", "/update/gauge/m2/1", nil)).Code)
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/gauge/m1/2", nil)).Code)
+
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("DELETE", "/t/team/value/gauge/m1", nil)).Code)
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/gauge/m2/1", nil)).Code)
+
+		assert.Equal(t, int64(1), srv.limits.stats.CardinalityRejected)
+	})
+
+	t.Run("drop-new policy", func(t *testing.T) {
+		srv := newTestServer(t, serverConfig{MaxMetrics: 1, OverflowPolicy: OverflowDropNew})
+
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/gauge/m1/1", nil)).Code)
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/gauge/m2/1", nil)).Code)
+
+		_, err := srv.storage.GetMetric("m2")
+		assert.Error(t, err)
+
+		assert.Equal(t, int64(1), srv.limits.stats.Dropped)
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		srv := NewServer(serverConfig{OverflowPolicy: "ignore"})
+		srv.storage = newTestServer(t, serverConfig{}).storage
+
+		assert.ErrorIs(t, srv.initLimits(), errOverflowPolicy)
+	})
+}
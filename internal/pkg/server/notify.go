@@ -0,0 +1,65 @@
+package server
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/notify"
+)
+
+const (
+	// Default periods of grouping and deduplication of alert changes.
+	defaultNotifyGroupWindow = 30 * time.Second
+	defaultNotifyDedupWindow = 5 * time.Minute
+
+	// Period of checking groups of alert changes ready to be sent.
+	notifyInterval = time.Second
+)
+
+// initNotifier initializes sending alert changes to webhooks defined in config.
+func (srv *server) initNotifier() error {
+	if srv.alerts == nil || len(srv.config.Webhooks) == 0 {
+		return nil
+	}
+
+	notifier, err := notify.New(srv.config.Webhooks, srv.config.HashKey,
+		notifyWindow(srv.config.NotifyGroupWindow, defaultNotifyGroupWindow),
+		notifyWindow(srv.config.NotifyDedupWindow, defaultNotifyDedupWindow))
+	if err != nil {
+		return err
+	}
+
+	srv.notifier = notifier
+
+	return nil
+}
+
+// notifyWindow gives default window for zero value and turns window off for negative one.
+func notifyWindow(window, def time.Duration) time.Duration {
+	switch {
+	case window == 0:
+		return def
+	case window < 0:
+		return 0
+	default:
+		return window
+	}
+}
+
+// sendNotifications sends grouped alert changes until server shutdown.
+func (srv *server) sendNotifications() {
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, err := range srv.notifier.Flush(now) {
+				log.Println(err)
+			}
+		case <-srv.shutdown:
+			return
+		}
+	}
+}
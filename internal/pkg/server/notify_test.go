@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/goslammu/yp_go_devops/internal/pkg/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_notifyAlerts(t *testing.T) {
+	mu := sync.Mutex{}
+	payloads := []notify.Payload{}
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		p := notify.Payload{}
+		assert.NoError(t, json.Unmarshal(body, &p))
+
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	srv := newTestServer(t, serverConfig{
+		RulesFile:         writeRules(t, `{"alerts":[{"name":"heap","expr":"HeapAlloc > 1e9","tenant":"team"}]}`),
+		Webhooks:          []notify.Target{{Name: "ops", URL: receiver.URL}},
+		NotifyGroupWindow: -1,
+	})
+	assert.NoError(t, srv.initRules())
+	assert.NoError(t, srv.initNotifier())
+
+	start := time.Now()
+	for i, value := range []string{"2000000000", "1", "2000000000", "1"} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/team/update/gauge/HeapAlloc/"+value, nil)).Code)
+
+		now := start.Add(time.Duration(i) * time.Minute)
+		srv.evalAlerts(now)
+		assert.Empty(t, srv.notifier.Flush(now))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, alerting.StateFiring, payloads[0].Alerts[0].State)
+	assert.Equal(t, 2e9, payloads[0].Alerts[0].Value)
+	assert.Equal(t, alerting.StateResolved, payloads[1].Alerts[0].State)
+}
+
+func Test_initNotifier(t *testing.T) {
+	srv := newTestServer(t, serverConfig{Webhooks: []notify.Target{{Name: "ops"}}})
+	assert.NoError(t, srv.initNotifier())
+	assert.Nil(t, srv.notifier)
+
+	srv = newTestServer(t, serverConfig{
+		RulesFile: writeRules(t, `{"alerts":[]}`),
+		Webhooks:  []notify.Target{{Name: "ops"}},
+	})
+	assert.NoError(t, srv.initRules())
+	assert.ErrorIs(t, srv.initNotifier(), notify.ErrInvalidTarget)
+}
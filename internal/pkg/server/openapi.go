@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/go-chi/chi"
+	"github.com/goslammu/yp_go_devops/internal/pkg/openapi"
+)
+
+// OpenAPI document of all server routes. Every chi route must have entry in it.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+const (
+	// Route prefix of tenant metric routes. It is defined in document as server, not as part of paths.
+	tenantRoutePrefix = "/t/{tenant}"
+
+	apiV1Prefix = "/api/v1/"
+)
+
+// initSpec parses embedded OpenAPI document.
+func (srv *server) initSpec() error {
+	spec, err := openapi.Parse(openAPIDocument)
+	if err != nil {
+		return err
+	}
+
+	srv.spec = spec
+
+	return nil
+}
+
+// Outputs OpenAPI document.
+func (srv *server) handlerGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", JSONCT)
+
+	if _, err := w.Write(openAPIDocument); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Middleware component which validates JSON request body against OpenAPI document.
+// Must be attached to endpoints by With, so route pattern is already known.
+// Errors of API v1 routes are given in JSON envelope, others in plain text. Items of operations
+// with item results are validated by handlers.
+func (srv *server) validateBody(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Malformed JSON is reported by handler the same way as without validation.
+		if !json.Valid(body) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		path := specPath(chi.RouteContext(r.Context()).RoutePattern())
+		if err := srv.spec.ValidateRequest(r.Method, path, body); err != nil {
+			if strings.HasPrefix(path, apiV1Prefix) {
+				writeAPIError(w, err, nil)
+				return
+			}
+
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// specPath converts chi route pattern to path of OpenAPI document:
+// drops tenant prefix, wildcards of subrouters and trailing slash.
+func specPath(pattern string) string {
+	pattern = strings.TrimPrefix(pattern, tenantRoutePrefix)
+	pattern = strings.ReplaceAll(pattern, "/*/", "/")
+	pattern = strings.ReplaceAll(pattern, "//", "/")
+
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	if pattern == "" {
+		return "/"
+	}
+
+	return pattern
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test fails if route is added to router without entry in OpenAPI document, or if document describes absent route.
+func Test_openAPICoversRoutes(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	routes := map[string]bool{}
+
+	err := chi.Walk(srv.server.Handler.(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		path := specPath(route)
+		routes[method+" "+path] = true
+
+		assert.NotNil(t, srv.spec.Operation(method, path), "route %s %s is not described in openapi.json", method, route)
+
+		return nil
+	})
+	assert.NoError(t, err)
+
+	for path, item := range srv.spec.Paths {
+		for method := range item.Operations() {
+			assert.True(t, routes[method+" "+path], "openapi.json describes absent route %s %s", method, path)
+		}
+	}
+}
+
+func Test_specPath(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{Name: "root", Pattern: "/", Expected: "/"},
+		{Name: "subrouter root", Pattern: "/update/", Expected: "/update"},
+		{Name: "subrouter root with slash", Pattern: "/update//", Expected: "/update"},
+		{Name: "tenant root", Pattern: "/t/{tenant}/*/", Expected: "/"},
+		{Name: "tenant route", Pattern: "/t/{tenant}/value/{type}/{name}", Expected: "/value/{type}/{name}"},
+		{Name: "api route", Pattern: "/api/v1/updates", Expected: "/api/v1/updates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.Expected, specPath(tt.Pattern))
+		})
+	}
+}
+
+func Test_handlerGetOpenAPI(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	rec := serve(srv, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, JSONCT, rec.Header().Get("Content-Type"))
+	assert.Equal(t, string(openAPIDocument), rec.Body.String())
+}
+
+func Test_validateBody(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		Body           string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "valid metric",
+			URL:            "/update/",
+			Body:           `{"id":"Alloc","type":"gauge","value":1}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "valid metric of tenant",
+			URL:            "/t/team/update/",
+			Body:           `{"id":"Alloc","type":"gauge","value":1}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "missing id",
+			URL:            "/update/",
+			Body:           `{"type":"gauge","value":1}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "string value",
+			URL:            "/t/team/update/",
+			Body:           `{"id":"Alloc","type":"gauge","value":"1"}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "batch is not array",
+			URL:            "/updates/",
+			Body:           `{"id":"Alloc","type":"gauge","value":1}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			rec := serve(srv, httptest.NewRequest("POST", tt.URL, strings.NewReader(tt.Body)))
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/otlp"
+)
+
+// Stores metrics of OTLP/HTTP export request in JSON or protobuf encoding. Data points which can't be stored
+// are reported in response as partial success, other errors fail the whole request.
+func (srv *server) handlerOTLP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+
+	req, err := otlp.Decode(contentType, body)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	conv := newOTLPConverter(srv.limits.checkName)
+	conv.convert(req)
+
+	if err := srv.storeTotals(r, conv.batch, conv.cumulative); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	res := otlp.Response{}
+	if conv.rejected > 0 {
+		res.PartialSuccess = &otlp.PartialSuccess{
+			RejectedDataPoints: conv.rejected,
+			ErrorMessage:       conv.reason,
+		}
+	}
+
+	out, err := res.Encode(contentType)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", otlp.MediaType(contentType))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(out); err != nil {
+		log.Println(err)
+	}
+}
+
+// otlpConverter maps data points of OTLP metrics to metrics. Labels of metrics are attributes of resource and
+// data point. Gauges and non-monotonic cumulative sums are gauges, monotonic sums are counters keeping accumulated
+// values or increments according to temporality. Histograms are gauges "<name>_bucket" with label "le" keeping
+// cumulative counts of buckets, and gauges "<name>_count" and "<name>_sum". The latest point of every series
+// is kept, increments of the same counter are summed. Points of series with invalid names and delta sums with
+// non-integer values are rejected, since increments of counters are integers.
+type otlpConverter struct {
+	index      map[string]int
+	checkName  func(id string) error
+	reason     string
+	batch      []*metric.Metric
+	cumulative []bool
+	times      []uint64
+	rejected   int64
+}
+
+// Constructor.
+func newOTLPConverter(checkName func(id string) error) *otlpConverter {
+	return &otlpConverter{
+		index:     map[string]int{},
+		checkName: checkName,
+	}
+}
+
+// convert maps all metrics of request.
+func (c *otlpConverter) convert(req *otlp.Request) {
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for i := range sm.Metrics {
+				c.convertMetric(rm.Resource.Attributes, &sm.Metrics[i])
+			}
+		}
+	}
+}
+
+func (c *otlpConverter) convertMetric(resource []otlp.KeyValue, m *otlp.Metric) {
+	switch {
+	case m.Name == "":
+		c.reject(m.Name, countPoints(m), "metric without name")
+	case m.Gauge != nil:
+		for _, p := range m.Gauge.DataPoints {
+			c.addNumber(resource, m.Name, p, Gauge, false)
+		}
+	case m.Sum != nil:
+		s := m.Sum
+
+		switch {
+		case s.AggregationTemporality != otlp.TemporalityDelta && s.AggregationTemporality != otlp.TemporalityCumulative:
+			c.reject(m.Name, len(s.DataPoints), "unspecified aggregation temporality")
+		case !s.IsMonotonic && s.AggregationTemporality == otlp.TemporalityDelta:
+			c.reject(m.Name, len(s.DataPoints), "non-monotonic delta sums are not supported")
+		case !s.IsMonotonic:
+			for _, p := range s.DataPoints {
+				c.addNumber(resource, m.Name, p, Gauge, false)
+			}
+		default:
+			for _, p := range s.DataPoints {
+				c.addNumber(resource, m.Name, p, Counter, s.AggregationTemporality == otlp.TemporalityCumulative)
+			}
+		}
+	case m.Histogram != nil:
+		for _, p := range m.Histogram.DataPoints {
+			c.addHistogram(resource, m.Name, p)
+		}
+	default:
+		c.reject(m.Name, countPoints(m), "unsupported metric type")
+	}
+}
+
+func (c *otlpConverter) addNumber(resource []otlp.KeyValue, name string, p otlp.NumberDataPoint, mType string, cumulative bool) {
+	value, ok := p.Value()
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		c.reject(name, 1, "data point without finite value")
+		return
+	}
+
+	labels := otlpLabels(resource, p.Attributes)
+	m := &metric.Metric{ID: seriesID(name, labels), MType: mType, Labels: labels}
+
+	switch {
+	case mType != Counter:
+		m.Value = &value
+	case value < 0:
+		c.reject(name, 1, "negative value of monotonic sum")
+		return
+	case !cumulative && value != math.Trunc(value):
+		c.reject(name, 1, "non-integer value of delta sum")
+		return
+	default:
+		delta := roundTotal(value)
+		m.Delta = &delta
+	}
+
+	c.addPoint(name, uint64(p.TimeUnixNano), cumulative, m)
+}
+
+func (c *otlpConverter) addHistogram(resource []otlp.KeyValue, name string, p otlp.HistogramDataPoint) {
+	if len(p.BucketCounts) > 0 && len(p.BucketCounts) != len(p.ExplicitBounds)+1 {
+		c.reject(name, 1, "number of bucket counts doesn't match explicit bounds")
+		return
+	}
+
+	labels := otlpLabels(resource, p.Attributes)
+	t := uint64(p.TimeUnixNano)
+
+	count := float64(p.Count)
+	point := []*metric.Metric{{ID: seriesID(name+"_count", labels), MType: Gauge, Value: &count, Labels: labels}}
+
+	if p.Sum != nil {
+		sum := *p.Sum
+		point = append(point, &metric.Metric{ID: seriesID(name+"_sum", labels), MType: Gauge, Value: &sum, Labels: labels})
+	}
+
+	accumulated := 0.0
+	for i, n := range p.BucketCounts {
+		accumulated += float64(n)
+
+		le := "+Inf"
+		if i < len(p.ExplicitBounds) {
+			le = strconv.FormatFloat(p.ExplicitBounds[i], 'g', -1, 64)
+		}
+
+		bucketLabels := map[string]string{"le": le}
+		for k, v := range labels {
+			bucketLabels[k] = v
+		}
+
+		value := accumulated
+		point = append(point, &metric.Metric{ID: seriesID(name+"_bucket", bucketLabels), MType: Gauge, Value: &value, Labels: bucketLabels})
+	}
+
+	c.addPoint(name, t, false, point...)
+}
+
+// addPoint adds metrics of data point at time t to batch, if all their names are valid.
+func (c *otlpConverter) addPoint(name string, t uint64, cumulative bool, point ...*metric.Metric) {
+	for _, m := range point {
+		if err := c.checkName(m.ID); err != nil {
+			c.reject(name, 1, err.Error())
+			return
+		}
+	}
+
+	for _, m := range point {
+		c.add(m, t, cumulative)
+	}
+}
+
+// add adds metric of data point at time t to batch.
+func (c *otlpConverter) add(m *metric.Metric, t uint64, cumulative bool) {
+	i, ok := c.index[m.ID]
+	if !ok {
+		c.index[m.ID] = len(c.batch)
+		c.batch = append(c.batch, m)
+		c.cumulative = append(c.cumulative, cumulative)
+		c.times = append(c.times, t)
+		return
+	}
+
+	prev := c.batch[i]
+	if m.MType == Counter && !cumulative && prev.MType == Counter && !c.cumulative[i] {
+		sum := *prev.Delta + *m.Delta
+		prev.Delta = &sum
+		if t > c.times[i] {
+			c.times[i] = t
+		}
+		return
+	}
+
+	if t >= c.times[i] {
+		c.batch[i], c.cumulative[i], c.times[i] = m, cumulative, t
+	}
+}
+
+// reject counts rejected data points and remembers the first reason.
+func (c *otlpConverter) reject(name string, points int, reason string) {
+	if points == 0 {
+		return
+	}
+
+	c.rejected += int64(points)
+	if c.reason == "" {
+		c.reason = fmt.Sprintf("%s: %s", name, reason)
+	}
+}
+
+// countPoints gives number of data points of metric of any type.
+func countPoints(m *otlp.Metric) int {
+	switch {
+	case m.Gauge != nil:
+		return len(m.Gauge.DataPoints)
+	case m.Sum != nil:
+		return len(m.Sum.DataPoints)
+	case m.Histogram != nil:
+		return len(m.Histogram.DataPoints)
+	case m.ExponentialHistogram != nil:
+		return len(m.ExponentialHistogram.DataPoints)
+	case m.Summary != nil:
+		return len(m.Summary.DataPoints)
+	default:
+		return 0
+	}
+}
+
+// otlpLabels gives labels of resource and data point attributes. Attributes of data point override ones of resource.
+func otlpLabels(resource, attributes []otlp.KeyValue) map[string]string {
+	if len(resource)+len(attributes) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(resource)+len(attributes))
+	for _, kv := range resource {
+		labels[kv.Key] = kv.Value.String()
+	}
+	for _, kv := range attributes {
+		labels[kv.Key] = kv.Value.String()
+	}
+
+	return labels
+}
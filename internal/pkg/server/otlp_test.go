@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/otlp"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handlerOTLP(t *testing.T) {
+	resource := map[string]string{"service.name": "checkout", "host.name": "web-1"}
+	with := func(labels map[string]string) map[string]string {
+		res := map[string]string{}
+		for _, l := range []map[string]string{resource, labels} {
+			for k, v := range l {
+				res[k] = v
+			}
+		}
+		return res
+	}
+
+	expected := []struct {
+		Labels map[string]string
+		Name   string
+		Type   string
+		Value  float64
+	}{
+		{Name: "system.memory.usage", Type: Gauge, Value: 2048, Labels: with(map[string]string{"state": "used"})},
+		{Name: "http.server.requests", Type: Counter, Value: 110, Labels: with(map[string]string{"http.route": "/cart"})},
+		{Name: "queue.processed", Type: Counter, Value: 7, Labels: resource},
+		{Name: "queue.size", Type: Gauge, Value: 12.5, Labels: resource},
+		{Name: "http.server.duration_count", Type: Gauge, Value: 6, Labels: with(map[string]string{"http.route": "/cart"})},
+		{Name: "http.server.duration_sum", Type: Gauge, Value: 1.5, Labels: with(map[string]string{"http.route": "/cart"})},
+		{Name: "http.server.duration_bucket", Type: Gauge, Value: 2, Labels: with(map[string]string{"http.route": "/cart", "le": "0.1"})},
+		{Name: "http.server.duration_bucket", Type: Gauge, Value: 5, Labels: with(map[string]string{"http.route": "/cart", "le": "0.5"})},
+		{Name: "http.server.duration_bucket", Type: Gauge, Value: 6, Labels: with(map[string]string{"http.route": "/cart", "le": "+Inf"})},
+	}
+
+	tests := []struct {
+		Name        string
+		Fixture     string
+		ContentType string
+	}{
+		{Name: "json", Fixture: "otlp_metrics.json", ContentType: otlp.ContentTypeJSON},
+		{Name: "protobuf", Fixture: "otlp_metrics.pb", ContentType: otlp.ContentTypeProtobuf},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := newTestServer(t, serverConfig{})
+
+			body, err := os.ReadFile("testdata/" + tt.Fixture)
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest("POST", "/t/team/v1/metrics", bytes.NewReader(body))
+			req.Header.Set("Content-Type", tt.ContentType)
+
+			rec := serve(srv, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.ContentType, rec.Header().Get("Content-Type"))
+
+			// Summary and sum of unspecified temporality are rejected.
+			if tt.ContentType == otlp.ContentTypeJSON {
+				res := otlp.Response{}
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+				assert.NotNil(t, res.PartialSuccess)
+				assert.Equal(t, int64(2), res.PartialSuccess.RejectedDataPoints)
+				assert.Equal(t, "rpc.latency: unsupported metric type", res.PartialSuccess.ErrorMessage)
+			} else {
+				assert.NotEmpty(t, rec.Body.Bytes())
+			}
+
+			batch, err := srv.storage.ForTenant("team").GetBatch()
+			assert.NoError(t, err)
+			assert.Len(t, batch, len(expected))
+
+			for _, e := range expected {
+				m, err := srv.storage.ForTenant("team").GetMetric(seriesID(e.Name, e.Labels))
+				assert.NoError(t, err, e.Name)
+				if err == nil {
+					assert.Equal(t, e.Type, m.MType, e.Name)
+					assert.Equal(t, e.Value, m.SortValue(), e.Name)
+					assert.Equal(t, e.Labels, m.Labels, e.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_handlerOTLPTemporality(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	post := func(temporality, value string) {
+		body := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"jobs","sum":{"aggregationTemporality":` +
+			temporality + `,"isMonotonic":true,"dataPoints":[{"asInt":"` + value + `"}]}}]}]}]}`
+		req := httptest.NewRequest("POST", "/v1/metrics", strings.NewReader(body))
+		req.Header.Set("Content-Type", otlp.ContentTypeJSON)
+
+		rec := serve(srv, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "{}", rec.Body.String())
+	}
+
+	value := func() float64 {
+		m, err := srv.storage.GetMetric("jobs")
+		assert.NoError(t, err)
+		return m.SortValue()
+	}
+
+	post("2", "10")
+	assert.Equal(t, 10.0, value())
+
+	// Cumulative values are converted into increments.
+	post("2", "15")
+	assert.Equal(t, 15.0, value())
+
+	// Decrease of cumulative value is restart of reporting process.
+	post("2", "4")
+	assert.Equal(t, 19.0, value())
+
+	// Delta values are increments already.
+	post("1", "3")
+	assert.Equal(t, 22.0, value())
+}
+
+func Test_handlerOTLPRejected(t *testing.T) {
+	tests := []struct {
+		Name             string
+		Metrics          string
+		ExpectedMessage  string
+		ExpectedStored   []string
+		ExpectedRejected int64
+	}{
+		{
+			Name:             "invalid name",
+			Metrics:          `{"name":"a b","gauge":{"dataPoints":[{"asInt":"1"},{"asInt":"2"}]}},{"name":"up","gauge":{"dataPoints":[{"asInt":"1"}]}}`,
+			ExpectedRejected: 2,
+			ExpectedMessage:  "a b: metric name is invalid",
+			ExpectedStored:   []string{"up"},
+		},
+		{
+			Name:             "invalid name of histogram",
+			Metrics:          `{"name":"a b","histogram":{"dataPoints":[{"count":"1","bucketCounts":["1"]}]}}`,
+			ExpectedRejected: 1,
+			ExpectedMessage:  "a b: metric name is invalid",
+			ExpectedStored:   []string{},
+		},
+		{
+			Name:             "non-integer delta sum",
+			Metrics:          `{"name":"jobs","sum":{"aggregationTemporality":1,"isMonotonic":true,"dataPoints":[{"asDouble":0.5},{"asDouble":2}]}}`,
+			ExpectedRejected: 1,
+			ExpectedMessage:  "jobs: non-integer value of delta sum",
+			ExpectedStored:   []string{"jobs"},
+		},
+		{
+			Name:           "non-integer cumulative sum",
+			Metrics:        `{"name":"jobs","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"asDouble":2.5}]}}`,
+			ExpectedStored: []string{"jobs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := newTestServer(t, serverConfig{NameCharset: testNameCharset})
+
+			body := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[` + tt.Metrics + `]}]}]}`
+			req := httptest.NewRequest("POST", "/v1/metrics", strings.NewReader(body))
+			req.Header.Set("Content-Type", otlp.ContentTypeJSON)
+
+			rec := serve(srv, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := otlp.Response{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			if tt.ExpectedRejected == 0 {
+				assert.Nil(t, res.PartialSuccess)
+			} else if assert.NotNil(t, res.PartialSuccess) {
+				assert.Equal(t, tt.ExpectedRejected, res.PartialSuccess.RejectedDataPoints)
+				assert.Equal(t, tt.ExpectedMessage, res.PartialSuccess.ErrorMessage)
+			}
+
+			batch, err := srv.storage.GetBatch()
+			assert.NoError(t, err)
+
+			stored := []string{}
+			for _, m := range batch {
+				stored = append(stored, m.ID)
+			}
+			assert.ElementsMatch(t, tt.ExpectedStored, stored)
+		})
+	}
+}
+
+func Test_handlerOTLPErrors(t *testing.T) {
+	tests := []struct {
+		Name           string
+		ContentType    string
+		Body           string
+		ExpectedCode   string
+		Config         serverConfig
+		ExpectedStatus int
+	}{
+		{Name: "unsupported content type", ContentType: "text/plain", Body: "{}", ExpectedStatus: http.StatusUnsupportedMediaType, ExpectedCode: "unsupported_media_type"},
+		{Name: "invalid json", ContentType: otlp.ContentTypeJSON, Body: "{", ExpectedStatus: http.StatusBadRequest, ExpectedCode: "invalid_body"},
+		{Name: "invalid protobuf", ContentType: otlp.ContentTypeProtobuf, Body: "\x0a\x05\x01", ExpectedStatus: http.StatusBadRequest, ExpectedCode: "invalid_body"},
+		{
+			Name:           "metric limits",
+			Config:         serverConfig{MaxMetrics: 1},
+			ContentType:    otlp.ContentTypeJSON,
+			Body:           `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"a","gauge":{"dataPoints":[{"asInt":"1"}]}},{"name":"b","gauge":{"dataPoints":[{"asInt":"1"}]}}]}]}]}`,
+			ExpectedStatus: http.StatusTooManyRequests,
+			ExpectedCode:   "cardinality_exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := newTestServer(t, tt.Config)
+
+			req := httptest.NewRequest("POST", "/v1/metrics", strings.NewReader(tt.Body))
+			req.Header.Set("Content-Type", tt.ContentType)
+
+			rec := serve(srv, req)
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"code":"`+tt.ExpectedCode+`"`)
+
+			batch, err := srv.storage.GetBatch()
+			assert.NoError(t, err)
+			assert.Empty(t, batch)
+		})
+	}
+
+	// Write scope is required.
+	srv := newTestServer(t, serverConfig{
+		Tokens: []auth.Token{{Token: "reader", Scopes: []string{auth.ScopeRead}}},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/metrics", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", otlp.ContentTypeJSON)
+	req.Header.Set("Authorization", "Bearer reader")
+	assert.Equal(t, http.StatusForbidden, serve(srv, req).Code)
+}
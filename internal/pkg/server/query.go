@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/counters"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// queryResult is the body of query response. NextCursor is empty on the last page.
+type queryResult struct {
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Metrics    []queryMetric `json:"metrics"`
+}
+
+// queryMetric is metric of query results. Counters are given with their rates and resets if they are tracked.
+type queryMetric struct {
+	*metric.Metric
+	Counter *counters.State `json:"counter,omitempty"`
+}
+
+// queryCursor is the content of opaque cursor. Cursor is valid only with the sort order it was given for.
+// Sort value is kept as bits of float, since JSON can't keep NaN and infinities.
+type queryCursor struct {
+	SortBy    string `json:"sort"`
+	ID        string `json:"id"`
+	MType     string `json:"type"`
+	ValueBits uint64 `json:"value_bits"`
+	Desc      bool   `json:"desc,omitempty"`
+}
+
+// newQueryCursor gives cursor of the page ending with metric.
+func newQueryCursor(m *metric.Metric, q metric.Query) queryCursor {
+	c := metric.CursorOf(m)
+
+	return queryCursor{
+		SortBy:    q.SortBy,
+		Desc:      q.Desc,
+		ID:        c.ID,
+		MType:     c.MType,
+		ValueBits: math.Float64bits(c.Value),
+	}
+}
+
+// cursor gives position in query results.
+func (c *queryCursor) cursor() *metric.Cursor {
+	return &metric.Cursor{
+		ID:    c.ID,
+		MType: c.MType,
+		Value: math.Float64frombits(c.ValueBits),
+	}
+}
+
+// Outputs metrics of request tenant selected by query parameters in json-format:
+// "name" is glob of names, "name_regex" is regular expression of names, "type" is metric type,
+// "label" is matcher in format "key=value", "key!=value", "key=~regex" or "key!~regex",
+// "sort" is "name", "type" or "value" with optional "-" for descending order,
+// "limit" is page size and "cursor" is "next_cursor" of previous page.
+func (srv *server) handlerQuery(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	st, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	// One more metric is requested to know if there is the next page.
+	pageLimit := q.Limit
+	q.Limit++
+
+	batch, err := queryStorage(st, q)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	res := queryResult{
+		Metrics: make([]queryMetric, 0, len(batch)),
+	}
+
+	if len(batch) > pageLimit {
+		batch = batch[:pageLimit]
+		if res.NextCursor, err = encodeCursor(newQueryCursor(batch[pageLimit-1], q)); err != nil {
+			writeAPIError(w, err, nil)
+			return
+		}
+	}
+
+	for i := range batch {
+		m := *batch[i]
+		m.Hash = ""
+
+		qm := queryMetric{Metric: &m}
+		if m.MType == Counter {
+			if s, ok := srv.counters.Get(tenant, m.ID); ok {
+				qm.Counter = &s
+			}
+		}
+		res.Metrics = append(res.Metrics, qm)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// queryStorage selects metrics of storage by query. Storages which can't run queries are filtered in memory.
+func queryStorage(st metric.MetricStorage, q metric.Query) ([]*metric.Metric, error) {
+	if querier, ok := st.(metric.Querier); ok {
+		return querier.Query(q)
+	}
+
+	batch, err := st.GetBatch()
+	if err != nil {
+		return nil, err
+	}
+
+	return metric.QueryBatch(batch, q)
+}
+
+// parseQuery gets metric query from request parameters.
+func parseQuery(r *http.Request) (metric.Query, error) {
+	params := r.URL.Query()
+
+	q := metric.Query{
+		NameGlob:   params.Get("name"),
+		NameRegexp: params.Get("name_regex"),
+		MType:      params.Get("type"),
+		SortBy:     strings.TrimPrefix(params.Get("sort"), "-"),
+		Desc:       strings.HasPrefix(params.Get("sort"), "-"),
+		Limit:      defaultQueryLimit,
+	}
+
+	if q.SortBy == "" {
+		q.SortBy = metric.SortByName
+	}
+
+	if q.MType != "" {
+		if err := checkTypeSupport(q.MType); err != nil {
+			return q, err
+		}
+	}
+
+	for _, param := range params["label"] {
+		lm, err := parseLabelMatcher(param)
+		if err != nil {
+			return q, err
+		}
+		q.Labels = append(q.Labels, lm)
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxQueryLimit {
+			return q, fmt.Errorf("%w: limit must be from 1 to %d", metric.ErrInvalidQuery, maxQueryLimit)
+		}
+		q.Limit = n
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+
+		if c.SortBy != q.SortBy || c.Desc != q.Desc {
+			return q, fmt.Errorf("%w: cursor is given for another sort order", errInvalidCursor)
+		}
+		q.Cursor = c.cursor()
+	}
+
+	return q, q.Validate()
+}
+
+// parseLabelMatcher parses label matcher in format "key=value", "key!=value", "key=~regex" or "key!~regex".
+func parseLabelMatcher(param string) (metric.LabelMatcher, error) {
+	i := strings.IndexAny(param, "=!")
+	if i < 1 {
+		return metric.LabelMatcher{}, fmt.Errorf("%w: invalid label matcher %q", metric.ErrInvalidQuery, param)
+	}
+
+	lm := metric.LabelMatcher{Name: param[:i]}
+
+	for _, op := range []string{metric.MatchNotEqual, metric.MatchRegexp, metric.MatchNotRegexp, metric.MatchEqual} {
+		if strings.HasPrefix(param[i:], op) {
+			lm.Op = op
+			lm.Value = param[i+len(op):]
+
+			return lm, nil
+		}
+	}
+
+	return metric.LabelMatcher{}, fmt.Errorf("%w: invalid label matcher %q", metric.ErrInvalidQuery, param)
+}
+
+// encodeCursor makes opaque cursor string.
+func encodeCursor(c queryCursor) (string, error) {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(body), nil
+}
+
+// decodeCursor parses opaque cursor string.
+func decodeCursor(s string) (queryCursor, error) {
+	c := queryCursor{}
+
+	body, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: %v", errInvalidCursor, err)
+	}
+
+	if err := json.Unmarshal(body, &c); err != nil {
+		return c, fmt.Errorf("%w: %v", errInvalidCursor, err)
+	}
+
+	return c, nil
+}
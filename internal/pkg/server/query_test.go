@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+// queryIDs runs query and gives names of found metrics and cursor of the next page.
+func queryIDs(t *testing.T, srv *server, query string) ([]string, string) {
+	rec := serve(srv, httptest.NewRequest("GET", "/t/team/api/query"+query, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := queryResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+
+	ids := []string{}
+	for _, m := range res.Metrics {
+		ids = append(ids, m.ID)
+	}
+
+	return ids, res.NextCursor
+}
+
+func Test_handlerQuery(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	body := `[{"id":"HeapAlloc","type":"gauge","value":30,"labels":{"host":"a"}},` +
+		`{"id":"HeapSys","type":"gauge","value":10,"labels":{"host":"b"}},` +
+		`{"id":"Alloc","type":"gauge","value":20},` +
+		`{"id":"PollCount","type":"counter","delta":5}]`
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/team/api/v1/updates", strings.NewReader(body))).Code)
+
+	tests := []struct {
+		Name     string
+		Query    string
+		Expected []string
+	}{
+		{
+			Name:     "everything",
+			Expected: []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount"},
+		},
+		{
+			Name:     "glob and type",
+			Query:    "?name=Heap*&type=gauge",
+			Expected: []string{"HeapAlloc", "HeapSys"},
+		},
+		{
+			Name:     "regexp",
+			Query:    "?name_regex=" + url.QueryEscape("(Heap)?Alloc"),
+			Expected: []string{"Alloc", "HeapAlloc"},
+		},
+		{
+			Name:     "label matchers",
+			Query:    "?label=" + url.QueryEscape("host=~a|b") + "&label=" + url.QueryEscape("host!=b"),
+			Expected: []string{"HeapAlloc"},
+		},
+		{
+			Name:     "sort by value descending",
+			Query:    "?sort=-value",
+			Expected: []string{"HeapAlloc", "Alloc", "HeapSys", "PollCount"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			ids, cursor := queryIDs(t, srv, tt.Query)
+			assert.Equal(t, tt.Expected, ids)
+			assert.Empty(t, cursor)
+		})
+	}
+
+	t.Run("tenants are isolated", func(t *testing.T) {
+		rec := serve(srv, httptest.NewRequest("GET", "/api/query", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"metrics":[]}`, rec.Body.String())
+	})
+}
+
+func Test_handlerQueryPagination(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	body := `[{"id":"A","type":"gauge","value":3},{"id":"B","type":"gauge","value":1},` +
+		`{"id":"C","type":"gauge","value":2},{"id":"D","type":"gauge","value":1},{"id":"E","type":"gauge","value":5}]`
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/team/api/v1/updates", strings.NewReader(body))).Code)
+
+	pages := [][]string{}
+
+	ids, cursor := queryIDs(t, srv, "?sort=value&limit=2")
+	pages = append(pages, ids)
+
+	for cursor != "" {
+		ids, cursor = queryIDs(t, srv, "?sort=value&limit=2&cursor="+cursor)
+		pages = append(pages, ids)
+	}
+
+	assert.Equal(t, [][]string{{"B", "D"}, {"C", "A"}, {"E"}}, pages)
+}
+
+func Test_encodeCursor(t *testing.T) {
+	q := metric.Query{SortBy: metric.SortByValue, Desc: true}
+
+	for _, value := range []float64{1.5, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		v := value
+
+		encoded, err := encodeCursor(newQueryCursor(&metric.Metric{ID: "A", MType: Gauge, Value: &v}, q))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, encoded)
+
+		c, err := decodeCursor(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, q.SortBy, c.SortBy)
+		assert.True(t, c.Desc)
+		assert.Equal(t, "A", c.cursor().ID)
+		assert.Equal(t, math.Float64bits(value), math.Float64bits(c.cursor().Value))
+	}
+}
+
+func Test_handlerQueryErrors(t *testing.T) {
+	cursor, err := encodeCursor(queryCursor{ID: "A", SortBy: metric.SortByName})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		Name           string
+		Query          string
+		ExpectedCode   string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "invalid regexp",
+			Query:          "?name_regex=" + url.QueryEscape("("),
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_query",
+		},
+		{
+			Name:           "unknown sort",
+			Query:          "?sort=hash",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_query",
+		},
+		{
+			Name:           "invalid label matcher",
+			Query:          "?label=host",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_query",
+		},
+		{
+			Name:           "limit is too large",
+			Query:          "?limit=1001",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_query",
+		},
+		{
+			Name:           "unsupported type",
+			Query:          "?type=hist",
+			ExpectedStatus: http.StatusNotImplemented,
+			ExpectedCode:   "unsupported_type",
+		},
+		{
+			Name:           "malformed cursor",
+			Query:          "?cursor=%25%25",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_cursor",
+		},
+		{
+			Name:           "cursor of another sort order",
+			Query:          "?sort=-name&cursor=" + cursor,
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedCode:   "invalid_cursor",
+		},
+	}
+
+	srv := newTestServer(t, serverConfig{})
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			rec := serve(srv, httptest.NewRequest("GET", "/api/query"+tt.Query, nil))
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+
+			res := apiErrorResponse{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tt.ExpectedCode, res.Error.Code)
+		})
+	}
+}
+
+func Test_parseLabelMatcher(t *testing.T) {
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Param         string
+		Expected      metric.LabelMatcher
+	}{
+		{Name: "equal", Param: "host=a=b", Expected: metric.LabelMatcher{Name: "host", Op: metric.MatchEqual, Value: "a=b"}},
+		{Name: "not equal", Param: "host!=a", Expected: metric.LabelMatcher{Name: "host", Op: metric.MatchNotEqual, Value: "a"}},
+		{Name: "regexp", Param: "host=~a|b", Expected: metric.LabelMatcher{Name: "host", Op: metric.MatchRegexp, Value: "a|b"}},
+		{Name: "not regexp", Param: "host!~a.*", Expected: metric.LabelMatcher{Name: "host", Op: metric.MatchNotRegexp, Value: "a.*"}},
+		{Name: "empty value", Param: "host=", Expected: metric.LabelMatcher{Name: "host", Op: metric.MatchEqual}},
+		{Name: "missing name", Param: "=a", ExpectedError: metric.ErrInvalidQuery},
+		{Name: "missing operator", Param: "host!a", ExpectedError: metric.ErrInvalidQuery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			lm, err := parseLabelMatcher(tt.Param)
+			assert.ErrorIs(t, err, tt.ExpectedError)
+			assert.Equal(t, tt.Expected, lm)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/prompb"
+)
+
+// Maximal size of decompressed remote write request.
+const maxRemoteWriteSize = 32 << 20
+
+// Stores samples of Prometheus remote write request: snappy-compressed protobuf WriteRequest.
+// Only the latest sample of every series is stored.
+func (srv *server) handlerRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	req, err := prompb.Decode(body, maxRemoteWriteSize)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	batch, err := remoteWriteBatch(req)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if err := srv.storeTotals(r, batch, nil); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storeTotals stores batch whose counters keep accumulated values instead of increments. If cumulative is defined,
+// only counters marked in it keep accumulated values.
+func (srv *server) storeTotals(r *http.Request, batch []*metric.Metric, cumulative []bool) error {
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		return err
+	}
+
+	return srv.storeTenantTotals(r.Context(), tenant, batch, cumulative)
+}
+
+// storeTenantTotals is storeTotals for tenant given without request.
+func (srv *server) storeTenantTotals(ctx context.Context, tenant string, batch []*metric.Metric, cumulative []bool) error {
+	if err := srv.limits.checkBatchLength(len(batch)); err != nil {
+		return err
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return srv.totals.store(srv.tenantMetrics(ctx, tenant), tenant, batch, cumulative, func() error {
+		return srv.storeTenantMetrics(ctx, tenant, batch...)
+	})
+}
+
+// remoteWriteBatch maps series of request to metrics. Series are counters if their metadata say so, or if they have
+// no metadata and their names end with "_total", other series are gauges. Counters keep accumulated values rounded
+// to integers. Samples which are not finite, e.g. staleness markers, are skipped.
+func remoteWriteBatch(req *prompb.WriteRequest) ([]*metric.Metric, error) {
+	types := map[string]int{}
+	for _, md := range req.Metadata {
+		types[md.MetricFamilyName] = md.Type
+	}
+
+	batch := []*metric.Metric{}
+	index := map[string]int{}
+	times := []int64{}
+
+	for _, ts := range req.Timeseries {
+		name := ts.Name()
+		if name == "" {
+			return nil, fmt.Errorf("%w: series without %s label", prompb.ErrInvalidRequest, prompb.NameLabel)
+		}
+
+		latest := -1
+		for i, s := range ts.Samples {
+			if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				continue
+			}
+			if latest < 0 || s.Timestamp >= ts.Samples[latest].Timestamp {
+				latest = i
+			}
+		}
+
+		if latest < 0 {
+			continue
+		}
+		sample := ts.Samples[latest]
+
+		var labels map[string]string
+		for _, l := range ts.Labels {
+			if l.Name == prompb.NameLabel {
+				continue
+			}
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[l.Name] = l.Value
+		}
+
+		m := &metric.Metric{ID: seriesID(name, labels), Labels: labels}
+
+		mType, ok := types[name]
+		if mType == prompb.TypeCounter || !ok && strings.HasSuffix(name, "_total") {
+			total := roundTotal(sample.Value)
+			m.MType, m.Delta = Counter, &total
+		} else {
+			value := sample.Value
+			m.MType, m.Value = Gauge, &value
+		}
+
+		// Repeated series keep the latest sample.
+		if i, ok := index[m.ID]; ok {
+			if sample.Timestamp >= times[i] {
+				batch[i], times[i] = m, sample.Timestamp
+			}
+			continue
+		}
+
+		index[m.ID] = len(batch)
+		batch = append(batch, m)
+		times = append(times, sample.Timestamp)
+	}
+
+	return batch, nil
+}
+
+// seriesID gives metric name for series of external systems. Series with labels get suffix of labels hash,
+// so series of the same name with different labels are kept as different metrics, e.g. "up:5f0a3c1b".
+func seriesID(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New32a()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(labels[k]))
+		h.Write([]byte{0})
+	}
+
+	return fmt.Sprintf("%s:%08x", name, h.Sum32())
+}
+
+// seriesName gives name of series metric was stored by, i.e. metric name without suffix of labels hash.
+func seriesName(m *metric.Metric) string {
+	if i := strings.LastIndexByte(m.ID, ':'); i > 0 && seriesID(m.ID[:i], m.Labels) == m.ID {
+		return m.ID[:i]
+	}
+
+	return m.ID
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+// postFixture sends recorded remote write request to server.
+func postFixture(t *testing.T, srv *server, url, fixture string) *httptest.ResponseRecorder {
+	body, err := os.ReadFile("testdata/" + fixture)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	return serve(srv, req)
+}
+
+func Test_handlerRemoteWrite(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+	st := srv.storage.ForTenant("team")
+
+	upID := seriesID("up", map[string]string{"instance": "localhost:9100", "job": "node"})
+	cpuID := seriesID("node_cpu_seconds_total", map[string]string{"cpu": "0", "instance": "localhost:9100", "job": "node", "mode": "idle"})
+	requestsID := seriesID("http_requests_total", map[string]string{"code": "200"})
+
+	// Stored value of counter is the base of its first reported total.
+	delta := int64(4)
+	assert.NoError(t, st.UpdateMetric(&metric.Metric{ID: requestsID, MType: Counter, Delta: &delta}))
+
+	assert.Equal(t, http.StatusNoContent, postFixture(t, srv, "/t/team/api/v1/write", "remote_write.snappy").Code)
+
+	tests := []struct {
+		ExpectedLabels map[string]string
+		Name           string
+		ID             string
+		ExpectedType   string
+		ExpectedValue  float64
+	}{
+		{Name: "gauge by metadata", ID: upID, ExpectedType: Gauge, ExpectedValue: 1, ExpectedLabels: map[string]string{"instance": "localhost:9100", "job": "node"}},
+		{Name: "counter by metadata, the latest sample", ID: cpuID, ExpectedType: Counter, ExpectedValue: 120},
+		{Name: "gauge without labels", ID: "process_resident_memory_bytes", ExpectedType: Gauge, ExpectedValue: 1.2e7},
+		{Name: "counter by name", ID: requestsID, ExpectedType: Counter, ExpectedValue: 10, ExpectedLabels: map[string]string{"code": "200"}},
+		{Name: "series with exemplar", ID: "rpc_duration_seconds", ExpectedType: Gauge, ExpectedValue: 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			m, err := st.GetMetric(tt.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ExpectedType, m.MType)
+			assert.Equal(t, tt.ExpectedValue, m.SortValue())
+			if tt.ExpectedLabels != nil {
+				assert.Equal(t, tt.ExpectedLabels, m.Labels)
+			}
+		})
+	}
+
+	// Staleness markers are skipped.
+	_, err := st.GetMetric("go_goroutines")
+	assert.ErrorIs(t, err, metric.ErrMetricDoesntExist)
+
+	// Decrease of total is a restart of reporting process.
+	assert.Equal(t, http.StatusNoContent, postFixture(t, srv, "/t/team/api/v1/write", "remote_write_reset.snappy").Code)
+
+	for id, expected := range map[string]float64{cpuID: 125, requestsID: 15, upID: 0} {
+		m, err := st.GetMetric(id)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, m.SortValue(), id)
+	}
+
+	rec := serve(srv, httptest.NewRequest("GET", "/t/team/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `http_requests_total{code="200"} 15`)
+	assert.Contains(t, rec.Body.String(), `up{instance="localhost:9100",job="node"} 0`)
+}
+
+func Test_handlerRemoteWriteErrors(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	rec := postFixture(t, srv, "/api/v1/write", "remote_write_unnamed.snappy")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_body"`)
+
+	rec = serve(srv, httptest.NewRequest("POST", "/api/v1/write", strings.NewReader("not snappy")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	srv = newTestServer(t, serverConfig{MaxBatchLength: 2})
+	rec = postFixture(t, srv, "/api/v1/write", "remote_write.snappy")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
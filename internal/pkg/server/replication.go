@@ -0,0 +1,379 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/replication"
+)
+
+var (
+	errStandby        = errors.New("server is in standby mode")
+	errNotStandby     = errors.New("server is not in standby mode")
+	errReplicationOff = errors.New("replication is turned off")
+)
+
+const (
+	RolePrimary = "primary"
+	RoleStandby = "standby"
+
+	NDJSONContentType = "application/x-ndjson"
+)
+
+// follower runs replication of primary storage while server is standby.
+type follower struct {
+	*replication.Follower
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+// initReplication initializes log of changes for standbys and standby mode according to server configuration.
+func (srv *server) initReplication() {
+	if srv.config.EnableReplication {
+		srv.replication = replication.NewLog(srv.config.ReplicationLogSize)
+	}
+
+	if srv.config.ReplicaOf != "" {
+		srv.follower = &follower{
+			Follower: replication.NewFollower(srv.config.ReplicaOf, srv.config.ReplicaHeaders, srv.applyReplication, srv.dropTenants),
+		}
+		atomic.StoreInt32(&srv.standby, 1)
+	}
+}
+
+// isStandby checks if server follows primary.
+func (srv *server) isStandby() bool {
+	return atomic.LoadInt32(&srv.standby) == 1
+}
+
+// checkWritable rejects changes of storage by clients while server is standby.
+func (srv *server) checkWritable() error {
+	if srv.isStandby() {
+		return errStandby
+	}
+
+	return nil
+}
+
+// startFollower starts following primary.
+func (srv *server) startFollower() {
+	f := srv.follower
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel, f.done = cancel, make(chan struct{})
+
+	go func(done chan struct{}) {
+		f.Run(ctx)
+		close(done)
+	}(f.done)
+}
+
+// stopFollower stops following primary and waits until the last change is applied.
+func (srv *server) stopFollower() {
+	f := srv.follower
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.cancel == nil {
+		return
+	}
+
+	f.cancel()
+	<-f.done
+	f.cancel = nil
+}
+
+// promote turns standby into primary accepting writes.
+func (srv *server) promote() error {
+	if srv.follower == nil || !srv.isStandby() {
+		return errNotStandby
+	}
+
+	srv.stopFollower()
+
+	if !atomic.CompareAndSwapInt32(&srv.standby, 1, 0) {
+		return errNotStandby
+	}
+
+	return nil
+}
+
+// replicate logs values of stored metrics of batch for standbys.
+func (srv *server) replicate(st metric.MetricStorage, tenant string, batch []*metric.Metric) {
+	if srv.replication == nil {
+		return
+	}
+
+	ids := make([]string, 0, len(batch))
+	for i := range batch {
+		ids = append(ids, batch[i].ID)
+	}
+
+	if err := srv.replication.Append(tenant, nil, func() ([]*metric.Metric, error) {
+		return st.GetMetrics(ids)
+	}); err != nil {
+		log.Println(err)
+	}
+}
+
+// replicateDeletion logs deletion of metrics for standbys.
+func (srv *server) replicateDeletion(tenant string, ids ...string) {
+	if err := srv.replication.Append(tenant, ids, nil); err != nil {
+		log.Println(err)
+	}
+}
+
+// forgetMetric removes everything known about deleted metric.
+func (srv *server) forgetMetric(tenant, id string) {
+	srv.limits.release(tenant, id)
+	srv.history.Forget(tenant, id)
+	srv.counters.Forget(tenant, id)
+	srv.totals.forget(tenant, id)
+	srv.updated.forget(tenant, id)
+}
+
+// applyReplication applies change of primary storage. Metrics are set to values of primary: counters
+// are incremented by difference between values of primary and standby.
+func (srv *server) applyReplication(e replication.Entry) error {
+	st := srv.storage.ForTenant(e.Tenant)
+
+	deleted := e.Deleted
+
+	if e.Snapshot {
+		stored, err := st.GetBatch()
+		if err != nil {
+			return err
+		}
+
+		kept := make(map[string]struct{}, len(e.Metrics))
+		for _, m := range e.Metrics {
+			kept[m.ID] = struct{}{}
+		}
+
+		for _, m := range stored {
+			if _, ok := kept[m.ID]; !ok {
+				deleted = append(deleted, m.ID)
+			}
+		}
+	}
+
+	if err := srv.deleteReplicated(st, e.Tenant, deleted); err != nil {
+		return err
+	}
+
+	if len(e.Metrics) == 0 {
+		return nil
+	}
+
+	counterIDs := []string{}
+	for _, m := range e.Metrics {
+		if m.MType == Counter {
+			counterIDs = append(counterIDs, m.ID)
+		}
+	}
+
+	current := map[string]int64{}
+	if len(counterIDs) > 0 {
+		stored, err := st.GetMetrics(counterIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range stored {
+			if m.MType == Counter && m.Delta != nil {
+				current[m.ID] = *m.Delta
+			}
+		}
+	}
+
+	batch := make([]*metric.Metric, 0, len(e.Metrics))
+	ids := make([]string, 0, len(e.Metrics))
+
+	for _, m := range e.Metrics {
+		if m.MType == Counter && m.Delta != nil {
+			increment := *m.Delta - current[m.ID]
+			m.Delta = &increment
+		}
+
+		batch = append(batch, m)
+		ids = append(ids, m.ID)
+	}
+
+	events := srv.broker.Snapshot(e.Tenant, batch...)
+
+	if err := st.UpdateBatch(batch); err != nil {
+		return err
+	}
+
+	srv.limits.keep(e.Tenant, ids...)
+	srv.broker.Publish(events...)
+	srv.updated.touch(e.Tenant, batch, time.Now())
+	srv.trackCounters(st, e.Tenant, batch, make([]int64, len(batch)))
+	srv.replicate(st, e.Tenant, batch)
+
+	if srv.config.StoreInterval == 0 {
+		srv.fileUpload()
+	}
+
+	return nil
+}
+
+// deleteReplicated deletes metrics deleted by primary.
+func (srv *server) deleteReplicated(st metric.MetricStorage, tenant string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := st.DeleteMetric(id); err != nil && !errors.Is(err, metric.ErrMetricDoesntExist) {
+			return err
+		}
+
+		srv.forgetMetric(tenant, id)
+	}
+
+	srv.replicateDeletion(tenant, ids...)
+
+	if srv.config.StoreInterval == 0 {
+		srv.fileUpload()
+	}
+
+	return nil
+}
+
+// dropTenants deletes metrics of tenants missing on primary.
+func (srv *server) dropTenants(tenants []string) error {
+	kept := make(map[string]struct{}, len(tenants))
+	for _, tenant := range tenants {
+		kept[tenant] = struct{}{}
+	}
+
+	stored, err := srv.storage.Tenants()
+	if err != nil {
+		return err
+	}
+
+	for _, tenant := range stored {
+		if _, ok := kept[tenant]; ok {
+			continue
+		}
+
+		st := srv.storage.ForTenant(tenant)
+
+		batch, err := st.GetBatch()
+		if err != nil {
+			return err
+		}
+
+		ids := make([]string, 0, len(batch))
+		for i := range batch {
+			ids = append(ids, batch[i].ID)
+		}
+
+		if err := srv.deleteReplicated(st, tenant, ids); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replicationSnapshot gives the whole content of storage by tenants.
+func (srv *server) replicationSnapshot() ([]replication.Entry, error) {
+	tenants, err := srv.storage.Tenants()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]replication.Entry, 0, len(tenants))
+	for _, tenant := range tenants {
+		batch, err := srv.storage.ForTenant(tenant).GetBatch()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range batch {
+			m := *batch[i]
+			m.Hash = ""
+			batch[i] = &m
+		}
+
+		entries = append(entries, replication.Entry{Tenant: tenant, Metrics: batch})
+	}
+
+	return entries, nil
+}
+
+// Streams changes of storage to standby as json lines. Query parameter "position" is position of standby
+// in replication log, if it is missing or unknown, stream starts with snapshot of storage.
+func (srv *server) handlerReplicationStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println(errStreamingUnsupported)
+		http.Error(w, errStreamingUnsupported.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if srv.replication == nil {
+		log.Println(errReplicationOff)
+		http.Error(w, errReplicationOff.Error(), errorStatus(errReplicationOff))
+		return
+	}
+
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	position := r.URL.Query().Get("position")
+	if err := srv.replication.Serve(r.Context(), w, flusher.Flush, position, srv.replicationSnapshot); err != nil {
+		log.Println(err)
+	}
+}
+
+// Outputs role of server and its position in replication: position of its log for primary,
+// position applied from primary for standby.
+func (srv *server) handlerGetReplication(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.replicationStatus())
+}
+
+// Turns standby into primary: stops following primary and starts accepting writes.
+func (srv *server) handlerPromote(w http.ResponseWriter, r *http.Request) {
+	if err := srv.promote(); err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, srv.replicationStatus())
+}
+
+// replicationStatus describes replication state of server.
+func (srv *server) replicationStatus() replication.Status {
+	status := srv.replication.Status()
+	status.Role = RolePrimary
+
+	if srv.isStandby() {
+		followed := srv.follower.Status()
+		status.Role = RoleStandby
+		status.Primary = followed.Primary
+		status.Log = followed.Log
+		status.Seq = followed.Seq
+		status.Connected = followed.Connected
+	}
+
+	return status
+}
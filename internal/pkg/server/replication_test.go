@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/replication"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_replication(t *testing.T) {
+	primary := newTestServer(t, serverConfig{EnableReplication: true})
+	primary.initReplication()
+
+	ts := httptest.NewServer(primary.server.Handler)
+	defer ts.Close()
+
+	for _, target := range []string{"/t/team/update/counter/jobs/3", "/t/team/update/gauge/load/0.5", "/update/gauge/up/1"} {
+		assert.Equal(t, http.StatusOK, serve(primary, httptest.NewRequest("POST", target, nil)).Code)
+	}
+
+	standby := newTestServer(t, serverConfig{ReplicaOf: ts.URL})
+	standby.initReplication()
+
+	old := 1.0
+	assert.NoError(t, standby.storage.ForTenant("stale").UpdateMetric(&metric.Metric{ID: "old", MType: Gauge, Value: &old}))
+
+	standby.startFollower()
+	defer standby.stopFollower()
+
+	value := func(tenant, id string) float64 {
+		m, err := standby.storage.ForTenant(tenant).GetMetric(id)
+		if err != nil {
+			return -1
+		}
+
+		return m.SortValue()
+	}
+
+	// Standby starts from snapshot of primary, metrics missing on primary are dropped.
+	assert.Eventually(t, func() bool {
+		return value("team", "jobs") == 3 && value("", "up") == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0.5, value("team", "load"))
+	assert.Equal(t, -1.0, value("stale", "old"))
+
+	// Counters are set to values of primary, deletions are repeated.
+	assert.Equal(t, http.StatusOK, serve(primary, httptest.NewRequest("POST", "/t/team/update/counter/jobs/4", nil)).Code)
+	assert.Equal(t, http.StatusOK, serve(primary, httptest.NewRequest("DELETE", "/t/team/value/gauge/load", nil)).Code)
+
+	assert.Eventually(t, func() bool {
+		return value("team", "jobs") == 7 && value("team", "load") == -1
+	}, time.Second, 5*time.Millisecond)
+
+	rec := serve(standby, httptest.NewRequest("GET", "/replication", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	status := replication.Status{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, RoleStandby, status.Role)
+	assert.Equal(t, primary.replication.Status().Log, status.Log)
+	assert.Equal(t, primary.replication.Status().Seq, status.Seq)
+	assert.True(t, status.Connected)
+	assert.Equal(t, int64(1), primary.replication.Status().Standbys)
+
+	// Standby rejects writes.
+	rec = serve(standby, httptest.NewRequest("POST", "/t/team/update/counter/jobs/1", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = serve(standby, httptest.NewRequest("POST", "/api/v1/update", strings.NewReader(`{"id":"jobs","type":"counter","delta":1}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"standby"`)
+
+	rec = serve(standby, httptest.NewRequest("DELETE", "/value/gauge/up", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// Promoted standby accepts writes and doesn't follow primary anymore.
+	rec = serve(standby, httptest.NewRequest("POST", "/admin/promote", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, RolePrimary, status.Role)
+
+	assert.Equal(t, http.StatusOK, serve(standby, httptest.NewRequest("POST", "/t/team/update/counter/jobs/1", nil)).Code)
+	assert.Equal(t, 8.0, value("team", "jobs"))
+
+	assert.Equal(t, http.StatusOK, serve(primary, httptest.NewRequest("POST", "/update/gauge/up/0", nil)).Code)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1.0, value("", "up"))
+
+	rec = serve(standby, httptest.NewRequest("POST", "/admin/promote", nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"not_standby"`)
+
+	// Server without log of changes can't be followed.
+	rec = serve(standby, httptest.NewRequest("GET", replication.Path, nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func Test_replicationShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := l.Addr().String()
+	assert.NoError(t, l.Close())
+
+	primary := NewServer(serverConfig{
+		ServerAddress:     address,
+		FileDestination:   filepath.Join(t.TempDir(), "metrics.json"),
+		StoreInterval:     time.Hour,
+		EnableReplication: true,
+	})
+	assert.NoError(t, primary.Init())
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- primary.Run()
+	}()
+
+	standby := newTestServer(t, serverConfig{ReplicaOf: "http://" + address})
+	standby.initReplication()
+	standby.startFollower()
+	defer standby.stopFollower()
+
+	assert.Eventually(t, func() bool {
+		return primary.replication.Status().Standbys == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Primary doesn't wait for streams of connected standbys.
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- primary.Shutdown()
+	}()
+
+	for _, done := range []chan error{shutdown, stopped} {
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("primary isn't stopped")
+		}
+	}
+}
+
+func Test_applyReplicationSnapshot(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	jobs, load := int64(5), 0.5
+	assert.NoError(t, srv.storage.ForTenant("").UpdateBatch([]*metric.Metric{
+		{ID: "jobs", MType: Counter, Delta: &jobs},
+		{ID: "load", MType: Gauge, Value: &load},
+	}))
+
+	total := int64(2)
+	assert.NoError(t, srv.applyReplication(replication.Entry{
+		Snapshot: true,
+		Metrics:  []*metric.Metric{{ID: "jobs", MType: Counter, Delta: &total}},
+	}))
+
+	batch, err := srv.storage.GetBatch()
+	assert.NoError(t, err)
+	if assert.Len(t, batch, 1) {
+		assert.Equal(t, int64(2), *batch[0].Delta)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
+)
+
+var (
+	errInvalidRange = errors.New("invalid range")
+	errRollupsOff   = errors.New("rollups are turned off")
+)
+
+const (
+	// Period of removing points kept longer than retention of their tiers.
+	rollupPruneInterval = time.Hour
+
+	// Number of points range query gives by default.
+	defaultRangePoints = 500
+
+	// Default length of range of range query.
+	defaultRange = time.Hour
+)
+
+// rangeResult is the body of range query response.
+type rangeResult struct {
+	ID         string       `json:"id"`
+	Resolution string       `json:"resolution"`
+	Step       string       `json:"step"`
+	Points     []rangePoint `json:"points"`
+}
+
+// rangePoint is aggregate of metric over step. For counters min, max, avg and sum are of increments.
+type rangePoint struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Sum   float64   `json:"sum"`
+	Last  float64   `json:"last"`
+	Count int64     `json:"count"`
+}
+
+// initRollups initializes rollup tiers defined in config. Storage must keep rollup points.
+func (srv *server) initRollups() error {
+	if srv.config.RollupTiers == "" {
+		return nil
+	}
+
+	tiers, err := rollup.ParseTiers(srv.config.RollupTiers)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := srv.storage.(rollup.Storage); !ok {
+		return fmt.Errorf("%w: storage doesn't keep rollups", rollup.ErrInvalidTiers)
+	}
+
+	srv.tiers = tiers
+	srv.sampler = rollup.NewSampler()
+
+	return nil
+}
+
+// rollupMetrics samples metrics at resolution of the first tier and compacts coarser tiers until server shutdown.
+func (srv *server) rollupMetrics() {
+	ticker := time.NewTicker(srv.tiers[0].Resolution)
+	defer ticker.Stop()
+
+	pruned := time.Time{}
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := srv.sampleRollups(now); err != nil {
+				log.Println(err)
+			}
+
+			st := srv.storage.(rollup.Storage)
+
+			if err := rollup.Compact(st, srv.tiers, now); err != nil {
+				log.Println(err)
+			}
+
+			if now.Sub(pruned) >= rollupPruneInterval {
+				if err := rollup.Prune(st, srv.tiers, now); err != nil {
+					log.Println(err)
+				}
+				pruned = now
+			}
+		case <-srv.shutdown:
+			return
+		}
+	}
+}
+
+// sampleRollups saves current values of metrics of all tenants as raw points of the first tier.
+func (srv *server) sampleRollups(now time.Time) error {
+	tenants, err := srv.storage.Tenants()
+	if err != nil {
+		return err
+	}
+
+	t := now.Truncate(srv.tiers[0].Resolution)
+	points := []rollup.Point{}
+
+	for _, tenant := range tenants {
+		batch, err := srv.storage.ForTenant(tenant).GetBatch()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range batch {
+			switch {
+			case m.MType == Gauge && m.Value != nil:
+				points = append(points, srv.sampler.Gauge(tenant, m.ID, t, *m.Value))
+			case m.MType == Counter && m.Delta != nil:
+				points = append(points, srv.sampler.Counter(tenant, m.ID, t, float64(*m.Delta)))
+			}
+		}
+	}
+
+	if len(points) == 0 {
+		return nil
+	}
+
+	return srv.storage.(rollup.Storage).SavePoints(srv.tiers[0].Resolution, points)
+}
+
+// Outputs history of metric of request tenant in json-format. Range is given by parameters "from" and "to"
+// (RFC 3339 or unix seconds), points are aggregated over "step". Tier is chosen by range and step.
+func (srv *server) handlerRange(w http.ResponseWriter, r *http.Request) {
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if len(srv.tiers) == 0 {
+		writeAPIError(w, errRollupsOff, nil)
+		return
+	}
+
+	params := r.URL.Query()
+
+	id := params.Get("name")
+	if id == "" {
+		writeAPIError(w, fmt.Errorf("%w: name is required", errInvalidRange), nil)
+		return
+	}
+
+	now := time.Now()
+
+	to, err := parseRangeTime(params.Get("to"), now)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	from, err := parseRangeTime(params.Get("from"), to.Add(-defaultRange))
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if !from.Before(to) {
+		writeAPIError(w, fmt.Errorf("%w: from must be before to", errInvalidRange), nil)
+		return
+	}
+
+	step := to.Sub(from) / defaultRangePoints
+	if s := params.Get("step"); s != "" {
+		if step, err = time.ParseDuration(s); err != nil || step <= 0 {
+			writeAPIError(w, fmt.Errorf("%w: step must be positive duration", errInvalidRange), nil)
+			return
+		}
+	}
+
+	tier := rollup.Choose(srv.tiers, from, now, step)
+	if step < tier.Resolution {
+		step = tier.Resolution
+	}
+
+	points, err := srv.storage.(rollup.Storage).GetPoints(tier.Resolution, tenant, id, from, to)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	if step > tier.Resolution {
+		points = rollup.Aggregate(points, step)
+	}
+
+	res := rangeResult{
+		ID:         id,
+		Resolution: tier.Resolution.String(),
+		Step:       step.String(),
+		Points:     make([]rangePoint, len(points)),
+	}
+
+	for i, p := range points {
+		res.Points[i] = rangePoint{Time: p.Time, Min: p.Min, Max: p.Max, Avg: p.Avg(), Sum: p.Sum, Last: p.Last, Count: p.Count}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// parseRangeTime parses time in RFC 3339 or unix seconds. Empty string gives default time.
+func parseRangeTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q is neither RFC 3339 time nor unix seconds", errInvalidRange, s)
+	}
+
+	return t, nil
+}
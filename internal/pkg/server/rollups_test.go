@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_initRollups(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+	assert.NoError(t, srv.initRollups())
+	assert.Empty(t, srv.tiers)
+
+	srv = newTestServer(t, serverConfig{RollupTiers: "10s:1d,15s:2d"})
+	assert.ErrorIs(t, srv.initRollups(), rollup.ErrInvalidTiers)
+
+	srv = newTestServer(t, serverConfig{RollupTiers: "10s:1d,1m:30d,1h:1y"})
+	assert.NoError(t, srv.initRollups())
+	assert.Len(t, srv.tiers, 3)
+}
+
+func Test_handlerRange(t *testing.T) {
+	srv := newTestServer(t, serverConfig{RollupTiers: "10s:1h,1m:1d"})
+	assert.NoError(t, srv.initRollups())
+
+	st := srv.storage.ForTenant("team")
+	start := time.Now().Truncate(10 * time.Minute).Add(-10 * time.Minute)
+
+	for i := 0; i < 30; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		value, delta := float64(i), int64(2)
+		assert.NoError(t, st.UpdateBatch([]*metric.Metric{
+			{ID: "Alloc", MType: Gauge, Value: &value},
+			{ID: "PollCount", MType: Counter, Delta: &delta},
+		}))
+		assert.NoError(t, srv.sampleRollups(now))
+	}
+	assert.NoError(t, rollup.Compact(srv.storage.(rollup.Storage), srv.tiers, start.Add(5*time.Minute)))
+
+	from := strconv.FormatInt(start.Unix(), 10)
+	to := start.Add(5 * time.Minute).Format(time.RFC3339)
+
+	tests := []struct {
+		Name               string
+		URL                string
+		ExpectedResolution string
+		ExpectedPoints     []rangePoint
+		ExpectedLen        int
+	}{
+		{
+			Name:               "raw tier",
+			URL:                "/t/team/api/range?name=Alloc&from=" + from + "&to=" + to + "&step=10s",
+			ExpectedResolution: "10s",
+			ExpectedLen:        30,
+		},
+		{
+			Name:               "coarse tier",
+			URL:                "/t/team/api/range?name=Alloc&from=" + from + "&to=" + to + "&step=1m",
+			ExpectedResolution: "1m0s",
+			ExpectedLen:        5,
+			ExpectedPoints:     []rangePoint{{Time: start, Min: 0, Max: 5, Avg: 2.5, Sum: 15, Last: 5, Count: 6}},
+		},
+		{
+			Name:               "coarse tier aggregated over step",
+			URL:                "/t/team/api/range?name=Alloc&from=" + from + "&to=" + to + "&step=5m",
+			ExpectedResolution: "1m0s",
+			ExpectedLen:        1,
+			ExpectedPoints:     []rangePoint{{Time: start, Min: 0, Max: 29, Avg: 14.5, Sum: 435, Last: 29, Count: 30}},
+		},
+		{
+			Name:               "counter increments",
+			URL:                "/t/team/api/range?name=PollCount&from=" + from + "&to=" + to + "&step=1m",
+			ExpectedResolution: "1m0s",
+			ExpectedLen:        5,
+			ExpectedPoints:     []rangePoint{{Time: start, Min: 0, Max: 2, Avg: 10.0 / 6, Sum: 10, Last: 12, Count: 6}},
+		},
+		{
+			Name:               "another tenant",
+			URL:                "/api/range?name=Alloc&from=" + from + "&to=" + to,
+			ExpectedResolution: "10s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			rec := serve(srv, httptest.NewRequest("GET", tt.URL, nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := rangeResult{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tt.ExpectedResolution, res.Resolution)
+			assert.Len(t, res.Points, tt.ExpectedLen)
+
+			for i, p := range tt.ExpectedPoints {
+				assert.True(t, p.Time.Equal(res.Points[i].Time))
+				res.Points[i].Time = p.Time
+				assert.InDelta(t, p.Avg, res.Points[i].Avg, 1e-9)
+				res.Points[i].Avg = p.Avg
+				assert.Equal(t, p, res.Points[i])
+			}
+		})
+	}
+}
+
+func Test_handlerRangeErrors(t *testing.T) {
+	srv := newTestServer(t, serverConfig{RollupTiers: "10s:1h"})
+	assert.NoError(t, srv.initRollups())
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedCode   string
+		ExpectedStatus int
+	}{
+		{Name: "missing name", URL: "/api/range", ExpectedStatus: http.StatusBadRequest, ExpectedCode: "invalid_range"},
+		{Name: "invalid time", URL: "/api/range?name=Alloc&from=yesterday", ExpectedStatus: http.StatusBadRequest, ExpectedCode: "invalid_range"},
+		{Name: "empty range", URL: "/api/range?name=Alloc&from=100&to=100", ExpectedStatus: http.StatusBadRequest, ExpectedCode: "invalid_range"},
+		{Name: "invalid step", URL: "/api/range?name=Alloc&step=-1m", ExpectedStatus: http.StatusBadRequest, ExpectedCode: "invalid_range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			rec := serve(srv, httptest.NewRequest("GET", tt.URL, nil))
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"code":"`+tt.ExpectedCode+`"`)
+		})
+	}
+
+	srv = newTestServer(t, serverConfig{})
+	rec := serve(srv, httptest.NewRequest("GET", "/api/range?name=Alloc", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"rollups_off"`)
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/goslammu/yp_go_devops/internal/pkg/expr"
+	"github.com/goslammu/yp_go_devops/internal/pkg/history"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/recording"
+)
+
+var errUnknownAlertState = errors.New("unknown alert state")
+
+const (
+	// Default period of rules evaluation.
+	defaultRuleInterval = 15 * time.Second
+
+	// Minimal period rates of counters are computed over.
+	minRateWindow = time.Minute
+)
+
+// rulesFile is the content of file with rules.
+type rulesFile struct {
+	Alerts     []alerting.Rule  `json:"alerts"`
+	Recordings []recording.Rule `json:"recordings"`
+}
+
+// alertStorage is implemented by storages which persist alert states.
+type alertStorage interface {
+	GetAlerts() ([]alerting.Alert, error)
+	SaveAlerts(alerts []alerting.Alert) error
+}
+
+// alertsResult is the body of alerts response.
+type alertsResult struct {
+	Alerts []alerting.Alert `json:"alerts"`
+}
+
+// recordingsResult is the body of recording rules response.
+type recordingsResult struct {
+	Recordings []recording.Result `json:"recordings"`
+}
+
+// initRules loads rules from file defined in config and restores alert states persisted by storage.
+func (srv *server) initRules() error {
+	if srv.config.RulesFile == "" {
+		return nil
+	}
+
+	rj, err := os.ReadFile(srv.config.RulesFile)
+	if err != nil {
+		return err
+	}
+
+	rules := rulesFile{}
+	if err := json.Unmarshal(rj, &rules); err != nil {
+		return err
+	}
+
+	engine, err := alerting.NewEngine(rules.Alerts)
+	if err != nil {
+		return err
+	}
+
+	recordings, err := recording.NewEngine(rules.Recordings)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recordings.Results() {
+		if err := srv.limits.checkName(r.Record); err != nil {
+			return fmt.Errorf("recording rule %q: %w", r.Record, err)
+		}
+	}
+
+	if as, ok := srv.storage.(alertStorage); ok {
+		alerts, err := as.GetAlerts()
+		if err != nil {
+			return err
+		}
+
+		engine.Restore(alerts)
+	}
+
+	srv.alerts = engine
+	srv.recordings = recordings
+
+	return nil
+}
+
+// evaluateRules evaluates rules on schedule until server shutdown. Recordings are evaluated first,
+// so alerts see their fresh results.
+func (srv *server) evaluateRules() {
+	interval := srv.config.RuleInterval
+	if interval <= 0 {
+		interval = defaultRuleInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			// Standby gets results of recording rules from primary.
+			if !srv.isStandby() {
+				srv.evalRecordings(now)
+			}
+			srv.evalAlerts(now)
+		case <-srv.shutdown:
+			return
+		}
+	}
+}
+
+// evalRecordings evaluates recording rules against storage and writes their results as gauges.
+func (srv *server) evalRecordings(now time.Time) {
+	results, errs := srv.recordings.Eval(now, func(tenant string) expr.Env {
+		return srv.ruleEnv(tenant, now)
+	})
+
+	for _, err := range errs {
+		log.Println(err)
+	}
+
+	batches := map[string][]*metric.Metric{}
+	for i := range results {
+		value := results[i].Value
+		batches[results[i].Tenant] = append(batches[results[i].Tenant], &metric.Metric{
+			ID:    results[i].Record,
+			MType: Gauge,
+			Value: &value,
+		})
+	}
+
+	for tenant, batch := range batches {
+		if err := srv.storeRecorded(tenant, batch); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// storeRecorded updates results of recording rules in tenant storage within limits and tenant quota.
+// Metrics owned by other nodes of cluster are stored by them.
+func (srv *server) storeRecorded(tenant string, batch []*metric.Metric) error {
+	if err := srv.checkWritable(); err != nil {
+		return err
+	}
+
+	batch, err := srv.storeForeign(context.Background(), tenant, batch)
+	if err != nil {
+		return err
+	}
+
+	batch, reserved, err := srv.limits.admit(tenant, srv.tenantQuota(tenant), batch)
+	if err != nil {
+		return err
+	}
+
+	return srv.storeAdmitted(context.Background(), tenant, batch, reserved)
+}
+
+// evalAlerts evaluates alerting rules against storage, persists states if they changed and passes changes to notifier.
+// Gives alerts which changed their states.
+func (srv *server) evalAlerts(now time.Time) []alerting.Alert {
+	changed, errs := srv.alerts.Eval(now, func(tenant string) expr.Env {
+		return srv.ruleEnv(tenant, now)
+	})
+
+	for _, err := range errs {
+		log.Println(err)
+	}
+
+	if len(changed) == 0 {
+		return changed
+	}
+
+	if srv.notifier != nil {
+		srv.notifier.Add(now, changed)
+	}
+
+	if as, ok := srv.storage.(alertStorage); ok {
+		if err := as.SaveAlerts(srv.alerts.Alerts()); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return changed
+}
+
+// Outputs alerts of request tenant in json-format. Alerts could be filtered by query parameter "state".
+func (srv *server) handlerGetAlerts(w http.ResponseWriter, r *http.Request) {
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", alerting.StatePending, alerting.StateFiring, alerting.StateResolved:
+	default:
+		writeAPIError(w, errUnknownAlertState, nil)
+		return
+	}
+
+	res := alertsResult{
+		Alerts: []alerting.Alert{},
+	}
+
+	if srv.alerts != nil {
+		for _, a := range srv.alerts.Alerts() {
+			if a.Tenant == tenant && (state == "" || a.State == state) {
+				res.Alerts = append(res.Alerts, a)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Outputs the latest results of recording rules of request tenant in json-format, including evaluation errors.
+func (srv *server) handlerGetRecordings(w http.ResponseWriter, r *http.Request) {
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		writeAPIError(w, err, nil)
+		return
+	}
+
+	res := recordingsResult{
+		Recordings: []recording.Result{},
+	}
+
+	if srv.recordings != nil {
+		for _, rec := range srv.recordings.Results() {
+			if rec.Tenant == tenant {
+				res.Recordings = append(res.Recordings, rec)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// ruleEnv gives data of tenant metrics to rules.
+func (srv *server) ruleEnv(tenant string, now time.Time) *ruleEnv {
+	window := 4 * srv.config.HistoryInterval
+	if window < minRateWindow {
+		window = minRateWindow
+	}
+
+	return &ruleEnv{
+		now:     now,
+		metrics: srv.tenantMetrics(context.Background(), tenant),
+		history: srv.history,
+		tenant:  tenant,
+		window:  window,
+	}
+}
+
+// ruleEnv implements expr.Env by tenant metrics, which are read from their owners in cluster. Rates are computed
+// by history samples of the latest window, so in cluster they are known only for metrics owned by node.
+type ruleEnv struct {
+	now     time.Time
+	metrics func(ids []string) ([]*metric.Metric, error)
+	history *history.History
+	tenant  string
+	window  time.Duration
+}
+
+func (e *ruleEnv) Value(id string) (float64, error) {
+	found, err := e.metrics([]string{id})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(found) == 0 {
+		return 0, expr.ErrNoData
+	}
+
+	return found[0].SortValue(), nil
+}
+
+func (e *ruleEnv) Rate(id string) (float64, error) {
+	rate, ok := history.Rate(e.history.Get(e.tenant, id), e.now.Add(-e.window))
+	if !ok {
+		return 0, expr.ErrNoData
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/goslammu/yp_go_devops/internal/pkg/history"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeRules writes rules file to temporary directory and gives its path.
+func writeRules(t *testing.T, rules string) string {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	assert.NoError(t, os.WriteFile(path, []byte(rules), 0600))
+
+	return path
+}
+
+// getAlerts requests alerts and gives their states by rule names.
+func getAlerts(t *testing.T, srv *server, url string) map[string]string {
+	rec := serve(srv, httptest.NewRequest("GET", url, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := alertsResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+
+	states := map[string]string{}
+	for _, a := range res.Alerts {
+		states[a.Rule] = a.State
+	}
+
+	return states
+}
+
+func Test_evalAlerts(t *testing.T) {
+	srv := newTestServer(t, serverConfig{
+		RulesFile: writeRules(t, `{"alerts":[
+			{"name":"heap","expr":"HeapAlloc > 1e9 for 2m","tenant":"team"},
+			{"name":"stuck","expr":"rate(PollCount) == 0 for 1m","tenant":"team"},
+			{"name":"absent","expr":"absent(CPUutilization1)"}
+		]}`),
+	})
+	srv.history = history.New(0)
+	assert.NoError(t, srv.initRules())
+
+	for _, url := range []string{
+		"/t/team/update/gauge/HeapAlloc/2000000000",
+		"/t/team/update/counter/PollCount/1",
+	} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", url, nil)).Code)
+	}
+
+	start := time.Now()
+	assert.NoError(t, srv.sampleHistory(start))
+	assert.NoError(t, srv.sampleHistory(start.Add(10*time.Second)))
+
+	changed := srv.evalAlerts(start.Add(10 * time.Second))
+	assert.Len(t, changed, 3)
+	assert.Equal(t, map[string]string{"heap": alerting.StatePending, "stuck": alerting.StatePending}, getAlerts(t, srv, "/t/team/alerts"))
+	assert.Equal(t, map[string]string{"absent": alerting.StateFiring}, getAlerts(t, srv, "/alerts"))
+
+	assert.NoError(t, srv.sampleHistory(start.Add(70*time.Second)))
+	changed = srv.evalAlerts(start.Add(70 * time.Second))
+	assert.Len(t, changed, 1)
+	assert.Equal(t, map[string]string{"stuck": alerting.StateFiring}, getAlerts(t, srv, "/t/team/alerts?state=firing"))
+
+	// Firing states are restored after restart from storage.
+	restarted := newTestServer(t, srv.config)
+	restarted.storage = srv.storage
+	assert.NoError(t, restarted.initRules())
+	assert.Equal(t, getAlerts(t, srv, "/t/team/alerts"), getAlerts(t, restarted, "/t/team/alerts"))
+
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/team/update/counter/PollCount/5", nil)).Code)
+	assert.NoError(t, srv.sampleHistory(start.Add(80*time.Second)))
+	changed = srv.evalAlerts(start.Add(80 * time.Second))
+	assert.Equal(t, alerting.StateResolved, changed[0].State)
+	assert.Equal(t, map[string]string{"stuck": alerting.StateResolved}, getAlerts(t, srv, "/t/team/alerts?state=resolved"))
+}
+
+func Test_handlerGetAlertsErrors(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	assert.Equal(t, map[string]string{}, getAlerts(t, srv, "/alerts"))
+
+	rec := serve(srv, httptest.NewRequest("GET", "/alerts?state=silenced", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	res := apiErrorResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, "invalid_state", res.Error.Code)
+}
+
+func Test_initRules(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Rules         string
+		ExpectedError bool
+	}{
+		{Name: "valid rules", Rules: `{"alerts":[{"name":"heap","expr":"HeapAlloc > 1e9 for 2m"}]}`},
+		{Name: "invalid json", Rules: `{"alerts":`, ExpectedError: true},
+		{Name: "invalid expression", Rules: `{"alerts":[{"name":"heap","expr":"HeapAlloc >"}]}`, ExpectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			srv := newTestServer(t, serverConfig{RulesFile: writeRules(t, tt.Rules)})
+
+			err := srv.initRules()
+			if tt.ExpectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotNil(t, srv.alerts)
+		})
+	}
+}
+
+func Test_evalRecordings(t *testing.T) {
+	srv := newTestServer(t, serverConfig{
+		RulesFile: writeRules(t, `{"recordings":[
+			{"expr":"HeapUtil = HeapInuse / HeapSys","tenant":"team"},
+			{"record":"MemUsedPct","expr":"1 - FreeMemory/TotalMemory","tenant":"team"},
+			{"expr":"PollRate = rate(PollCount)","tenant":"team"}
+		],"alerts":[{"name":"heap","expr":"HeapUtil > 0.5","tenant":"team"}]}`),
+	})
+	srv.history = history.New(0)
+	assert.NoError(t, srv.initRules())
+
+	for _, url := range []string{
+		"/t/team/update/gauge/HeapInuse/30",
+		"/t/team/update/gauge/HeapSys/40",
+		"/t/team/update/gauge/FreeMemory/25",
+		"/t/team/update/counter/PollCount/10",
+	} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", url, nil)).Code)
+	}
+
+	start := time.Now()
+	assert.NoError(t, srv.sampleHistory(start))
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/team/update/counter/PollCount/20", nil)).Code)
+	assert.NoError(t, srv.sampleHistory(start.Add(10*time.Second)))
+
+	srv.evalRecordings(start.Add(10 * time.Second))
+	assert.Len(t, srv.evalAlerts(start.Add(10*time.Second)), 1)
+
+	for url, expected := range map[string]string{
+		"/t/team/value/gauge/HeapUtil": "0.750",
+		"/t/team/value/gauge/PollRate": "2.000",
+	} {
+		rec := serve(srv, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, expected, rec.Body.String())
+	}
+
+	rec := serve(srv, httptest.NewRequest("GET", "/t/team/value/gauge/MemUsedPct", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(srv, httptest.NewRequest("GET", "/t/team/recordings", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := recordingsResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Len(t, res.Recordings, 3)
+	assert.Equal(t, "MemUsedPct", res.Recordings[1].Record)
+	assert.Equal(t, "no data", res.Recordings[1].Error)
+	assert.Empty(t, res.Recordings[0].Error)
+
+	rec = serve(srv, httptest.NewRequest("GET", "/recordings", nil))
+	assert.JSONEq(t, `{"recordings":[]}`, rec.Body.String())
+}
+
+func Test_initRulesRecordingName(t *testing.T) {
+	srv := newTestServer(t, serverConfig{
+		NameCharset: "^[A-Za-z]+$",
+		RulesFile:   writeRules(t, `{"recordings":[{"expr":"heap_util = HeapInuse / HeapSys"}]}`),
+	})
+
+	assert.Error(t, srv.initRules())
+}
@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
@@ -20,10 +21,23 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/goslammu/yp_go_devops/internal/pkg/alerting"
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
 	"github.com/goslammu/yp_go_devops/internal/pkg/compresser"
+	"github.com/goslammu/yp_go_devops/internal/pkg/counters"
+	"github.com/goslammu/yp_go_devops/internal/pkg/federation"
 	"github.com/goslammu/yp_go_devops/internal/pkg/filestorage"
+	"github.com/goslammu/yp_go_devops/internal/pkg/forward"
+	"github.com/goslammu/yp_go_devops/internal/pkg/history"
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/notify"
+	"github.com/goslammu/yp_go_devops/internal/pkg/openapi"
 	"github.com/goslammu/yp_go_devops/internal/pkg/pgxstorage"
+	"github.com/goslammu/yp_go_devops/internal/pkg/pubsub"
+	"github.com/goslammu/yp_go_devops/internal/pkg/recording"
+	"github.com/goslammu/yp_go_devops/internal/pkg/replication"
+	"github.com/goslammu/yp_go_devops/internal/pkg/rollup"
+	"github.com/goslammu/yp_go_devops/internal/pkg/signer"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
@@ -34,6 +48,7 @@ var (
 	errAlreadyInitialized   = errors.New("server is already initialized")
 	errNotTurnedOn          = errors.New("server is not turned on")
 	errTurnedOn             = errors.New("server is turned on")
+	errSignatureKeyMissing  = errors.New("signature is required, but hash key is not defined")
 )
 
 const (
@@ -43,17 +58,40 @@ const (
 
 // server struct implements full value server for metric storaging and getting them from clients.
 type server struct {
-	storage               metric.MetricStorage
+	storage               metric.TenantStorage
 	uploadSig, shutdown   chan struct{}
 	server                *http.Server
+	verifier              *signer.Verifier
+	authenticator         *auth.Authenticator
+	limits                *limits
+	spec                  *openapi.Document
+	broker                *pubsub.Broker
+	history               *history.History
+	counters              *counters.Tracker
+	totals                *totals
+	updated               *updateTimes
+	alerts                *alerting.Engine
+	notifier              *notify.Notifier
+	forwarder             *forward.Forwarder
+	federation            *federation.Client
+	replication           *replication.Log
+	follower              *follower
+	cluster               *shards
+	recordings            *recording.Engine
+	sampler               *rollup.Sampler
+	graphite              *graphiteListener
+	tiers                 []rollup.Tier
 	config                serverConfig
+	loops                 sync.WaitGroup
+	standby               int32
 	initialized, turnedOn bool
 }
 
 // Server constructor.
 func NewServer(config serverConfig) *server {
 	return &server{
-		config: config,
+		config:   config,
+		shutdown: make(chan struct{}),
 	}
 }
 
@@ -67,10 +105,55 @@ func (srv *server) Init() error {
 		return errTurnedOn
 	}
 
+	if err := srv.initVerifier(); err != nil {
+		return err
+	}
+
 	if err := srv.initStorage(); err != nil {
 		return err
 	}
 
+	if err := srv.initAuthenticator(); err != nil {
+		return err
+	}
+
+	if err := srv.initLimits(); err != nil {
+		return err
+	}
+
+	if err := srv.initSpec(); err != nil {
+		return err
+	}
+
+	srv.initBroker()
+	srv.initHistory()
+	srv.initCounters()
+	srv.initReplication()
+
+	if err := srv.initForwarder(); err != nil {
+		return err
+	}
+
+	if err := srv.initFederation(); err != nil {
+		return err
+	}
+
+	if err := srv.initCluster(); err != nil {
+		return err
+	}
+
+	if err := srv.initRollups(); err != nil {
+		return err
+	}
+
+	if err := srv.initRules(); err != nil {
+		return err
+	}
+
+	if err := srv.initNotifier(); err != nil {
+		return err
+	}
+
 	if err := srv.initRouter(); err != nil {
 		return err
 	}
@@ -95,7 +178,42 @@ func (srv *server) Run() error {
 		return errTurnedOn
 	}
 
-	srv.shutdown = make(chan struct{})
+	if srv.config.GraphiteAddress != "" {
+		if err := srv.listenGraphite(); err != nil {
+			return err
+		}
+		srv.goLoop(func() { srv.acceptGraphite(srv.graphite) })
+	}
+
+	if srv.history != nil {
+		srv.goLoop(srv.recordHistory)
+	}
+
+	if len(srv.tiers) > 0 {
+		srv.goLoop(srv.rollupMetrics)
+	}
+
+	if srv.alerts != nil {
+		srv.goLoop(srv.evaluateRules)
+	}
+
+	if srv.notifier != nil {
+		srv.goLoop(srv.sendNotifications)
+	}
+
+	if srv.federation != nil {
+		srv.goLoop(srv.federate)
+	}
+
+	if srv.isStandby() {
+		srv.startFollower()
+	}
+
+	if srv.cluster != nil && !srv.isStandby() {
+		srv.goLoop(srv.rebalanceOnStart)
+	}
+
+	srv.forwarder.Start()
 
 	if srv.config.EnableHTTPS {
 		go srv.runHTTPS()
@@ -108,6 +226,31 @@ func (srv *server) Run() error {
 	return srv.shutdownHandler()
 }
 
+// goLoop runs background loop until server shutdown. Shutdown waits for the loop to return.
+func (srv *server) goLoop(loop func()) {
+	srv.loops.Add(1)
+
+	go func() {
+		defer srv.loops.Done()
+		loop()
+	}()
+}
+
+// shutdownContext gives context cancelled on server shutdown.
+func (srv *server) shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-srv.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
 func (srv *server) Shutdown() error {
 	if !srv.initialized {
 		return errNotInitialized
@@ -117,15 +260,37 @@ func (srv *server) Shutdown() error {
 		return errNotTurnedOn
 	}
 
-	if srv.uploadSig != nil {
-		close(srv.uploadSig)
+	// Graphite connections are closed, so their handlers return.
+	if srv.graphite != nil {
+		if err := srv.graphite.Close(); err != nil {
+			log.Println(err)
+		}
 	}
 
-	if err := srv.storage.Close(); err != nil {
+	// Streams are ended, so HTTP server doesn't wait for them, and the last requests are completed.
+	srv.broker.Close()
+	srv.replication.Close()
+
+	if err := srv.server.Shutdown(context.Background()); err != nil {
 		return err
 	}
 
-	if err := srv.server.Shutdown(context.Background()); err != nil {
+	// Background loops stop before components they use are closed, pending webhooks aren't waited for.
+	close(srv.shutdown)
+	srv.notifier.Close()
+	srv.loops.Wait()
+	srv.stopFollower()
+
+	// Changes made after the last periodic or sync upload are saved.
+	if st, ok := srv.storage.(uploader); ok && srv.config.StoreInterval >= 0 {
+		if err := st.UploadStorage(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	srv.forwarder.Close()
+
+	if err := srv.storage.Close(); err != nil {
 		return err
 	}
 
@@ -168,9 +333,11 @@ func (srv *server) initFileStorage() error {
 		}
 	}
 
-	if srv.config.StoreInterval != 0 {
-		go func() {
+	switch {
+	case srv.config.StoreInterval > 0:
+		srv.goLoop(func() {
 			uploadTimer := time.NewTicker(srv.config.StoreInterval)
+			defer uploadTimer.Stop()
 
 			for {
 				select {
@@ -182,11 +349,11 @@ func (srv *server) initFileStorage() error {
 					return
 				}
 			}
-		}()
-	} else {
+		})
+	case srv.config.StoreInterval == 0:
 		srv.uploadSig = make(chan struct{})
 
-		go func() {
+		srv.goLoop(func() {
 			for {
 				select {
 				case <-srv.uploadSig:
@@ -197,8 +364,7 @@ func (srv *server) initFileStorage() error {
 					return
 				}
 			}
-		}()
-
+		})
 	}
 
 	srv.storage = filestorage
@@ -206,29 +372,89 @@ func (srv *server) initFileStorage() error {
 	return nil
 }
 
+// initVerifier initializes checker of whole-request signatures.
+func (srv *server) initVerifier() error {
+	if srv.config.RequireSignature && srv.config.HashKey == "" {
+		return errSignatureKeyMissing
+	}
+
+	srv.verifier = signer.NewVerifier(srv.config.HashKey, srv.config.SignatureWindow, srv.config.RequireSignature)
+
+	return nil
+}
+
+// uploader is implemented by storages which keep metrics in file.
+type uploader interface {
+	UploadStorage() error
+}
+
+// tokenStorage is implemented by storages which keep API tokens.
+type tokenStorage interface {
+	GetTokens() ([]auth.Token, error)
+}
+
+// initAuthenticator initializes checker of bearer tokens defined in config and in storage.
+func (srv *server) initAuthenticator() error {
+	tokens := srv.config.Tokens
+
+	if ts, ok := srv.storage.(tokenStorage); ok {
+		storedTokens, err := ts.GetTokens()
+		if err != nil {
+			return err
+		}
+
+		tokens = append(tokens, storedTokens...)
+	}
+
+	authenticator, err := auth.NewAuthenticator(tokens)
+	if err != nil {
+		return err
+	}
+
+	srv.authenticator = authenticator
+
+	return nil
+}
+
 // initRouter initializes server main http-router.
 func (srv *server) initRouter() error {
 	mainRouter := chi.NewRouter()
 
 	mainRouter.Use(compresser.Compresser)
+	mainRouter.Use(srv.advertiseHashVersions)
+	mainRouter.Use(srv.limits.limitBody)
 
-	mainRouter.Route("/", func(r chi.Router) {
-		r.Get("/", srv.handlerGetAll)
+	srv.routeMetrics(mainRouter)
+
+	mainRouter.Route("/t/{tenant}", srv.routeMetrics)
+
+	mainRouter.Route("/ping", func(r chi.Router) {
+		r.Get("/", srv.handlerCheckConnection)
 	})
-	mainRouter.Route("/value", func(r chi.Router) {
-		r.Post("/", srv.handlerGetMetricJSON)
-		r.Get("/{type}/{name}", srv.handlerGetMetric)
+	mainRouter.Route("/limits", func(r chi.Router) {
+		r.Use(srv.authenticator.Authorize(auth.ScopeRead))
+		r.Get("/", srv.handlerGetLimits)
 	})
-	mainRouter.Route("/update", func(r chi.Router) {
-		r.Post("/", srv.handlerUpdateJSON)
-		r.Post("/{type}/{name}/{val}", srv.handlerUpdateDirect)
+	mainRouter.Route("/forwarding", func(r chi.Router) {
+		r.Use(srv.authenticator.Authorize(auth.ScopeRead))
+		r.Get("/", srv.handlerGetForwarding)
 	})
-	mainRouter.Route("/updates", func(r chi.Router) {
-		r.Post("/", srv.handlerUpdateBatch)
+	mainRouter.Route("/replication", func(r chi.Router) {
+		r.Use(srv.authenticator.Authorize(auth.ScopeAdmin))
+		r.With(jsonErrors).Get("/", srv.handlerGetReplication)
+		r.Get("/stream", srv.handlerReplicationStream)
 	})
-	mainRouter.Route("/ping", func(r chi.Router) {
-		r.Get("/", srv.handlerCheckConnection)
+	mainRouter.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeAdmin), srv.verifier.Middleware).Post("/admin/promote", srv.handlerPromote)
+	mainRouter.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeAdmin)).Get("/admin/backup", srv.handlerBackup)
+	mainRouter.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeAdmin), srv.verifier.Middleware).Post(restorePath, srv.handlerRestore)
+	mainRouter.Route("/cluster", func(r chi.Router) {
+		r.Use(jsonErrors)
+		r.With(srv.authenticator.Authorize(auth.ScopeRead)).Get("/", srv.handlerGetCluster)
+		r.With(srv.authenticator.Authorize(auth.ScopeAdmin), srv.verifier.Middleware).Put("/nodes", srv.handlerPutClusterNodes)
+		r.With(srv.authenticator.Authorize(auth.ScopeWrite), srv.verifier.Middleware).Post("/updates", srv.handlerClusterUpdates)
+		r.With(srv.authenticator.Authorize(auth.ScopeRead)).Post("/values", srv.handlerClusterValues)
 	})
+	mainRouter.Get("/openapi.json", srv.handlerGetOpenAPI)
 
 	srv.server = &http.Server{
 		Addr:    srv.config.ServerAddress,
@@ -238,6 +464,46 @@ func (srv *server) initRouter() error {
 	return nil
 }
 
+// routeMetrics defines metric routes. They are served both on root and under tenant prefix "/t/{tenant}".
+// Every route must be described in openapi.json.
+func (srv *server) routeMetrics(r chi.Router) {
+	r.Route("/", func(r chi.Router) {
+		r.Use(srv.authenticator.Authorize(auth.ScopeRead))
+		r.Get("/", srv.handlerGetAll)
+	})
+	r.With(srv.authenticator.Authorize(auth.ScopeRead)).Get("/stream", srv.handlerStream)
+	r.With(srv.authenticator.Authorize(auth.ScopeRead)).Get("/metrics", srv.handlerPrometheus)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeRead)).Get("/api/query", srv.handlerQuery)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeRead)).Get("/api/range", srv.handlerRange)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeRead)).Get("/alerts", srv.handlerGetAlerts)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeRead)).Get("/recordings", srv.handlerGetRecordings)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeWrite), srv.verifier.Middleware).Post("/v1/metrics", srv.handlerOTLP)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeWrite), srv.verifier.Middleware).Post("/write", srv.handlerInfluxWrite)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeRead)).Get("/federate", srv.handlerGetFederate)
+	r.With(jsonErrors, srv.authenticator.Authorize(auth.ScopeWrite), srv.verifier.Middleware).Post("/federate", srv.handlerPostFederate)
+	r.Route("/value", func(r chi.Router) {
+		r.With(srv.authenticator.Authorize(auth.ScopeRead), srv.validateBody, srv.routeToOwner).Post("/", srv.handlerGetMetricJSON)
+		r.With(srv.authenticator.Authorize(auth.ScopeRead), srv.routeToOwner).Get("/{type}/{name}", srv.handlerGetMetric)
+		r.With(srv.authenticator.Authorize(auth.ScopeAdmin), srv.verifier.Middleware, srv.routeToOwner).Delete("/{type}/{name}", srv.handlerDeleteMetric)
+	})
+	r.Route("/values", func(r chi.Router) {
+		r.With(srv.authenticator.Authorize(auth.ScopeRead), srv.validateBody).Post("/", srv.handlerGetMetrics)
+	})
+	r.Route("/update", func(r chi.Router) {
+		r.Use(srv.authenticator.Authorize(auth.ScopeWrite))
+		r.Use(srv.verifier.Middleware)
+		r.With(srv.validateBody, srv.routeToOwner).Post("/", srv.handlerUpdateJSON)
+		r.With(srv.routeToOwner).Post("/{type}/{name}/{val}", srv.handlerUpdateDirect)
+	})
+	r.Route("/updates", func(r chi.Router) {
+		r.Use(srv.authenticator.Authorize(auth.ScopeWrite))
+		r.Use(srv.verifier.Middleware)
+		r.With(srv.validateBody).Post("/", srv.handlerUpdateBatch)
+	})
+
+	srv.routeAPIv1(r)
+}
+
 func (srv *server) UpdateCert() error {
 	cert := &x509.Certificate{
 		SerialNumber: big.NewInt(time.Now().Unix()),
@@ -314,7 +580,7 @@ func (srv *server) shutdownHandler() error {
 func (srv *server) runHTTPS() {
 	if err := srv.server.ListenAndServeTLS(
 		srv.config.CertDestination+certFileName,
-		srv.config.CertDestination+keyFileName); err != nil {
+		srv.config.CertDestination+keyFileName); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Println(err)
 
 		if srv.turnedOn {
@@ -326,7 +592,7 @@ func (srv *server) runHTTPS() {
 }
 
 func (srv *server) runHTTP() {
-	if err := srv.server.ListenAndServe(); err != nil {
+	if err := srv.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Println(err)
 
 		if srv.turnedOn {
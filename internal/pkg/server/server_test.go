@@ -3,11 +3,19 @@ package server
 import (
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/caarlos0/env"
+	"github.com/goslammu/yp_go_devops/internal/pkg/cluster"
+	"github.com/goslammu/yp_go_devops/internal/pkg/federation"
 	"github.com/goslammu/yp_go_devops/internal/pkg/filestorage"
 	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+	"github.com/goslammu/yp_go_devops/internal/pkg/notify"
 	"github.com/goslammu/yp_go_devops/internal/pkg/pgxstorage"
 	"github.com/stretchr/testify/assert"
 )
@@ -141,3 +149,93 @@ func Benchmark_pgxstorageUpdateBatch(b *testing.B) {
 		assert.NoError(b, srv.storage.UpdateBatch(batch))
 	}
 }
+
+func Test_serverRunShutdown(t *testing.T) {
+	tests := []struct {
+		Name          string
+		StoreInterval time.Duration
+	}{
+		{Name: "periodic upload", StoreInterval: time.Hour},
+		{Name: "sync upload", StoreInterval: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.NoError(t, err)
+			address := l.Addr().String()
+			assert.NoError(t, l.Close())
+
+			// Every background loop is turned on, children and webhooks are unavailable.
+			// Recording rule stores its results while server is shut down.
+			storeFile := filepath.Join(t.TempDir(), "metrics.json")
+			srv := NewServer(serverConfig{
+				ServerAddress:    address,
+				FileDestination:  storeFile,
+				StoreInterval:    tt.StoreInterval,
+				HistoryInterval:  10 * time.Millisecond,
+				RuleInterval:     10 * time.Millisecond,
+				RollupTiers:      "10s:1d",
+				RulesFile:        writeRules(t, `{"recordings":[{"record":"MemUsedPct","expr":"1 - FreeMemory/TotalMemory"}],"alerts":[{"name":"heap","expr":"HeapAlloc > 1e9"}]}`),
+				Webhooks:         []notify.Target{{Name: "ops", URL: "http://127.0.0.1:1"}},
+				FederateChildren: []federation.Child{{Name: "child", URL: "http://127.0.0.1:1"}},
+				ClusterNode:      "a",
+				ClusterNodes:     []cluster.Node{{Name: "a", URL: "http://" + address}},
+				GraphiteAddress:  "127.0.0.1:0",
+			})
+			assert.NoError(t, srv.Init())
+
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- srv.Run()
+			}()
+
+			transport := &http.Transport{}
+			client := &http.Client{Transport: transport}
+
+			assert.Eventually(t, func() bool {
+				resp, err := client.Get("http://" + address + "/ping")
+				if err != nil {
+					return false
+				}
+
+				return resp.Body.Close() == nil && resp.StatusCode == http.StatusOK
+			}, 5*time.Second, 10*time.Millisecond)
+
+			for _, path := range []string{"/update/gauge/FreeMemory/25", "/update/gauge/TotalMemory/100"} {
+				resp, err := client.Post("http://"+address+path, "text/plain", nil)
+				if assert.NoError(t, err) {
+					assert.NoError(t, resp.Body.Close())
+				}
+			}
+
+			assert.Eventually(t, func() bool {
+				_, err := srv.storage.GetMetric("MemUsedPct")
+				return err == nil
+			}, 5*time.Second, 10*time.Millisecond)
+
+			// Unused connections of client would keep HTTP server from shutdown for a while.
+			transport.CloseIdleConnections()
+
+			// Shutdown returns only after every loop is stopped, and Run returns after it.
+			shutdown := make(chan error, 1)
+			go func() {
+				shutdown <- srv.Shutdown()
+			}()
+
+			for _, done := range []chan error{shutdown, stopped} {
+				select {
+				case err := <-done:
+					assert.NoError(t, err)
+				case <-time.After(5 * time.Second):
+					t.Fatal("server isn't stopped")
+				}
+			}
+
+			// The last changes are uploaded on shutdown.
+			data, err := os.ReadFile(storeFile)
+			assert.NoError(t, err)
+			assert.Contains(t, string(data), "MemUsedPct")
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/pubsub"
+)
+
+var (
+	errStreamingUnsupported = errors.New("streaming is not supported by connection")
+	errLabelFilterInvalid   = errors.New("label filter must be in format key=value")
+)
+
+const (
+	EventStreamCT = "text/event-stream"
+
+	// Period of comments sent to idle streams, so proxies don't close them.
+	streamHeartbeat = 15 * time.Second
+)
+
+// initBroker initializes publisher of accepted updates.
+func (srv *server) initBroker() {
+	srv.broker = pubsub.New(srv.config.StreamBuffer)
+}
+
+// Streams updates accepted for request tenant as server-sent events "update".
+// Updates could be filtered by query parameters "prefix", "type" and "label" in format "key=value".
+// If client is too slow, updates are lost and event "dropped" with number of lost updates is sent.
+func (srv *server) handlerStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println(errStreamingUnsupported)
+		http.Error(w, errStreamingUnsupported.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, tenant, err := srv.tenantStorage(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	filter, err := streamFilter(r, tenant)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := srv.broker.Subscribe(filter)
+	defer srv.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", EventStreamCT)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	var reported int64
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				log.Println(err)
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+
+			if dropped := sub.Dropped(); dropped > reported {
+				if _, err := fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped-reported); err != nil {
+					log.Println(err)
+					return
+				}
+
+				reported = dropped
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			if _, err := fmt.Fprintf(w, "event: update\ndata: %s\n\n", data); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+// streamFilter builds filter of stream from query parameters.
+func streamFilter(r *http.Request, tenant string) (pubsub.Filter, error) {
+	query := r.URL.Query()
+
+	filter := pubsub.Filter{
+		Tenant: tenant,
+		Prefix: query.Get("prefix"),
+		MType:  query.Get("type"),
+	}
+
+	if filter.MType != "" {
+		if err := checkTypeSupport(filter.MType); err != nil {
+			return filter, err
+		}
+	}
+
+	for _, label := range query["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return filter, errLabelFilterInvalid
+		}
+
+		if filter.Labels == nil {
+			filter.Labels = map[string]string{}
+		}
+
+		filter.Labels[kv[0]] = kv[1]
+	}
+
+	return filter, nil
+}
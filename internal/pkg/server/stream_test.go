@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/pubsub"
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvent reads next server-sent event and gives its name and data.
+func readEvent(t *testing.T, scanner *bufio.Scanner) (string, string) {
+	var name, data string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	t.Fatal("stream is closed")
+
+	return "", ""
+}
+
+// waitSubscribers waits until broker has given number of subscribers.
+func waitSubscribers(t *testing.T, broker *pubsub.Broker, expected int) {
+	assert.Eventually(t, func() bool {
+		subscribers, _, _ := broker.Stats()
+		return subscribers == expected
+	}, time.Second, time.Millisecond)
+}
+
+func Test_handlerStream(t *testing.T) {
+	srv := newTestServer(t, serverConfig{NameCharset: testNameCharset})
+	ts := httptest.NewServer(srv.server.Handler)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/t/team/stream?type=gauge&prefix=Heap", nil)
+	assert.NoError(t, err)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, EventStreamCT, resp.Header.Get("Content-Type"))
+
+	waitSubscribers(t, srv.broker, 1)
+
+	for _, url := range []string{
+		"/t/team/update/counter/HeapCount/1",
+		"/t/team/update/gauge/Alloc/1",
+		"/update/gauge/HeapAlloc/1",
+		"/t/team/update/gauge/HeapAlloc/5",
+	} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", url, nil)).Code)
+	}
+
+	body := `[{"id":"HeapSys","type":"gauge","value":7},{"id":"bad name","type":"gauge","value":1}]`
+	assert.Equal(t, http.StatusMultiStatus, serve(srv, httptest.NewRequest("POST", "/t/team/api/v1/updates", strings.NewReader(body))).Code)
+
+	scanner := bufio.NewScanner(resp.Body)
+
+	for _, expected := range []struct {
+		ID    string
+		Value float64
+	}{
+		{ID: "HeapAlloc", Value: 5},
+		{ID: "HeapSys", Value: 7},
+	} {
+		name, data := readEvent(t, scanner)
+		assert.Equal(t, "update", name)
+
+		e := pubsub.Event{}
+		assert.NoError(t, json.Unmarshal([]byte(data), &e))
+		assert.Equal(t, expected.ID, e.ID)
+		assert.Equal(t, "team", e.Tenant)
+		assert.Equal(t, expected.Value, *e.Value)
+	}
+
+	cancel()
+	waitSubscribers(t, srv.broker, 0)
+}
+
+func Test_handlerStreamDropped(t *testing.T) {
+	const updates = 1000
+
+	srv := newTestServer(t, serverConfig{StreamBuffer: 1})
+	ts := httptest.NewServer(srv.server.Handler)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/stream?type=counter")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	waitSubscribers(t, srv.broker, 1)
+
+	batch := []string{}
+	for i := 0; i < updates; i++ {
+		batch = append(batch, fmt.Sprintf(`{"id":"C%d","type":"counter","delta":1}`, i))
+	}
+
+	body := "[" + strings.Join(batch, ",") + "]"
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/api/v1/updates", strings.NewReader(body))).Code)
+
+	scanner := bufio.NewScanner(resp.Body)
+
+	var received, dropped int
+	for received+dropped < updates {
+		name, data := readEvent(t, scanner)
+
+		switch name {
+		case "update":
+			received++
+		case "dropped":
+			res := struct {
+				Dropped int `json:"dropped"`
+			}{}
+			assert.NoError(t, json.Unmarshal([]byte(data), &res))
+
+			dropped += res.Dropped
+		}
+	}
+
+	assert.Equal(t, updates, received+dropped)
+	assert.Positive(t, dropped)
+
+	srv.broker.Close()
+	assert.False(t, scanner.Scan())
+}
+
+func Test_streamFilter(t *testing.T) {
+	tests := []struct {
+		ExpectedError error
+		Name          string
+		Query         string
+		Expected      pubsub.Filter
+	}{
+		{
+			Name:     "empty filter",
+			Expected: pubsub.Filter{Tenant: "team"},
+		},
+		{
+			Name:  "all parameters",
+			Query: "?prefix=Heap&type=gauge&label=host=a&label=dc=b=c",
+			Expected: pubsub.Filter{
+				Tenant: "team",
+				Prefix: "Heap",
+				MType:  Gauge,
+				Labels: map[string]string{"host": "a", "dc": "b=c"},
+			},
+		},
+		{
+			Name:          "unsupported type",
+			Query:         "?type=hist",
+			ExpectedError: errUnsupportedType,
+		},
+		{
+			Name:          "invalid label",
+			Query:         "?label=host",
+			ExpectedError: errLabelFilterInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			filter, err := streamFilter(httptest.NewRequest("GET", "/stream"+tt.Query, nil), "team")
+			assert.ErrorIs(t, err, tt.ExpectedError)
+
+			if tt.ExpectedError == nil {
+				assert.Equal(t, tt.Expected, filter)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi"
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/metric"
+)
+
+var (
+	errTenantInvalid       = errors.New("tenant name is invalid")
+	errTenantForbidden     = errors.New("token is not allowed to work with this tenant")
+	errTenantQuotaExceeded = errors.New("tenant metrics quota is exceeded")
+)
+
+// Request header defining tenant, if it is not defined by URL prefix or token.
+const TenantHeader = "X-Tenant"
+
+var tenantNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{0,64}$`)
+
+// Resolves tenant of request. Tenant is taken from token, URL prefix "/t/{tenant}" or header, in this order.
+// Token bound to tenant doesn't allow to request any other tenant.
+func (srv *server) tenant(r *http.Request) (string, error) {
+	requested := chi.URLParam(r, "tenant")
+	if requested == "" {
+		requested = r.Header.Get(TenantHeader)
+	}
+
+	if !tenantNameRegexp.MatchString(requested) {
+		return "", errTenantInvalid
+	}
+
+	if token, ok := auth.FromContext(r.Context()); ok && token.Tenant != "" {
+		if requested != "" && requested != token.Tenant {
+			return "", errTenantForbidden
+		}
+
+		return token.Tenant, nil
+	}
+
+	return requested, nil
+}
+
+// Returns storage of request tenant.
+func (srv *server) tenantStorage(r *http.Request) (metric.MetricStorage, string, error) {
+	tenant, err := srv.tenant(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return srv.storage.ForTenant(tenant), tenant, nil
+}
+
+// Gives maximal number of metrics allowed for tenant. Zero means unlimited.
+func (srv *server) tenantQuota(tenant string) int {
+	if quota, ok := srv.config.TenantQuotas[tenant]; ok {
+		return quota
+	}
+
+	return srv.config.TenantQuota
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goslammu/yp_go_devops/internal/pkg/auth"
+	"github.com/goslammu/yp_go_devops/internal/pkg/filestorage"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer gives server with in-memory file storage and initialized router.
+func newTestServer(t *testing.T, config serverConfig) *server {
+	config.StoreInterval = -1
+
+	srv := NewServer(config)
+	srv.storage = filestorage.New("")
+
+	assert.NoError(t, srv.initVerifier())
+	assert.NoError(t, srv.initAuthenticator())
+	assert.NoError(t, srv.initLimits())
+	assert.NoError(t, srv.initSpec())
+	srv.initBroker()
+	srv.initCounters()
+	assert.NoError(t, srv.initRouter())
+
+	return srv
+}
+
+// serve sends request to server router and returns recorded response.
+func serve(srv *server, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func Test_tenantIsolation(t *testing.T) {
+	srv := newTestServer(t, serverConfig{})
+
+	reqA := httptest.NewRequest("POST", "/t/teamA/update/gauge/Alloc/1", nil)
+	assert.Equal(t, http.StatusOK, serve(srv, reqA).Code)
+
+	reqB := httptest.NewRequest("POST", "/update/gauge/Alloc/2", nil)
+	reqB.Header.Set(TenantHeader, "teamB")
+	assert.Equal(t, http.StatusOK, serve(srv, reqB).Code)
+
+	tests := []struct {
+		Name           string
+		URL            string
+		Header         string
+		ExpectedBody   string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "tenant from URL prefix",
+			URL:            "/t/teamA/value/gauge/Alloc",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "1.000",
+		},
+		{
+			Name:           "tenant from header",
+			URL:            "/value/gauge/Alloc",
+			Header:         "teamB",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "2.000",
+		},
+		{
+			Name:           "default tenant",
+			URL:            "/value/gauge/Alloc",
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "invalid tenant",
+			URL:            "/value/gauge/Alloc",
+			Header:         "team/B",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.URL, nil)
+			if tt.Header != "" {
+				req.Header.Set(TenantHeader, tt.Header)
+			}
+
+			rec := serve(srv, req)
+
+			assert.Equal(t, tt.ExpectedStatus, rec.Code)
+			if tt.ExpectedBody != "" {
+				assert.Equal(t, tt.ExpectedBody, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("delete is isolated", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("DELETE", "/t/teamA/value/gauge/Alloc", nil)).Code)
+		assert.Equal(t, http.StatusNotFound, serve(srv, httptest.NewRequest("GET", "/t/teamA/value/gauge/Alloc", nil)).Code)
+
+		req := httptest.NewRequest("GET", "/value/gauge/Alloc", nil)
+		req.Header.Set(TenantHeader, "teamB")
+		assert.Equal(t, http.StatusOK, serve(srv, req).Code)
+	})
+
+	t.Run("tenants are listed", func(t *testing.T) {
+		tenants, err := srv.storage.Tenants()
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"", "teamB"}, tenants)
+	})
+}
+
+func Test_tenantQuota(t *testing.T) {
+	srv := newTestServer(t, serverConfig{
+		TenantQuota:  2,
+		TenantQuotas: map[string]int{"big": 3},
+	})
+
+	for _, name := range []string{"m1", "m2", "m1"} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/update/gauge/"+name+"/1", nil)).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve(srv, httptest.NewRequest("POST", "/update/gauge/m3/1", nil)).Code)
+
+	for _, name := range []string{"m1", "m2", "m3"} {
+		assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("POST", "/t/big/update/gauge/"+name+"/1", nil)).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve(srv, httptest.NewRequest("POST", "/t/big/update/gauge/m4/1", nil)).Code)
+}
+
+func Test_tenantFromToken(t *testing.T) {
+	srv := newTestServer(t, serverConfig{
+		Tokens: []auth.Token{{Token: "teamA", Scopes: []string{auth.ScopeWrite}, Tenant: "teamA"}},
+	})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "tenant is taken from token",
+			URL:            "/update/gauge/Alloc/1",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "same tenant in URL",
+			URL:            "/t/teamA/update/gauge/Alloc/1",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "other tenant in URL",
+			URL:            "/t/teamB/update/gauge/Alloc/1",
+			ExpectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.URL, nil)
+			req.Header.Set("Authorization", "Bearer teamA")
+
+			assert.Equal(t, tt.ExpectedStatus, serve(srv, req).Code)
+		})
+	}
+
+	_, err := srv.storage.ForTenant("teamA").GetMetric("Alloc")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,234 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrKeyNotDefined        = errors.New("signing key is not defined")
+	ErrSignatureMissing     = errors.New("request signature is missing")
+	ErrSignatureInvalid     = errors.New("request signature is invalid")
+	ErrTimestampInvalid     = errors.New("request timestamp is invalid")
+	ErrTimestampOutOfWindow = errors.New("request timestamp is out of allowed window")
+	ErrNonceInvalid         = errors.New("request nonce is invalid")
+	ErrNonceReused          = errors.New("request nonce was already used")
+)
+
+// Headers carrying whole-request signature data.
+const (
+	HeaderSignature = "Signature"
+	HeaderTimestamp = "Signature-Timestamp"
+	HeaderNonce     = "Signature-Nonce"
+)
+
+const (
+	nonceSize      = 16
+	maxNonceLength = 128
+
+	DefaultWindow = 30 * time.Second
+)
+
+// Sign calculates HMAC of request method, path, query, timestamp, nonce and body by given key.
+// Query is signed in canonical form, so order of parameters doesn't matter.
+func Sign(key, method, path, query string, timestamp int64, nonce string, body []byte) (string, error) {
+	if key == "" {
+		return "", ErrKeyNotDefined
+	}
+
+	h := hmac.New(sha256.New, []byte(key))
+
+	for _, part := range [][]byte{
+		[]byte(method),
+		[]byte(path),
+		[]byte(canonicalQuery(query)),
+		[]byte(strconv.FormatInt(timestamp, 10)),
+		[]byte(nonce),
+	} {
+		if _, err := h.Write(part); err != nil {
+			return "", err
+		}
+
+		if _, err := h.Write([]byte{'\n'}); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := h.Write(body); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonicalQuery gives query with parameters sorted by names. Query which can't be parsed is kept as is.
+func canonicalQuery(query string) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return query
+	}
+
+	return values.Encode()
+}
+
+// SignRequest generates fresh timestamp and nonce and puts request signature into headers.
+// Body must be the same bytes which are sent in request.
+func SignRequest(req *http.Request, key string, body []byte) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+
+	sign, err := Sign(key, req.Method, req.URL.Path, req.URL.RawQuery, timestamp, nonce, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, sign)
+
+	return nil
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, nonceSize)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Verifier checks whole-request signatures and keeps recently seen nonces to reject replayed requests.
+type Verifier struct {
+	now       func() time.Time
+	nonces    map[string]time.Time
+	lastPurge time.Time
+	key       string
+	window    time.Duration
+	required  bool
+	sync.Mutex
+}
+
+// Constructor. Window defines allowed clock skew between signer and server.
+// If required is false, unsigned requests are passed, but signed ones are still verified.
+func NewVerifier(key string, window time.Duration, required bool) *Verifier {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+
+	return &Verifier{
+		now:      time.Now,
+		nonces:   map[string]time.Time{},
+		key:      key,
+		window:   window,
+		required: required,
+	}
+}
+
+// Verify checks request signature against given body.
+func (v *Verifier) Verify(r *http.Request, body []byte) error {
+	sign := r.Header.Get(HeaderSignature)
+	if sign == "" {
+		if v.required {
+			return ErrSignatureMissing
+		}
+
+		return nil
+	}
+
+	if v.key == "" {
+		return ErrKeyNotDefined
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrTimestampInvalid
+	}
+
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return ErrNonceInvalid
+	}
+
+	now := v.now()
+	signedAt := time.Unix(timestamp, 0)
+
+	if signedAt.Before(now.Add(-v.window)) || signedAt.After(now.Add(v.window)) {
+		return ErrTimestampOutOfWindow
+	}
+
+	expected, err := Sign(v.key, r.Method, r.URL.Path, r.URL.RawQuery, timestamp, nonce, body)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(sign)) {
+		return ErrSignatureInvalid
+	}
+
+	return v.useNonce(nonce, now)
+}
+
+// useNonce remembers nonce till the end of the window. Returns error if nonce is already remembered.
+func (v *Verifier) useNonce(nonce string, now time.Time) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if now.Sub(v.lastPurge) > v.window {
+		for n, expires := range v.nonces {
+			if now.After(expires) {
+				delete(v.nonces, n)
+			}
+		}
+
+		v.lastPurge = now
+	}
+
+	if expires, ok := v.nonces[nonce]; ok && !now.After(expires) {
+		return ErrNonceReused
+	}
+
+	// Timestamps are accepted in both directions of the window, so nonce must live twice as long.
+	v.nonces[nonce] = now.Add(2 * v.window)
+
+	return nil
+}
+
+// Middleware component for verifying signed requests. Request body is restored for next handlers.
+func (v *Verifier) Middleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := v.Verify(r, body); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,147 @@
+package signer
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSignedRequest(t *testing.T, key string, body []byte) *http.Request {
+	req, err := http.NewRequest("POST", "/updates/", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.NoError(t, SignRequest(req, key, body))
+
+	return req
+}
+
+func Test_Verify(t *testing.T) {
+	key := "key"
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+
+	t.Run("valid signature", func(t *testing.T) {
+		v := NewVerifier(key, time.Minute, true)
+
+		assert.NoError(t, v.Verify(newSignedRequest(t, key, body), body))
+	})
+
+	t.Run("replayed request", func(t *testing.T) {
+		v := NewVerifier(key, time.Minute, true)
+		req := newSignedRequest(t, key, body)
+
+		assert.NoError(t, v.Verify(req, body))
+		assert.ErrorIs(t, v.Verify(req, body), ErrNonceReused)
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		v := NewVerifier(key, time.Minute, true)
+		req := newSignedRequest(t, key, body)
+
+		assert.ErrorIs(t, v.Verify(req, []byte(`[{"id":"PollCount","type":"counter","delta":100}]`)), ErrSignatureInvalid)
+	})
+
+	t.Run("tampered path", func(t *testing.T) {
+		v := NewVerifier(key, time.Minute, true)
+		req := newSignedRequest(t, key, body)
+		req.URL.Path = "/update/"
+
+		assert.ErrorIs(t, v.Verify(req, body), ErrSignatureInvalid)
+	})
+
+	t.Run("tampered query", func(t *testing.T) {
+		v := NewVerifier(key, time.Minute, true)
+
+		req, err := http.NewRequest("POST", "/cluster/updates?move=1&tenant=a", bytes.NewBuffer(body))
+		assert.NoError(t, err)
+		assert.NoError(t, SignRequest(req, key, body))
+
+		req.URL.RawQuery = "move=1&tenant=b"
+		assert.ErrorIs(t, v.Verify(req, body), ErrSignatureInvalid)
+
+		// Order of parameters doesn't change signature.
+		req.URL.RawQuery = "tenant=a&move=1"
+		assert.NoError(t, v.Verify(req, body))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		v := NewVerifier("otherKey", time.Minute, true)
+
+		assert.ErrorIs(t, v.Verify(newSignedRequest(t, key, body), body), ErrSignatureInvalid)
+	})
+
+	t.Run("stale timestamp", func(t *testing.T) {
+		v := NewVerifier(key, time.Minute, true)
+		v.now = func() time.Time {
+			return time.Now().Add(2 * time.Minute)
+		}
+
+		assert.ErrorIs(t, v.Verify(newSignedRequest(t, key, body), body), ErrTimestampOutOfWindow)
+	})
+
+	t.Run("bad timestamp", func(t *testing.T) {
+		v := NewVerifier(key, time.Minute, true)
+		req := newSignedRequest(t, key, body)
+		req.Header.Set(HeaderTimestamp, "yesterday")
+
+		assert.ErrorIs(t, v.Verify(req, body), ErrTimestampInvalid)
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/updates/", bytes.NewBuffer(body))
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, NewVerifier(key, time.Minute, true).Verify(req, body), ErrSignatureMissing)
+		assert.NoError(t, NewVerifier(key, time.Minute, false).Verify(req, body))
+	})
+}
+
+func Test_useNonce(t *testing.T) {
+	v := NewVerifier("key", time.Minute, true)
+	now := time.Now()
+
+	assert.NoError(t, v.useNonce("nonce", now))
+	assert.ErrorIs(t, v.useNonce("nonce", now.Add(time.Minute)), ErrNonceReused)
+
+	t.Run("expired nonce is purged", func(t *testing.T) {
+		later := now.Add(3 * time.Minute)
+
+		assert.NoError(t, v.useNonce("other", later))
+		assert.NotContains(t, v.nonces, "nonce")
+	})
+}
+
+func Test_Middleware(t *testing.T) {
+	key := "key"
+	body := []byte(`{"id":"Alloc","type":"gauge","value":1.5}`)
+
+	var received []byte
+	handler := NewVerifier(key, time.Minute, true).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := new(bytes.Buffer)
+		_, err := buf.ReadFrom(r.Body)
+		assert.NoError(t, err)
+		received = buf.Bytes()
+	}))
+
+	t.Run("signed request", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newSignedRequest(t, key, body))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, body, received)
+	})
+
+	t.Run("unsigned request", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/update/", bytes.NewBuffer(body))
+		assert.NoError(t, err)
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}